@@ -0,0 +1,132 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// AppendLog stores a grow-only sequence of values in a bucket, such as the
+// events of an event-sourced application. Each value is stored under the
+// 8-byte big endian sequence number returned by Append.
+//
+// Appends within a transaction insert directly into the rightmost leaf of
+// the bucket instead of searching the tree for each key. The underlying
+// bucket should only be accessed through an AppendLog.
+type AppendLog struct {
+	b *Bucket
+}
+
+// NewAppendLog returns an AppendLog that stores values in b. Nodes of b are
+// filled completely when they split since keys are only added at the end.
+func NewAppendLog(b *Bucket) *AppendLog {
+	b.FillPercent = maxFillPercent
+	return &AppendLog{b: b}
+}
+
+// Bucket returns the underlying bucket.
+func (l *AppendLog) Bucket() *Bucket {
+	return l.b
+}
+
+// Append adds a value to the end of the log and returns its sequence number.
+// Sequence numbers start at 1 and are never reused, even after truncation.
+func (l *AppendLog) Append(value []byte) (uint64, error) {
+	b := l.b
+	if b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !b.Writable() {
+		return 0, ErrTxNotWritable
+	} else if int64(len(value)) > MaxValueSize {
+		return 0, ErrValueTooLarge
+	}
+
+	seq := b.bucket.sequence + 1
+	key := appendLogKey(seq)
+	if err := b.authorize(OpPut, key); err != nil {
+		return 0, err
+	}
+
+	// Reuse the rightmost leaf while the key sorts after its last key and
+	// the tree has not been replaced by rolling back to a savepoint.
+	n := b.tail
+	if n == nil || n.root() != b.rootNode || (len(n.inodes) > 0 && bytes.Compare(key, n.inodes[len(n.inodes)-1].key) <= 0) {
+		c := b.Cursor()
+		k, _, flags := c.seek(key)
+		if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
+			return 0, ErrIncompatibleValue
+		}
+		n = c.node()
+
+		// Only a leaf without any later keys is the rightmost leaf.
+		b.tail = nil
+		if k == nil {
+			b.tail = n
+		}
+	}
+
+	if value == nil {
+		value = []byte{}
+	}
+	n.put(key, key, value, 0, 0)
+	b.bucket.sequence = seq
+	b.bloomAdd(key)
+	b.audit(OpPut, key, value)
+
+	return seq, nil
+}
+
+// Get returns the value with the given sequence number or nil if it does
+// not exist or was truncated.
+func (l *AppendLog) Get(seq uint64) []byte {
+	return l.b.Get(appendLogKey(seq))
+}
+
+// Last returns the sequence number of the last value appended to the log,
+// or zero if nothing has been appended.
+func (l *AppendLog) Last() uint64 {
+	return l.b.bucket.sequence
+}
+
+// ForEachFrom executes fn for each value in the log in order, starting with
+// the value with sequence number seq or the first value after it. If fn
+// returns an error then the iteration is stopped and the error is returned.
+func (l *AppendLog) ForEachFrom(seq uint64, fn func(seq uint64, v []byte) error) error {
+	if l.b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := l.b.authorize(OpForEach, nil); err != nil {
+		return err
+	}
+
+	c := l.b.Cursor()
+	for k, v := c.Seek(appendLogKey(seq)); k != nil; k, v = c.Next() {
+		if len(k) != 8 || v == nil {
+			continue
+		}
+		if err := fn(binary.BigEndian.Uint64(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TruncateBefore removes every value with a sequence number less than seq
+// and returns the number of values removed.
+func (l *AppendLog) TruncateBefore(seq uint64) (int, error) {
+	end := appendLogKey(seq)
+	c := l.b.Cursor()
+	var n int
+	for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.First() {
+		if err := l.b.Delete(k); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// appendLogKey returns the key of the value with the given sequence number.
+func appendLogKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
@@ -0,0 +1,127 @@
+package bolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that an append log assigns sequences, reads from a sequence and
+// truncates old values.
+func TestAppendLog(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Append enough values to split the rightmost leaf several times.
+	for i := 0; i < 3; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("events"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := bolt.NewAppendLog(b)
+			for j := 0; j < 1000; j++ {
+				want := uint64(i*1000 + j + 1)
+				if seq, err := l.Append([]byte(fmt.Sprintf("event %d", want))); err != nil {
+					t.Fatal(err)
+				} else if seq != want {
+					t.Fatalf("unexpected sequence: %d", seq)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		l := bolt.NewAppendLog(tx.Bucket([]byte("events")))
+		if n := l.Last(); n != 3000 {
+			t.Fatalf("unexpected last sequence: %d", n)
+		} else if v := l.Get(1234); string(v) != "event 1234" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if n, err := l.TruncateBefore(2990); err != nil {
+			t.Fatal(err)
+		} else if n != 2989 {
+			t.Fatalf("unexpected truncated count: %d", n)
+		} else if v := l.Get(1); v != nil {
+			t.Fatalf("expected truncated value: %q", v)
+		}
+
+		var seqs []uint64
+		if err := l.ForEachFrom(2995, func(seq uint64, v []byte) error {
+			if string(v) != fmt.Sprintf("event %d", seq) {
+				t.Fatalf("unexpected value: %d=%q", seq, v)
+			}
+			seqs = append(seqs, seq)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if s := fmt.Sprint(seqs); s != "[2995 2996 2997 2998 2999 3000]" {
+			t.Fatalf("unexpected sequences: %s", s)
+		}
+
+		// Sequences are not reused after truncating everything.
+		if _, err := l.TruncateBefore(3001); err != nil {
+			t.Fatal(err)
+		} else if seq, err := l.Append([]byte("next")); err != nil {
+			t.Fatal(err)
+		} else if seq != 3001 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+}
+
+// Ensure that appends after rolling back to a savepoint are not written to
+// the discarded tree.
+func TestAppendLog_RollbackTo(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("events"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := bolt.NewAppendLog(b)
+		if _, err := l.Append([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Append([]byte("b")); err != nil {
+			t.Fatal(err)
+		} else if err := tx.RollbackTo(sp); err != nil {
+			t.Fatal(err)
+		}
+		if seq, err := l.Append([]byte("c")); err != nil {
+			t.Fatal(err)
+		} else if seq != 2 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		l := bolt.NewAppendLog(tx.Bucket([]byte("events")))
+		if v := l.Get(1); string(v) != "a" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := l.Get(2); string(v) != "c" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
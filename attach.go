@@ -0,0 +1,115 @@
+package bolt
+
+import "sort"
+
+// Attach opens the bolt file at path read-only and attaches it to the
+// database under name. Transactions read attached files with Tx.Attached, so
+// queries can span the live file and sealed files such as archives without
+// merging them. Attached files are closed by Detach or when the database is
+// closed. Returns ErrAttachmentExists if name is already attached.
+//
+// Attach waits for a process that has the file open for writing to close it.
+// Other attachments can be used while it waits.
+func (db *DB) Attach(name, path string) error {
+	db.attachlock.RLock()
+	_, ok := db.attached[name]
+	db.attachlock.RUnlock()
+	if ok {
+		return ErrAttachmentExists
+	}
+
+	// Open the file, which may wait for its file lock, before taking the
+	// attach lock so that transactions reading attachments are not blocked.
+	adb, err := Open(path, 0600, &Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	db.attachlock.Lock()
+	defer db.attachlock.Unlock()
+	if _, ok := db.attached[name]; ok {
+		_ = adb.Close()
+		return ErrAttachmentExists
+	}
+	if db.attached == nil {
+		db.attached = make(map[string]*DB)
+	}
+	db.attached[name] = adb
+	return nil
+}
+
+// Detach closes the file attached under name. It blocks until transactions
+// that read the file with Tx.Attached have finished. Returns
+// ErrAttachmentNotFound if name is not attached.
+func (db *DB) Detach(name string) error {
+	db.attachlock.Lock()
+	adb, ok := db.attached[name]
+	delete(db.attached, name)
+	db.attachlock.Unlock()
+	if !ok {
+		return ErrAttachmentNotFound
+	}
+	return adb.Close()
+}
+
+// Attachments returns the names of the attached files in sorted order.
+func (db *DB) Attachments() []string {
+	db.attachlock.RLock()
+	defer db.attachlock.RUnlock()
+	names := make([]string, 0, len(db.attached))
+	for name := range db.attached {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detachAll closes every attached file.
+func (db *DB) detachAll() {
+	db.attachlock.Lock()
+	attached := db.attached
+	db.attached = nil
+	db.attachlock.Unlock()
+	for _, adb := range attached {
+		_ = adb.Close()
+	}
+}
+
+// Attached returns a read-only transaction on the file attached under name,
+// or nil if no file is attached under that name. The transaction is begun the
+// first time it is requested and sees the attached file as of that moment.
+// It is rolled back when tx is closed and must not be committed or rolled
+// back directly.
+func (tx *Tx) Attached(name string) *Tx {
+	if tx.db == nil {
+		return nil
+	} else if atx := tx.attached[name]; atx != nil {
+		return atx
+	}
+
+	db := tx.db
+	db.attachlock.RLock()
+	adb := db.attached[name]
+	db.attachlock.RUnlock()
+	if adb == nil {
+		return nil
+	}
+
+	atx, err := adb.Begin(false)
+	if err != nil {
+		return nil
+	}
+	if tx.attached == nil {
+		tx.attached = make(map[string]*Tx)
+	}
+	tx.attached[name] = atx
+	return atx
+}
+
+// closeAttached rolls back the transactions begun by Attached.
+func (tx *Tx) closeAttached() {
+	for _, atx := range tx.attached {
+		_ = atx.Rollback()
+	}
+	tx.attached = nil
+}
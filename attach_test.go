@@ -0,0 +1,152 @@
+package bolt_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that transactions can read files attached to the database.
+func TestDB_Attach(t *testing.T) {
+	// Create a sealed archive file.
+	path := tempfile()
+	defer os.Remove(path)
+	archive, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("events"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("2015"), []byte("old"))
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("events"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("2016"), []byte("new"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Attach("archive", path); err != nil {
+		t.Fatal(err)
+	} else if err := db.Attach("archive", path); err != bolt.ErrAttachmentExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if names := db.Attachments(); !reflect.DeepEqual(names, []string{"archive"}) {
+		t.Fatalf("unexpected attachments: %v", names)
+	}
+
+	var atx *bolt.Tx
+	if err := db.View(func(tx *bolt.Tx) error {
+		atx = tx.Attached("archive")
+		if atx == nil {
+			t.Fatal("expected attached transaction")
+		} else if tx.Attached("archive") != atx {
+			t.Fatal("expected the same attached transaction")
+		} else if tx.Attached("missing") != nil {
+			t.Fatal("unexpected attached transaction")
+		}
+
+		if v := tx.Bucket([]byte("events")).Get([]byte("2016")); string(v) != "new" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := atx.Bucket([]byte("events")).Get([]byte("2015")); string(v) != "old" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if err := atx.Bucket([]byte("events")).Put([]byte("2017"), []byte("x")); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The attached transaction is closed with the transaction that began it.
+	if err := atx.Rollback(); err != bolt.ErrTxClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Detach("archive"); err != nil {
+		t.Fatal(err)
+	} else if err := db.Detach("archive"); err != bolt.ErrAttachmentNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Attached("archive") != nil {
+			t.Fatal("unexpected attached transaction")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attached files are closed with the database.
+	if err := db.Attach("archive", path); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	} else if names := db.Attachments(); len(names) != 0 {
+		t.Fatalf("unexpected attachments: %v", names)
+	}
+}
+
+// Ensure that attaching a file locked by another process does not block the
+// use of other attachments while it waits.
+func TestDB_Attach_Wait(t *testing.T) {
+	archive := tempfile()
+	defer os.Remove(archive)
+	if adb, err := bolt.Open(archive, 0666, nil); err != nil {
+		t.Fatal(err)
+	} else if err := adb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	locked := tempfile()
+	defer os.Remove(locked)
+	release := lockInChild(t, locked)
+	defer release()
+
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	done := make(chan error, 1)
+	go func() { done <- db.Attach("locked", locked) }()
+
+	// Other files can be attached and read while the attach waits.
+	time.Sleep(50 * time.Millisecond)
+	if err := db.Attach("archive", archive); err != nil {
+		t.Fatal(err)
+	} else if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Attached("archive") == nil {
+			t.Fatal("expected attached transaction")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("attach did not wait: %v", err)
+	default:
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	} else if names := db.Attachments(); !reflect.DeepEqual(names, []string{"archive", "locked"}) {
+		t.Fatalf("unexpected attachments: %v", names)
+	}
+}
@@ -0,0 +1,97 @@
+package bolt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditTruncateSize is the number of value bytes recorded in the audit log
+// when DB.AuditValues is AuditValueTruncate.
+const AuditTruncateSize = 32
+
+// AuditValueMode controls how values are recorded in the audit log.
+type AuditValueMode int
+
+const (
+	// AuditValueNone omits values from the audit log.
+	AuditValueNone AuditValueMode = iota
+
+	// AuditValueHash records the hex encoded SHA-256 hash of each value.
+	AuditValueHash
+
+	// AuditValueTruncate records up to AuditTruncateSize bytes of each value.
+	AuditValueTruncate
+
+	// AuditValueFull records each value in full.
+	AuditValueFull
+)
+
+// auditRecord is a mutation recorded by a writable transaction.
+type auditRecord struct {
+	op     Op
+	bucket string // Quoted path of the bucket.
+	key    []byte
+	value  string
+}
+
+// audit records a mutation to the bucket if the database has an audit writer.
+func (b *Bucket) audit(op Op, key, value []byte) {
+	db := b.tx.db
+	if db.Audit == nil {
+		return
+	}
+
+	r := auditRecord{op: op, bucket: auditPath(b.path()), key: cloneBytes(key)}
+	if op == OpPut {
+		switch db.AuditValues {
+		case AuditValueHash:
+			sum := sha256.Sum256(value)
+			r.value = hex.EncodeToString(sum[:])
+		case AuditValueTruncate:
+			if len(value) > AuditTruncateSize {
+				r.value = fmt.Sprintf("%q...", value[:AuditTruncateSize])
+			} else {
+				r.value = fmt.Sprintf("%q", value)
+			}
+		case AuditValueFull:
+			r.value = fmt.Sprintf("%q", value)
+		}
+	}
+	b.tx.audit = append(b.tx.audit, r)
+}
+
+// auditPath returns the path of a bucket as its quoted names joined by
+// slashes, or "" for the root bucket. Quoting keeps paths distinct when names
+// contain slashes.
+func auditPath(path [][]byte) string {
+	if len(path) == 0 {
+		return `""`
+	}
+	names := make([]string, len(path))
+	for i, name := range path {
+		names[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(names, "/")
+}
+
+// writeAudit writes the mutations recorded by the transaction to the
+// database's audit writer once the transaction has committed.
+func (tx *Tx) writeAudit(db *DB, id txid, t time.Time) {
+	if db.Audit == nil || len(tx.audit) == 0 {
+		return
+	}
+	db.auditlock.Lock()
+	defer db.auditlock.Unlock()
+	ts := t.UTC().Format(time.RFC3339Nano)
+	for _, r := range tx.audit {
+		if r.value != "" {
+			fmt.Fprintf(db.Audit, "time=%s tx=%d op=%s bucket=%s key=%q value=%s\n", ts, id, r.op, r.bucket, r.key, r.value)
+		} else {
+			fmt.Fprintf(db.Audit, "time=%s tx=%d op=%s bucket=%s key=%q\n", ts, id, r.op, r.bucket, r.key)
+		}
+	}
+	tx.audit = nil
+}
@@ -0,0 +1,60 @@
+package bolt
+
+// Op identifies the operation passed to an authorizer.
+type Op int
+
+const (
+	// OpGet reads a value with Bucket.Get.
+	OpGet Op = iota
+
+	// OpPut writes a value with Bucket.Put or any method built on it.
+	OpPut
+
+	// OpDelete removes a value with Bucket.Delete.
+	OpDelete
+
+	// OpBucket opens a nested bucket with Bucket.Bucket or checks for it
+	// with Bucket.HasBucket.
+	OpBucket
+
+	// OpCreateBucket creates a nested bucket.
+	OpCreateBucket
+
+	// OpDeleteBucket deletes a nested bucket.
+	OpDeleteBucket
+
+	// OpForEach iterates over a bucket with ForEach or ForEachDirect. The key
+	// is nil, or the prefix for ForEachPrefix.
+	OpForEach
+)
+
+// String returns the name of the operation.
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	case OpBucket:
+		return "bucket"
+	case OpCreateBucket:
+		return "create-bucket"
+	case OpDeleteBucket:
+		return "delete-bucket"
+	case OpForEach:
+		return "foreach"
+	default:
+		return "unknown"
+	}
+}
+
+// authorize calls the database authorizer, if set, for an operation on the
+// bucket, passing the path of the bucket from the root.
+func (b *Bucket) authorize(op Op, key []byte) error {
+	if b.tx.db == nil || b.tx.db.Authorize == nil {
+		return nil
+	}
+	return b.tx.db.Authorize(op, b.path(), key)
+}
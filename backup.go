@@ -0,0 +1,436 @@
+package bolt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// DefaultChunkSize is the default size of chunks emitted by a ChunkWriter.
+// It is above the minimum part size of common object storage services.
+const DefaultChunkSize = 8 << 20 // 8MB
+
+// castagnoli is the CRC-32C table used for chunk checksums.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encryptedMagic marks the start of an encrypted backup stream.
+var encryptedMagic = []byte("boltenc1")
+
+// encryptedSegmentSize is the amount of plaintext sealed in each segment of
+// an encrypted backup stream.
+const encryptedSegmentSize = 64 * 1024
+
+// gzipMagic marks the start of a gzip compressed stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Compression represents the compression applied to a backup.
+type Compression int
+
+const (
+	// NoCompression writes the backup uncompressed.
+	NoCompression Compression = iota
+
+	// GzipCompression compresses the backup with gzip.
+	GzipCompression
+)
+
+// BackupOptions represents the options used when writing or restoring a backup.
+type BackupOptions struct {
+	// EncryptionKey, when set, encrypts the backup with AES-GCM. The key
+	// must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+	// The same key must be passed to Restore.
+	EncryptionKey []byte
+
+	// Compression sets the compression applied to the backup. Data is
+	// compressed before it is encrypted. Restore detects compressed
+	// backups automatically.
+	Compression Compression
+}
+
+// Backup writes a consistent copy of the entire database to w and returns
+// the number of bytes written to w. A read-only transaction is held for the
+// duration of the backup so it is safe to continue using the database while
+// a backup is in progress. Passing in nil options writes a plain copy of the
+// database file.
+func (db *DB) Backup(w io.Writer, options *BackupOptions) (n int64, err error) {
+	err = db.View(func(tx *Tx) error {
+		n, err = tx.Backup(w, options)
+		return err
+	})
+	return n, err
+}
+
+// Backup writes the entire database to w using the given options and returns
+// the number of bytes written to w.
+func (tx *Tx) Backup(w io.Writer, options *BackupOptions) (int64, error) {
+	if options == nil || (options.EncryptionKey == nil && options.Compression == NoCompression) {
+		return tx.WriteTo(w)
+	}
+
+	// Build the writer chain from the output back to the database copy.
+	cw := &countWriter{w: w}
+	var dst io.Writer = cw
+	var closers []io.Closer
+	if options.EncryptionKey != nil {
+		ew, err := newEncryptWriter(dst, options.EncryptionKey)
+		if err != nil {
+			return 0, err
+		}
+		dst, closers = ew, append(closers, ew)
+	}
+	switch options.Compression {
+	case NoCompression:
+	case GzipCompression:
+		zw := gzip.NewWriter(dst)
+		dst, closers = zw, append(closers, zw)
+	default:
+		return 0, fmt.Errorf("unknown compression: %d", options.Compression)
+	}
+
+	if _, err := tx.WriteTo(dst); err != nil {
+		return cw.n, err
+	}
+
+	// Flush the chain from the innermost writer outwards.
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// BackupFile writes a backup of the entire database to the file at path
+// using the given options.
+func (tx *Tx) BackupFile(path string, mode os.FileMode, options *BackupOptions) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Backup(f, options); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Restore writes a backup read from r to a new database file at path.
+// Compressed backups are decompressed automatically. Encrypted backups
+// require the same encryption key that was used to create them. Restore
+// will not overwrite an existing file.
+func Restore(path string, r io.Reader, mode os.FileMode, options *BackupOptions) error {
+	br := bufio.NewReader(r)
+
+	// Decrypt the stream if it begins with the encryption header.
+	src := br
+	if hdr, _ := br.Peek(len(encryptedMagic)); bytes.Equal(hdr, encryptedMagic) {
+		if options == nil || options.EncryptionKey == nil {
+			return ErrBackupEncrypted
+		}
+		dr, err := newDecryptReader(br, options.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		src = bufio.NewReader(dr)
+	} else if options != nil && options.EncryptionKey != nil {
+		return ErrBackupDecrypt
+	}
+
+	// Decompress the stream if it begins with the gzip header.
+	var rd io.Reader = src
+	if hdr, _ := src.Peek(len(gzipMagic)); bytes.Equal(hdr, gzipMagic) {
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = zr.Close() }()
+		rd = zr
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rd); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// newAEAD returns an AES-GCM cipher for key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter seals a stream into a sequence of AES-GCM segments.
+//
+// The stream starts with a magic value and a random nonce prefix. Each
+// segment is a flag byte, a 4-byte ciphertext length and the ciphertext.
+// Segment nonces are the prefix followed by the segment counter and the
+// flag byte is authenticated so that reordered, dropped or truncated
+// segments fail to decrypt.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &encryptWriter{
+		w:     w,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		buf:   make([]byte, 0, encryptedSegmentSize),
+	}
+	prefix := ew.nonce[:len(ew.nonce)-4]
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	// Write the header.
+	if _, err := w.Write(encryptedMagic); err != nil {
+		return nil, err
+	} else if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// Seal the buffered segment only once more data arrives so that
+		// the last segment can always be marked as final on Close.
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+
+		sz := cap(w.buf) - len(w.buf)
+		if sz > len(p) {
+			sz = len(p)
+		}
+		w.buf = append(w.buf, p[:sz]...)
+		p, n = p[sz:], n+sz
+	}
+	return n, nil
+}
+
+// Close seals the remaining data as the final segment.
+func (w *encryptWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *encryptWriter) seal(final bool) error {
+	var hdr [5]byte
+	if final {
+		hdr[0] = 1
+	}
+	binary.BigEndian.PutUint32(w.nonce[len(w.nonce)-4:], w.counter)
+	ciphertext := w.aead.Seal(nil, w.nonce, w.buf, hdr[:1])
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(ciphertext)))
+
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	} else if _, err := w.w.Write(ciphertext); err != nil {
+		return err
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// decryptReader opens a stream written by encryptWriter.
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	final   bool
+}
+
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &decryptReader{r: r, aead: aead, nonce: make([]byte, aead.NonceSize())}
+	if _, err := io.ReadFull(r, make([]byte, len(encryptedMagic))); err != nil {
+		return nil, ErrBackupDecrypt
+	} else if _, err := io.ReadFull(r, dr.nonce[:len(dr.nonce)-4]); err != nil {
+		return nil, ErrBackupDecrypt
+	}
+	return dr, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.final {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptReader) open() error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return ErrBackupDecrypt
+	}
+	sz := binary.BigEndian.Uint32(hdr[1:])
+	if hdr[0] > 1 || sz > uint32(encryptedSegmentSize+r.aead.Overhead()) {
+		return ErrBackupDecrypt
+	}
+
+	ciphertext := make([]byte, sz)
+	if _, err := io.ReadFull(r.r, ciphertext); err != nil {
+		return ErrBackupDecrypt
+	}
+	binary.BigEndian.PutUint32(r.nonce[len(r.nonce)-4:], r.counter)
+	plaintext, err := r.aead.Open(ciphertext[:0], r.nonce, ciphertext, hdr[:1])
+	if err != nil {
+		return ErrBackupDecrypt
+	}
+
+	r.counter++
+	r.buf = plaintext
+	r.final = hdr[0] == 1
+	return nil
+}
+
+// Chunk is a contiguous piece of a backup stream emitted by a ChunkWriter.
+type Chunk struct {
+	// Index is the zero-based position of the chunk in the stream.
+	Index int
+
+	// Offset is the byte offset of the chunk in the stream.
+	Offset int64
+
+	// Data holds the contents of the chunk. It is only valid for the
+	// duration of the callback and must be copied to be retained.
+	Data []byte
+
+	// Checksum is the CRC-32C (Castagnoli) checksum of Data.
+	Checksum uint32
+}
+
+// ChunkWriter splits a stream into fixed size chunks and passes each one,
+// along with its checksum, to a callback. This allows a backup to be
+// uploaded as the parts of a multipart upload without staging the whole
+// file locally.
+//
+// Every chunk except the last is exactly the chunk size. Close must be
+// called to emit the final chunk.
+type ChunkWriter struct {
+	fn     func(c *Chunk) error
+	buf    []byte
+	index  int
+	offset int64
+	err    error
+}
+
+// NewChunkWriter returns a ChunkWriter that emits chunks of size bytes to fn.
+// If size is zero or less then DefaultChunkSize is used.
+func NewChunkWriter(size int, fn func(c *Chunk) error) *ChunkWriter {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	return &ChunkWriter{fn: fn, buf: make([]byte, 0, size)}
+}
+
+// Write buffers p and emits every chunk that is filled.
+// Once the callback returns an error, all subsequent writes return it.
+func (w *ChunkWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if w.err != nil {
+			return n, w.err
+		}
+
+		// Fill the buffer as far as possible.
+		sz := cap(w.buf) - len(w.buf)
+		if sz > len(p) {
+			sz = len(p)
+		}
+		w.buf = append(w.buf, p[:sz]...)
+		p, n = p[sz:], n+sz
+
+		// Emit the chunk once it is full.
+		if len(w.buf) == cap(w.buf) {
+			w.err = w.flush()
+		}
+	}
+	return n, w.err
+}
+
+// Close emits any remaining buffered data as the final chunk.
+func (w *ChunkWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		w.err = w.flush()
+	}
+	return w.err
+}
+
+// flush passes the buffered data to the callback and resets the buffer.
+func (w *ChunkWriter) flush() error {
+	c := &Chunk{
+		Index:    w.index,
+		Offset:   w.offset,
+		Data:     w.buf,
+		Checksum: crc32.Checksum(w.buf, castagnoli),
+	}
+	if err := w.fn(c); err != nil {
+		return err
+	}
+	w.index++
+	w.offset += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
@@ -0,0 +1,216 @@
+package bolt_test
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a backup can be split into checksummed chunks.
+func TestDB_Backup_ChunkWriter(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var chunks []bolt.Chunk
+	w := bolt.NewChunkWriter(1000, func(c *bolt.Chunk) error {
+		if c.Offset != int64(buf.Len()) {
+			t.Fatalf("unexpected offset: %d", c.Offset)
+		} else if c.Checksum != crc32.Checksum(c.Data, crc32.MakeTable(crc32.Castagnoli)) {
+			t.Fatalf("unexpected checksum: %d", c.Index)
+		}
+		buf.Write(c.Data)
+		chunks = append(chunks, bolt.Chunk{Index: c.Index, Offset: c.Offset})
+		return nil
+	})
+	n, err := db.Backup(w, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the chunks cover the entire backup.
+	if int64(buf.Len()) != n {
+		t.Fatalf("unexpected size: %d != %d", buf.Len(), n)
+	} else if exp := int((n + 999) / 1000); len(chunks) != exp {
+		t.Fatalf("unexpected chunk count: %d != %d", len(chunks), exp)
+	}
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Fatalf("unexpected index: %d != %d", c.Index, i)
+		}
+	}
+
+	// Ensure the reassembled backup matches a direct copy.
+	var exp bytes.Buffer
+	if _, err := db.Backup(&exp, nil); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), exp.Bytes()) {
+		t.Fatal("chunked backup does not match direct backup")
+	}
+}
+
+// Ensure that a callback error stops the chunk writer.
+func TestChunkWriter_Error(t *testing.T) {
+	errUpload := errors.New("upload failed")
+	w := bolt.NewChunkWriter(4, func(c *bolt.Chunk) error { return errUpload })
+	if _, err := w.Write([]byte("0123456789")); err != errUpload {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := w.Close(); err != errUpload {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that an encrypted backup can be restored with the same key.
+func TestDB_Backup_Encrypted(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("secret value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	var buf bytes.Buffer
+	n, err := db.Backup(&buf, &bolt.BackupOptions{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Fatalf("unexpected size: %d != %d", n, buf.Len())
+	} else if bytes.Contains(buf.Bytes(), []byte("secret value")) {
+		t.Fatal("backup contains plaintext value")
+	}
+
+	// Restoring without a key or with the wrong key must fail.
+	path := tempfile()
+	defer os.Remove(path)
+	if err := bolt.Restore(path, bytes.NewReader(buf.Bytes()), 0666, nil); err != bolt.ErrBackupEncrypted {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	badKey := &bolt.BackupOptions{EncryptionKey: []byte("fedcba9876543210fedcba9876543210")}
+	if err := bolt.Restore(path, bytes.NewReader(buf.Bytes()), 0666, badKey); err != bolt.ErrBackupDecrypt {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	os.Remove(path)
+
+	// Restore with the correct key and verify the contents.
+	if err := bolt.Restore(path, bytes.NewReader(buf.Bytes()), 0666, &bolt.BackupOptions{EncryptionKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if err := restored.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); string(v) != "secret value" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a truncated encrypted backup is rejected.
+func TestRestore_EncryptedTruncated(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	opts := &bolt.BackupOptions{EncryptionKey: []byte("0123456789abcdef")}
+	var buf bytes.Buffer
+	if _, err := db.Backup(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	path := tempfile()
+	defer os.Remove(path)
+	if err := bolt.Restore(path, bytes.NewReader(buf.Bytes()[:buf.Len()-1]), 0666, opts); err != bolt.ErrBackupDecrypt {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that a compressed backup is smaller than the database and can be
+// restored, with and without encryption.
+func TestTx_BackupFile_Compressed(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []*bolt.BackupOptions{
+		{Compression: bolt.GzipCompression},
+		{Compression: bolt.GzipCompression, EncryptionKey: []byte("0123456789abcdef")},
+	} {
+		backup, path := tempfile(), tempfile()
+		if err := db.View(func(tx *bolt.Tx) error {
+			if err := tx.BackupFile(backup, 0666, opts); err != nil {
+				return err
+			}
+			if fi, err := os.Stat(backup); err != nil {
+				return err
+			} else if fi.Size() >= tx.Size()/2 {
+				t.Fatalf("backup not compressed: %d >= %d", fi.Size(), tx.Size()/2)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bolt.Restore(path, f, 0666, opts); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		restored, err := bolt.Open(path, 0666, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.View(func(tx *bolt.Tx) error {
+			if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 1000 {
+				t.Fatalf("unexpected key count: %d", n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		_ = restored.Close()
+		_ = os.Remove(backup)
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,376 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// minBloomKeys is the smallest number of keys a bloom filter is sized for.
+const minBloomKeys = 1024
+
+// bloomMagic identifies a bloom filter sidecar file.
+var bloomMagic = [8]byte{'b', 'o', 'l', 't', 'b', 'l', 'm', 2}
+
+// SetBloomFilter enables a bloom filter with bitsPerKey bits per key for the
+// top-level bucket named name. A value of zero or less disables it. Ten bits
+// per key give a false positive rate of about one percent.
+//
+// Get and Has consult the filter and return immediately for keys that are
+// definitely missing, skipping the search of the bucket. The filter is built
+// by the first commit after it is enabled and updated by every Put. Deleted
+// keys stay in the filter until it is rebuilt, which happens when the number
+// of keys added grows to twice the number it was sized for. Transactions
+// that started before a filter was built do not use it.
+//
+// Filters are saved next to the data file with a ".bloom" suffix when the
+// database is closed and reused by the next Open if the file has not changed
+// since. A compacted or restored copy of the file rebuilds its filters.
+func (db *DB) SetBloomFilter(name []byte, bitsPerKey int) {
+	db.updateBloomSpec(name, func(spec *bloomSpec) {
+		spec.bitsPerKey = bitsPerKey
+	})
+	if bitsPerKey <= 0 {
+		db.bloomlock.Lock()
+		delete(db.blooms, string(name))
+		db.bloomlock.Unlock()
+	}
+}
+
+// SetPrefixBloomFilter enables a bloom filter over the first prefixLen bytes
+// of the keys of the top-level bucket named name, using bitsPerKey bits per
+// distinct prefix. A prefixLen or bitsPerKey of zero or less disables it.
+//
+// ForEachPrefix consults the filter and returns immediately when no key
+// starts with the first prefixLen bytes of the prefix. Shorter prefixes are
+// always scanned. The filter is built, updated and saved like the filter set
+// with SetBloomFilter.
+func (db *DB) SetPrefixBloomFilter(name []byte, prefixLen, bitsPerKey int) {
+	if prefixLen <= 0 || bitsPerKey <= 0 {
+		prefixLen, bitsPerKey = 0, 0
+	}
+	db.updateBloomSpec(name, func(spec *bloomSpec) {
+		spec.prefixLen, spec.prefixBitsPerKey = prefixLen, bitsPerKey
+	})
+
+	db.bloomlock.Lock()
+	if f := db.prefixBlooms[string(name)]; f != nil && f.prefix != prefixLen {
+		delete(db.prefixBlooms, string(name))
+	}
+	db.bloomlock.Unlock()
+}
+
+// bloomSpec describes the bloom filters enabled for a bucket.
+type bloomSpec struct {
+	bitsPerKey       int // bits per key of the key filter
+	prefixLen        int // length of the key prefixes in the prefix filter
+	prefixBitsPerKey int // bits per prefix of the prefix filter
+}
+
+// updateBloomSpec applies fn to the bloom filter settings of a bucket.
+func (db *DB) updateBloomSpec(name []byte, fn func(spec *bloomSpec)) {
+	db.bucketlock.Lock()
+	defer db.bucketlock.Unlock()
+	spec := db.bloomSpecs[string(name)]
+	fn(&spec)
+	if spec.bitsPerKey <= 0 && spec.prefixBitsPerKey <= 0 {
+		delete(db.bloomSpecs, string(name))
+		return
+	}
+	if db.bloomSpecs == nil {
+		db.bloomSpecs = make(map[string]bloomSpec)
+	}
+	db.bloomSpecs[string(name)] = spec
+}
+
+// dropBlooms discards the bloom filters of a top-level bucket whose contents
+// were replaced. Enabled filters are rebuilt by the next commit.
+func (db *DB) dropBlooms(name []byte) {
+	db.bloomlock.Lock()
+	delete(db.blooms, string(name))
+	delete(db.prefixBlooms, string(name))
+	db.bloomlock.Unlock()
+}
+
+// bloomFilter is a bloom filter over the keys or key prefixes of a top-level
+// bucket.
+type bloomFilter struct {
+	bits   []uint64
+	k      uint32 // number of hash functions
+	prefix int    // length of the key prefixes added, or zero for whole keys
+	cap    int    // number of keys the filter was sized for
+	keys   int    // number of distinct keys added
+	since  txid   // first transaction that can use the filter
+}
+
+// newBloomFilter returns an empty filter sized for n keys or key prefixes.
+func newBloomFilter(n, bitsPerKey, prefix int) *bloomFilter {
+	if n < minBloomKeys {
+		n = minBloomKeys
+	}
+
+	// The optimal number of hash functions is bitsPerKey * ln(2).
+	k := uint32(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	return &bloomFilter{bits: make([]uint64, (n*bitsPerKey+63)/64), k: k, prefix: prefix, cap: n}
+}
+
+// add adds a key, or its prefix for a prefix filter, to the filter. Keys
+// that may already be present are not counted again.
+func (f *bloomFilter) add(key []byte) {
+	if f.prefix > 0 {
+		if len(key) < f.prefix {
+			return
+		}
+		key = key[:f.prefix]
+	}
+
+	h1, h2 := bloomHash(key)
+	m := uint32(len(f.bits) * 64)
+	var added bool
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			f.bits[bit/64] |= 1 << (bit % 64)
+			added = true
+		}
+	}
+	if added {
+		f.keys++
+	}
+}
+
+// full returns true if the filter should be rebuilt because its false
+// positive rate has grown too high.
+func (f *bloomFilter) full() bool {
+	return f.keys >= 2*f.cap
+}
+
+// mayContain returns false if the key was definitely never added.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	m := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash returns the two hashes that are combined to derive each of the
+// filter's hash functions.
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// mayContain returns false if the bucket's bloom filter shows that the key is
+// definitely missing.
+func (b *Bucket) mayContain(key []byte) bool {
+	db := b.tx.db
+	db.bloomlock.RLock()
+	defer db.bloomlock.RUnlock()
+	f := db.blooms[string(b.name)]
+	if f == nil || f.since > b.tx.meta.txid || !b.topLevel() || f.mayContain(key) {
+		return true
+	}
+	b.tx.stats.BloomSkip++
+	return false
+}
+
+// mayContainPrefix returns false if the bucket's prefix bloom filter shows
+// that no key starts with prefix.
+func (b *Bucket) mayContainPrefix(prefix []byte) bool {
+	db := b.tx.db
+	db.bloomlock.RLock()
+	defer db.bloomlock.RUnlock()
+	f := db.prefixBlooms[string(b.name)]
+	if f == nil || f.since > b.tx.meta.txid || len(prefix) < f.prefix || !b.topLevel() || f.mayContain(prefix[:f.prefix]) {
+		return true
+	}
+	b.tx.stats.BloomSkip++
+	return false
+}
+
+// bloomAdd adds a key put into the bucket to its bloom filters.
+func (b *Bucket) bloomAdd(key []byte) {
+	db := b.tx.db
+	db.bloomlock.Lock()
+	defer db.bloomlock.Unlock()
+	f, pf := db.blooms[string(b.name)], db.prefixBlooms[string(b.name)]
+	if (f == nil && pf == nil) || !b.topLevel() {
+		return
+	}
+	if f != nil {
+		f.add(key)
+	}
+	if pf != nil {
+		pf.add(key)
+	}
+}
+
+// topLevel returns true if b is a top-level bucket of its transaction.
+func (b *Bucket) topLevel() bool {
+	return b != &b.tx.root && b.tx.root.buckets[string(b.name)] == b
+}
+
+// buildBlooms builds the bloom filters that are missing or full from the
+// state committed by tx. The writer lock must be held.
+func (tx *Tx) buildBlooms() {
+	db := tx.db
+	db.bucketlock.RLock()
+	specs := make(map[string]bloomSpec, len(db.bloomSpecs))
+	for name, spec := range db.bloomSpecs {
+		specs[name] = spec
+	}
+	db.bucketlock.RUnlock()
+
+	for name, spec := range specs {
+		db.bloomlock.RLock()
+		f, pf := db.blooms[name], db.prefixBlooms[name]
+		db.bloomlock.RUnlock()
+		buildKeys := spec.bitsPerKey > 0 && (f == nil || f.full())
+		buildPrefixes := spec.prefixBitsPerKey > 0 && (pf == nil || pf.prefix != spec.prefixLen || pf.full())
+		if !buildKeys && !buildPrefixes {
+			continue
+		}
+
+		b := tx.root.child([]byte(name))
+		if b == nil {
+			continue
+		}
+
+		// Count the keys and distinct prefixes to size the filters.
+		var n, np int
+		var last []byte
+		_ = b.forEach(func(k, v []byte) error {
+			n++
+			if len(k) >= spec.prefixLen && (last == nil || !bytes.Equal(k[:spec.prefixLen], last)) {
+				np, last = np+1, k[:spec.prefixLen]
+			}
+			return nil
+		})
+		if buildKeys {
+			f = newBloomFilter(n, spec.bitsPerKey, 0)
+		}
+		if buildPrefixes {
+			pf = newBloomFilter(np, spec.prefixBitsPerKey, spec.prefixLen)
+		}
+		_ = b.forEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			} else if buildKeys {
+				f.add(k)
+			}
+			if buildPrefixes {
+				pf.add(k)
+			}
+			return nil
+		})
+
+		db.bloomlock.Lock()
+		if buildKeys {
+			f.since = tx.meta.txid
+			if db.blooms == nil {
+				db.blooms = make(map[string]*bloomFilter)
+			}
+			db.blooms[name] = f
+		}
+		if buildPrefixes {
+			pf.since = tx.meta.txid
+			if db.prefixBlooms == nil {
+				db.prefixBlooms = make(map[string]*bloomFilter)
+			}
+			db.prefixBlooms[name] = pf
+		}
+		db.bloomlock.Unlock()
+	}
+}
+
+// bloomPath returns the path of the bloom filter sidecar file.
+func (db *DB) bloomPath() string {
+	return db.path + ".bloom"
+}
+
+// saveBlooms writes the bloom filters to the sidecar file. Filters are only a
+// cache so errors are ignored and any partial file is removed.
+func (db *DB) saveBlooms() {
+	if len(db.blooms) == 0 && len(db.prefixBlooms) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.Write(bloomMagic[:])
+	_ = binary.Write(&buf, binary.BigEndian, db.meta().checksum)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(db.blooms)+len(db.prefixBlooms)))
+	for _, blooms := range []map[string]*bloomFilter{db.blooms, db.prefixBlooms} {
+		for name, f := range blooms {
+			_ = binary.Write(&buf, binary.BigEndian, uint32(len(name)))
+			buf.WriteString(name)
+			_ = binary.Write(&buf, binary.BigEndian, []uint64{uint64(f.k), uint64(f.prefix), uint64(f.cap), uint64(f.keys), uint64(len(f.bits))})
+			_ = binary.Write(&buf, binary.BigEndian, f.bits)
+		}
+	}
+
+	tmp := db.bloomPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		_ = os.Remove(tmp)
+	} else if err := os.Rename(tmp, db.bloomPath()); err != nil {
+		_ = os.Remove(tmp)
+	}
+}
+
+// loadBlooms reads the bloom filters saved by saveBlooms. Filters are ignored
+// if the data file has been committed to since they were saved.
+func (db *DB) loadBlooms() {
+	data, err := ioutil.ReadFile(db.bloomPath())
+	if err != nil || len(data) < len(bloomMagic)+12 || !bytes.Equal(data[:len(bloomMagic)], bloomMagic[:]) {
+		return
+	}
+	r := bytes.NewReader(data[len(bloomMagic):])
+
+	m := db.meta()
+	var checksum uint64
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &checksum) != nil || checksum != m.checksum {
+		return
+	} else if binary.Read(r, binary.BigEndian, &n) != nil {
+		return
+	}
+
+	blooms, prefixBlooms := make(map[string]*bloomFilter), make(map[string]*bloomFilter)
+	for i := uint32(0); i < n; i++ {
+		var sz uint32
+		if binary.Read(r, binary.BigEndian, &sz) != nil || int64(sz) > int64(r.Len()) {
+			return
+		}
+		name := make([]byte, sz)
+		var hdr [5]uint64
+		if _, err := io.ReadFull(r, name); err != nil {
+			return
+		} else if binary.Read(r, binary.BigEndian, &hdr) != nil || hdr[0] == 0 || hdr[1] > MaxKeySize || hdr[4] == 0 || hdr[4] > uint64(r.Len()/8) {
+			return
+		}
+		f := &bloomFilter{k: uint32(hdr[0]), prefix: int(hdr[1]), cap: int(hdr[2]), keys: int(hdr[3]), bits: make([]uint64, hdr[4]), since: m.txid}
+		if binary.Read(r, binary.BigEndian, f.bits) != nil {
+			return
+		}
+		if f.prefix > 0 {
+			prefixBlooms[string(name)] = f
+		} else {
+			blooms[string(name)] = f
+		}
+	}
+	db.blooms, db.prefixBlooms = blooms, prefixBlooms
+}
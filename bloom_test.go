@@ -0,0 +1,186 @@
+package bolt_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a bloom filter answers lookups of missing keys and is reused
+// after the database is reopened.
+func TestDB_SetBloomFilter(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	defer os.Remove(db.Path() + ".bloom")
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transaction started before the filter is built does not use it.
+	old, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = old.Rollback() }()
+
+	db.SetBloomFilter([]byte("widgets"), 10)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(1000), []byte("value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(tx *bolt.Tx, n int) {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 2000; i++ {
+			if has := b.Has(u64tob(uint64(i))); has != (i < n) {
+				t.Fatalf("unexpected Has(%d): %v", i, has)
+			}
+		}
+	}
+
+	lookup(old, 1000)
+	if n := old.Stats().BloomSkip; n != 0 {
+		t.Fatalf("unexpected skips before the filter was built: %d", n)
+	}
+
+	check := func() {
+		if err := db.View(func(tx *bolt.Tx) error {
+			lookup(tx, 1001)
+			if n := tx.Stats().BloomSkip; n < 900 {
+				t.Fatalf("expected most missing keys to be skipped: %d", n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check()
+
+	// Keys put after the filter was built are found.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(5000), []byte("value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if !tx.Bucket([]byte("widgets")).Has(u64tob(5000)) {
+			t.Fatal("expected key put after the filter was built")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved filter is used after reopening without another commit.
+	if err := old.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+// Ensure that a prefix bloom filter lets ForEachPrefix skip empty prefixes.
+func TestDB_SetPrefixBloomFilter(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	defer os.Remove(db.Path() + ".bloom")
+	db.SetPrefixBloomFilter([]byte("tenants"), 4, 10)
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("tenants"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			for j := 0; j < 10; j++ {
+				if err := b.Put([]byte(fmt.Sprintf("t%03d/%d", i, j)), []byte("value")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(b *bolt.Bucket, prefix string) int {
+		var n int
+		if err := b.ForEachPrefix([]byte(prefix), func(k, v []byte) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tenants"))
+		if n := count(b, "t042/"); n != 10 {
+			t.Fatalf("unexpected count: %d", n)
+		} else if n := count(b, "t04"); n != 10*10 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		for i := 100; i < 1100; i++ {
+			if n := count(b, fmt.Sprintf("t%03d/", i)); n != 0 {
+				t.Fatalf("unexpected count for tenant %d: %d", i, n)
+			}
+		}
+		if n := tx.Stats().BloomSkip; n < 900 {
+			t.Fatalf("expected most empty prefixes to be skipped: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys put after the filter was built are found.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("tenants")).Put([]byte("t500/0"), []byte("value"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := count(tx.Bucket([]byte("tenants")), "t500/"); n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The saved filter is used after reopening.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := count(tx.Bucket([]byte("tenants")), "t777/"); n != 0 {
+			t.Fatalf("unexpected count: %d", n)
+		} else if n := tx.Stats().BloomSkip; n != 1 {
+			t.Fatalf("expected the empty prefix to be skipped: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,10 +1,42 @@
 package bolt
 
 import (
+	"os"
 	"syscall"
 )
 
+// Filesystem magic numbers from statfs(2) for network filesystems.
+var networkFSTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x5346414f: "afs",
+	0x73757245: "coda",
+	0x01021997: "9p",
+}
+
 // fdatasync flushes written data to a file descriptor.
 func fdatasync(db *DB) error {
 	return syscall.Fdatasync(int(db.file.Fd()))
 }
+
+// networkFS returns the filesystem type if the data file is on a network filesystem.
+func networkFS(db *DB) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Fstatfs(int(db.file.Fd()), &st); err != nil {
+		return "", false
+	}
+	fstype, ok := networkFSTypes[uint32(st.Type)]
+	return fstype, ok
+}
+
+// openDirect opens the file at path for reading with O_DIRECT so reads bypass
+// the page cache. Filesystems without direct I/O support use a normal open.
+func openDirect(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if e, ok := err.(*os.PathError); ok && e.Err == syscall.EINVAL {
+		return os.Open(path)
+	}
+	return f, err
+}
@@ -47,7 +47,11 @@ func funlock(db *DB) error {
 // mmap memory maps a DB's data file.
 func mmap(db *DB, sz int) error {
 	// Map the data file to memory.
-	b, err := syscall.Mmap(int(db.file.Fd()), 0, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
+	prot := syscall.PROT_READ
+	if db.mmapWrites {
+		prot |= syscall.PROT_WRITE
+	}
+	b, err := syscall.Mmap(int(db.file.Fd()), 0, sz, prot, syscall.MAP_SHARED|db.MmapFlags)
 	if err != nil {
 		return err
 	}
@@ -79,6 +83,19 @@ func munmap(db *DB) error {
 	return err
 }
 
+// adviseRange advises the kernel how n bytes of the mmap starting at off
+// will be accessed.
+func adviseRange(db *DB, off, n int, h AccessHint) error {
+	advice := syscall.MADV_RANDOM
+	switch h {
+	case HintHot:
+		advice = syscall.MADV_WILLNEED
+	case HintCold:
+		advice = syscall.MADV_DONTNEED
+	}
+	return madvise(db.dataref[off:off+n], advice)
+}
+
 // NOTE: This function is copied from stdlib because it is not available on darwin.
 func madvise(b []byte, advice int) (err error) {
 	_, _, e1 := syscall.Syscall(syscall.SYS_MADVISE, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(advice))
@@ -57,7 +57,11 @@ func funlock(db *DB) error {
 // mmap memory maps a DB's data file.
 func mmap(db *DB, sz int) error {
 	// Map the data file to memory.
-	b, err := unix.Mmap(int(db.file.Fd()), 0, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
+	prot := syscall.PROT_READ
+	if db.mmapWrites {
+		prot |= syscall.PROT_WRITE
+	}
+	b, err := unix.Mmap(int(db.file.Fd()), 0, sz, prot, syscall.MAP_SHARED|db.MmapFlags)
 	if err != nil {
 		return err
 	}
@@ -74,6 +78,24 @@ func mmap(db *DB, sz int) error {
 	return nil
 }
 
+// msyncData flushes writes made through a DB's data mapping to the file.
+func msyncData(db *DB) error {
+	return unix.Msync(db.dataref, unix.MS_SYNC)
+}
+
+// adviseRange advises the kernel how n bytes of the mmap starting at off
+// will be accessed.
+func adviseRange(db *DB, off, n int, h AccessHint) error {
+	advice := syscall.MADV_RANDOM
+	switch h {
+	case HintHot:
+		advice = syscall.MADV_WILLNEED
+	case HintCold:
+		advice = syscall.MADV_DONTNEED
+	}
+	return unix.Madvise(db.dataref[off:off+n], advice)
+}
+
 // munmap unmaps a DB's data file from memory.
 func munmap(db *DB) error {
 	// Ignore the unmap if we have no mapped data.
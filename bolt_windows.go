@@ -129,6 +129,17 @@ func mmap(db *DB, sz int) error {
 	return nil
 }
 
+// msyncData is a no-op since writes through the mapping are not supported
+// on Windows.
+func msyncData(db *DB) error {
+	return nil
+}
+
+// adviseRange is a no-op since Windows has no equivalent of madvise().
+func adviseRange(db *DB, off, n int, h AccessHint) error {
+	return nil
+}
+
 // munmap unmaps a pointer from a file.
 // Based on: https://github.com/edsrzf/mmap-go
 func munmap(db *DB) error {
@@ -0,0 +1,17 @@
+// +build linux,amd64 linux,arm64 linux,ppc64 linux,ppc64le
+
+package bolt
+
+import "syscall"
+
+// fadvDontneed is POSIX_FADV_DONTNEED from fadvise(2).
+const fadvDontneed = 4
+
+// dropCaches evicts the clean pages of the data file from the page cache.
+func dropCaches(db *DB) error {
+	_, _, e1 := syscall.Syscall6(syscall.SYS_FADVISE64, db.file.Fd(), 0, 0, fadvDontneed, 0, 0)
+	if e1 != 0 {
+		return e1
+	}
+	return nil
+}
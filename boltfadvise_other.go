@@ -0,0 +1,8 @@
+// +build !linux !amd64,!arm64,!ppc64,!ppc64le
+
+package bolt
+
+// dropCaches is a no-op since fadvise() is not supported on this platform.
+func dropCaches(db *DB) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package bolt
+
+import "os"
+
+// networkFS returns the filesystem type if the data file is on a network filesystem.
+// Detection is not supported on this platform.
+func networkFS(db *DB) (string, bool) {
+	return "", false
+}
+
+// openDirect opens the file at path for reading. Direct I/O is not supported
+// on this platform so reads go through the page cache.
+func openDirect(path string) (*os.File, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,19 @@
+package bolt
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// sysMsync is the __msync13 system call, which the syscall package does not
+// define on NetBSD.
+const sysMsync = 277
+
+// msyncData flushes writes made through a DB's data mapping to the file.
+func msyncData(db *DB) error {
+	_, _, errno := syscall.Syscall(sysMsync, uintptr(unsafe.Pointer(&db.dataref[0])), uintptr(len(db.dataref)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
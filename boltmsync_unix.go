@@ -0,0 +1,17 @@
+// +build !windows,!plan9,!solaris,!netbsd
+
+package bolt
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// msyncData flushes writes made through a DB's data mapping to the file.
+func msyncData(db *DB) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&db.dataref[0])), uintptr(len(db.dataref)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
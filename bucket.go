@@ -2,7 +2,11 @@ package bolt
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"unsafe"
 )
 
@@ -12,6 +16,9 @@ const (
 
 	// MaxValueSize is the maximum length of a value, in bytes.
 	MaxValueSize = (1 << 31) - 2
+
+	// MaxBucketNameSize is the maximum length of a bucket name, in bytes.
+	MaxBucketNameSize = MaxKeySize
 )
 
 const (
@@ -26,12 +33,33 @@ const bucketHeaderSize = int(unsafe.Sizeof(bucket{}))
 const (
 	minFillPercent = 0.1
 	maxFillPercent = 1.0
+
+	maxRebalancePercent = 0.5
 )
 
 // DefaultFillPercent is the percentage that split pages are filled.
 // This value can be changed by setting Bucket.FillPercent.
 const DefaultFillPercent = 0.5
 
+// DefaultRebalancePercent is the percentage of a page below which nodes are
+// merged with a sibling after deletions. This value can be changed by setting
+// Bucket.RebalancePercent.
+const DefaultRebalancePercent = 0.25
+
+// AccessHint describes how the pages of a bucket are expected to be accessed.
+type AccessHint int
+
+const (
+	// HintNormal restores the default random access advice.
+	HintNormal AccessHint = iota
+
+	// HintHot marks pages as frequently read and starts reading them in.
+	HintHot
+
+	// HintCold marks pages as rarely read so their memory can be reclaimed.
+	HintCold
+)
+
 // Bucket represents a collection of key/value pairs inside the database.
 type Bucket struct {
 	*bucket
@@ -40,6 +68,13 @@ type Bucket struct {
 	page     *page              // inline page reference
 	rootNode *node              // materialized node for the root page.
 	nodes    map[pgid]*node     // node cache
+	name     []byte             // name within the parent bucket
+	parent   *Bucket            // parent bucket, or nil for the root bucket
+	tail     *node              // rightmost leaf cached by AppendLog
+	reads    ReadStats          // pages read by the last Get
+	totals   bucketTotals       // stored key count and data size
+
+	valueSize int // fixed value size, or -1 until it is read
 
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
@@ -47,12 +82,28 @@ type Bucket struct {
 	//
 	// This is non-persisted across transactions so it must be set in every Tx.
 	FillPercent float64
+
+	// Sets the threshold below which nodes that had deletions are merged with
+	// a sibling. By default, nodes filled to less than 25% of a page are
+	// merged. A lower value merges less often, which writes fewer pages in
+	// delete-heavy workloads but leaves more sparse pages in the file. A
+	// higher value keeps pages fuller at the cost of more merging. Values
+	// are capped at 50%. Nodes with too few keys are always merged.
+	//
+	// This is non-persisted across transactions so it must be set in every Tx.
+	RebalancePercent float64
 }
 
 // bucket represents the on-file representation of a bucket.
 // This is stored as the "value" of a bucket key. If the bucket is small enough,
 // then its root page can be stored inline in the "value", after the bucket
 // header. In the case of inline buckets, the "root" will be 0.
+//
+// Headers of top-level buckets are stored in the bucket directory, the root
+// B+tree referenced by the meta page. Its pages carry directoryPageFlag so
+// they can be identified and validated on their own, and new per-bucket
+// metadata belongs in this header. The key count is stored after the header
+// instead; see bucketTotalsSize.
 type bucket struct {
 	root     pgid   // page id of the bucket's root-level page
 	sequence uint64 // monotonically incrementing, used by NextSequence()
@@ -60,7 +111,7 @@ type bucket struct {
 
 // newBucket returns a new bucket associated with a transaction.
 func newBucket(tx *Tx) Bucket {
-	var b = Bucket{tx: tx, FillPercent: DefaultFillPercent}
+	var b = Bucket{tx: tx, valueSize: -1, FillPercent: DefaultFillPercent, RebalancePercent: DefaultRebalancePercent}
 	if tx.writable {
 		b.buckets = make(map[string]*Bucket)
 		b.nodes = make(map[pgid]*node)
@@ -83,6 +134,61 @@ func (b *Bucket) Writable() bool {
 	return b.tx.writable
 }
 
+// path returns the names of the bucket and its parents, starting with the
+// top-level bucket. The root bucket has an empty path.
+func (b *Bucket) path() [][]byte {
+	var path [][]byte
+	for p := b; p.parent != nil; p = p.parent {
+		path = append(path, p.name)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// pathKey returns the key of the settings stored for the bucket at path.
+// Names are length prefixed so that different paths never share a key and
+// the key of a bucket is a prefix of the keys of its nested buckets.
+func pathKey(path [][]byte) string {
+	var buf []byte
+	for _, name := range path {
+		var sz [binary.MaxVarintLen64]byte
+		buf = append(buf, sz[:binary.PutUvarint(sz[:], uint64(len(name)))]...)
+		buf = append(buf, name...)
+	}
+	return string(buf)
+}
+
+// configured returns true if any settings are registered for the bucket at
+// path or its nested buckets.
+func (db *DB) configured(path [][]byte) bool {
+	db.bucketlock.RLock()
+	defer db.bucketlock.RUnlock()
+	if len(path) == 1 {
+		if _, ok := db.bloomSpecs[string(path[0])]; ok {
+			return true
+		}
+	}
+	prefix := pathKey(path)
+	for k := range db.valueSizes {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	for k := range db.tombstones {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	for k := range db.merges {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // Cursor creates a cursor associated with the bucket.
 // The cursor is only valid as long as the transaction is open.
 // Do not use a cursor after the transaction is closed.
@@ -98,37 +204,94 @@ func (b *Bucket) Cursor() *Cursor {
 }
 
 // Bucket retrieves a nested bucket by name.
-// Returns nil if the bucket does not exist.
+// Returns nil if the bucket does not exist or access is denied by DB.Authorize.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	if b.authorize(OpBucket, name) != nil {
+		return nil
+	}
+	return b.child(name)
+}
+
+// child retrieves a nested bucket by name without authorization.
+// Buckets are cached for the rest of the transaction once they are opened.
+func (b *Bucket) child(name []byte) *Bucket {
 	if b.buckets != nil {
 		if child := b.buckets[string(name)]; child != nil {
 			return child
 		}
 	}
 
-	// Move cursor to key.
-	c := b.Cursor()
-	k, v, flags := c.seek(name)
+	// Read-only transactions can reuse the headers of top-level buckets
+	// looked up by earlier transactions on the same committed state.
+	shared := !b.tx.writable && b == &b.tx.root
+	var hdr bucketCacheEntry
+	var ok bool
+	if shared {
+		hdr, ok = b.tx.db.cachedBucket(b.tx.meta.txid, name)
+	}
 
-	// Return nil if the key doesn't exist or it is not a bucket.
-	if !bytes.Equal(name, k) || (flags&bucketLeafFlag) == 0 {
-		return nil
+	var child *Bucket
+	if ok {
+		c := newBucket(b.tx)
+		c.bucket = &hdr.bucket
+		c.totals = hdr.totals
+		c.name = cloneBytes(name)
+		c.parent = b
+		child = &c
+	} else {
+		// Move cursor to key.
+		c := b.Cursor()
+		k, v, flags := c.seek(name)
+
+		// Return nil if the key doesn't exist or it is not a bucket.
+		if !bytes.Equal(name, k) || (flags&bucketLeafFlag) == 0 {
+			return nil
+		}
+
+		child = b.openBucket(v)
+		child.name = cloneBytes(k)
+		if shared && child.root != 0 {
+			b.tx.db.cacheBucket(b.tx.meta.txid, name, bucketCacheEntry{*child.bucket, child.totals})
+		}
 	}
 
-	// Otherwise create a bucket and cache it.
-	var child = b.openBucket(v)
-	if b.buckets != nil {
-		b.buckets[string(name)] = child
+	// Cache the bucket for the rest of the transaction.
+	if b.buckets == nil {
+		b.buckets = make(map[string]*Bucket)
 	}
+	b.buckets[string(name)] = child
 
 	return child
 }
 
+// bucketCount returns the number of buckets directly within the bucket. It
+// is stored with the bucket like the key count. The top-level buckets are
+// counted once per transaction.
+func (b *Bucket) bucketCount() int {
+	return b.loadTotals().bucketN
+}
+
+// HasBucket returns true if a nested bucket exists with the given name.
+func (b *Bucket) HasBucket(name []byte) bool {
+	if b.authorize(OpBucket, name) != nil {
+		return false
+	}
+	if b.buckets != nil {
+		if child := b.buckets[string(name)]; child != nil {
+			return true
+		}
+	}
+
+	k, _, flags := b.Cursor().seek(name)
+	return bytes.Equal(name, k) && (flags&bucketLeafFlag) != 0
+}
+
 // Helper method that re-interprets a sub-bucket value
 // from a parent into a Bucket
 func (b *Bucket) openBucket(value []byte) *Bucket {
 	var child = newBucket(b.tx)
+	child.parent = b
 
 	// If this is a writable transaction then we need to copy the bucket entry.
 	// Read-only transactions can point directly at the mmap entry.
@@ -143,12 +306,14 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 	if child.root == 0 {
 		child.page = (*page)(unsafe.Pointer(&value[bucketHeaderSize]))
 	}
+	child.readTotals(value)
 
 	return &child
 }
 
 // CreateBucket creates a new bucket at the given key and returns the new bucket.
-// Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
+// Returns an error if the key already exists, if the bucket name is blank, if the bucket name is too long,
+// or if the bucket already contains DB.MaxBuckets buckets.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 	if b.tx.db == nil {
@@ -157,14 +322,19 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 		return nil, ErrTxNotWritable
 	} else if len(key) == 0 {
 		return nil, ErrBucketNameRequired
+	} else if len(key) > MaxBucketNameSize {
+		return nil, ErrBucketNameTooLarge
+	} else if err := b.authorize(OpCreateBucket, key); err != nil {
+		return nil, err
 	}
 
 	// Move cursor to correct position.
 	c := b.Cursor()
 	k, _, flags := c.seek(key)
 
-	// Return an error if there is an existing key.
-	if bytes.Equal(key, k) {
+	// Return an error if there is an existing key. Tombstones and expired
+	// keys are replaced.
+	if bytes.Equal(key, k) && (flags&hiddenLeafFlags) == 0 {
 		if (flags & bucketLeafFlag) != 0 {
 			return nil, ErrBucketExists
 		} else {
@@ -172,24 +342,39 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 		}
 	}
 
+	// Enforce the bucket limit, if set.
+	if max := b.tx.db.MaxBuckets; max > 0 && b.bucketCount() >= max {
+		return nil, ErrTooManyBuckets
+	}
+
 	// Create empty, inline bucket.
 	var bucket = Bucket{
-		bucket:      &bucket{},
-		rootNode:    &node{isLeaf: true},
-		FillPercent: DefaultFillPercent,
+		bucket:           &bucket{},
+		rootNode:         &node{isLeaf: true},
+		totals:           bucketTotals{counted: true},
+		FillPercent:      DefaultFillPercent,
+		RebalancePercent: DefaultRebalancePercent,
 	}
 	var value = bucket.write()
 
 	// Insert into node.
 	key = cloneBytes(key)
 	c.node().put(key, key, value, 0, bucketLeafFlag)
+	b.audit(OpCreateBucket, key, nil)
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
 	// to be treated as a regular, non-inline bucket for the rest of the tx.
 	b.page = nil
 
-	return b.Bucket(key), nil
+	// Open the new bucket directly from its value to avoid a second search.
+	var child = b.openBucket(value)
+	child.name = key
+	if b.buckets != nil {
+		b.buckets[string(key)] = child
+	}
+
+	return child, nil
 }
 
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist and returns a reference to it.
@@ -198,7 +383,7 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 	child, err := b.CreateBucket(key)
 	if err == ErrBucketExists {
-		return b.Bucket(key), nil
+		return b.child(key), nil
 	} else if err != nil {
 		return nil, err
 	}
@@ -212,6 +397,8 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 		return ErrTxClosed
 	} else if !b.Writable() {
 		return ErrTxNotWritable
+	} else if err := b.authorize(OpDeleteBucket, key); err != nil {
+		return err
 	}
 
 	// Move cursor to correct position.
@@ -219,15 +406,15 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 	k, _, flags := c.seek(key)
 
 	// Return an error if bucket doesn't exist or is not a bucket.
-	if !bytes.Equal(key, k) {
+	if !bytes.Equal(key, k) || (flags&hiddenLeafFlags) != 0 {
 		return ErrBucketNotFound
 	} else if (flags & bucketLeafFlag) == 0 {
 		return ErrIncompatibleValue
 	}
 
 	// Recursively delete all child buckets.
-	child := b.Bucket(key)
-	err := child.ForEach(func(k, v []byte) error {
+	child := b.child(key)
+	err := child.forEach(func(k, v []byte) error {
 		if v == nil {
 			if err := child.DeleteBucket(k); err != nil {
 				return fmt.Errorf("delete bucket: %s", err)
@@ -249,18 +436,111 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 
 	// Delete the node if we have a matching key.
 	c.node().del(key)
+	b.audit(OpDeleteBucket, key, nil)
+
+	return nil
+}
+
+// RenameBucket moves the nested bucket at oldKey to newKey without copying
+// its contents. A bucket already at newKey is deleted and replaced, so a
+// bucket can be built under a temporary name and then renamed over the live
+// one. The rename is authorized as a delete of oldKey and a create of newKey.
+//
+// Settings such as fixed value sizes, tombstones, merge operators and bloom
+// filters are registered by path and do not follow a renamed bucket, so
+// buckets with settings at or below the old path cannot be renamed and return
+// ErrBucketConfigured. Settings registered for the new path apply to the
+// renamed bucket.
+// Returns an error if the bucket does not exist, if either key holds a
+// non-bucket value, if the new name is blank, or if the new name is too long.
+func (b *Bucket) RenameBucket(oldKey, newKey []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(newKey) == 0 {
+		return ErrBucketNameRequired
+	} else if len(newKey) > MaxBucketNameSize {
+		return ErrBucketNameTooLarge
+	} else if err := b.authorize(OpDeleteBucket, oldKey); err != nil {
+		return err
+	} else if err := b.authorize(OpCreateBucket, newKey); err != nil {
+		return err
+	}
+
+	// Return an error if the bucket doesn't exist or is not a bucket.
+	c := b.Cursor()
+	k, v, flags := c.seek(oldKey)
+	if !bytes.Equal(oldKey, k) || (flags&hiddenLeafFlags) != 0 {
+		return ErrBucketNotFound
+	} else if (flags & bucketLeafFlag) == 0 {
+		return ErrIncompatibleValue
+	} else if bytes.Equal(oldKey, newKey) {
+		return nil
+	} else if b.tx.db.configured(append(b.path(), oldKey)) {
+		return ErrBucketConfigured
+	}
+	value := cloneBytes(v)
+	child := b.child(oldKey)
+
+	// Delete any bucket being replaced. Tombstones and expired keys are
+	// overwritten.
+	k, _, flags = c.seek(newKey)
+	if bytes.Equal(newKey, k) && (flags&hiddenLeafFlags) == 0 {
+		if (flags & bucketLeafFlag) == 0 {
+			return ErrIncompatibleValue
+		} else if err := b.DeleteBucket(newKey); err != nil {
+			return err
+		}
+	}
+
+	// Move the bucket header. A bucket with materialized nodes rewrites its
+	// header under the new name when it is spilled.
+	delete(b.buckets, string(oldKey))
+	c.seek(oldKey)
+	c.node().del(oldKey)
+	newKey = cloneBytes(newKey)
+	c.seek(newKey)
+	c.node().put(newKey, newKey, value, 0, bucketLeafFlag)
+	child.name = newKey
+	b.buckets[string(newKey)] = child
+	b.audit(OpDeleteBucket, oldKey, nil)
+	b.audit(OpCreateBucket, newKey, nil)
+
+	// Dereference the inline page, if it exists, as in CreateBucket.
+	b.page = nil
+
+	// The bloom filters of a replaced top-level bucket do not cover the
+	// renamed bucket's keys, and those of the old name are stale.
+	if b == &b.tx.root {
+		b.tx.db.dropBlooms(newKey)
+		b.tx.db.dropBlooms(oldKey)
+	}
 
 	return nil
 }
 
 // Get retrieves the value for a key in the bucket.
-// Returns a nil value if the key does not exist or if the key is a nested bucket.
+// Returns a nil value if the key does not exist, if the key is a nested bucket
+// or if access is denied by DB.Authorize.
+// Keys stored with an empty value return a non-nil, zero-length value.
 // The returned value is only valid for the life of the transaction.
 func (b *Bucket) Get(key []byte) []byte {
-	k, v, flags := b.Cursor().seek(key)
+	track := b.tx.db != nil && b.tx.db.TrackReads
+	if track {
+		b.reads = ReadStats{}
+	}
+	if b.authorize(OpGet, key) != nil || !b.mayContain(key) {
+		return nil
+	}
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+	if track {
+		b.reads = c.reads
+	}
 
-	// Return nil if this is a bucket.
-	if (flags & bucketLeafFlag) != 0 {
+	// Return nil if this is a bucket, a tombstone or an expired key.
+	if (flags & (bucketLeafFlag | hiddenLeafFlags)) != 0 {
 		return nil
 	}
 
@@ -271,8 +551,52 @@ func (b *Bucket) Get(key []byte) []byte {
 	return v
 }
 
+// ReadStats returns the pages read by the last call to Get on the bucket. A
+// lookup answered by a bloom filter reads no pages. It is only recorded
+// while DB.TrackReads is enabled.
+func (b *Bucket) ReadStats() ReadStats {
+	return b.reads
+}
+
+// Has returns true if the key exists and holds a value rather than a nested
+// bucket. Like Get, it returns false if access is denied by DB.Authorize.
+func (b *Bucket) Has(key []byte) bool {
+	return b.Get(key) != nil
+}
+
+// Floor returns the greatest key less than or equal to key and its value.
+// Returns a nil key if no such key exists or if access is denied by
+// DB.Authorize. Nested buckets are returned with a nil value.
+// The returned key and value are only valid for the life of the transaction.
+func (b *Bucket) Floor(key []byte) ([]byte, []byte) {
+	if b.authorize(OpGet, key) != nil {
+		return nil, nil
+	}
+	c := b.Cursor()
+	k, v := c.Seek(key)
+	if k == nil {
+		return c.Last()
+	} else if !bytes.Equal(k, key) {
+		return c.Prev()
+	}
+	return k, v
+}
+
+// Ceiling returns the smallest key greater than or equal to key and its
+// value. Returns a nil key if no such key exists or if access is denied by
+// DB.Authorize. Nested buckets are returned with a nil value.
+// The returned key and value are only valid for the life of the transaction.
+func (b *Bucket) Ceiling(key []byte) ([]byte, []byte) {
+	if b.authorize(OpGet, key) != nil {
+		return nil, nil
+	}
+	return b.Cursor().Seek(key)
+}
+
 // Put sets the value for a key in the bucket.
 // If the key exist then its previous value will be overwritten.
+// A nil value is stored as an empty value so that Get returns a non-nil,
+// zero-length slice for the key and nil only for missing keys.
 // Supplied value must remain valid for the life of the transaction.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
 func (b *Bucket) Put(key []byte, value []byte) error {
@@ -286,6 +610,8 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 		return ErrKeyTooLarge
 	} else if int64(len(value)) > MaxValueSize {
 		return ErrValueTooLarge
+	} else if err := b.authorize(OpPut, key); err != nil {
+		return err
 	}
 
 	// Move cursor to correct position.
@@ -297,38 +623,179 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 		return ErrIncompatibleValue
 	}
 
+	// Store nil values as empty so they are distinguishable from missing keys.
+	if value == nil {
+		value = []byte{}
+	}
+
 	// Insert into node.
 	key = cloneBytes(key)
 	c.node().put(key, key, value, 0, 0)
+	b.bloomAdd(key)
+	b.audit(OpPut, key, value)
+
+	return nil
+}
+
+// validatePut returns the error Put would return for the key and value
+// without modifying the bucket. Keep in sync with Put.
+func (b *Bucket) validatePut(key []byte, value []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if int64(len(value)) > MaxValueSize {
+		return ErrValueTooLarge
+	} else if err := b.authorize(OpPut, key); err != nil {
+		return err
+	}
+
+	// Return an error if there is an existing key with a bucket value.
+	k, _, flags := b.Cursor().seek(key)
+	if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
+		return ErrIncompatibleValue
+	}
 
 	return nil
 }
 
 // Delete removes a key from the bucket.
 // If the key does not exist then nothing is done and a nil error is returned.
-// Returns an error if the bucket was created from a read-only transaction.
+// If tombstones are enabled for the bucket with DB.SetTombstones then the key
+// is replaced with a tombstone instead of being removed.
+// Returns an error if the bucket was created from a read-only transaction or if the key is blank.
 func (b *Bucket) Delete(key []byte) error {
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
 		return ErrTxNotWritable
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if err := b.authorize(OpDelete, key); err != nil {
+		return err
 	}
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	_, _, flags := c.seek(key)
+	k, _, flags := c.seek(key)
 
 	// Return an error if there is already existing bucket value.
 	if (flags & bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
 
-	// Delete the node if we have a matching key.
-	c.node().del(key)
+	// Delete the node if we have a matching key. Expired keys are removed
+	// without leaving a tombstone.
+	if !bytes.Equal(key, k) {
+		return nil
+	} else if (flags & expiredLeafFlag) != 0 {
+		c.node().del(key)
+		return nil
+	} else if (flags & tombstoneLeafFlag) == 0 {
+		b.audit(OpDelete, key, nil)
+	} else if b.tombstones() {
+		return nil
+	}
+	b.remove(c, key)
 
 	return nil
 }
 
+// CompareAndSwap sets the value for a key only if its current value equals
+// old. A nil old value requires that the key does not exist. Returns
+// ErrConflict if the current value does not match.
+func (b *Bucket) CompareAndSwap(key, old, value []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if err := b.authorize(OpPut, key); err != nil {
+		return err
+	}
+
+	// Move cursor to correct position.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+
+	// Return an error if there is an existing bucket value.
+	exists := bytes.Equal(key, k) && (flags&hiddenLeafFlags) == 0
+	if exists && (flags&bucketLeafFlag) != 0 {
+		return ErrIncompatibleValue
+	}
+
+	if exists != (old != nil) || (exists && !bytes.Equal(v, old)) {
+		return ErrConflict
+	}
+	return b.Put(key, value)
+}
+
+// Swap exchanges the values of two keys. If only one of the keys exists then
+// its value is moved to the other key and it is deleted. Expiry times set
+// with PutTTL move with their values.
+// Returns an error if the bucket was created from a read-only transaction, if
+// either key is blank or too large, or if either key is a nested bucket.
+func (b *Bucket) Swap(key1, key2 []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(key1) == 0 || len(key2) == 0 {
+		return ErrKeyRequired
+	} else if len(key1) > MaxKeySize || len(key2) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if err := b.authorize(OpPut, key1); err != nil {
+		return err
+	} else if err := b.authorize(OpPut, key2); err != nil {
+		return err
+	}
+
+	// Position a cursor on each key.
+	c1, c2 := b.Cursor(), b.Cursor()
+	k1, _, flags1 := c1.seek(key1)
+	k2, _, flags2 := c2.seek(key2)
+	exists1 := bytes.Equal(key1, k1) && (flags1&hiddenLeafFlags) == 0
+	exists2 := bytes.Equal(key2, k2) && (flags2&hiddenLeafFlags) == 0
+	if (exists1 && (flags1&bucketLeafFlag) != 0) || (exists2 && (flags2&bucketLeafFlag) != 0) {
+		return ErrIncompatibleValue
+	} else if bytes.Equal(key1, key2) {
+		return nil
+	}
+
+	// Read the values as stored so that expiry times are kept.
+	_, v1, flags1 := c1.rawKeyValue()
+	_, v2, flags2 := c2.rawKeyValue()
+	switch {
+	case exists1 && exists2:
+		b.swapPut(c1, key1, v2, flags2)
+		b.swapPut(c2, key2, v1, flags1)
+	case exists1:
+		b.audit(OpDelete, key1, nil)
+		b.remove(c1, key1)
+		b.swapPut(c2, key2, v1, flags1)
+	case exists2:
+		b.audit(OpDelete, key2, nil)
+		b.remove(c2, key2)
+		b.swapPut(c1, key1, v2, flags2)
+	}
+
+	return nil
+}
+
+// swapPut stores a value moved by Swap at the cursor's key.
+func (b *Bucket) swapPut(c *Cursor, key, value []byte, flags uint32) {
+	key = cloneBytes(key)
+	c.node().put(key, key, value, 0, flags&expiringLeafFlag)
+	b.bloomAdd(key)
+	v, _ := expiringValue(value, flags)
+	b.audit(OpPut, key, v)
+}
+
 // NextSequence returns an autoincrementing integer for the bucket.
 func (b *Bucket) NextSequence() (uint64, error) {
 	if b.tx.db == nil {
@@ -355,7 +822,139 @@ func (b *Bucket) NextSequence() (uint64, error) {
 func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 	if b.tx.db == nil {
 		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, nil); err != nil {
+		return err
+	}
+	return b.forEach(fn)
+}
+
+// ForEachReverse executes a function for each key/value pair in a bucket in
+// descending key order, so the newest entries of time-ordered keys are
+// visited first. If the provided function returns an error then the
+// iteration is stopped and the error is returned to the caller. The provided
+// function must not modify the bucket; this will result in undefined
+// behavior.
+func (b *Bucket) ForEachReverse(fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, nil); err != nil {
+		return err
 	}
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk executes a function for each key/value pair in a bucket in key order,
+// along with the depth of the leaf page holding it, starting at 0 for the
+// root, and the page's id. The page id is 0 for inline buckets and for pages
+// that the transaction has modified but not yet written. Nested buckets have
+// a nil value. If the provided function returns an error then the walk is
+// stopped and the error is returned to the caller. The provided function must
+// not modify the bucket.
+func (b *Bucket) Walk(fn func(depth int, pgid int, k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, nil); err != nil {
+		return err
+	}
+	return b.walk(b.root, 0, fn)
+}
+
+func (b *Bucket) walk(id pgid, depth int, fn func(depth int, pgid int, k, v []byte) error) error {
+	p, n := b.pageNode(id)
+
+	// Descend into the children of branches.
+	if p != nil && (p.flags&branchPageFlag) != 0 {
+		for i := 0; i < int(p.count); i++ {
+			if err := b.walk(p.branchPageElement(uint16(i)).pgid, depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if n != nil && !n.isLeaf {
+		for _, inode := range n.inodes {
+			if err := b.walk(inode.pgid, depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Visit the elements of the leaf.
+	visit := func(id pgid, k, v []byte, flags uint32) error {
+		if v, flags = expiringValue(v, flags); (flags & hiddenLeafFlags) != 0 {
+			return nil
+		} else if (flags & bucketLeafFlag) != 0 {
+			v = nil
+		}
+		return fn(depth, int(id), k, v)
+	}
+	if n != nil {
+		for _, inode := range n.inodes {
+			if err := visit(n.pgid, inode.key, inode.value, inode.flags); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < int(p.count); i++ {
+		k, v, flags := p.leafElement(uint16(i))
+		if err := visit(p.id, k, v, flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachPrefix executes a function for each key/value pair in a bucket whose
+// key starts with prefix, in key order. If the provided function returns an
+// error then the iteration is stopped and the error is returned to the caller.
+// A prefix bloom filter set with DB.SetPrefixBloomFilter lets a scan of a
+// prefix without keys return immediately.
+func (b *Bucket) ForEachPrefix(prefix []byte, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, prefix); err != nil {
+		return err
+	} else if !b.mayContainPrefix(prefix) {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range executes a function for each key/value pair in a bucket whose key is
+// in the range [start, end), in key order. A nil start begins at the first
+// key and a nil end continues to the last key. If the provided function
+// returns an error then the iteration is stopped and the error is returned
+// to the caller.
+func (b *Bucket) Range(start, end []byte, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, start); err != nil {
+		return err
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(start); k != nil && (end == nil || bytes.Compare(k, end) < 0); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEach executes a function for each key/value pair without authorization.
+func (b *Bucket) forEach(fn func(k, v []byte) error) error {
 	c := b.Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		if err := fn(k, v); err != nil {
@@ -365,6 +964,110 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 	return nil
 }
 
+// ForEachDirect executes a function for each key/value pair in a bucket like
+// ForEach but reads pages from the data file with direct I/O instead of
+// through the mmap. This keeps a scan of a bucket that is larger than memory
+// from evicting the rest of the working set from the page cache. Keys and
+// values are only valid for the duration of the call to fn.
+//
+// Direct I/O is only used on Linux. Writable transactions and inline buckets
+// use ForEach since their data is not all on disk, as do ephemeral databases
+// since their file cannot be opened again by path. Databases whose page size
+// is not a multiple of the logical block size of the device, assumed to be
+// 4KB, also use ForEach since their pages cannot be read with direct I/O.
+func (b *Bucket) ForEachDirect(fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, nil); err != nil {
+		return err
+	} else if b.tx.writable || b.root == 0 || b.tx.db.inmem || b.tx.db.ephemeral {
+		return b.ForEach(fn)
+	} else if b.tx.db.pageSize%directAlign != 0 {
+		return b.ForEach(fn)
+	}
+
+	f, err := openDirect(b.tx.db.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	s := &directScanner{file: f, pageSize: b.tx.db.pageSize}
+	return s.scan(b.root, 0, fn)
+}
+
+// Hint advises the operating system how the pages of the bucket and its
+// nested buckets will be accessed. HintHot starts reading the pages into
+// memory and HintCold releases them from the process so that rarely read
+// buckets do not compete for memory with frequently read ones. The hint
+// only applies to the pages that currently hold the bucket.
+func (b *Bucket) Hint(h AccessHint) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if b.tx.db.inmem {
+		return nil
+	}
+
+	var ids pgids
+	b.hintPages(&ids)
+	sort.Sort(ids)
+
+	// Advise contiguous runs of pages at once, aligned to the OS page size.
+	db := b.tx.db
+	ospage := os.Getpagesize()
+	for i := 0; i < len(ids); {
+		j := i + 1
+		for j < len(ids) && ids[j] <= ids[j-1]+1 {
+			j++
+		}
+		start := int(ids[i]) * db.pageSize
+		end := (int(ids[j-1]) + 1) * db.pageSize
+		start -= start % ospage
+		if end > db.datasz {
+			end = db.datasz
+		}
+		if start < end {
+			if err := adviseRange(db, start, end-start, h); err != nil {
+				return fmt.Errorf("madvise: %s", err)
+			}
+		}
+		i = j
+	}
+	return nil
+}
+
+// hintPages appends the ids of all pages used by the bucket and its nested
+// buckets to ids. Inline buckets live in their parent's page and add nothing.
+func (b *Bucket) hintPages(ids *pgids) {
+	if b.root == 0 {
+		return
+	}
+	b.forEachPage(func(p *page, _ int) {
+		for i := 0; i <= int(p.overflow); i++ {
+			*ids = append(*ids, p.id+pgid(i))
+		}
+	})
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			b.child(k).hintPages(ids)
+		}
+	}
+}
+
+// String returns the string representation of the bucket, including its name,
+// root page and the number of keys directly in the bucket. The top-level
+// bucket of a transaction has an empty name and inline buckets have a root
+// page of zero. Counting keys walks the bucket's pages.
+func (b *Bucket) String() string {
+	if b.tx.db == nil {
+		return fmt.Sprintf("Bucket<%q closed>", b.name)
+	}
+
+	return fmt.Sprintf("Bucket<%q root=%d keys=%d>", b.name, b.root, b.countTotals().keyN)
+}
+
 // Stat returns stats on a bucket.
 func (b *Bucket) Stats() BucketStats {
 	var s, subStats BucketStats
@@ -378,20 +1081,7 @@ func (b *Bucket) Stats() BucketStats {
 			s.KeyN += int(p.count)
 
 			// used totals the used bytes for the page
-			used := pageHeaderSize
-
-			if p.count != 0 {
-				// If page has any elements, add all element headers.
-				used += leafPageElementSize * int(p.count-1)
-
-				// Add all element key, value sizes.
-				// The computation takes advantage of the fact that the position
-				// of the last element's key/value equals to the total of the sizes
-				// of all previous elements' keys and values.
-				// It also includes the last element's header.
-				lastElement := p.leafPageElement(p.count - 1)
-				used += int(lastElement.pos + lastElement.ksize + lastElement.vsize)
-			}
+			used := pageHeaderSize + p.leafInuse()
 
 			if b.root == 0 {
 				// For inlined bucket just update the inline stats
@@ -406,11 +1096,10 @@ func (b *Bucket) Stats() BucketStats {
 				// Do that by iterating over all element headers
 				// looking for the ones with the bucketLeafFlag.
 				for i := uint16(0); i < p.count; i++ {
-					e := p.leafPageElement(i)
-					if (e.flags & bucketLeafFlag) != 0 {
+					if _, v, flags := p.leafElement(i); (flags & bucketLeafFlag) != 0 {
 						// For any bucket element, open the element value
 						// and recursively call Stats on the contained bucket.
-						subStats.Add(b.openBucket(e.value()).Stats())
+						subStats.Add(b.openBucket(v).Stats())
 					}
 				}
 			}
@@ -496,7 +1185,8 @@ func (b *Bucket) _forEachPageNode(pgid pgid, depth int, fn func(*page, *node, in
 // spill writes all the nodes for this bucket to dirty pages.
 func (b *Bucket) spill() error {
 	// Spill all child buckets first.
-	for name, child := range b.buckets {
+	for _, name := range b.childNames() {
+		child := b.buckets[name]
 		// If the child bucket is small enough and it has no child buckets then
 		// write it inline into the parent bucket's page. Otherwise spill it
 		// like a normal bucket and make the parent value a pointer to the page.
@@ -513,6 +1203,7 @@ func (b *Bucket) spill() error {
 			value = make([]byte, unsafe.Sizeof(bucket{}))
 			var bucket = (*bucket)(unsafe.Pointer(&value[0]))
 			*bucket = *child.bucket
+			value = child.appendTotals(value)
 		}
 
 		// Skip writing the bucket if there are no materialized nodes.
@@ -552,6 +1243,25 @@ func (b *Bucket) spill() error {
 	return nil
 }
 
+// pendingPages returns the number of pages the bucket's materialized nodes,
+// and those of its cached subbuckets, will occupy when spilled. Inline
+// buckets are written as part of their parent so they do not add pages.
+func (b *Bucket) pendingPages(root bool) int {
+	var count int
+	for _, child := range b.buckets {
+		count += child.pendingPages(false)
+	}
+	if !root && b.inlineable() {
+		return count
+	}
+
+	pageSize := b.tx.db.pageSize
+	for _, n := range b.nodes {
+		count += (n.size() / pageSize) + 1
+	}
+	return count
+}
+
 // inlineable returns true if a bucket is small enough to be written inline
 // and if it contains no subbuckets. Otherwise returns false.
 func (b *Bucket) inlineable() bool {
@@ -597,17 +1307,44 @@ func (b *Bucket) write() []byte {
 	var p = (*page)(unsafe.Pointer(&value[bucketHeaderSize]))
 	n.write(p)
 
-	return value
+	return b.appendTotals(value)
 }
 
 // rebalance attempts to balance all nodes.
 func (b *Bucket) rebalance() {
-	for _, n := range b.nodes {
-		n.rebalance()
+	if b.tx.db.Deterministic {
+		ids := make(pgids, 0, len(b.nodes))
+		for id := range b.nodes {
+			ids = append(ids, id)
+		}
+		sort.Sort(ids)
+		for _, id := range ids {
+			// Nodes merged into a sibling are removed as we go.
+			if n := b.nodes[id]; n != nil {
+				n.rebalance()
+			}
+		}
+	} else {
+		for _, n := range b.nodes {
+			n.rebalance()
+		}
 	}
-	for _, child := range b.buckets {
-		child.rebalance()
+	for _, name := range b.childNames() {
+		b.buckets[name].rebalance()
+	}
+}
+
+// childNames returns the names of the cached child buckets. They are sorted
+// in deterministic mode so that pages are allocated in a stable order.
+func (b *Bucket) childNames() []string {
+	names := make([]string, 0, len(b.buckets))
+	for name := range b.buckets {
+		names = append(names, name)
+	}
+	if b.tx.db.Deterministic {
+		sort.Strings(names)
 	}
+	return names
 }
 
 // node creates a node from a page and associates it with a given parent.
@@ -8,10 +8,13 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -143,6 +146,51 @@ func TestBucket_Put(t *testing.T) {
 	}
 }
 
+// Ensure that empty values are distinguishable from missing keys.
+func TestBucket_Put_EmptyValue(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	check := func(b *bolt.Bucket) {
+		for _, k := range []string{"empty", "nil"} {
+			if v := b.Get([]byte(k)); v == nil {
+				t.Fatalf("expected non-nil value for %q", k)
+			} else if len(v) != 0 {
+				t.Fatalf("unexpected value for %q: %q", k, v)
+			}
+		}
+		if v := b.Get([]byte("missing")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if k, v := b.Cursor().Seek([]byte("nil")); string(k) != "nil" || v == nil {
+			t.Fatalf("unexpected cursor value: %q=%v", k, v)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("empty"), []byte{}); err != nil {
+			t.Fatal(err)
+		} else if err := b.Put([]byte("nil"), nil); err != nil {
+			t.Fatal(err)
+		}
+		check(b)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx.Bucket([]byte("widgets")))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a bucket can rewrite a key in the same transaction.
 func TestBucket_Put_Repeat(t *testing.T) {
 	db := MustOpenDB()
@@ -512,6 +560,62 @@ func TestBucket_Nested(t *testing.T) {
 	}
 }
 
+// Ensure that nil and empty keys are rejected by Put and Delete and are never found by Get.
+func TestBucket_EmptyKey(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range [][]byte{nil, {}} {
+			if err := b.Put(key, []byte("bar")); err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected put error: %v", err)
+			} else if err := b.Delete(key); err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected delete error: %v", err)
+			} else if v := b.Get(key); v != nil {
+				t.Fatalf("unexpected value: %q", v)
+			} else if err := b.PutTTL(key, []byte("bar"), time.Hour); err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected put ttl error: %v", err)
+			} else if err := b.CompareAndSwap(key, nil, []byte("bar")); err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected compare and swap error: %v", err)
+			} else if err := b.Swap(key, []byte("foo")); err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected swap error: %v", err)
+			} else if err := b.Merge(key, []byte("bar")); err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected merge error: %v", err)
+			}
+			if err := tx.PutAll(map[string][]bolt.KV{"widgets": {{Key: key, Value: []byte("x")}}}); err == nil {
+				t.Fatal("expected error")
+			} else if e, ok := err.(*bolt.PutError); !ok || e.Err != bolt.ErrKeyRequired {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure the DB convenience methods surface the same error.
+	if err := db.UpdateBucket([]byte("widgets"), func(b *bolt.Bucket) error {
+		return b.Delete(nil)
+	}); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := db.Get([]byte("widgets"), nil); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected get error: %v", err)
+	} else if err := db.Put([]byte("widgets"), nil, []byte("bar")); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected put error: %v", err)
+	} else if err := db.Delete([]byte("widgets"), nil); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected delete error: %v", err)
+	} else if err := db.Merge([]byte("widgets"), nil, []byte("bar")); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected merge error: %v", err)
+	}
+}
+
 // Ensure that deleting a bucket using Delete() returns an error.
 func TestBucket_Delete_Bucket(t *testing.T) {
 	db := MustOpenDB()
@@ -782,6 +886,332 @@ func TestBucket_DeleteBucket_IncompatibleValue(t *testing.T) {
 	}
 }
 
+// Ensure that a bucket can be renamed over an existing bucket.
+func TestBucket_RenameBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetBloomFilter([]byte("live"), 10)
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		live, err := tx.CreateBucket([]byte("live"))
+		if err != nil {
+			return err
+		} else if err := live.Put([]byte("old"), []byte("x")); err != nil {
+			return err
+		} else if _, err := live.CreateBucket([]byte("sub")); err != nil {
+			return err
+		}
+
+		tmp, err := tx.CreateBucket([]byte("tmp"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := tmp.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		sub, err := tmp.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		}
+		return sub.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.RenameBucket([]byte("tmp"), []byte("live"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("tmp")) != nil {
+			t.Fatal("expected old name to be removed")
+		}
+		b := tx.Bucket([]byte("live"))
+		if v := b.Get([]byte("old")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get(u64tob(999)); len(v) != 100 {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Bucket([]byte("sub")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket modified in the same transaction keeps its changes
+// when it is renamed.
+func TestBucket_RenameBucket_Dirty(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		sub, err := b.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		} else if err := sub.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		} else if err := b.RenameBucket([]byte("a"), []byte("b")); err != nil {
+			return err
+		} else if err := sub.Put([]byte("baz"), []byte("bat")); err != nil {
+			return err
+		}
+		if b.Bucket([]byte("a")) != nil {
+			t.Fatal("expected old name to be removed")
+		} else if b.Bucket([]byte("b")) != sub {
+			t.Fatal("expected renamed bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		sub := tx.Bucket([]byte("widgets")).Bucket([]byte("b"))
+		if v := sub.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := sub.Get([]byte("baz")); !bytes.Equal(v, []byte("bat")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that renaming a missing bucket or a value returns an error.
+func TestBucket_RenameBucket_Errors(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			return err
+		} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+
+		if err := b.RenameBucket([]byte("missing"), []byte("x")); err != bolt.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.RenameBucket([]byte("foo"), []byte("x")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.RenameBucket([]byte("sub"), []byte("foo")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.RenameBucket([]byte("sub"), nil); err != bolt.ErrBucketNameRequired {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.RenameBucket([]byte("sub"), []byte("sub")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !b.HasBucket([]byte("sub")) {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if err := tx.RenameBucket([]byte("widgets"), []byte("x")); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that buckets with settings registered for their path, or the path of
+// a nested bucket, cannot be renamed.
+func TestBucket_RenameBucket_Configured(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("sub"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rename := func(path ...[]byte) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(path[0])
+			if len(path) == 1 {
+				return tx.RenameBucket(path[0], []byte("x"))
+			}
+			return b.RenameBucket(path[1], []byte("x"))
+		})
+	}
+	for _, set := range []func(enable bool){
+		func(enable bool) { db.SetTombstones(enable, []byte("widgets"), []byte("sub")) },
+		func(enable bool) {
+			size := 0
+			if enable {
+				size = 8
+			}
+			db.SetFixedValueSize(size, []byte("widgets"), []byte("sub"))
+		},
+		func(enable bool) {
+			var fn bolt.MergeFunc
+			if enable {
+				fn = bolt.MergeAdd
+			}
+			db.RegisterMerge(fn, []byte("widgets"), []byte("sub"))
+		},
+	} {
+		set(true)
+		if err := rename([]byte("widgets")); err != bolt.ErrBucketConfigured {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := rename([]byte("widgets"), []byte("sub")); err != bolt.ErrBucketConfigured {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		set(false)
+	}
+
+	db.SetBloomFilter([]byte("widgets"), 10)
+	if err := rename([]byte("widgets")); err != bolt.ErrBucketConfigured {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := rename([]byte("widgets"), []byte("sub")); err != nil {
+		t.Fatal(err)
+	}
+	db.SetBloomFilter([]byte("widgets"), 0)
+	if err := rename([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a value is only swapped when it matches the expected value.
+func TestBucket_CompareAndSwap(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.CompareAndSwap([]byte("foo"), nil, []byte("bar")); err != nil {
+			t.Fatal(err)
+		} else if err := b.CompareAndSwap([]byte("foo"), nil, []byte("baz")); err != bolt.ErrConflict {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.CompareAndSwap([]byte("foo"), []byte("baz"), []byte("bat")); err != bolt.ErrConflict {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.CompareAndSwap([]byte("foo"), []byte("bar"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		} else if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bat")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if err := b.CompareAndSwap([]byte("missing"), []byte("bar"), []byte("baz")); err != bolt.ErrConflict {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			t.Fatal(err)
+		} else if err := b.CompareAndSwap([]byte("sub"), nil, []byte("bar")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Swap exchanges or moves the values of two keys.
+func TestBucket_Swap(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), u64tob(uint64(i))); err != nil {
+				return err
+			}
+		}
+		if err := b.PutTTL([]byte("ttl"), []byte("x"), time.Hour); err != nil {
+			return err
+		} else if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			return err
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		// Keys on different pages are exchanged.
+		if err := b.Swap(u64tob(0), u64tob(999)); err != nil {
+			return err
+		} else if v := b.Get(u64tob(0)); !bytes.Equal(v, u64tob(999)) {
+			t.Fatalf("unexpected value: %x", v)
+		} else if v := b.Get(u64tob(999)); !bytes.Equal(v, u64tob(0)) {
+			t.Fatalf("unexpected value: %x", v)
+		}
+
+		// A value is moved to a missing key along with its expiry time.
+		if err := b.Swap([]byte("ttl"), []byte("moved")); err != nil {
+			return err
+		} else if b.Get([]byte("ttl")) != nil {
+			t.Fatal("expected key to be deleted")
+		} else if v := b.Get([]byte("moved")); !bytes.Equal(v, []byte("x")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if b.ExpiresAt([]byte("moved")).IsZero() {
+			t.Fatal("expected expiry time")
+		}
+
+		// Missing keys and identical keys are left alone.
+		if err := b.Swap([]byte("a"), []byte("b")); err != nil {
+			return err
+		} else if err := b.Swap(u64tob(1), u64tob(1)); err != nil {
+			return err
+		} else if v := b.Get(u64tob(1)); !bytes.Equal(v, u64tob(1)) {
+			t.Fatalf("unexpected value: %x", v)
+		}
+
+		if err := b.Swap([]byte("sub"), u64tob(1)); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.Swap(nil, u64tob(1)); err != bolt.ErrKeyRequired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get(u64tob(0)); !bytes.Equal(v, u64tob(999)) {
+			t.Fatalf("unexpected value: %x", v)
+		} else if err := b.Swap(u64tob(0), u64tob(1)); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a bucket can return an autoincrementing sequence.
 func TestBucket_NextSequence(t *testing.T) {
 	db := MustOpenDB()
@@ -1027,40 +1457,529 @@ func TestBucket_ForEach_Closed(t *testing.T) {
 	}
 }
 
-// Ensure that an error is returned when inserting with an empty key.
-func TestBucket_Put_EmptyKey(t *testing.T) {
+// Ensure that ForEachDirect visits the same pairs as ForEach.
+func TestBucket_ForEachDirect(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
-			t.Fatal(err)
+			return err
 		}
-		if err := b.Put([]byte(""), []byte("bar")); err != bolt.ErrKeyRequired {
-			t.Fatalf("unexpected error: %s", err)
+		for i := 0; i < 10000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
 		}
-		if err := b.Put(nil, []byte("bar")); err != bolt.ErrKeyRequired {
-			t.Fatalf("unexpected error: %s", err)
+		// Include overflow pages and a nested bucket.
+		if err := b.Put([]byte("large"), make([]byte, 20000)); err != nil {
+			return err
 		}
-		return nil
+		_, err = b.CreateBucket([]byte("sub"))
+		return err
 	}); err != nil {
 		t.Fatal(err)
 	}
-}
 
-// Ensure that an error is returned when inserting with a key that's too large.
-func TestBucket_Put_KeyTooLarge(t *testing.T) {
-	db := MustOpenDB()
-	defer db.MustClose()
-	if err := db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucket([]byte("widgets"))
+	type kv struct{ k, v string }
+	collect := func(tx *bolt.Tx, direct bool) []kv {
+		var a []kv
+		fn := func(k, v []byte) error {
+			a = append(a, kv{string(k), string(v)})
+			return nil
+		}
+		b := tx.Bucket([]byte("widgets"))
+		var err error
+		if direct {
+			err = b.ForEachDirect(fn)
+		} else {
+			err = b.ForEach(fn)
+		}
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put(make([]byte, 32769), []byte("bar")); err != bolt.ErrKeyTooLarge {
-			t.Fatalf("unexpected error: %s", err)
-		}
+		return a
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		exp, got := collect(tx, false), collect(tx, true)
+		if len(exp) != 10002 {
+			t.Fatalf("unexpected count: %d", len(exp))
+		} else if !reflect.DeepEqual(exp, got) {
+			t.Fatal("ForEachDirect does not match ForEach")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from fn stop the scan.
+	errDone := errors.New("done")
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEachDirect(func(k, v []byte) error {
+			return errDone
+		})
+	}); err != errDone {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that Walk visits every pair in order with its depth and page.
+func TestBucket_Walk(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		_, err = b.CreateBucket([]byte("sub"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(tx *bolt.Tx) {
+		var keys []string
+		var maxDepth int
+		pages := make(map[int]bool)
+		if err := tx.Bucket([]byte("widgets")).Walk(func(depth int, pgid int, k, v []byte) error {
+			if bytes.Equal(k, []byte("sub")) {
+				if v != nil {
+					t.Fatalf("unexpected value for nested bucket: %x", v)
+				}
+			} else if len(v) != 100 {
+				t.Fatalf("unexpected value length: %d", len(v))
+			}
+			keys = append(keys, string(k))
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+			pages[pgid] = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 1001 {
+			t.Fatalf("unexpected count: %d", len(keys))
+		} else if !sort.StringsAreSorted(keys) {
+			t.Fatal("keys not in order")
+		} else if maxDepth == 0 {
+			t.Fatal("expected leaves below the root")
+		} else if len(pages) < 2 {
+			t.Fatalf("unexpected page count: %d", len(pages))
+		} else if pages[0] {
+			t.Fatal("unexpected zero page id")
+		}
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		walk(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Walking a bucket with modified nodes visits the same pairs.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).Put([]byte("00500"), make([]byte, 100)); err != nil {
+			return err
+		}
+		var n int
+		if err := tx.Bucket([]byte("widgets")).Walk(func(depth int, pgid int, k, v []byte) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if n != 1001 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from fn stop the walk.
+	errDone := errors.New("done")
+	if err := db.View(func(tx *bolt.Tx) error {
+		var n int
+		err := tx.Bucket([]byte("widgets")).Walk(func(depth int, pgid int, k, v []byte) error {
+			n++
+			return errDone
+		})
+		if n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return err
+	}); err != errDone {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that ForEachReverse visits every pair in descending key order.
+func TestBucket_ForEachReverse(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), u64tob(uint64(i))); err != nil {
+				return err
+			}
+		}
+		if _, err := b.CreateBucket(u64tob(1000)); err != nil {
+			return err
+		}
+		return b.Delete(u64tob(500))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		exp := uint64(1000)
+		if err := tx.Bucket([]byte("widgets")).ForEachReverse(func(k, v []byte) error {
+			if exp == 500 {
+				exp--
+			}
+			if got := binary.BigEndian.Uint64(k); got != exp {
+				t.Fatalf("unexpected key: %d, expected %d", got, exp)
+			} else if exp == 1000 && v != nil {
+				t.Fatalf("unexpected value for nested bucket: %x", v)
+			} else if exp < 1000 && !bytes.Equal(v, k) {
+				t.Fatalf("unexpected value: %x", v)
+			}
+			exp--
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if exp != ^uint64(0) {
+			t.Fatalf("stopped early at %d", exp)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from fn stop the iteration.
+	errDone := errors.New("done")
+	if err := db.View(func(tx *bolt.Tx) error {
+		var n int
+		err := tx.Bucket([]byte("widgets")).ForEachReverse(func(k, v []byte) error {
+			n++
+			return errDone
+		})
+		if n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return err
+	}); err != errDone {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that Range visits the keys between its bounds in order.
+func TestBucket_Range(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	scan := func(b *bolt.Bucket, start, end string) string {
+		var startb, endb []byte
+		if start != "" {
+			startb = []byte(start)
+		}
+		if end != "" {
+			endb = []byte(end)
+		}
+		var keys []string
+		if err := b.Range(startb, endb, func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(keys, ",")
+	}
+	check := func(b *bolt.Bucket) {
+		for _, tt := range []struct{ start, end, exp string }{
+			{"b", "d", "b,c"},
+			{"bb", "cc", "c"},
+			{"", "c", "a,b"},
+			{"c", "", "c,d,e"},
+			{"", "", "a,b,c,d,e"},
+			{"c", "c", ""},
+			{"d", "b", ""},
+			{"f", "", ""},
+		} {
+			if got := scan(b, tt.start, tt.end); got != tt.exp {
+				t.Fatalf("[%s, %s): unexpected keys: %s", tt.start, tt.end, got)
+			}
+		}
+	}
+
+	// Ranges work in writable transactions before and after a commit.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c", "d", "e"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				return err
+			}
+		}
+		check(b)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx.Bucket([]byte("widgets")))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors from fn stop the scan.
+	errDone := errors.New("done")
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Range(nil, nil, func(k, v []byte) error {
+			return errDone
+		})
+	}); err != errDone {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that Floor and Ceiling return the nearest keys around a key.
+func TestBucket_FloorCeiling(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"b", "d", "f"} {
+			if err := b.Put([]byte(k), []byte(k+k)); err != nil {
+				return err
+			}
+		}
+		_, err = b.CreateBucket([]byte("h"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for _, tt := range []struct {
+			key, floor, ceiling string
+		}{
+			{"a", "", "b"},
+			{"b", "b", "b"},
+			{"c", "b", "d"},
+			{"e", "d", "f"},
+			{"f", "f", "f"},
+			{"g", "f", "h"},
+			{"i", "h", ""},
+		} {
+			k, v := b.Floor([]byte(tt.key))
+			if string(k) != tt.floor {
+				t.Fatalf("unexpected floor for %s: %s", tt.key, k)
+			} else if k != nil && k[0] != 'h' && string(v) != tt.floor+tt.floor {
+				t.Fatalf("unexpected floor value for %s: %s", tt.key, v)
+			}
+			k, v = b.Ceiling([]byte(tt.key))
+			if string(k) != tt.ceiling {
+				t.Fatalf("unexpected ceiling for %s: %s", tt.key, k)
+			} else if k != nil && k[0] != 'h' && string(v) != tt.ceiling+tt.ceiling {
+				t.Fatalf("unexpected ceiling value for %s: %s", tt.key, v)
+			}
+		}
+
+		// Nested buckets have no value.
+		if k, v := b.Floor([]byte("z")); string(k) != "h" || v != nil {
+			t.Fatalf("unexpected floor: %s=%s", k, v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that access hints can be applied to buckets of any size.
+func TestBucket_Hint(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		archive, err := tx.CreateBucket([]byte("archive"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10000; i++ {
+			if err := archive.Put([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		sub, err := archive.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		} else if err := sub.Put([]byte("large"), make([]byte, 20000)); err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte("index"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("archive")).Hint(bolt.HintCold); err != nil {
+			t.Fatal(err)
+		} else if err := tx.Bucket([]byte("index")).Hint(bolt.HintHot); err != nil {
+			t.Fatal(err)
+		} else if err := tx.Bucket([]byte("archive")).Hint(bolt.HintNormal); err != nil {
+			t.Fatal(err)
+		}
+
+		// Data is still readable after the pages are released.
+		if v := tx.Bucket([]byte("archive")).Bucket([]byte("sub")).Get([]byte("large")); len(v) != 20000 {
+			t.Fatalf("unexpected value length: %d", len(v))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the string representation of a bucket includes its name, root
+// page and key count.
+func TestBucket_String(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+
+		// Modified buckets count keys in their nodes.
+		if s := b.String(); s != `Bucket<"widgets" root=0 keys=1000>` {
+			t.Fatalf("unexpected string: %s", s)
+		}
+
+		inline, err := tx.CreateBucket([]byte("inline"))
+		if err != nil {
+			return err
+		} else if err := inline.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		} else if s := inline.String(); s != `Bucket<"inline" root=0 keys=1>` {
+			t.Fatalf("unexpected string: %s", s)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := tx.Bucket([]byte("widgets"))
+	if s, exp := b.String(), fmt.Sprintf(`Bucket<"widgets" root=%d keys=1000>`, b.Root()); s != exp || b.Root() == 0 {
+		t.Fatalf("unexpected string: %s", s)
+	} else if s := tx.Bucket([]byte("inline")).String(); s != `Bucket<"inline" root=0 keys=1>` {
+		t.Fatalf("unexpected string: %s", s)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if s := b.String(); s != `Bucket<"widgets" closed>` {
+		t.Fatalf("unexpected string: %s", s)
+	}
+}
+
+// Ensure that a bucket's name remains readable after the database is closed.
+func TestBucket_String_Closed(t *testing.T) {
+	db := MustOpenDB()
+	path := db.Path()
+	defer os.Remove(path)
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := tx.Bucket([]byte("widgets"))
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if s := b.String(); s != `Bucket<"widgets" closed>` {
+		t.Fatalf("unexpected string: %s", s)
+	}
+}
+
+// Ensure that an error is returned when inserting with an empty key.
+func TestBucket_Put_EmptyKey(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte(""), []byte("bar")); err != bolt.ErrKeyRequired {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := b.Put(nil, []byte("bar")); err != bolt.ErrKeyRequired {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that an error is returned when inserting with a key that's too large.
+func TestBucket_Put_KeyTooLarge(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put(make([]byte, 32769), []byte("bar")); err != bolt.ErrKeyTooLarge {
+			t.Fatalf("unexpected error: %s", err)
+		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
@@ -1091,6 +2010,76 @@ func TestBucket_Put_ValueTooLarge(t *testing.T) {
 	}
 }
 
+// Ensure that RebalancePercent trades space for pages written when deleting.
+func TestBucket_RebalancePercent(t *testing.T) {
+	run := func(rebalancePercent float64) (leafPageN, written int) {
+		db := MustOpenDB()
+		defer db.MustClose()
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10000; i++ {
+				if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Delete most keys a few at a time, spread across the bucket.
+		before := db.Stats().TxStats
+		for i := 0; i < 100; i++ {
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				b.RebalancePercent = rebalancePercent
+				for j := i; j < 10000; j += 100 {
+					if j%10 == 0 {
+						continue
+					} else if err := b.Delete(u64tob(uint64(j))); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		after := db.Stats().TxStats
+		written = after.Sub(&before).Write
+		db.MustCheck()
+
+		if err := db.View(func(tx *bolt.Tx) error {
+			stats := tx.Bucket([]byte("widgets")).Stats()
+			if stats.KeyN != 1000 {
+				t.Fatalf("unexpected KeyN: %d", stats.KeyN)
+			}
+			leafPageN = stats.LeafPageN
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return leafPageN, written
+	}
+
+	// Higher thresholds leave fewer, fuller pages behind.
+	prev := -1
+	for _, p := range []float64{0, 0.1, bolt.DefaultRebalancePercent, 0.5} {
+		n, w := run(p)
+		t.Logf("rebalance %.2f: %d leaf pages, %d pages written", p, n, w)
+		if prev != -1 && n > prev {
+			t.Fatalf("unexpected leaf pages at %.2f: %d > %d", p, n, prev)
+		}
+		prev = n
+	}
+	if n, _ := run(0); n < 2*prev {
+		t.Fatalf("expected no rebalancing to leave sparse pages: %d < 2*%d", n, prev)
+	}
+}
+
 // Ensure a bucket can calculate stats.
 func TestBucket_Stats(t *testing.T) {
 	db := MustOpenDB()
@@ -1435,12 +2424,12 @@ func TestBucket_Stats_Nested(t *testing.T) {
 		foo := 16            // foo (pghdr)
 		foo += 101 * 16      // foo leaf elements
 		foo += 100*2 + 100*2 // foo leaf key/values
-		foo += 3 + 16        // foo -> bar key/value
+		foo += 3 + 16 + 24   // foo -> bar key/value and totals
 
-		bar := 16      // bar (pghdr)
-		bar += 11 * 16 // bar leaf elements
-		bar += 10 + 10 // bar leaf key/values
-		bar += 3 + 16  // bar -> baz key/value
+		bar := 16          // bar (pghdr)
+		bar += 11 * 16     // bar leaf elements
+		bar += 10 + 10     // bar leaf key/values
+		bar += 3 + 16 + 24 // bar -> baz key/value and totals
 
 		baz := 16      // baz (inline) (pghdr)
 		baz += 10 * 16 // baz leaf elements
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
@@ -46,6 +54,9 @@ var (
 	// divided by the iteration count.
 	ErrNonDivisibleBatchSize = errors.New("number of iterations must be divisible by the batch size")
 
+	// ErrBucketRequired is returned when a required bucket name is not specified.
+	ErrBucketRequired = errors.New("bucket required")
+
 	// ErrPageIDRequired is returned when a required page id is not specified.
 	ErrPageIDRequired = errors.New("page id required")
 
@@ -102,10 +113,16 @@ func (m *Main) Run(args ...string) error {
 		return newBenchCommand(m).Run(args[1:]...)
 	case "check":
 		return newCheckCommand(m).Run(args[1:]...)
+	case "convert":
+		return newConvertCommand(m).Run(args[1:]...)
 	case "dump":
 		return newDumpCommand(m).Run(args[1:]...)
+	case "export":
+		return newExportCommand(m).Run(args[1:]...)
 	case "info":
 		return newInfoCommand(m).Run(args[1:]...)
+	case "keys":
+		return newKeysCommand(m).Run(args[1:]...)
 	case "page":
 		return newPageCommand(m).Run(args[1:]...)
 	case "pages":
@@ -130,7 +147,10 @@ The commands are:
 
     bench       run synthetic benchmark against bolt
     check       verifies integrity of bolt database
+    convert     copy a database into another format
+    export      export a database or bucket
     info        print basic info
+    keys        print keys of a bucket that match a filter
     help        print this screen
     pages       print list of pages with their types
     stats       iterate over all pages and generate usage stats
@@ -410,6 +430,412 @@ Dump prints a hexidecimal dump of a single page.
 `, "\n")
 }
 
+// ExportCommand represents the "export" command execution.
+type ExportCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newExportCommand returns a ExportCommand.
+func newExportCommand(m *Main) *ExportCommand {
+	return &ExportCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ExportCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	format := fs.String("format", "binary", "")
+	name := fs.String("bucket", "", "")
+	hex := fs.Bool("hex", false, "")
+	header := fs.Bool("header", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Validate the format before opening the database.
+	switch *format {
+	case "binary":
+	case "csv":
+		if *name == "" {
+			return ErrBucketRequired
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	// Open the database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *format == "binary" {
+		_, err := db.Export(cmd.Stdout)
+		return err
+	}
+
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(*name))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return b.ExportCSV(cmd.Stdout, &bolt.CSVOptions{Hex: *hex, Header: *header})
+	})
+}
+
+// Usage returns the help message.
+func (cmd *ExportCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt export [-format binary|csv] [-bucket NAME] [-hex] [-header] PATH
+
+Export writes the contents of the Bolt database at PATH to STDOUT.
+
+The default binary format writes every bucket and key in a compact stream
+that can be loaded with DB.Import. The csv format writes one key,value row
+for each key in the bucket given by -bucket. Nested buckets are skipped.
+
+Additional options include:
+
+	-format FORMAT
+		Output format: "binary" (default) or "csv".
+	-bucket NAME
+		Bucket to export. Required for the csv format.
+	-hex
+		Hex encode keys and values in csv output.
+	-header
+		Write a "key,value" header row in csv output.
+`, "\n")
+}
+
+// ConvertCommand represents the "convert" command execution.
+type ConvertCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newConvertCommand returns a ConvertCommand.
+func newConvertCommand(m *Main) *ConvertCommand {
+	return &ConvertCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ConvertCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	to := fs.String("to", "", "")
+	batchSize := fs.Int("batch-size", 1000, "")
+	sqlite := fs.String("sqlite3", "sqlite3", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database and output paths.
+	path, out := fs.Arg(0), fs.Arg(1)
+	if path == "" || out == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("output file already exists: %s", out)
+	} else if *batchSize <= 0 {
+		return fmt.Errorf("invalid batch size: %d", *batchSize)
+	}
+
+	switch *to {
+	case "sqlite":
+	case "":
+		return errors.New("target format required")
+	default:
+		return fmt.Errorf("unknown target format: %s", *to)
+	}
+
+	// Open the database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Write to a temporary file next to the output and rename it into place
+	// so that a failed conversion does not leave a partial file behind.
+	f, err := ioutil.TempFile(filepath.Dir(out), filepath.Base(out)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := convertSQLite(db, *sqlite, tmp, *batchSize, cmd.Stdout, cmd.Stderr); err != nil {
+		os.Remove(tmp)
+		os.Remove(tmp + "-journal")
+		return err
+	}
+	return os.Rename(tmp, out)
+}
+
+// convertSQLite streams the SQL for db into the sqlite3 shell at sqlite,
+// which writes it to the file at path, so no driver is required.
+func convertSQLite(db *bolt.DB, sqlite, path string, n int, stdout, stderr io.Writer) error {
+	c := exec.Command(sqlite, "-bail", path)
+	c.Stdout, c.Stderr = stdout, stderr
+	w, err := c.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		return writeSQL(w, tx, n)
+	})
+	if e := w.Close(); e != nil && err == nil {
+		err = e
+	}
+	if e := c.Wait(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// writeSQL writes SQL statements that create one table for each bucket in tx
+// and insert its keys in batches of n rows. Nested buckets are written to
+// tables named by their full path joined with "/". Returns an error if two
+// buckets map to the same table name, such as a top-level bucket named "a/b"
+// and bucket "b" nested in "a".
+func writeSQL(w io.Writer, tx *bolt.Tx, n int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "BEGIN;")
+
+	tables := make(map[string]bool)
+
+	var walk func(path string, b *bolt.Bucket) error
+	walk = func(path string, b *bolt.Bucket) error {
+		key := foldSQLName(path)
+		if tables[key] {
+			return fmt.Errorf("table name collision: %q", path)
+		}
+		tables[key] = true
+		table := quoteSQLName(path)
+		fmt.Fprintf(bw, "CREATE TABLE %s (key BLOB PRIMARY KEY, value BLOB NOT NULL);\n", table)
+
+		var rows int
+		var children [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				children = append(children, k)
+				return nil
+			}
+			if rows%n == 0 {
+				if rows > 0 {
+					fmt.Fprintln(bw, ";")
+				}
+				fmt.Fprintf(bw, "INSERT INTO %s (key, value) VALUES\n", table)
+			} else {
+				fmt.Fprintln(bw, ",")
+			}
+			rows++
+			_, err := fmt.Fprintf(bw, "(X'%x', X'%x')", k, v)
+			return err
+		}); err != nil {
+			return err
+		}
+		if rows > 0 {
+			fmt.Fprintln(bw, ";")
+		}
+
+		for _, k := range children {
+			if err := walk(path+"/"+string(k), b.Bucket(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return walk(string(name), b)
+	}); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(bw, "COMMIT;")
+	return bw.Flush()
+}
+
+// foldSQLName returns name with ASCII letters lowercased, as SQLite compares
+// table names.
+func foldSQLName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// quoteSQLName returns name as a quoted SQL identifier.
+func quoteSQLName(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Usage returns the help message.
+func (cmd *ConvertCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt convert -to sqlite [-batch-size N] [-sqlite3 PATH] PATH OUT
+
+Convert copies the Bolt database at PATH into a new file at OUT in another
+database format. The sqlite format creates one table for each bucket with
+blob "key" and "value" columns. Nested buckets are written to tables named
+by their full path joined with "/", and the conversion fails if two buckets
+map to the same table name. The sqlite3 command line shell is used to write
+the file, and no file is left at OUT if the conversion fails.
+
+Additional options include:
+
+	-to FORMAT
+		Target format. Only "sqlite" is supported.
+	-batch-size N
+		Number of rows inserted by each statement. Defaults to 1000.
+	-sqlite3 PATH
+		Path to the sqlite3 shell. Defaults to "sqlite3".
+`, "\n")
+}
+
+// KeysCommand represents the "keys" command execution.
+type KeysCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newKeysCommand returns a KeysCommand.
+func newKeysCommand(m *Main) *KeysCommand {
+	return &KeysCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *KeysCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	pattern := fs.String("regex", "", "")
+	glob := fs.String("glob", "", "")
+	minSize := fs.Int("min-size", 0, "")
+	maxSize := fs.Int("max-size", 0, "")
+	values := fs.Bool("values", false, "")
+	hex := fs.Bool("hex", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path and bucket.
+	path, name := fs.Arg(0), fs.Arg(1)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if name == "" {
+		return ErrBucketRequired
+	}
+
+	options := &bolt.MatchOptions{Glob: *glob, MinValueSize: *minSize, MaxValueSize: *maxSize}
+	if *pattern != "" {
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			return err
+		}
+		options.Regexp = re
+	}
+
+	// Open the database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	format := func(p []byte) string {
+		if *hex {
+			return fmt.Sprintf("%x", p)
+		}
+		return string(p)
+	}
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return b.ForEachMatch(options, func(k, v []byte) error {
+			if *values {
+				_, err := fmt.Fprintf(cmd.Stdout, "%s\t%s\n", format(k), format(v))
+				return err
+			}
+			_, err := fmt.Fprintln(cmd.Stdout, format(k))
+			return err
+		})
+	})
+}
+
+// Usage returns the help message.
+func (cmd *KeysCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt keys [-regex PATTERN] [-glob PATTERN] [-min-size N] [-max-size N] [-values] [-hex] PATH BUCKET
+
+Keys prints the keys of the bucket BUCKET in the Bolt database at PATH that
+match every given filter, one per line. Filters are applied while reading the
+database and patterns that start with literal text only read matching keys.
+
+Additional options include:
+
+	-regex PATTERN
+		Print keys matching the regular expression. Anchor the pattern
+		with ^ to only read keys with its literal prefix.
+	-glob PATTERN
+		Print keys matching the glob pattern. '*' does not match '/'.
+	-min-size N
+		Print keys whose value is at least N bytes. Skips nested buckets.
+	-max-size N
+		Print keys whose value is at most N bytes. Skips nested buckets.
+	-values
+		Print each value after its key, separated by a tab.
+	-hex
+		Hex encode keys and values.
+`, "\n")
+}
+
 // PageCommand represents the "page" command execution.
 type PageCommand struct {
 	Stdin  io.Reader
@@ -476,7 +902,11 @@ func (cmd *PageCommand) Run(args ...string) error {
 
 		// Print basic page info.
 		fmt.Fprintf(cmd.Stdout, "Page ID:    %d\n", p.id)
-		fmt.Fprintf(cmd.Stdout, "Page Type:  %s\n", p.Type())
+		if (p.flags & directoryPageFlag) != 0 {
+			fmt.Fprintf(cmd.Stdout, "Page Type:  %s (directory)\n", p.Type())
+		} else {
+			fmt.Fprintf(cmd.Stdout, "Page Type:  %s\n", p.Type())
+		}
 		fmt.Fprintf(cmd.Stdout, "Total Size: %d bytes\n", len(buf))
 
 		// Print type-specific data.
@@ -523,25 +953,25 @@ func (cmd *PageCommand) PrintLeaf(w io.Writer, buf []byte) error {
 
 	// Print each key/value.
 	for i := uint16(0); i < p.count; i++ {
-		e := p.leafPageElement(i)
+		key, value, flags := p.leafElement(i)
 
 		// Format key as string.
 		var k string
-		if isPrintable(string(e.key())) {
-			k = fmt.Sprintf("%q", string(e.key()))
+		if isPrintable(string(key)) {
+			k = fmt.Sprintf("%q", string(key))
 		} else {
-			k = fmt.Sprintf("%x", string(e.key()))
+			k = fmt.Sprintf("%x", string(key))
 		}
 
 		// Format value as string.
 		var v string
-		if (e.flags & uint32(bucketLeafFlag)) != 0 {
-			b := (*bucket)(unsafe.Pointer(&e.value()[0]))
+		if (flags & uint32(bucketLeafFlag)) != 0 {
+			b := (*bucket)(unsafe.Pointer(&value[0]))
 			v = fmt.Sprintf("<pgid=%d,seq=%d>", b.root, b.sequence)
-		} else if isPrintable(string(e.value())) {
-			k = fmt.Sprintf("%q", string(e.value()))
+		} else if isPrintable(string(value)) {
+			k = fmt.Sprintf("%q", string(value))
 		} else {
-			k = fmt.Sprintf("%x", string(e.value()))
+			k = fmt.Sprintf("%x", string(value))
 		}
 
 		fmt.Fprintf(w, "%s: %s\n", k, v)
@@ -713,8 +1143,12 @@ func (cmd *PagesCommand) Run(args ...string) error {
 				}
 			}
 
-			// Print table row.
-			fmt.Fprintf(cmd.Stdout, "%-8d %-10s %-6s %-6s\n", p.ID, p.Type, count, overflow)
+			// Print table row. Pages of the bucket directory are prefixed.
+			typ := p.Type
+			if p.Directory {
+				typ = "dir-" + typ
+			}
+			fmt.Fprintf(cmd.Stdout, "%-8d %-10s %-6s %-6s\n", p.ID, typ, count, overflow)
 
 			// Move to the next non-overflow page.
 			id += 1
@@ -762,6 +1196,10 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	// Parse flags.
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	interval := fs.Duration("interval", 0, "")
+	count := fs.Int("count", 0, "")
+	format := fs.String("format", "text", "")
+	name := fs.String("bucket", "", "")
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
@@ -777,6 +1215,20 @@ func (cmd *StatsCommand) Run(args ...string) error {
 		return ErrFileNotFound
 	}
 
+	switch *format {
+	case "text", "json", "csv":
+	default:
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+	if *interval > 0 && (*format != "text" || *name != "") {
+		return errors.New("-format and -bucket cannot be used with -interval")
+	}
+
+	// Sample the database periodically if an interval is set.
+	if *interval > 0 {
+		return cmd.Watch(path, *interval, *count)
+	}
+
 	// Open database.
 	db, err := bolt.Open(path, 0666, nil)
 	if err != nil {
@@ -785,18 +1237,32 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	defer db.Close()
 
 	return db.View(func(tx *bolt.Tx) error {
-		var s bolt.BucketStats
-		var count int
-		if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			if bytes.HasPrefix(name, []byte(prefix)) {
-				s.Add(b.Stats())
-				count += 1
+		// Collect the stats of the matching buckets.
+		var records []statsRecord
+		if err := tx.ForEach(func(bname []byte, b *bolt.Bucket) error {
+			if *name != "" && string(bname) != *name {
+				return nil
+			} else if bytes.HasPrefix(bname, []byte(prefix)) {
+				records = append(records, newStatsRecord(bname, b.Stats()))
 			}
 			return nil
 		}); err != nil {
 			return err
 		}
 
+		switch *format {
+		case "json":
+			return writeStatsJSON(cmd.Stdout, records)
+		case "csv":
+			return writeStatsCSV(cmd.Stdout, records)
+		}
+
+		var s bolt.BucketStats
+		for _, r := range records {
+			s.Add(r.stats)
+		}
+		count := len(records)
+
 		fmt.Fprintf(cmd.Stdout, "Aggregate statistics for %d buckets\n\n", count)
 
 		fmt.Fprintln(cmd.Stdout, "Page count statistics")
@@ -840,10 +1306,193 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	})
 }
 
+// statsRecord is the stats of a single top-level bucket in machine-readable
+// output. Bucket is omitted from JSON when the name is not valid UTF-8;
+// BucketHex always holds the name exactly.
+type statsRecord struct {
+	Bucket              string `json:"bucket,omitempty"`
+	BucketHex           string `json:"bucket_hex"`
+	BranchPages         int    `json:"branch_pages"`
+	BranchOverflowPages int    `json:"branch_overflow_pages"`
+	LeafPages           int    `json:"leaf_pages"`
+	LeafOverflowPages   int    `json:"leaf_overflow_pages"`
+	Keys                int    `json:"keys"`
+	Depth               int    `json:"depth"`
+	BranchAlloc         int    `json:"branch_alloc"`
+	BranchInuse         int    `json:"branch_inuse"`
+	LeafAlloc           int    `json:"leaf_alloc"`
+	LeafInuse           int    `json:"leaf_inuse"`
+	Buckets             int    `json:"buckets"`
+	InlineBuckets       int    `json:"inline_buckets"`
+	InlineBucketInuse   int    `json:"inline_bucket_inuse"`
+
+	name  []byte
+	stats bolt.BucketStats
+}
+
+// statsColumns is the header row of csv output, matching statsRecord.row.
+var statsColumns = []string{
+	"bucket", "branch_pages", "branch_overflow_pages", "leaf_pages", "leaf_overflow_pages",
+	"keys", "depth", "branch_alloc", "branch_inuse", "leaf_alloc", "leaf_inuse",
+	"buckets", "inline_buckets", "inline_bucket_inuse",
+}
+
+func newStatsRecord(name []byte, s bolt.BucketStats) statsRecord {
+	r := statsRecord{
+		BucketHex:           hex.EncodeToString(name),
+		BranchPages:         s.BranchPageN,
+		BranchOverflowPages: s.BranchOverflowN,
+		LeafPages:           s.LeafPageN,
+		LeafOverflowPages:   s.LeafOverflowN,
+		Keys:                s.KeyN,
+		Depth:               s.Depth,
+		BranchAlloc:         s.BranchAlloc,
+		BranchInuse:         s.BranchInuse,
+		LeafAlloc:           s.LeafAlloc,
+		LeafInuse:           s.LeafInuse,
+		Buckets:             s.BucketN,
+		InlineBuckets:       s.InlineBucketN,
+		InlineBucketInuse:   s.InlineBucketInuse,
+		name:                name,
+		stats:               s,
+	}
+	if utf8.Valid(name) {
+		r.Bucket = string(name)
+	}
+	return r
+}
+
+// row returns the record as a csv row.
+func (r *statsRecord) row() []string {
+	row := []string{string(r.name)}
+	for _, n := range []int{
+		r.BranchPages, r.BranchOverflowPages, r.LeafPages, r.LeafOverflowPages,
+		r.Keys, r.Depth, r.BranchAlloc, r.BranchInuse, r.LeafAlloc, r.LeafInuse,
+		r.Buckets, r.InlineBuckets, r.InlineBucketInuse,
+	} {
+		row = append(row, strconv.Itoa(n))
+	}
+	return row
+}
+
+// writeStatsJSON writes each record to w as a line of JSON.
+func writeStatsJSON(w io.Writer, records []statsRecord) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStatsCSV writes the records to w as csv with a header row.
+func writeStatsCSV(w io.Writer, records []statsRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(statsColumns); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write(r.row()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Watch prints the commit rate and page growth of the database at path every
+// interval until count samples have been printed, or forever if count is zero.
+// The meta pages are read directly from the file so that it can run alongside
+// a process that has the database open for writing.
+func (cmd *StatsCommand) Watch(path string, interval time.Duration, count int) error {
+	prev, err := readStatsSample(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.Stdout, "%-8s %12s %10s %10s %10s %10s\n", "TIME", "TXID", "COMMITS/S", "PAGES", "PAGES/S", "FREE")
+	for i := 0; count == 0 || i < count; i++ {
+		time.Sleep(interval)
+
+		cur, err := readStatsSample(path)
+		if err != nil {
+			return err
+		}
+		secs := cur.time.Sub(prev.time).Seconds()
+		fmt.Fprintf(cmd.Stdout, "%-8s %12d %10.1f %10d %10.1f %10d\n",
+			cur.time.Format("15:04:05"),
+			cur.txid,
+			float64(cur.txid-prev.txid)/secs,
+			cur.pgid,
+			float64(int64(cur.pgid)-int64(prev.pgid))/secs,
+			cur.freeN,
+		)
+		prev = cur
+	}
+	return nil
+}
+
+// statsSample is a point-in-time reading of a database's meta page.
+type statsSample struct {
+	time  time.Time
+	txid  txid
+	pgid  pgid
+	freeN int
+}
+
+// readStatsSample reads the current meta page and freelist size from path.
+// This is not transactionally safe.
+func readStatsSample(path string) (*statsSample, error) {
+	var m *meta
+	for i := 0; i < 2; i++ {
+		_, buf, err := ReadPage(path, i)
+		if err != nil {
+			return nil, err
+		}
+		mi := (*meta)(unsafe.Pointer(&buf[PageHeaderSize]))
+		if mi.validate() == nil && (m == nil || mi.txid > m.txid) {
+			m = mi
+		}
+	}
+	if m == nil {
+		return nil, errors.New("no valid meta page")
+	}
+
+	// Read the freelist to count free pages. Files written by bbolt with
+	// NoFreelistSync do not store one.
+	if m.freelist == pgid(^uint64(0)) {
+		return &statsSample{time: time.Now(), txid: m.txid, pgid: m.pgid}, nil
+	}
+	p, _, err := ReadPage(path, int(m.freelist))
+	if err != nil {
+		return nil, fmt.Errorf("read freelist: %s", err)
+	}
+	freeN := int(p.count)
+	if freeN == 0xFFFF {
+		freeN = int(((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[0])
+	}
+
+	return &statsSample{time: time.Now(), txid: m.txid, pgid: m.pgid, freeN: freeN}, nil
+}
+
 // Usage returns the help message.
 func (cmd *StatsCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt stats PATH
+usage: bolt stats [-format text|json|csv] [-bucket NAME] [-interval DURATION [-count N]] PATH [PREFIX]
+
+Stats prints the aggregate statistics of the top-level buckets whose names
+start with PREFIX, or only of the bucket NAME if -bucket is set. With the
+json format, stats instead prints one JSON object per bucket on its own
+line; "bucket_hex" holds the hex encoded name and "bucket" is omitted when
+the name is not valid UTF-8. With the csv format, stats prints a header row followed by one row
+per bucket.
+
+With -interval, stats instead prints the transaction id, commits per second,
+high water mark in pages, its growth per second and the number of free pages
+every DURATION. The meta page is read directly so that it can watch a
+database that is open in another process. -count stops after N samples.
+-format and -bucket cannot be combined with -interval.
 
 Stats performs an extensive search of the database to track every page
 reference. It starts at the current meta page and recursively iterates
@@ -1430,15 +2079,20 @@ const maxAllocSize = 0xFFFFFFF
 
 // DO NOT EDIT. Copied from the "bolt" package.
 const (
-	branchPageFlag   = 0x01
-	leafPageFlag     = 0x02
-	metaPageFlag     = 0x04
-	freelistPageFlag = 0x10
+	branchPageFlag    = 0x01
+	leafPageFlag      = 0x02
+	metaPageFlag      = 0x04
+	freelistPageFlag  = 0x10
+	directoryPageFlag = 0x20 // set with branchPageFlag or leafPageFlag
+	packedPageFlag    = 0x40 // set with leafPageFlag
 )
 
 // DO NOT EDIT. Copied from the "bolt" package.
 const bucketLeafFlag = 0x01
 
+// DO NOT EDIT. Copied from the "bolt" package.
+const magic uint32 = 0xED0CDAED
+
 // DO NOT EDIT. Copied from the "bolt" package.
 type pgid uint64
 
@@ -1458,6 +2112,24 @@ type meta struct {
 	checksum uint64
 }
 
+// validate checks the marker bytes and the checksum of the meta page. Unlike
+// the "bolt" package, the version is not checked.
+func (m *meta) validate() error {
+	if m.magic != magic {
+		return errors.New("invalid meta page")
+	} else if m.checksum != 0 && m.checksum != m.sum64() {
+		return errors.New("meta page checksum error")
+	}
+	return nil
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (m *meta) sum64() uint64 {
+	var h = fnv.New64a()
+	_, _ = h.Write((*[unsafe.Offsetof(meta{}.checksum)]byte)(unsafe.Pointer(m))[:])
+	return h.Sum64()
+}
+
 // DO NOT EDIT. Copied from the "bolt" package.
 type bucket struct {
 	root     pgid
@@ -1493,6 +2165,27 @@ func (p *page) leafPageElement(index uint16) *leafPageElement {
 	return n
 }
 
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) packedValueSize() uint32 {
+	return *(*uint32)(unsafe.Pointer(&p.ptr))
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) packedLeafPageElement(index uint16) *packedLeafPageElement {
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(&p.ptr))
+	return &((*[0x7FFFFFF]packedLeafPageElement)(unsafe.Pointer(&buf[packedLeafHeaderSize])))[index]
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) leafElement(index uint16) (key, value []byte, flags uint32) {
+	if (p.flags & packedPageFlag) != 0 {
+		elem := p.packedLeafPageElement(index)
+		return elem.key(), elem.value(p.packedValueSize()), 0
+	}
+	elem := p.leafPageElement(index)
+	return elem.key(), elem.value(), elem.flags
+}
+
 // DO NOT EDIT. Copied from the "bolt" package.
 func (p *page) branchPageElement(index uint16) *branchPageElement {
 	return &((*[0x7FFFFFF]branchPageElement)(unsafe.Pointer(&p.ptr)))[index]
@@ -1530,3 +2223,24 @@ func (n *leafPageElement) value() []byte {
 	buf := (*[maxAllocSize]byte)(unsafe.Pointer(n))
 	return buf[n.pos+n.ksize : n.pos+n.ksize+n.vsize]
 }
+
+// DO NOT EDIT. Copied from the "bolt" package.
+const packedLeafHeaderSize = 8
+
+// DO NOT EDIT. Copied from the "bolt" package.
+type packedLeafPageElement struct {
+	pos   uint32
+	ksize uint32
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (n *packedLeafPageElement) key() []byte {
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(n))
+	return buf[n.pos : n.pos+n.ksize]
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (n *packedLeafPageElement) value(vsize uint32) []byte {
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(n))
+	return buf[n.pos+n.ksize : n.pos+n.ksize+vsize]
+}
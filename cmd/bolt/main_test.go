@@ -2,9 +2,16 @@ package main_test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/boltdb/bolt"
@@ -141,6 +148,209 @@ func TestStatsCommand_Run(t *testing.T) {
 	}
 }
 
+// Ensure the "stats" command can print per-bucket stats as JSON and CSV.
+func TestStatsCommand_Run_Format(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"bar", "foo"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 10; i++ {
+				if err := b.Put([]byte(strconv.Itoa(i)), []byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	// Print one JSON object per line.
+	m := NewMain()
+	if err := m.Run("stats", "-format", "json", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	dec := json.NewDecoder(&m.Stdout)
+	for dec.More() {
+		var r struct {
+			Bucket string `json:"bucket"`
+			Keys   int    `json:"keys"`
+		}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		} else if r.Keys != 10 {
+			t.Fatalf("unexpected key count: %d", r.Keys)
+		}
+		names = append(names, r.Bucket)
+	}
+	if strings.Join(names, ",") != "bar,foo" {
+		t.Fatalf("unexpected buckets: %v", names)
+	}
+
+	// Print CSV for a single bucket.
+	m = NewMain()
+	if err := m.Run("stats", "-format", "csv", "-bucket", "foo", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&m.Stdout).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 2 {
+		t.Fatalf("unexpected rows: %v", rows)
+	} else if rows[0][0] != "bucket" || rows[0][5] != "keys" {
+		t.Fatalf("unexpected header: %v", rows[0])
+	} else if rows[1][0] != "foo" || rows[1][5] != "10" {
+		t.Fatalf("unexpected row: %v", rows[1])
+	}
+
+	// Reject machine-readable output and a bucket filter in watch mode.
+	for _, args := range [][]string{{"-format", "json"}, {"-bucket", "foo"}} {
+		args = append(args, "-interval", "1s", db.Path)
+		if err := NewMain().Run(append([]string{"stats"}, args...)...); err == nil || !strings.Contains(err.Error(), "-interval") {
+			t.Fatalf("unexpected error for %v: %v", args, err)
+		}
+	}
+
+	// Unknown formats are rejected.
+	if err := NewMain().Run("stats", "-format", "xml", db.Path); err == nil || err.Error() != "unknown format: xml" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the "stats" command keeps bucket names that are not valid UTF-8 in JSON.
+func TestStatsCommand_Run_BinaryName(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	name := []byte{0xff, 0x00, 'a'}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(name)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	m := NewMain()
+	if err := m.Run("stats", "-format", "json", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	var r map[string]interface{}
+	if err := json.NewDecoder(&m.Stdout).Decode(&r); err != nil {
+		t.Fatal(err)
+	} else if r["bucket_hex"] != "ff0061" {
+		t.Fatalf("unexpected bucket_hex: %v", r["bucket_hex"])
+	} else if _, ok := r["bucket"]; ok {
+		t.Fatalf("unexpected bucket: %v", r["bucket"])
+	}
+}
+
+// Ensure the "stats" command can sample a database that is open for writing.
+func TestStatsCommand_Run_Interval(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("foo"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(strconv.Itoa(i)), []byte("bar"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMain()
+	if err := m.Run("stats", "-interval", "10ms", "-count", "2", db.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(m.Stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	} else if !strings.HasPrefix(lines[0], "TIME") {
+		t.Fatalf("unexpected header: %s", lines[0])
+	} else if fields := strings.Fields(lines[2]); len(fields) != 6 || fields[1] != "4" || fields[2] != "0.0" {
+		t.Fatalf("unexpected sample: %s", lines[2])
+	}
+
+	// Corrupt the checksum of the newest meta page so that the previous
+	// transaction is reported.
+	db.DB.Close()
+	buf, err := ioutil.ReadFile(db.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const txidOffset, checksumOffset = main.PageHeaderSize + 48, main.PageHeaderSize + 56
+	off := 0
+	if binary.LittleEndian.Uint64(buf[os.Getpagesize()+txidOffset:]) == 4 {
+		off = os.Getpagesize()
+	}
+	buf[off+checksumOffset]++
+	if err := ioutil.WriteFile(db.Path, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m = NewMain()
+	if err := m.Run("stats", "-interval", "10ms", "-count", "1", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(m.Stdout.String()), "\n")
+	if fields := strings.Fields(lines[len(lines)-1]); len(fields) != 6 || fields[1] != "3" {
+		t.Fatalf("unexpected sample: %s", lines[len(lines)-1])
+	}
+}
+
+// Ensure the "export" command can write a bucket as CSV.
+func TestExportCommand_Run_CSV(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("quote"), []byte(`a "b", c`)); err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("sub"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	if err := m.Run("export", "-format", "csv", "-bucket", "widgets", "-header", db.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := "key,value\nfoo,bar\nquote,\"a \"\"b\"\", c\"\n"; m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+
+	m = NewMain()
+	if err := m.Run("export", "-format", "csv", "-bucket", "widgets", "-hex", db.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := "666f6f,626172\n71756f7465,61202262222c2063\n"; m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+
+	if err := NewMain().Run("export", "-format", "csv", db.Path); err != main.ErrBucketRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Main represents a test wrapper for main.Main that records output.
 type Main struct {
 	*main.Main
@@ -183,3 +393,140 @@ func (db *DB) Close() error {
 	defer os.Remove(db.Path)
 	return db.DB.Close()
 }
+
+// Ensure the "keys" command prints keys that match the filters.
+func TestKeysCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"user/1", "user/22", "group/1"} {
+			if err := b.Put([]byte(k), []byte(k[len(k)-1:])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	if err := m.Run("keys", "-regex", `^user/\d$`, db.Path, "widgets"); err != nil {
+		t.Fatal(err)
+	} else if exp := "user/1\n"; m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+
+	m = NewMain()
+	if err := m.Run("keys", "-glob", "*/1", "-values", "-hex", db.Path, "widgets"); err != nil {
+		t.Fatal(err)
+	} else if exp := "67726f75702f31\t31\n757365722f31\t31\n"; m.Stdout.String() != exp {
+		t.Fatalf("unexpected stdout:\n\n%s", m.Stdout.String())
+	}
+
+	if err := NewMain().Run("keys", db.Path); err != main.ErrBucketRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the "convert" command can copy a database into a SQLite file.
+func TestConvertCommand_Run(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not found")
+	}
+
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 25; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%02d", i)), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		child, err := b.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		}
+		return child.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	out := db.Path + ".sqlite"
+	defer os.Remove(out)
+	if err := NewMain().Run("convert", "-to", "sqlite", "-batch-size", "10", db.Path, out); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(sql string) string {
+		b, err := exec.Command("sqlite3", out, sql).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if s := query(`SELECT count(*), sum(unicode(value)) FROM widgets`); s != "25|300\n" {
+		t.Fatalf("unexpected result: %q", s)
+	} else if s := query(`SELECT CAST(key AS TEXT), CAST(value AS TEXT) FROM "widgets/sub"`); s != "foo|bar\n" {
+		t.Fatalf("unexpected result: %q", s)
+	}
+
+	if err := NewMain().Run("convert", "-to", "sqlite", db.Path, out); err == nil {
+		t.Fatal("expected error for existing output file")
+	}
+}
+
+// Ensure the "convert" command rejects buckets whose table names collide and
+// leaves no output file behind.
+func TestConvertCommand_Run_Collision(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not found")
+	}
+
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		} else if _, err := b.CreateBucket([]byte("B")); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte("a/b"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	out := db.Path + ".sqlite"
+	defer os.Remove(out)
+	if err := NewMain().Run("convert", "-to", "sqlite", db.Path, out); err == nil || err.Error() != `table name collision: "a/b"` {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("expected no output file: %v", err)
+	}
+	if m, err := filepath.Glob(out + ".tmp*"); err != nil || len(m) != 0 {
+		t.Fatalf("unexpected temporary files: %v %v", m, err)
+	}
+}
+
+// Ensure the "convert" command rejects unknown target formats.
+func TestConvertCommand_Run_UnknownFormat(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	if err := NewMain().Run("convert", "-to", "lmdb", db.Path, db.Path+".lmdb"); err == nil || err.Error() != "unknown target format: lmdb" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
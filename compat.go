@@ -0,0 +1,46 @@
+package bolt
+
+// pgidNoFreelist is stored as the freelist page id by etcd-io/bbolt when it
+// is opened with NoFreelistSync and does not write the freelist to disk.
+const pgidNoFreelist pgid = 0xffffffffffffffff
+
+// readFreelist initializes the freelist from the meta page.
+//
+// Files created by boltdb/bolt and etcd-io/bbolt share this package's file
+// format and are opened directly. bbolt files written with NoFreelistSync do
+// not store a freelist, so it is rebuilt from the pages that are not
+// reachable from the bucket directory. The next commit writes a freelist
+// page, which converts the file.
+func (db *DB) readFreelist() {
+	db.freelist = newFreelist()
+	if db.meta().freelist == pgidNoFreelist {
+		db.freelist.readIDs(db.freePages())
+		return
+	}
+	db.freelist.read(db.page(db.meta().freelist))
+}
+
+// freePages returns the ids of the pages below the high water mark that are
+// not reachable from the committed bucket directory.
+func (db *DB) freePages() []pgid {
+	var tx Tx
+	tx.init(db)
+
+	reachable := make(map[pgid]bool)
+	_ = tx.ForEachPage(func(p *TreePage) error {
+		if !p.Inline {
+			for i := 0; i <= p.OverflowCount; i++ {
+				reachable[pgid(p.ID+i)] = true
+			}
+		}
+		return nil
+	})
+
+	var ids []pgid
+	for id := pgid(2); id < tx.meta.pgid; id++ {
+		if !reachable[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
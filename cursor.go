@@ -18,6 +18,22 @@ import (
 type Cursor struct {
 	bucket *Bucket
 	stack  []elemRef
+	reads  ReadStats
+}
+
+// ReadStats records the pages read to position a cursor or look up a key.
+// It is only recorded while DB.TrackReads is enabled.
+type ReadStats struct {
+	PageN     int // number of pages read, excluding nodes modified in the transaction
+	OverflowN int // number of overflow pages of the pages read
+	Depth     int // number of levels of the B+tree traversed
+}
+
+// ReadStats returns the pages read by the cursor since it was last
+// positioned with First, Last or Seek. It is only recorded while
+// DB.TrackReads is enabled.
+func (c *Cursor) ReadStats() ReadStats {
+	return c.reads
 }
 
 // Bucket returns the bucket that this cursor was created from.
@@ -30,8 +46,22 @@ func (c *Cursor) Bucket() *Bucket {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) First() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
+	k, v, flags := c.first0()
+	for k != nil && (flags&hiddenLeafFlags) != 0 {
+		k, v, flags = c.next()
+	}
+	if (flags & uint32(bucketLeafFlag)) != 0 {
+		return k, nil
+	}
+	return k, v
+}
+
+// first0 moves the cursor to the first leaf element in the bucket, including
+// tombstones, and returns its key, value and flags.
+func (c *Cursor) first0() (key []byte, value []byte, flags uint32) {
 	c.stack = c.stack[:0]
-	p, n := c.bucket.pageNode(c.bucket.root)
+	c.reads = ReadStats{}
+	p, n := c.pageNode(c.bucket.root)
 	c.stack = append(c.stack, elemRef{page: p, node: n, index: 0})
 	c.first()
 
@@ -41,12 +71,7 @@ func (c *Cursor) First() (key []byte, value []byte) {
 		c.next()
 	}
 
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
-
+	return c.keyValue()
 }
 
 // Last moves the cursor to the last item in the bucket and returns its key and value.
@@ -55,12 +80,16 @@ func (c *Cursor) First() (key []byte, value []byte) {
 func (c *Cursor) Last() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 	c.stack = c.stack[:0]
-	p, n := c.bucket.pageNode(c.bucket.root)
+	c.reads = ReadStats{}
+	p, n := c.pageNode(c.bucket.root)
 	ref := elemRef{page: p, node: n}
 	ref.index = ref.count() - 1
 	c.stack = append(c.stack, ref)
 	c.last()
 	k, v, flags := c.keyValue()
+	for k != nil && (flags&hiddenLeafFlags) != 0 {
+		k, v, flags = c.prev()
+	}
 	if (flags & uint32(bucketLeafFlag)) != 0 {
 		return k, nil
 	}
@@ -73,6 +102,9 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 func (c *Cursor) Next() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 	k, v, flags := c.next()
+	for k != nil && (flags&hiddenLeafFlags) != 0 {
+		k, v, flags = c.next()
+	}
 	if (flags & uint32(bucketLeafFlag)) != 0 {
 		return k, nil
 	}
@@ -84,7 +116,18 @@ func (c *Cursor) Next() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Prev() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
+	k, v, flags := c.prev()
+	for k != nil && (flags&hiddenLeafFlags) != 0 {
+		k, v, flags = c.prev()
+	}
+	if (flags & uint32(bucketLeafFlag)) != 0 {
+		return k, nil
+	}
+	return k, v
+}
 
+// prev moves to the previous leaf element and returns the key, value and flags.
+func (c *Cursor) prev() (key []byte, value []byte, flags uint32) {
 	// Attempt to move back one element until we're successful.
 	// Move up the stack as we hit the beginning of each page in our stack.
 	for i := len(c.stack) - 1; i >= 0; i-- {
@@ -98,16 +141,12 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 
 	// If we've hit the end then return nil.
 	if len(c.stack) == 0 {
-		return nil, nil
+		return nil, nil, 0
 	}
 
 	// Move down the stack to find the last element of the last leaf under this branch.
 	c.last()
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	return c.keyValue()
 }
 
 // Seek moves the cursor to a given key and returns it.
@@ -121,6 +160,9 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
 		k, v, flags = c.next()
 	}
+	for k != nil && (flags&hiddenLeafFlags) != 0 {
+		k, v, flags = c.next()
+	}
 
 	if k == nil {
 		return nil, nil
@@ -130,6 +172,53 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	return k, v
 }
 
+// SeekPrefix moves the cursor to the first key that starts with prefix and
+// returns it. If no key starts with prefix then a nil key is returned and the
+// cursor is left at the first key after the prefix range. Next does not stop
+// at the end of the range, so callers should check the prefix of each key or
+// use Bucket.ForEachPrefix.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekPrefix(prefix []byte) (key []byte, value []byte) {
+	k, v := c.Seek(prefix)
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil
+	}
+	return k, v
+}
+
+// SeekReverse moves the cursor to the last key that starts with prefix and
+// returns it. If no key starts with prefix then a nil key is returned. Prev
+// does not stop at the start of the range, so callers should check the
+// prefix of each key.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekReverse(prefix []byte) (key []byte, value []byte) {
+	var k, v []byte
+	if end := prefixEnd(prefix); end == nil {
+		k, v = c.Last()
+	} else if k, _ = c.Seek(end); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil
+	}
+	return k, v
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte{}, prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
 // Delete removes the current key/value under the cursor from the bucket.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
 func (c *Cursor) Delete() error {
@@ -144,7 +233,7 @@ func (c *Cursor) Delete() error {
 	if (flags & bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
-	c.node().del(key)
+	c.bucket.remove(c, key)
 
 	return nil
 }
@@ -156,7 +245,11 @@ func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
 
 	// Start from root page/node and traverse to correct page.
 	c.stack = c.stack[:0]
+	c.reads = ReadStats{}
 	c.search(seek, c.bucket.root)
+	if db := c.bucket.tx.db; db.ValidateSeek || db.Assertions {
+		c.validateSeek(seek)
+	}
 	ref := &c.stack[len(c.stack)-1]
 
 	// If the cursor is pointing to the end of page/node then return nil.
@@ -168,6 +261,58 @@ func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
 	return c.keyValue()
 }
 
+// validateSeek panics if the position found by a seek for key is not
+// consistent with the neighboring keys on each page of the stack or with the
+// range of its parent branch element. The panic names the page so that
+// corruption found by a read can be located without a full Check.
+func (c *Cursor) validateSeek(key []byte) {
+	for i := range c.stack {
+		ref := &c.stack[i]
+		id, n := ref.id(), ref.count()
+		for j := ref.index; j <= ref.index+1; j++ {
+			if j > 0 && j < n {
+				prev, cur := ref.key(j-1), ref.key(j)
+				_assert(bytes.Compare(prev, cur) == -1, "seek %x: page %d: key %x at index %d does not follow %x", key, id, cur, j, prev)
+			}
+		}
+
+		if ref.isLeaf() {
+			if ref.index < n {
+				k := ref.key(ref.index)
+				_assert(bytes.Compare(k, key) != -1, "seek %x: page %d: landed on key %x at index %d before the sought key", key, id, k, ref.index)
+			}
+			if ref.index > 0 && ref.index <= n {
+				k := ref.key(ref.index - 1)
+				_assert(bytes.Compare(k, key) == -1, "seek %x: page %d: key %x at index %d before the landing position is not before the sought key", key, id, k, ref.index-1)
+			}
+			continue
+		}
+
+		// The branch element must cover the sought key.
+		if ref.index > 0 {
+			k := ref.key(ref.index)
+			_assert(bytes.Compare(k, key) != 1, "seek %x: page %d: branch key %x at index %d is after the sought key", key, id, k, ref.index)
+		}
+		if ref.index+1 < n {
+			k := ref.key(ref.index + 1)
+			_assert(bytes.Compare(k, key) == 1, "seek %x: page %d: branch key %x at index %d is not after the sought key", key, id, k, ref.index+1)
+		}
+
+		// The child's keys must fall within the range of the branch element.
+		child := &c.stack[i+1]
+		if cn := child.count(); cn > 0 {
+			if ref.index > 0 {
+				lo, first := ref.key(ref.index), child.key(0)
+				_assert(bytes.Compare(first, lo) != -1, "seek %x: page %d: first key %x is before key %x of parent page %d", key, child.id(), first, lo, id)
+			}
+			if ref.index+1 < n {
+				hi, last := ref.key(ref.index+1), child.key(cn-1)
+				_assert(bytes.Compare(last, hi) == -1, "seek %x: page %d: last key %x is not before key %x of parent page %d", key, child.id(), last, hi, id)
+			}
+		}
+	}
+}
+
 // first moves the cursor to the first leaf element under the last page in the stack.
 func (c *Cursor) first() {
 	for {
@@ -184,7 +329,7 @@ func (c *Cursor) first() {
 		} else {
 			pgid = ref.page.branchPageElement(uint16(ref.index)).pgid
 		}
-		p, n := c.bucket.pageNode(pgid)
+		p, n := c.pageNode(pgid)
 		c.stack = append(c.stack, elemRef{page: p, node: n, index: 0})
 	}
 }
@@ -205,7 +350,7 @@ func (c *Cursor) last() {
 		} else {
 			pgid = ref.page.branchPageElement(uint16(ref.index)).pgid
 		}
-		p, n := c.bucket.pageNode(pgid)
+		p, n := c.pageNode(pgid)
 
 		var nextRef = elemRef{page: p, node: n}
 		nextRef.index = nextRef.count() - 1
@@ -249,9 +394,26 @@ func (c *Cursor) next() (key []byte, value []byte, flags uint32) {
 	}
 }
 
+// pageNode returns the page or node with the given id and records the read
+// if reads are tracked.
+func (c *Cursor) pageNode(id pgid) (*page, *node) {
+	p, n := c.bucket.pageNode(id)
+	if db := c.bucket.tx.db; db != nil && db.TrackReads {
+		// Inline buckets are stored in their parent's page.
+		if p != nil && c.bucket.root != 0 {
+			c.reads.PageN++
+			c.reads.OverflowN += int(p.overflow)
+		}
+		if depth := len(c.stack) + 1; depth > c.reads.Depth {
+			c.reads.Depth = depth
+		}
+	}
+	return p, n
+}
+
 // search recursively performs a binary search against a given page/node until it finds a given key.
 func (c *Cursor) search(key []byte, pgid pgid) {
-	p, n := c.bucket.pageNode(pgid)
+	p, n := c.pageNode(pgid)
 	if p != nil && (p.flags&(branchPageFlag|leafPageFlag)) == 0 {
 		panic(fmt.Sprintf("invalid page type: %d: %x", p.id, p.flags))
 	}
@@ -329,15 +491,23 @@ func (c *Cursor) nsearch(key []byte) {
 	}
 
 	// If we have a page then search its leaf elements.
-	inodes := p.leafPageElements()
 	index := sort.Search(int(p.count), func(i int) bool {
-		return bytes.Compare(inodes[i].key(), key) != -1
+		return bytes.Compare(p.leafKey(uint16(i)), key) != -1
 	})
 	e.index = index
 }
 
-// keyValue returns the key and value of the current leaf element.
+// keyValue returns the key and value of the current leaf element. The expiry
+// time is removed from the values of expiring elements.
 func (c *Cursor) keyValue() ([]byte, []byte, uint32) {
+	k, v, flags := c.rawKeyValue()
+	v, flags = expiringValue(v, flags)
+	return k, v, flags
+}
+
+// rawKeyValue returns the key and value of the current leaf element as
+// stored.
+func (c *Cursor) rawKeyValue() ([]byte, []byte, uint32) {
 	ref := &c.stack[len(c.stack)-1]
 	if ref.count() == 0 || ref.index >= ref.count() {
 		return nil, nil, 0
@@ -350,8 +520,7 @@ func (c *Cursor) keyValue() ([]byte, []byte, uint32) {
 	}
 
 	// Or retrieve value from page.
-	elem := ref.page.leafPageElement(uint16(ref.index))
-	return elem.key(), elem.value(), elem.flags
+	return ref.page.leafElement(uint16(ref.index))
 }
 
 // node returns the node that the cursor is currently positioned on.
@@ -391,6 +560,24 @@ func (r *elemRef) isLeaf() bool {
 	return (r.page.flags & leafPageFlag) != 0
 }
 
+// id returns the id of the page, or of the page the node was read from.
+func (r *elemRef) id() pgid {
+	if r.node != nil {
+		return r.node.pgid
+	}
+	return r.page.id
+}
+
+// key returns the key of the inode or page element at index.
+func (r *elemRef) key(index int) []byte {
+	if r.node != nil {
+		return r.node.inodes[index].key
+	} else if (r.page.flags & leafPageFlag) != 0 {
+		return r.page.leafKey(uint16(index))
+	}
+	return r.page.branchPageElement(uint16(index)).key()
+}
+
 // count returns the number of inodes or page elements.
 func (r *elemRef) count() int {
 	if r.node != nil {
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"testing/quick"
 
@@ -103,6 +105,262 @@ func TestCursor_Seek(t *testing.T) {
 	}
 }
 
+// Ensure that the pages read by lookups are recorded while TrackReads is set.
+func TestCursor_ReadStats(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("large"), make([]byte, 20000))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is recorded by default.
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		b.Get(u64tob(500))
+		if s := b.ReadStats(); s != (bolt.ReadStats{}) {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.TrackReads = true
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		// A lookup reads one page per level.
+		if v := b.Get(u64tob(500)); v == nil {
+			t.Fatal("expected value")
+		}
+		s := b.ReadStats()
+		if s.Depth < 2 || s.PageN != s.Depth || s.OverflowN != 0 {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+
+		// Large values are stored on overflow pages.
+		if v := b.Get([]byte("large")); len(v) != 20000 {
+			t.Fatalf("unexpected value length: %d", len(v))
+		} else if s := b.ReadStats(); s.OverflowN == 0 {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+
+		// Cursors record reads since they were positioned.
+		c := b.Cursor()
+		c.First()
+		if cs := c.ReadStats(); cs.Depth != s.Depth || cs.PageN != s.Depth {
+			t.Fatalf("unexpected cursor stats: %+v", cs)
+		}
+		for i := 0; i < 1000; i++ {
+			c.Next()
+		}
+		if cs := c.ReadStats(); cs.PageN <= s.Depth {
+			t.Fatalf("unexpected cursor stats after next: %+v", cs)
+		}
+		c.Seek(u64tob(500))
+		if cs := c.ReadStats(); cs != s {
+			t.Fatalf("unexpected cursor stats after seek: %+v", cs)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that ValidateSeek reports keys that are out of order on a page.
+func TestCursor_ValidateSeek(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.ValidateSeek = true
+
+	// Lookups in a valid database pass the checks.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 1000; i += 7 {
+			if v := b.Get([]byte(fmt.Sprintf("%04d", i))); v == nil {
+				t.Fatalf("expected value for %04d", i)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Find a key in the middle of a leaf so that it is not a branch key.
+	var key, next []byte
+	if err := db.View(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		var pages []int
+		return tx.Bucket([]byte("widgets")).Walk(func(depth int, pgid int, k, v []byte) error {
+			keys, pages = append(keys, k), append(pages, pgid)
+			if i := len(keys) - 2; key == nil && i > 500 && pages[i-1] == pgid && pages[i] == pgid {
+				key, next = append([]byte{}, keys[i]...), append([]byte{}, k...)
+			}
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	} else if key == nil {
+		t.Fatal("no key found")
+	}
+
+	// Move a key out of order in the file.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(buf, key) != 1 {
+		t.Fatalf("expected one copy of %s", key)
+	}
+	buf = bytes.Replace(buf, key, []byte("9"+string(key[1:])), 1)
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A seek next to the key panics and names the page.
+	var msg string
+	if err := db.View(func(tx *bolt.Tx) error {
+		defer func() {
+			msg = fmt.Sprint(recover())
+		}()
+		tx.Bucket([]byte("widgets")).Get(next)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg, fmt.Sprintf("seek %x: page ", next)) {
+		t.Fatalf("unexpected panic: %s", msg)
+	}
+}
+
+// Ensure that a cursor can seek to the first key with a prefix.
+func TestCursor_SeekPrefix(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a/1", "b/1", "b/2", "c/1"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+
+		// The first key with the prefix is returned.
+		if k, v := c.SeekPrefix([]byte("b/")); !bytes.Equal(k, []byte("b/1")) {
+			t.Fatalf("unexpected key: %s", k)
+		} else if !bytes.Equal(v, []byte("b/1")) {
+			t.Fatalf("unexpected value: %s", v)
+		}
+
+		// Prefixes without keys return no key.
+		for _, prefix := range []string{"a/2", "bb", "d"} {
+			if k, v := c.SeekPrefix([]byte(prefix)); k != nil || v != nil {
+				t.Fatalf("expected nil key for %s: %s", prefix, k)
+			}
+		}
+
+		// An empty prefix matches the first key.
+		if k, _ := c.SeekPrefix(nil); !bytes.Equal(k, []byte("a/1")) {
+			t.Fatalf("unexpected key: %s", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a cursor can seek to the last key with a prefix.
+func TestCursor_SeekReverse(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a/1", "b/1", "b/2", "c/1", "c/2", "\xff\x01", "\xff\xff"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+
+		// The last key with the prefix is returned.
+		for prefix, exp := range map[string]string{
+			"a/":       "a/1",
+			"b/":       "b/2",
+			"b":        "b/2",
+			"c":        "c/2",
+			"\xff":     "\xff\xff",
+			"\xff\x01": "\xff\x01",
+			"":         "\xff\xff",
+		} {
+			if k, v := c.SeekReverse([]byte(prefix)); !bytes.Equal(k, []byte(exp)) {
+				t.Fatalf("unexpected key for %q: %q", prefix, k)
+			} else if !bytes.Equal(v, []byte(exp)) {
+				t.Fatalf("unexpected value for %q: %q", prefix, v)
+			}
+		}
+
+		// Prefixes without keys return no key.
+		for _, prefix := range []string{"0", "a/2", "bb", "d", "\xff\x02"} {
+			if k, v := c.SeekReverse([]byte(prefix)); k != nil || v != nil {
+				t.Fatalf("expected nil key for %q: %q", prefix, k)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCursor_Delete(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
@@ -1,15 +1,20 @@
 package bolt
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 )
@@ -17,9 +22,30 @@ import (
 // The largest step that can be taken when remapping the mmap.
 const maxMmapStep = 1 << 30 // 1GB
 
+// The range of page sizes accepted when opening a file.
+const (
+	minPageSize = 512
+	maxPageSize = 1 << 20
+)
+
 // The data file format version.
 const version = 2
 
+// versionFeatures is the format version of files that use features older
+// versions of Bolt cannot read. The features are recorded in the meta flags
+// so that older versions refuse to open such files with ErrVersionMismatch.
+// Files that use no features keep the original version.
+const versionFeatures = 3
+
+// Format features recorded in the meta flags.
+const (
+	featurePacked     = 0x01 // packed leaf pages
+	featureTombstones = 0x02 // tombstone leaf elements
+	featureExpiring   = 0x04 // expiring leaf elements
+
+	features = featurePacked | featureTombstones | featureExpiring // features supported by this version
+)
+
 // Represents a marker value to indicate that a file is a Bolt DB.
 const magic uint32 = 0xED0CDAED
 
@@ -39,6 +65,20 @@ const (
 // default page size for db is set to the OS page size.
 var defaultPageSize = os.Getpagesize()
 
+// Durability is the set of fsync() calls made when a transaction commits.
+type Durability int
+
+const (
+	// DurabilityFull syncs the data pages and the meta page on every commit.
+	DurabilityFull Durability = iota
+
+	// DurabilityMeta syncs only the meta page on every commit.
+	DurabilityMeta
+
+	// DurabilityNone skips all syncs on commit.
+	DurabilityNone
+)
+
 // DB represents a collection of buckets persisted to a file on disk.
 // All data access is performed through transactions which can be obtained through the DB.
 // All the functions on DB will return a ErrDatabaseNotOpen if accessed before Open() is called.
@@ -49,6 +89,36 @@ type DB struct {
 	// debugging purposes.
 	StrictMode bool
 
+	// When enabled, cursors and buckets record the pages read by each seek
+	// and Get, which are returned by Cursor.ReadStats and Bucket.ReadStats.
+	// This can show whether a change to key design reduces I/O. This flag
+	// has a performance impact so it should only be used for debugging
+	// purposes.
+	TrackReads bool
+
+	// When enabled, every seek, including those made by Get, Put and Delete,
+	// checks that the key it lands on and its neighbors are in order on each
+	// page it passes through and that each page falls within the range of
+	// its parent. A panic naming the offending page is issued otherwise.
+	// This localizes corruption found by a read without a full Check. This
+	// flag has a performance impact so it should only be used for debugging
+	// purposes.
+	ValidateSeek bool
+
+	// When enabled, internal invariants such as page bounds, element counts
+	// and key order are checked whenever a node is read from or written to a
+	// page or a key is inserted or removed, and a descriptive panic is issued
+	// if one is violated. This also enables ValidateSeek. Default value is
+	// copied from Options.Assertions in Open.
+	Assertions bool
+
+	// When enabled, pages are allocated and written in a stable order and
+	// tombstones record the Unix epoch instead of the time of deletion, so
+	// the same sequence of transactions produces byte-identical files. Free
+	// pages are only reused identically if read transactions are also the
+	// same. Default value is copied from Options.Deterministic in Open.
+	Deterministic bool
+
 	// Setting the NoSync flag will cause the database to skip fsync()
 	// calls after each commit. This can be useful when bulk loading data
 	// into a database and you can restart the bulk load in the event of
@@ -61,6 +131,19 @@ type DB struct {
 	// THIS IS UNSAFE. PLEASE USE WITH CAUTION.
 	NoSync bool
 
+	// Durability controls which fsync() calls are made on commit. The default,
+	// DurabilityFull, syncs the data pages and then the meta page. Deployments
+	// with a battery-backed write cache can use DurabilityMeta to skip the data
+	// sync while keeping the meta sync that orders commits. DurabilityNone is
+	// equivalent to NoSync.
+	//
+	// If the package global IgnoreNoSync constant is true, this value is
+	// ignored.
+	//
+	// ANYTHING OTHER THAN DurabilityFull IS UNSAFE WITHOUT A WRITE CACHE THAT
+	// SURVIVES POWER LOSS.
+	Durability Durability
+
 	// When true, skips the truncate call when growing the database.
 	// Setting this to true is only safe on non-ext3/ext4 systems.
 	// Skipping truncation avoids preallocation of hard drive space and
@@ -89,18 +172,129 @@ type DB struct {
 	// Do not change concurrently with calls to Batch.
 	MaxBatchDelay time.Duration
 
+	// GroupCommitWindow is how long Put and Delete wait for concurrent calls
+	// to join the same write transaction before committing. Default value
+	// is copied from Options.GroupCommitWindow in Open.
+	//
+	// If <=0, each call commits its own transaction.
+	GroupCommitWindow time.Duration
+
+	// SyncInterval is how long a commit waits for later commits to share its
+	// fsync() calls. Commits write their pages immediately and are visible
+	// to new transactions, but Commit, and therefore Update and Batch, only
+	// returns once a sync covering the commit has finished. Longer intervals
+	// trade commit latency for fewer syncs. Default value is copied from
+	// Options.SyncInterval in Open.
+	//
+	// If a sync fails then every commit waiting for it returns the error even
+	// though its changes are visible, and they may or may not be durable.
+	// Syncs are not retried: write transactions return the error until the
+	// database is closed and reopened.
+	//
+	// If <=0, if syncs are disabled or if Options.UseMmapWrites is set, each
+	// commit syncs on its own.
+	SyncInterval time.Duration
+
 	// AllocSize is the amount of space allocated when the database
 	// needs to create new pages. This is done to amortize the cost
 	// of truncate() and fsync() when growing the data file.
 	AllocSize int
 
+	// MmapGrowthWindow sizes the mmap to hold this much time worth of writes
+	// at the rate the file has recently grown, instead of doubling it, when
+	// a write transaction needs a larger mapping. Busy databases remap less
+	// often and quiet databases reserve less address space. The mapping
+	// grows by at most 1GB at a time. Default value is copied from
+	// Options.MmapGrowthWindow in Open.
+	//
+	// If <=0, or until the growth rate is known, the size is doubled.
+	MmapGrowthWindow time.Duration
+
+	// DropCachesOnClose advises the kernel on Close that the file's pages
+	// will not be needed again so they are evicted from the OS page cache.
+	// This keeps batch jobs that scan a large database from crowding out the
+	// cache used by the rest of the host. Pages written without a sync may
+	// stay cached until they are flushed. Only supported on 64-bit Linux.
+	// Default value is copied from Options.DropCachesOnClose in Open.
+	DropCachesOnClose bool
+
+	// TrimThreshold is the number of free pages that must be at the end of
+	// the file before a commit lowers the high water mark below them and
+	// truncates the file. This lets disk usage track the data as it is
+	// deleted without a full compaction. On Windows the file is not
+	// truncated.
+	//
+	// If <=0, the file is never trimmed.
+	TrimThreshold int
+
+	// MaxBuckets is the maximum number of buckets that can be created
+	// directly within a single bucket, including top-level buckets. Nested
+	// buckets are counted with the bucket's stored key count, while the
+	// top-level buckets are counted once per transaction.
+	//
+	// If <=0, the number of buckets is unlimited.
+	MaxBuckets int
+
+	// Trace, when set, receives a line for every page read from the mmap and
+	// every page written to disk by a transaction. Each line records the
+	// transaction id, the operation and the page id. This is intended for
+	// offline analysis of access patterns and has a large performance
+	// impact so it should only be used for debugging purposes.
+	//
+	// Do not change concurrently with open transactions.
+	Trace io.Writer
+
+	// Authorize, when set, is called before every bucket and key operation
+	// with the operation, the path of names from the top-level bucket to the
+	// bucket it is performed on and the key. Top-level buckets are opened,
+	// created and deleted with a nil path. The path must not be retained or
+	// modified. If an error is returned then the operation fails with that
+	// error. Denied Get and Bucket calls return nil and denied top-level
+	// buckets are skipped by Tx.ForEach and Tx.Buckets.
+	//
+	// Cursors are not checked, so access should be controlled at the bucket
+	// level where cursors are used.
+	//
+	// Do not change concurrently with open transactions.
+	Authorize func(op Op, bucket [][]byte, key []byte) error
+
+	// StallThreshold is how long a writer can be blocked waiting for the
+	// writer lock, a remap, file growth or an fsync() before OnStall is
+	// called.
+	StallThreshold time.Duration
+
+	// OnStall, when set, is called on its own goroutine once a writer has
+	// been blocked for longer than StallThreshold, while it is still blocked.
+	// It is called at most once per wait and should return quickly. It can
+	// be used to shed load or alert before commit latency grows further.
+	//
+	// Do not change concurrently with open transactions.
+	OnStall func(Stall)
+
+	// Audit, when set, receives a line for every put, delete, bucket
+	// creation and bucket deletion once the transaction that made it has
+	// committed. Each line records the commit time, the transaction id, the
+	// operation, the bucket path and the key. The path is the quoted names
+	// of the bucket and its parents joined by slashes, such as
+	// "tenant-a"/"users". Rolled back changes are not recorded.
+	//
+	// Do not change concurrently with open transactions.
+	Audit io.Writer
+
+	// AuditValues controls whether and how values written by puts are
+	// recorded in the audit log. Values are omitted by default.
+	AuditValues AuditValueMode
+
 	path     string
 	file     *os.File
-	lockfile *os.File // windows only
-	dataref  []byte   // mmap'ed readonly, write throws SEGV
+	fileInfo os.FileInfo // Identity of the data file when it was opened.
+	fileErr  error       // Set if the data file could not be reopened. Protected by mmaplock.
+	lockfile *os.File    // windows only
+	dataref  []byte      // mmap'ed readonly, write throws SEGV
 	data     *[maxMapSize]byte
 	datasz   int
-	filesz   int // current on disk file size
+	growth   mmapGrowth // Protected by rwlock.
+	filesz   int        // current on disk file size
 	meta0    *meta
 	meta1    *meta
 	pageSize int
@@ -115,57 +309,198 @@ type DB struct {
 	batchMu sync.Mutex
 	batch   *batch
 
-	rwlock   sync.Mutex   // Allows only one writer at a time.
-	metalock sync.Mutex   // Protects meta page access.
-	mmaplock sync.RWMutex // Protects mmap access during remapping.
-	statlock sync.RWMutex // Protects stats access.
+	snapshots map[txid]*snapshot // Protected by metalock.
+
+	rolledBack *meta // Previous meta after a truncated tail. Protected by metalock.
+	recovery   RecoveryInfo
+
+	synclock    sync.Mutex // Serializes group syncs.
+	metaPage    pgid       // Meta page holding the latest written meta. Protected by synclock.
+	pendinglock sync.Mutex // Protects pendingMeta, durableTxid, syncGroup and syncErr.
+	pendingMeta *meta      // Latest commit waiting for a group sync.
+	durableTxid txid       // Latest commit whose meta is synced while pendingMeta is set.
+	syncGroup   *syncGroup
+	syncErr     error          // First failed group sync.
+	syncTimers  sync.WaitGroup // Group sync timers that have not finished.
+
+	cachelock   sync.Mutex // Protects the bucket cache.
+	cacheTxid   txid
+	bucketCache map[string]bucketCacheEntry
+
+	bucketlock sync.RWMutex // Protects per-bucket settings.
+	merges     map[string]MergeFunc
+	tombstones map[string]bool
+	valueSizes map[string]int
+	bloomSpecs map[string]bloomSpec
+
+	bloomlock    sync.RWMutex // Protects blooms and prefixBlooms.
+	blooms       map[string]*bloomFilter
+	prefixBlooms map[string]*bloomFilter
+
+	attachlock sync.RWMutex // Protects attached.
+	attached   map[string]*DB
+
+	rwlock    sync.Mutex   // Allows only one writer at a time.
+	metalock  sync.Mutex   // Protects meta page access.
+	mmaplock  sync.RWMutex // Protects mmap access during remapping.
+	statlock  sync.RWMutex // Protects stats access.
+	tracelock sync.Mutex   // Serializes writes to Trace.
+	auditlock sync.Mutex   // Serializes writes to Audit.
 
 	ops struct {
-		writeAt func(b []byte, off int64) (n int, err error)
+		writeAt   func(b []byte, off int64) (n int, err error)
+		fdatasync func(db *DB) error
 	}
 
+	faults *faultInjector
+
+	// Arguments from the last open, used by Reopen.
+	openPath    string
+	openMode    os.FileMode
+	openOptions *Options
+
+	// Set while CloseContext is waiting for transactions to finish.
+	// New transactions return ErrDatabaseNotOpen immediately.
+	closing int32
+
 	// Read only mode.
 	// When true, Update() and Begin(true) return ErrDatabaseReadOnly immediately.
 	readOnly bool
+
+	// In-memory mode.
+	// When true, data references a caller provided buffer instead of a
+	// memory-mapped file and there is no file handle.
+	inmem bool
+
+	// Set when pages are written through a writable mapping.
+	mmapWrites bool
+
+	// Written to the lock info sidecar while the file lock is held.
+	lockInfo LockInfo
+
+	// Closed to stop the goroutine started by Options.ReapInterval.
+	reapStop chan struct{}
+
+	// Ephemeral mode.
+	// When true, the data file has been removed from its directory, or is
+	// removed on Close on Windows, so it cannot be reopened by path.
+	ephemeral bool
+
+	// Set while the data file is recorded in openFiles.
+	registered bool
 }
 
-// Path returns the path to currently open database file.
+// Path returns the path to currently open database file. The path is
+// absolute and has symlinks resolved, so it is the same however the file
+// was named when it was opened.
 func (db *DB) Path() string {
 	return db.path
 }
 
 // GoString returns the Go string representation of the database.
 func (db *DB) GoString() string {
-	return fmt.Sprintf("bolt.DB{path:%q}", db.path)
+	return fmt.Sprintf("bolt.DB{path:%q, opened:%t, readOnly:%t}", db.displayPath(), db.opened, db.readOnly)
 }
 
-// String returns the string representation of the database.
+// String returns the string representation of the database, including its
+// path and whether it is open, so that log output identifies which database
+// a value belongs to. The path is kept after the database is closed.
 func (db *DB) String() string {
-	return fmt.Sprintf("DB<%q>", db.path)
+	state := "closed"
+	if db.opened {
+		state = "open"
+		if db.readOnly {
+			state = "open,readonly"
+		}
+	}
+	return fmt.Sprintf("DB<%q %s>", db.displayPath(), state)
+}
+
+// displayPath returns the path of the database, or the path it was last
+// opened with if it has been closed.
+func (db *DB) displayPath() string {
+	if db.path == "" {
+		return db.openPath
+	}
+	return db.path
 }
 
 // Open creates and opens a database at the given path.
 // If the file does not exist then it will be created automatically.
 // Passing in nil options will cause Bolt to open the database with the default options.
 func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
-	var db = &DB{opened: true}
+	var db = &DB{}
+
+	// Set default values for later DB operations.
+	db.MaxBatchSize = DefaultMaxBatchSize
+	db.MaxBatchDelay = DefaultMaxBatchDelay
+	db.AllocSize = DefaultAllocSize
+
+	if err := db.open(path, mode, options); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reopen opens a closed database again with the path, mode and options it
+// was originally opened with. All internal state is reset while exported
+// settings such as NoSync and MaxBatchSize are kept. Returns ErrDatabaseOpen
+// if the database has not been closed.
+func (db *DB) Reopen() error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
 
+	if db.opened {
+		return ErrDatabaseOpen
+	} else if db.inmem || db.ephemeral || db.openPath == "" {
+		return ErrReopenNotSupported
+	}
+	return db.open(db.openPath, db.openMode, db.openOptions)
+}
+
+// open opens the data file at path and initializes all internal state.
+func (db *DB) open(path string, mode os.FileMode, options *Options) error {
 	// Set default options if no options are provided.
 	if options == nil {
 		options = DefaultOptions
 	}
 	db.NoGrowSync = options.NoGrowSync
 	db.MmapFlags = options.MmapFlags
-
-	// Set default values for later DB operations.
-	db.MaxBatchSize = DefaultMaxBatchSize
-	db.MaxBatchDelay = DefaultMaxBatchDelay
-	db.AllocSize = DefaultAllocSize
+	db.GroupCommitWindow = options.GroupCommitWindow
+	db.SyncInterval = options.SyncInterval
+	db.MmapGrowthWindow = options.MmapGrowthWindow
+	db.DropCachesOnClose = options.DropCachesOnClose
+	db.Assertions = options.Assertions
+	db.Deterministic = options.Deterministic
+	db.mmapWrites = options.UseMmapWrites && !options.ReadOnly && runtime.GOOS != "windows"
+	db.ephemeral = options.Ephemeral && !options.ReadOnly
+
+	// Reset state left over from a previous open.
+	db.opened = true
+	atomic.StoreInt32(&db.closing, 0)
+	db.openPath, db.openMode, db.openOptions = path, mode, options
+	db.readOnly = false
+	db.rwtx = nil
+	db.txs = nil
+	db.snapshots = nil
+	db.rolledBack = nil
+	db.blooms, db.prefixBlooms = nil, nil
+	db.cacheTxid, db.bucketCache = 0, nil
+	db.batch = nil
+	db.filesz = 0
+	db.growth = mmapGrowth{}
+	db.faults = nil
+	db.fileInfo, db.fileErr = nil, nil
 
 	flag := os.O_RDWR
 	if options.ReadOnly {
 		flag = os.O_RDONLY
 		db.readOnly = true
+	} else if db.ephemeral {
+		flag |= os.O_EXCL
 	}
 
 	// Open data file and separate sync handler for metadata writes.
@@ -173,7 +508,40 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	var err error
 	if db.file, err = os.OpenFile(db.path, flag|os.O_CREATE, mode); err != nil {
 		_ = db.close()
-		return nil, err
+		return err
+	}
+
+	// Refer to the file by its canonical path from now on so that Reopen,
+	// sidecar files and lock files agree however the file was named.
+	if cpath, err := canonicalPath(path); err == nil {
+		db.path, db.openPath = cpath, cpath
+	}
+
+	// Remove ephemeral files from their directory right away so they are
+	// reclaimed however the process exits. Windows cannot remove open files.
+	if db.ephemeral && runtime.GOOS != "windows" {
+		if err := os.Remove(db.path); err != nil {
+			_ = db.close()
+			return err
+		}
+	}
+
+	// mmap and flock are not reliable on network filesystems so either refuse
+	// to open the file or warn loudly, depending on the options.
+	if fstype, ok := networkFS(db); ok {
+		if options.RefuseNetworkFS {
+			_ = db.close()
+			return ErrNetworkFilesystem
+		}
+		log.Printf("bolt.Open(): WARNING: %s is on a network filesystem (%s); locking and mmap are unsafe and may corrupt the database", path, fstype)
+	}
+
+	// Refuse to map the file twice in this process. This is checked before
+	// taking the file lock so Open fails instead of waiting on a lock held
+	// by this process.
+	if err := db.register(); err != nil {
+		_ = db.close()
+		return err
 	}
 
 	// Lock file so that other processes using Bolt in read-write mode cannot
@@ -185,19 +553,27 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	// hold a lock at the same time) otherwise (options.ReadOnly is set).
 	if err := flock(db, mode, !db.readOnly, options.Timeout); err != nil {
 		_ = db.close()
-		return nil, err
+		return err
+	}
+
+	// Record which process holds the lock for operators.
+	if !db.readOnly && !db.ephemeral {
+		db.writeLockInfo()
 	}
 
 	// Default values for test hooks
-	db.ops.writeAt = db.file.WriteAt
+	db.resetOps()
 
-	// Initialize the database if it doesn't exist.
+	// Initialize the database if it doesn't exist. The file is identified by
+	// its info so that a reopened handle can be checked against it.
 	if info, err := db.file.Stat(); err != nil {
-		return nil, err
-	} else if info.Size() == 0 {
+		_ = db.close()
+		return err
+	} else if db.fileInfo = info; info.Size() == 0 {
 		// Initialize new files with meta pages.
 		if err := db.init(); err != nil {
-			return nil, err
+			_ = db.close()
+			return err
 		}
 	} else {
 		// Read the first meta page to determine the page size.
@@ -217,6 +593,14 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 				db.pageSize = int(m.pageSize)
 			}
 		}
+
+		// Refuse files that cannot be mapped before attempting to do so.
+		if db.pageSize != 0 {
+			if err := db.validateSize(info.Size()); err != nil {
+				_ = db.close()
+				return err
+			}
+		}
 	}
 
 	// Initialize page pool.
@@ -229,25 +613,212 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	// Memory map the data file.
 	if err := db.mmap(options.InitialMmapSize); err != nil {
 		_ = db.close()
+		return err
+	}
+
+	// Ensure the meta page only references pages inside the file.
+	if info, err := db.file.Stat(); err != nil {
+		_ = db.close()
+		return err
+	} else if err := db.checkMeta(info.Size(), options.RecoverTruncated); err != nil {
+		_ = db.close()
+		return err
+	}
+
+	// Read in the freelist.
+	db.readFreelist()
+	db.recovery = db.recoveryInfo()
+
+	// Reuse bloom filters saved when the file was last closed.
+	db.loadBlooms()
+
+	// Remove expired keys in the background.
+	if options.ReapInterval > 0 && !db.readOnly {
+		db.reapStop = make(chan struct{})
+		go db.reap(options.ReapInterval, db.reapStop)
+	}
+
+	return nil
+}
+
+// canonicalPath returns the absolute path of the existing file at path with
+// any symlinks resolved.
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// OpenBytes opens a read-only database backed by an in-memory copy of a
+// database file, such as a downloaded backup. No file is created or read.
+// The data must not be modified while the database is open.
+// Only the RecoverTruncated and Assertions options apply. Options for the
+// file, locking, memory map and writes are ignored.
+// Passing in nil options will cause Bolt to open the database with the default options.
+func OpenBytes(data []byte, options *Options) (*DB, error) {
+	var db = &DB{opened: true, readOnly: true, inmem: true}
+
+	// Set default options if no options are provided.
+	if options == nil {
+		options = DefaultOptions
+	}
+	db.MaxBatchSize = DefaultMaxBatchSize
+	db.MaxBatchDelay = DefaultMaxBatchDelay
+	db.AllocSize = DefaultAllocSize
+	db.Assertions = options.Assertions
+
+	// In-memory databases are never written so syncing is a no-op.
+	db.ops.fdatasync = func(*DB) error { return nil }
+
+	// Read the first meta page to determine the page size.
+	db.pageSize = os.Getpagesize()
+	if len(data) >= 0x1000 {
+		if m := db.pageInBuffer(data, 0).meta(); m.validate() == nil {
+			db.pageSize = int(m.pageSize)
+		}
+	}
+	if err := db.validateSize(int64(len(data))); err != nil {
+		return nil, err
+	} else if len(data) < db.pageSize*2 {
+		return nil, fmt.Errorf("data size too small")
+	}
+
+	// Reference the data in place of a memory map.
+	db.dataref = data
+	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&data[0]))
+	db.datasz = len(data)
+	db.filesz = len(data)
+
+	// Validate the meta pages and ensure all pages are present.
+	db.meta0 = db.page(0).meta()
+	db.meta1 = db.page(1).meta()
+	err0 := db.meta0.validate()
+	err1 := db.meta1.validate()
+	if err0 != nil && err1 != nil {
+		return nil, err0
+	} else if err := db.checkMeta(int64(len(data)), options.RecoverTruncated); err != nil {
 		return nil, err
 	}
 
 	// Read in the freelist.
-	db.freelist = newFreelist()
-	db.freelist.read(db.page(db.meta().freelist))
+	db.readFreelist()
+	db.recovery = db.recoveryInfo()
 
-	// Mark the database as opened and return.
 	return db, nil
 }
 
+// OpenReaderAt reads size bytes of a database file from r and opens it as a
+// read-only in-memory database. See OpenBytes.
+func OpenReaderAt(r io.ReaderAt, size int64, options *Options) (*DB, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(r, 0, size), data); err != nil {
+		return nil, err
+	}
+	return OpenBytes(data, options)
+}
+
+// validateSize returns ErrCorrupt if the page size read from the first meta
+// page or the file size is outside of what can be safely mapped.
+func (db *DB) validateSize(size int64) error {
+	if db.pageSize < minPageSize || db.pageSize > maxPageSize || db.pageSize&(db.pageSize-1) != 0 {
+		return ErrCorrupt
+	} else if size > maxMapSize {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// checkMeta validates the current meta page against size bytes of data. If the
+// data has been truncated and rollback is true then the database is rolled
+// back to the previous meta page, provided that all of its pages fit.
+func (db *DB) checkMeta(size int64, rollback bool) error {
+	m := db.meta()
+	db.metaPage = 0
+	if m == db.meta1 {
+		db.metaPage = 1
+	}
+	err := db.validateMeta(m, size)
+	if _, ok := err.(*TruncatedError); !ok || !rollback {
+		return err
+	}
+
+	prev := db.meta0
+	if m == db.meta0 {
+		prev = db.meta1
+	}
+	if prev.validate() != nil || db.validateMeta(prev, size) != nil {
+		return err
+	}
+	log.Printf("bolt.Open(): %s; rolling back from tx %d to tx %d", err, m.txid, prev.txid)
+	rolledBack := *prev
+	db.rolledBack = &rolledBack
+	db.metaPage = 1 - db.metaPage
+	return nil
+}
+
+// validateMeta returns ErrCorrupt if m references pages beyond size bytes or
+// has a malformed freelist, or a *TruncatedError if the data is shorter than
+// the high water mark of m. This prevents a malformed file from causing reads
+// outside of the mmap or huge allocations.
+func (db *DB) validateMeta(m *meta, size int64) error {
+	if int(m.pageSize) != db.pageSize {
+		return ErrCorrupt
+	} else if m.pgid < 2 || uint64(m.pgid)*uint64(db.pageSize) > maxMapSize {
+		return ErrCorrupt
+	} else if expected := int64(m.pgid) * int64(db.pageSize); expected > size {
+		return &TruncatedError{Size: size, Expected: expected}
+	} else if m.root.root >= m.pgid {
+		return ErrCorrupt
+	} else if m.freelist == pgidNoFreelist {
+		return nil
+	} else if m.freelist < 2 || m.freelist >= m.pgid {
+		return ErrCorrupt
+	}
+
+	// The freelist and its overflow pages must fit and hold its page ids.
+	p := db.page(m.freelist)
+	if (p.flags & freelistPageFlag) == 0 {
+		return ErrCorrupt
+	} else if uint64(m.freelist)+uint64(p.overflow) >= uint64(m.pgid) {
+		return ErrCorrupt
+	}
+	n := uint64(p.count)
+	if n == 0xFFFF {
+		n = uint64(((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[0]) + 1
+	}
+	if uint64(pageHeaderSize)+n*uint64(unsafe.Sizeof(pgid(0))) > (uint64(p.overflow)+1)*uint64(db.pageSize) {
+		return ErrCorrupt
+	}
+	return nil
+}
+
 // mmap opens the underlying memory-mapped file and initializes the meta references.
 // minsz is the minimum size that the new mmap can be.
 func (db *DB) mmap(minsz int) error {
 	db.mmaplock.Lock()
 	defer db.mmaplock.Unlock()
 
+	// If the file descriptor was invalidated underneath us, for example by a
+	// network filesystem or a container checkpoint, reopen the file and try
+	// once more instead of failing until the process restarts.
+	err := db.remap(minsz)
+	if isBadFile(err) {
+		if rerr := db.reopenFile(err); rerr != nil {
+			return rerr
+		}
+		err = db.remap(minsz)
+	}
+	return err
+}
+
+// remap maps the data file at a size of at least minsz. The mmap lock must be held.
+func (db *DB) remap(minsz int) error {
 	info, err := db.file.Stat()
-	if err != nil {
+	if isBadFile(err) {
+		return err
+	} else if err != nil {
 		return fmt.Errorf("mmap stat error: %s", err)
 	} else if int(info.Size()) < db.pageSize*2 {
 		return fmt.Errorf("file size too small")
@@ -294,8 +865,152 @@ func (db *DB) mmap(minsz int) error {
 	return nil
 }
 
+// resetOps sets the file operations to their defaults for the current file
+// and wraps them with any injected faults.
+func (db *DB) resetOps() {
+	db.ops.writeAt = db.file.WriteAt
+	db.ops.fdatasync = fdatasync
+	if db.mmapWrites {
+		db.ops.writeAt = db.mmapWriteAt
+		db.ops.fdatasync = mmapFdatasync
+	}
+	if db.faults != nil {
+		db.ops.writeAt = db.faults.writeAt(db.ops.writeAt)
+		db.ops.fdatasync = db.faults.fdatasync(db.ops.fdatasync)
+	}
+}
+
+// mmapWriteAt copies b into the writable mapping at off. Ranges that are not
+// both mapped and known to be within the file are written to the file instead
+// since touching a mapping past the end of the file raises SIGBUS.
+func (db *DB) mmapWriteAt(b []byte, off int64) (int, error) {
+	end := off + int64(len(b))
+	if db.data == nil || end > int64(db.datasz) || end > int64(db.filesz) {
+		return db.file.WriteAt(b, off)
+	}
+	return copy(db.data[off:], b), nil
+}
+
+// mmapFdatasync flushes the writable mapping and then the data file.
+func mmapFdatasync(db *DB) error {
+	if db.dataref != nil {
+		if err := msyncData(db); err != nil {
+			return err
+		}
+	}
+	return fdatasync(db)
+}
+
+// reopenFile replaces the file handle invalidated by cause with a new handle
+// to the same file. The mmap lock must be held.
+//
+// The mapping keeps the old file, and so its lock, alive, so it is unmapped
+// first. The new handle must refer to the same file so that a replaced file
+// is never mapped under open transactions, and it is locked before a stale
+// handle is closed so that another process cannot take the lock in between.
+// If the file cannot be reopened then every new transaction fails with the
+// error until the database is closed.
+func (db *DB) reopenFile(cause error) (err error) {
+	defer func() {
+		if err != nil {
+			db.fileErr = fmt.Errorf("reopen after %s: %s", cause, err)
+			err = db.fileErr
+		}
+	}()
+
+	// Keep the meta pages readable so that the failed transaction can roll
+	// back and the database can be closed if the file cannot be reopened.
+	meta0, meta1 := *db.meta0, *db.meta1
+	if db.rwtx != nil {
+		db.rwtx.root.dereference()
+	}
+	if err := db.munmap(); err != nil {
+		return err
+	}
+	db.meta0, db.meta1 = &meta0, &meta1
+
+	// A descriptor that was closed underneath us may be handed out again to
+	// the new handle, so release it first. A stale one is still ours and is
+	// kept, with its lock, until the new handle is locked.
+	old := db.file
+	if !isStale(cause) {
+		_ = old.Close()
+		old, db.file = nil, nil
+	}
+
+	flag := os.O_RDWR
+	if db.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(db.path, flag, 0)
+	if err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err != nil {
+		_ = f.Close()
+		return err
+	} else if !os.SameFile(info, db.fileInfo) {
+		_ = f.Close()
+		return errors.New("data file was replaced")
+	}
+
+	db.file = f
+	if err := db.relock(); err != nil {
+		db.file = old
+		_ = f.Close()
+		return err
+	}
+	db.resetOps()
+	if !isStale(cause) {
+		return nil
+	}
+
+	// Closing a descriptor drops the POSIX record locks of the process, which
+	// some platforms and network filesystems use for flock, so lock again.
+	_ = old.Close()
+	return db.relock()
+}
+
+// relock takes the file lock on the current file handle. The lock is not
+// taken again on Windows since it is held on a separate lock file.
+func (db *DB) relock() error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	var timeout time.Duration
+	if db.openOptions != nil {
+		timeout = db.openOptions.Timeout
+	}
+	return flock(db, db.openMode, !db.readOnly, timeout)
+}
+
+// isBadFile returns true if err means the file descriptor is no longer valid.
+func isBadFile(err error) bool {
+	if e, ok := err.(*os.PathError); ok {
+		err = e.Err
+	}
+	return err == syscall.EBADF || err == os.ErrClosed || isStale(err)
+}
+
+// isStale returns true if err means that a network filesystem no longer
+// recognizes the file handle, although the descriptor is still open.
+func isStale(err error) bool {
+	if e, ok := err.(*os.PathError); ok {
+		err = e.Err
+	}
+	return err == syscall.ESTALE
+}
+
 // munmap unmaps the data file from memory.
 func (db *DB) munmap() error {
+	// In-memory data is owned by the caller and is simply released.
+	if db.inmem {
+		db.dataref = nil
+		db.data = nil
+		db.datasz = 0
+		return nil
+	}
+
 	if err := munmap(db); err != nil {
 		return fmt.Errorf("unmap error: " + err.Error())
 	}
@@ -306,6 +1021,11 @@ func (db *DB) munmap() error {
 // of the database. The minimum size is 32KB and doubles until it reaches 1GB.
 // Returns an error if the new mmap size is greater than the max allowed.
 func (db *DB) mmapSize(size int) (int, error) {
+	// Leave room for recent writes instead of doubling if configured.
+	if db.MmapGrowthWindow > 0 && db.growth.rate > 0 {
+		return db.mmapGrowthSize(size)
+	}
+
 	// Double the size from 32KB until 1GB.
 	for i := uint(15); i <= 30; i++ {
 		if size <= 1<<i {
@@ -339,6 +1059,56 @@ func (db *DB) mmapSize(size int) (int, error) {
 	return int(sz), nil
 }
 
+// mmapGrowth tracks how fast the high water mark grows between remaps.
+type mmapGrowth struct {
+	time time.Time // time of the last remap for an allocation
+	size int       // size required by that allocation
+	rate float64   // average growth in bytes per second
+}
+
+// sampleGrowth records that an allocation requires a mapping of at least
+// minsz and updates the average growth rate.
+func (db *DB) sampleGrowth(minsz int) {
+	now := time.Now()
+	g := &db.growth
+	if !g.time.IsZero() && minsz > g.size {
+		if d := now.Sub(g.time).Seconds(); d > 0 {
+			rate := float64(minsz-g.size) / d
+			if g.rate == 0 {
+				g.rate = rate
+			} else {
+				g.rate = (g.rate + rate) / 2
+			}
+		}
+	}
+	g.time, g.size = now, minsz
+}
+
+// mmapGrowthSize returns the mmap size for size plus MmapGrowthWindow of
+// growth at the recent rate, up to maxMmapStep more than size.
+func (db *DB) mmapGrowthSize(size int) (int, error) {
+	if size > maxMapSize {
+		return 0, fmt.Errorf("mmap too large")
+	}
+
+	headroom := db.growth.rate * db.MmapGrowthWindow.Seconds()
+	if headroom > maxMmapStep {
+		headroom = maxMmapStep
+	}
+	sz := int64(size) + int64(headroom)
+
+	// Ensure that the mmap size is a multiple of the page size.
+	pageSize := int64(db.pageSize)
+	if (sz % pageSize) != 0 {
+		sz = ((sz / pageSize) + 1) * pageSize
+	}
+	if sz > maxMapSize {
+		sz = maxMapSize
+	}
+
+	return int(sz), nil
+}
+
 // init creates a new database file and initializes its meta pages.
 func (db *DB) init() error {
 	// Set the page size to the OS page size.
@@ -379,7 +1149,7 @@ func (db *DB) init() error {
 	if _, err := db.ops.writeAt(buf, 0); err != nil {
 		return err
 	}
-	if err := fdatasync(db); err != nil {
+	if err := db.ops.fdatasync(db); err != nil {
 		return err
 	}
 
@@ -387,7 +1157,9 @@ func (db *DB) init() error {
 }
 
 // Close releases all database resources.
-// All transactions must be closed before closing the database.
+// Close blocks until all open read and write transactions have finished.
+// Transactions started while Close is waiting block until the database is
+// closed and then return ErrDatabaseNotOpen.
 func (db *DB) Close() error {
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
@@ -395,23 +1167,110 @@ func (db *DB) Close() error {
 	db.metalock.Lock()
 	defer db.metalock.Unlock()
 
-	db.mmaplock.RLock()
-	defer db.mmaplock.RUnlock()
+	// Obtain an exclusive lock on the mmap so that open read-only
+	// transactions finish before the data is unmapped.
+	db.mmaplock.Lock()
+	defer db.mmaplock.Unlock()
 
 	return db.close()
 }
 
+// CloseContext closes the database once open transactions have finished.
+// New transactions are rejected with ErrDatabaseNotOpen as soon as it is
+// called.
+//
+// If ctx is done before the open transactions finish then ctx.Err() is
+// returned. The database stays open for those transactions but keeps
+// rejecting new ones, so a timed out shutdown does not start accepting work
+// again; call Close to close it once they finish. Open transactions are not
+// rolled back since their goroutines may still be reading the mmap.
+func (db *DB) CloseContext(ctx context.Context) error {
+	atomic.StoreInt32(&db.closing, 1)
+
+	// Wait for the write transaction to finish. If ctx is done first then
+	// the lock is released as soon as it is obtained.
+	locked := make(chan struct{})
+	go func() {
+		db.rwlock.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			db.rwlock.Unlock()
+		}()
+		return ctx.Err()
+	}
+	defer db.rwlock.Unlock()
+
+	// Wait for read-only transactions to finish. Transactions remove
+	// themselves from the list under the meta lock.
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	for len(db.txs) > 0 {
+		db.metalock.Unlock()
+		err := db.closeWait(ctx)
+		db.metalock.Lock()
+		if err != nil {
+			return err
+		}
+	}
+
+	db.mmaplock.Lock()
+	defer db.mmaplock.Unlock()
+
+	return db.close()
+}
+
+// closeWait pauses between checks for open transactions while closing.
+func (db *DB) closeWait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Millisecond):
+		return nil
+	}
+}
+
 func (db *DB) close() error {
 	if !db.opened {
 		return nil
 	}
 
 	db.opened = false
+	defer db.unregister()
+
+	// Stop removing expired keys. A pass that is running fails once it
+	// cannot begin its transaction.
+	if db.reapStop != nil {
+		close(db.reapStop)
+		db.reapStop = nil
+	}
+
+	// Close attached files. Transactions reading them have finished.
+	db.detachAll()
+
+	// Write the meta of commits waiting for a group sync and wait for syncs
+	// started by a timer. Commits whose sync failed are discarded.
+	db.syncNow(nil)
+	db.syncTimers.Wait()
+	db.pendinglock.Lock()
+	db.pendingMeta, db.syncErr = nil, nil
+	db.pendinglock.Unlock()
+
+	// Save bloom filters while the meta page is still mapped.
+	if !db.readOnly && !db.ephemeral && db.file != nil {
+		db.saveBlooms()
+	}
+	db.blooms, db.prefixBlooms = nil, nil
 
 	db.freelist = nil
 
 	// Clear ops.
 	db.ops.writeAt = nil
+	db.faults = nil
 
 	// Close the mmap.
 	if err := db.munmap(); err != nil {
@@ -420,8 +1279,18 @@ func (db *DB) close() error {
 
 	// Close file handles.
 	if db.file != nil {
+		// Evict the file from the page cache now that it is unmapped.
+		if db.DropCachesOnClose {
+			if err := dropCaches(db); err != nil {
+				log.Printf("bolt.Close(): drop caches error: %s", err)
+			}
+		}
+
 		// No need to unlock read-only file.
 		if !db.readOnly {
+			// Remove the lock info before another process can take the lock.
+			db.removeLockInfo()
+
 			// Unlock the file.
 			if err := funlock(db); err != nil {
 				log.Printf("bolt.Close(): funlock error: %s", err)
@@ -433,6 +1302,10 @@ func (db *DB) close() error {
 			return fmt.Errorf("db file close: %s", err)
 		}
 		db.file = nil
+
+		if db.ephemeral && runtime.GOOS == "windows" {
+			_ = os.Remove(db.path)
+		}
 	}
 
 	db.path = ""
@@ -464,6 +1337,11 @@ func (db *DB) Begin(writable bool) (*Tx, error) {
 }
 
 func (db *DB) beginTx() (*Tx, error) {
+	// Reject new transactions while the database is closing.
+	if atomic.LoadInt32(&db.closing) == 1 {
+		return nil, ErrDatabaseNotOpen
+	}
+
 	// Lock the meta pages while we initialize the transaction. We obtain
 	// the meta lock before the mmap lock because that's the order that the
 	// write transaction will obtain them.
@@ -479,10 +1357,14 @@ func (db *DB) beginTx() (*Tx, error) {
 		db.mmaplock.RUnlock()
 		db.metalock.Unlock()
 		return nil, ErrDatabaseNotOpen
+	} else if db.fileErr != nil {
+		db.mmaplock.RUnlock()
+		db.metalock.Unlock()
+		return nil, db.fileErr
 	}
 
 	// Create a transaction associated with the database.
-	t := &Tx{}
+	t := &Tx{lease: &mmapLease{refs: 1}}
 	t.init(db)
 
 	// Keep track of transaction until it closes.
@@ -507,9 +1389,16 @@ func (db *DB) beginRWTx() (*Tx, error) {
 		return nil, ErrDatabaseReadOnly
 	}
 
+	// Reject new transactions while the database is closing.
+	if atomic.LoadInt32(&db.closing) == 1 {
+		return nil, ErrDatabaseNotOpen
+	}
+
 	// Obtain writer lock. This is released by the transaction when it closes.
 	// This enforces only one writer transaction at a time.
+	stop := db.watchStall(StallWriterLock)
 	db.rwlock.Lock()
+	stop()
 
 	// Once we have the writer lock then we can lock the meta pages so that
 	// we can set up the transaction.
@@ -522,18 +1411,42 @@ func (db *DB) beginRWTx() (*Tx, error) {
 		return nil, ErrDatabaseNotOpen
 	}
 
+	// Reject writes once a group sync has failed or the file was lost.
+	if err := db.syncFailed(); err != nil {
+		db.rwlock.Unlock()
+		return nil, err
+	} else if db.fileErr != nil {
+		db.rwlock.Unlock()
+		return nil, db.fileErr
+	}
+
 	// Create a transaction associated with the database.
 	t := &Tx{writable: true}
 	t.init(db)
 	db.rwtx = t
 
-	// Free any pages associated with closed read-only transactions.
+	// Free any pages associated with closed read-only transactions that are
+	// not part of a retained snapshot.
 	var minid txid = 0xFFFFFFFFFFFFFFFF
 	for _, t := range db.txs {
 		if t.meta.txid < minid {
 			minid = t.meta.txid
 		}
 	}
+	for id := range db.snapshots {
+		if id < minid {
+			minid = id
+		}
+	}
+
+	// Pages freed by commits waiting for a group sync are still referenced
+	// by the meta page on disk.
+	db.pendinglock.Lock()
+	if db.pendingMeta != nil && minid > db.durableTxid+1 {
+		minid = db.durableTxid + 1
+	}
+	db.pendinglock.Unlock()
+
 	if minid > 0 {
 		db.freelist.release(minid - 1)
 	}
@@ -543,8 +1456,10 @@ func (db *DB) beginRWTx() (*Tx, error) {
 
 // removeTx removes a transaction from the database.
 func (db *DB) removeTx(tx *Tx) {
-	// Release the read lock on the mmap.
-	db.mmaplock.RUnlock()
+	// Release the read lock on the mmap once clones have also finished.
+	if tx.lease.release() {
+		db.mmaplock.RUnlock()
+	}
 
 	// Use the meta lock to restrict access to the DB object.
 	db.metalock.Lock()
@@ -602,6 +1517,24 @@ func (db *DB) Update(fn func(*Tx) error) error {
 	return t.Commit()
 }
 
+// UpdateWithRetry executes a function within the context of a read-write
+// managed transaction like Update. If the function returns ErrConflict then
+// the transaction is rolled back and the function is called again in a new
+// transaction, up to maxRetries more times. ErrConflict is returned if the
+// function still conflicts after the last retry.
+//
+// ErrConflict is returned by Bucket.CompareAndSwap and may also be returned
+// directly by the function. This allows values read in an earlier read-only
+// transaction to be written back only if they are unchanged.
+func (db *DB) UpdateWithRetry(fn func(*Tx) error, maxRetries int) error {
+	for i := 0; ; i++ {
+		err := db.Update(fn)
+		if err != ErrConflict || i >= maxRetries {
+			return err
+		}
+	}
+}
+
 // View executes a function within the context of a managed read-only transaction.
 // Any error that is returned from the function is returned from the View() method.
 //
@@ -637,6 +1570,166 @@ func (db *DB) View(fn func(*Tx) error) error {
 	return nil
 }
 
+// HasBucket returns true if a top-level bucket exists with the given name.
+func (db *DB) HasBucket(name []byte) (exists bool, err error) {
+	err = db.View(func(tx *Tx) error {
+		exists = tx.HasBucket(name)
+		return nil
+	})
+	return exists, err
+}
+
+// Buckets returns the names of all top-level buckets beginning with prefix
+// in key order. Names that Authorize denies access to are skipped.
+func (db *DB) Buckets(prefix []byte) (names [][]byte, err error) {
+	err = db.View(func(tx *Tx) error {
+		names = tx.Buckets(prefix, nil, 0)
+		return nil
+	})
+	return names, err
+}
+
+// BucketSummaries returns the stored statistics of the top-level buckets
+// whose names begin with prefix, in key order.
+func (db *DB) BucketSummaries(prefix []byte) (summaries []BucketSummary, err error) {
+	err = db.View(func(tx *Tx) error {
+		summaries = tx.BucketSummaries(prefix, nil, 0)
+		return nil
+	})
+	return summaries, err
+}
+
+// ViewBucket executes a function against the named top-level bucket within
+// the context of a managed read-only transaction. Returns ErrBucketNotFound
+// if the bucket does not exist.
+func (db *DB) ViewBucket(name []byte, fn func(*Bucket) error) error {
+	return db.View(func(tx *Tx) error {
+		b := tx.Bucket(name)
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return fn(b)
+	})
+}
+
+// UpdateBucket executes a function against the named top-level bucket within
+// the context of a read-write managed transaction. Returns ErrBucketNotFound
+// if the bucket does not exist.
+func (db *DB) UpdateBucket(name []byte, fn func(*Bucket) error) error {
+	return db.Update(func(tx *Tx) error {
+		b := tx.Bucket(name)
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return fn(b)
+	})
+}
+
+// DeleteBucket removes a top-level bucket and all of its contents in a single
+// read-write transaction, releasing its pages to the freelist on commit.
+// Returns ErrBucketNotFound if the bucket does not exist.
+func (db *DB) DeleteBucket(name []byte) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.DeleteBucket(name)
+	})
+}
+
+// Get returns a copy of the value for a key in an existing bucket.
+// Returns a nil value if the key does not exist, ErrKeyRequired if the key is
+// blank and ErrBucketNotFound if the bucket does not exist.
+func (db *DB) Get(bucket, key []byte) (value []byte, err error) {
+	if len(key) == 0 {
+		return nil, ErrKeyRequired
+	}
+	err = db.ViewBucket(bucket, func(b *Bucket) error {
+		if v := b.Get(key); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Put sets the value for a key in an existing bucket and commits it.
+// Concurrent calls to Put and Delete are committed in a single transaction
+// when GroupCommitWindow is set. Returns ErrKeyRequired if the key is blank
+// and ErrBucketNotFound if the bucket does not exist.
+func (db *DB) Put(bucket, key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	return db.groupCommit(bucket, func(b *Bucket) error {
+		return b.Put(key, value)
+	})
+}
+
+// Delete removes a key from an existing bucket and commits it.
+// Concurrent calls to Put and Delete are committed in a single transaction
+// when GroupCommitWindow is set. Returns ErrKeyRequired if the key is blank
+// and ErrBucketNotFound if the bucket does not exist.
+func (db *DB) Delete(bucket, key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	return db.groupCommit(bucket, func(b *Bucket) error {
+		return b.Delete(key)
+	})
+}
+
+// groupCommit runs fn against a bucket, batching it with concurrent calls if
+// GroupCommitWindow is set.
+func (db *DB) groupCommit(name []byte, fn func(*Bucket) error) error {
+	if db.GroupCommitWindow <= 0 {
+		return db.UpdateBucket(name, fn)
+	}
+	return db.batchWithin(db.GroupCommitWindow, func(tx *Tx) error {
+		b := tx.Bucket(name)
+		if b == nil {
+			return ErrBucketNotFound
+		}
+		return fn(b)
+	})
+}
+
+// maxBucketCacheSize is the maximum number of top-level bucket headers cached
+// for read-only transactions.
+const maxBucketCacheSize = 1024
+
+// bucketCacheEntry is the cached header and totals of a top-level bucket.
+type bucketCacheEntry struct {
+	bucket
+	totals bucketTotals
+}
+
+// cachedBucket returns the cached header of a top-level bucket as of the
+// committed transaction id.
+func (db *DB) cachedBucket(id txid, name []byte) (bucketCacheEntry, bool) {
+	db.cachelock.Lock()
+	defer db.cachelock.Unlock()
+	if db.cacheTxid != id {
+		return bucketCacheEntry{}, false
+	}
+	hdr, ok := db.bucketCache[string(name)]
+	return hdr, ok
+}
+
+// cacheBucket caches the header of a top-level bucket as of the committed
+// transaction id. The cache is reset when a newer transaction id is seen and
+// headers from older transactions are ignored.
+func (db *DB) cacheBucket(id txid, name []byte, hdr bucketCacheEntry) {
+	db.cachelock.Lock()
+	defer db.cachelock.Unlock()
+	if id < db.cacheTxid {
+		return
+	} else if id > db.cacheTxid || db.bucketCache == nil {
+		db.cacheTxid = id
+		db.bucketCache = make(map[string]bucketCacheEntry)
+	} else if len(db.bucketCache) >= maxBucketCacheSize {
+		return
+	}
+	db.bucketCache[string(name)] = hdr
+}
+
 // Batch calls fn as part of a batch. It behaves similar to Update,
 // except:
 //
@@ -655,6 +1748,12 @@ func (db *DB) View(fn func(*Tx) error) error {
 //
 // Batch is only useful when there are multiple goroutines calling it.
 func (db *DB) Batch(fn func(*Tx) error) error {
+	return db.batchWithin(db.MaxBatchDelay, fn)
+}
+
+// batchWithin adds fn to the current batch, starting a new batch that runs
+// after delay if there is none.
+func (db *DB) batchWithin(delay time.Duration, fn func(*Tx) error) error {
 	errCh := make(chan error, 1)
 
 	db.batchMu.Lock()
@@ -663,7 +1762,7 @@ func (db *DB) Batch(fn func(*Tx) error) error {
 		db.batch = &batch{
 			db: db,
 		}
-		db.batch.timer = time.AfterFunc(db.MaxBatchDelay, db.batch.trigger)
+		db.batch.timer = time.AfterFunc(delay, db.batch.trigger)
 	}
 	db.batch.calls = append(db.batch.calls, call{fn: fn, err: errCh})
 	if len(db.batch.calls) >= db.MaxBatchSize {
@@ -771,7 +1870,47 @@ func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 //
 // This is not necessary under normal operation, however, if you use NoSync
 // then it allows you to force the database file to sync against the disk.
-func (db *DB) Sync() error { return fdatasync(db) }
+func (db *DB) Sync() error { return db.ops.fdatasync(db) }
+
+// Ping checks that the database is usable. It verifies that the data file is
+// mapped, that the current meta page is valid and that a read-only
+// transaction can read the root bucket. Returns ctx.Err() if the check does
+// not finish before ctx is done.
+func (db *DB) Ping(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() { done <- db.ping() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (db *DB) ping() error {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The transaction holds the mmap lock so the mapping cannot change.
+	if db.data == nil || db.datasz == 0 {
+		return ErrInvalid
+	} else if err := tx.meta.validate(); err != nil {
+		return err
+	} else if int64(tx.meta.pgid)*int64(db.pageSize) > int64(db.datasz) {
+		return ErrInvalid
+	}
+
+	// Read the root bucket page.
+	p := tx.page(tx.meta.root.root)
+	if (p.flags & (branchPageFlag | leafPageFlag)) == 0 {
+		return fmt.Errorf("root page %d: invalid page type: %s", p.id, p.typ())
+	}
+	return nil
+}
 
 // Stats retrieves ongoing performance stats for the database.
 // This is only updated when a transaction closes.
@@ -800,6 +1939,20 @@ func (db *DB) pageInBuffer(b []byte, id pgid) *page {
 
 // meta retrieves the current meta page reference.
 func (db *DB) meta() *meta {
+	// Use the latest commit if its meta page has not been written yet.
+	db.pendinglock.Lock()
+	pending := db.pendingMeta
+	db.pendinglock.Unlock()
+	if pending != nil {
+		return pending
+	}
+
+	// Use the previous meta page if the database was rolled back to it because
+	// the file was truncated. It is superseded by the next commit.
+	if db.rolledBack != nil {
+		return db.rolledBack
+	}
+
 	// We have to return the meta with the highest txid which doesn't fail
 	// validation. Otherwise, we can cause errors when in fact the database is
 	// in a consistent state. metaA is the one with the higher txid.
@@ -843,7 +1996,12 @@ func (db *DB) allocate(count int) (*page, error) {
 	p.id = db.rwtx.meta.pgid
 	var minsz = int((p.id+pgid(count))+1) * db.pageSize
 	if minsz >= db.datasz {
-		if err := db.mmap(minsz); err != nil {
+		db.sampleGrowth(minsz)
+		db.rwtx.stats.Remap++
+		stop := db.watchStall(StallRemap)
+		err := db.mmap(minsz)
+		stop()
+		if err != nil {
 			return nil, fmt.Errorf("mmap allocate error: %s", err)
 		}
 	}
@@ -871,7 +2029,8 @@ func (db *DB) grow(sz int) error {
 
 	// Truncate and fsync to ensure file size metadata is flushed.
 	// https://github.com/boltdb/bolt/issues/284
-	if !db.NoGrowSync && !db.readOnly {
+	// Writes through the mapping require the file to cover every page.
+	if (!db.NoGrowSync || db.mmapWrites) && !db.readOnly {
 		if runtime.GOOS != "windows" {
 			if err := db.file.Truncate(int64(sz)); err != nil {
 				return fmt.Errorf("file resize error: %s", err)
@@ -886,6 +2045,28 @@ func (db *DB) grow(sz int) error {
 	return nil
 }
 
+// shrink truncates the data file to sz bytes after the high water mark has been
+// lowered by a commit. Failing to shrink only leaves unused space at the end of
+// the file so errors are ignored.
+func (db *DB) shrink(sz int) {
+	if sz >= db.filesz || runtime.GOOS == "windows" {
+		return
+	}
+	if err := db.file.Truncate(int64(sz)); err == nil {
+		db.filesz = sz
+	}
+}
+
+// syncData returns true if data pages must be synced on commit.
+func (db *DB) syncData() bool {
+	return IgnoreNoSync || (!db.NoSync && db.Durability == DurabilityFull)
+}
+
+// syncMeta returns true if the meta page must be synced on commit.
+func (db *DB) syncMeta() bool {
+	return IgnoreNoSync || (!db.NoSync && db.Durability != DurabilityNone)
+}
+
 func (db *DB) IsReadOnly() bool {
 	return db.readOnly
 }
@@ -916,6 +2097,60 @@ type Options struct {
 	// If initialMmapSize is smaller than the previous database size,
 	// it takes no effect.
 	InitialMmapSize int
+
+	// RefuseNetworkFS causes Open to return ErrNetworkFilesystem when the
+	// database file is on a network filesystem such as NFS or CIFS. When
+	// false, only a warning is logged. Detection is only available on Linux.
+	RefuseNetworkFS bool
+
+	// Sets the DB.GroupCommitWindow value.
+	GroupCommitWindow time.Duration
+
+	// Sets the DB.SyncInterval value.
+	SyncInterval time.Duration
+
+	// Sets the DB.MmapGrowthWindow value.
+	MmapGrowthWindow time.Duration
+
+	// Sets the DB.DropCachesOnClose flag.
+	DropCachesOnClose bool
+
+	// ReapInterval starts a goroutine that removes the expired keys of every
+	// bucket, set with Bucket.PutTTL, once per interval until the database
+	// is closed. Each pass scans every bucket in a single write transaction
+	// so the interval should be long relative to the size of the database.
+	// Expired keys are hidden from readers whether or not they are removed.
+	//
+	// If <=0 or for read-only databases, expired keys are only removed by
+	// Bucket.DeleteExpired.
+	ReapInterval time.Duration
+
+	// Ephemeral creates a new database at path and removes the file from
+	// its directory as soon as it is open, so scratch data disappears when
+	// the database is closed or the process exits or crashes. Open fails if
+	// the file already exists. The database cannot be reopened, replaced or
+	// share bloom filters across opens. On Windows, the file is removed by
+	// Close instead. Ignored for read-only databases.
+	Ephemeral bool
+
+	// UseMmapWrites maps the data file writable and copies dirty pages into
+	// the mapping instead of calling pwrite(). Syncs use msync() followed by
+	// fdatasync(). This is experimental and is ignored on Windows and for
+	// read-only databases.
+	UseMmapWrites bool
+
+	// RecoverTruncated rolls the database back to the previous transaction
+	// if the file is shorter than the current meta page requires, such as an
+	// incomplete copy, and all of the previous transaction's pages fit. The
+	// first commit after opening makes the rollback permanent. When false,
+	// Open returns a *TruncatedError for such files.
+	RecoverTruncated bool
+
+	// Sets the DB.Assertions flag. Intended for tests and canary deployments.
+	Assertions bool
+
+	// Sets the DB.Deterministic flag.
+	Deterministic bool
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -982,10 +2217,12 @@ type meta struct {
 func (m *meta) validate() error {
 	if m.magic != magic {
 		return ErrInvalid
-	} else if m.version != version {
+	} else if m.version != version && m.version != versionFeatures {
 		return ErrVersionMismatch
 	} else if m.checksum != 0 && m.checksum != m.sum64() {
 		return ErrChecksum
+	} else if (m.flags &^ features) != 0 {
+		return ErrVersionMismatch
 	}
 	return nil
 }
@@ -1003,10 +2240,13 @@ func (m *meta) write(p *page) {
 		panic(fmt.Sprintf("freelist pgid (%d) above high water mark (%d)", m.freelist, m.pgid))
 	}
 
-	// Page id is either going to be 0 or 1 which we can determine by the transaction ID.
-	p.id = pgid(m.txid % 2)
 	p.flags |= metaPageFlag
 
+	// Files that use a feature are marked with the newer version.
+	if m.flags != 0 {
+		m.version = versionFeatures
+	}
+
 	// Calculate the checksum.
 	m.checksum = m.sum64()
 
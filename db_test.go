@@ -1,7 +1,9 @@
 package bolt_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"flag"
@@ -10,10 +12,12 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -41,12 +45,12 @@ const pageHeaderSize = 16
 type meta struct {
 	magic    uint32
 	version  uint32
-	_        uint32
-	_        uint32
+	pageSize uint32
+	flags    uint32
 	_        [16]byte
-	_        uint64
+	freelist uint64
 	pgid     uint64
-	_        uint64
+	txid     uint64
 	checksum uint64
 }
 
@@ -128,11 +132,51 @@ func TestOpen_ErrVersionMismatch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Rewrite meta pages.
+	// Rewrite meta pages. The next version marks files that use format
+	// features, so skip past it.
 	meta0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
-	meta0.version++
+	meta0.version += 2
 	meta1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
-	meta1.version++
+	meta1.version += 2
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen data file.
+	if _, err := bolt.Open(path, 0666, nil); err != bolt.ErrVersionMismatch {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that opening a file that uses an unknown format feature returns
+// ErrVersionMismatch.
+func TestOpen_ErrVersionMismatch_Features(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	// Create empty database.
+	db := MustOpenDB()
+	path := db.Path()
+	defer db.MustClose()
+
+	// Close database.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read data file.
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite meta pages with an unknown feature. Checksums are not checked
+	// when they are zero.
+	for _, off := range []int{pageHeaderSize, pageSize + pageHeaderSize} {
+		m := (*meta)(unsafe.Pointer(&buf[off]))
+		m.version, m.flags, m.checksum = 3, 0x80000000, 0
+	}
 	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
 		t.Fatal(err)
 	}
@@ -180,13 +224,11 @@ func TestOpen_ErrChecksum(t *testing.T) {
 	}
 }
 
-// Ensure that opening an already open database file will timeout.
-func TestOpen_Timeout(t *testing.T) {
-	if runtime.GOOS == "solaris" {
-		t.Skip("solaris fcntl locks don't support intra-process locking")
-	}
-
+// Ensure that opening a file that is already open in this process returns
+// an error without waiting for the file lock.
+func TestOpen_ErrDatabaseOpen(t *testing.T) {
 	path := tempfile()
+	defer os.Remove(path)
 
 	// Open a data file.
 	db0, err := bolt.Open(path, 0666, nil)
@@ -196,51 +238,179 @@ func TestOpen_Timeout(t *testing.T) {
 		t.Fatal("expected database")
 	}
 
-	// Attempt to open the database again.
+	// Attempt to open the database again, writable and read-only.
+	start := time.Now()
+	for _, options := range []*bolt.Options{
+		{Timeout: time.Second},
+		{Timeout: time.Second, ReadOnly: true},
+	} {
+		if db1, err := bolt.Open(path, 0666, options); err != bolt.ErrDatabaseOpen {
+			t.Fatalf("unexpected error: %v", err)
+		} else if db1 != nil {
+			t.Fatal("unexpected database")
+		}
+	}
+	if time.Since(start) >= time.Second {
+		t.Fatal("expected not to wait for the timeout")
+	}
+
+	// Other paths to the same file are detected.
+	link := path + ".link"
+	if err := os.Link(path, link); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(link)
+	if _, err := bolt.Open(link, 0666, nil); err != bolt.ErrDatabaseOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The file can be opened again once it is closed.
+	if err := db0.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db1, err := bolt.Open(link, 0666, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := db1.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that opening a database file locked by another process will timeout.
+func TestOpen_Timeout(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+	release := lockInChild(t, path)
+	defer release()
+
+	// Attempt to open the database while the child holds it.
 	start := time.Now()
-	db1, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 100 * time.Millisecond})
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 100 * time.Millisecond})
 	if err != bolt.ErrTimeout {
 		t.Fatalf("unexpected timeout: %s", err)
-	} else if db1 != nil {
+	} else if db != nil {
 		t.Fatal("unexpected database")
 	} else if time.Since(start) <= 100*time.Millisecond {
 		t.Fatal("expected to wait at least timeout duration")
 	}
+}
 
-	if err := db0.Close(); err != nil {
+// Ensure that opening a database file locked by another process will wait
+// until it is closed.
+func TestOpen_Wait(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+	release := lockInChild(t, path)
+
+	// Have the child close it in just a bit.
+	time.AfterFunc(100*time.Millisecond, release)
+
+	// Attempt to open the database again.
+	start := time.Now()
+	db, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	} else if time.Since(start) <= 100*time.Millisecond {
+		t.Fatal("expected to wait at least timeout duration")
+	}
+	if err := db.Close(); err != nil {
 		t.Fatal(err)
 	}
 }
 
-// Ensure that opening an already open database file will wait until its closed.
-func TestOpen_Wait(t *testing.T) {
-	if runtime.GOOS == "solaris" {
-		t.Skip("solaris fcntl locks don't support intra-process locking")
+// lockInChild opens the database at path in a child process, which holds the
+// file lock until the returned function is called.
+func lockInChild(t *testing.T, path string) (release func()) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess_Lock$")
+	cmd.Env = append(os.Environ(), "BOLT_TEST_LOCK_PATH="+path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	path := tempfile()
-
-	// Open a data file.
-	db0, err := bolt.Open(path, 0666, nil)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
 
-	// Close it in just a bit.
-	time.AfterFunc(100*time.Millisecond, func() { _ = db0.Close() })
+	// Wait for the child to report that it holds the lock.
+	s := bufio.NewScanner(stdout)
+	locked := false
+	for !locked && s.Scan() {
+		locked = s.Text() == "locked"
+	}
+	if !locked {
+		err := s.Err()
+		if err == nil {
+			err = cmd.Wait()
+		}
+		t.Fatalf("child exited without taking the lock: %v", err)
+	}
 
-	// Attempt to open the database again.
-	start := time.Now()
-	db1, err := bolt.Open(path, 0666, &bolt.Options{Timeout: 200 * time.Millisecond})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stdin.Close()
+			_ = cmd.Wait()
+		})
+	}
+}
+
+// TestHelperProcess_Lock is run by lockInChild in a child process. It holds
+// the database open until its standard input is closed.
+func TestHelperProcess_Lock(t *testing.T) {
+	path := os.Getenv("BOLT_TEST_LOCK_PATH")
+	if path == "" {
+		t.Skip("helper process")
+	}
+	db, err := bolt.Open(path, 0666, nil)
 	if err != nil {
 		t.Fatal(err)
-	} else if time.Since(start) <= 100*time.Millisecond {
-		t.Fatal("expected to wait at least timeout duration")
 	}
+	fmt.Println("locked")
+	_, _ = ioutil.ReadAll(os.Stdin)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	if err := db1.Close(); err != nil {
+// Ensure that Path returns the canonical path of the file however it was named.
+func TestOpen_CanonicalPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
 		t.Fatal(err)
 	}
+	path := filepath.Join(dir, "db")
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	for _, p := range []string{path, link, filepath.Join(dir, "x", "..", "link")} {
+		db, err := bolt.Open(p, 0666, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if db.Path() != path {
+			t.Fatalf("unexpected path for %s: %s", p, db.Path())
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := db.Reopen(); err != nil {
+			t.Fatal(err)
+		} else if db.Path() != path {
+			t.Fatalf("unexpected path after reopen: %s", db.Path())
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
 // Ensure that opening a database does not increase its size.
@@ -398,91 +568,325 @@ func TestOpen_Check(t *testing.T) {
 	}
 }
 
-// Ensure that write errors to the meta file handler during initialization are returned.
-func TestOpen_MetaInitWriteError(t *testing.T) {
-	t.Skip("pending")
+// Ensure that opening a file whose meta pages describe pages or sizes that do
+// not fit in the file returns ErrCorrupt.
+func TestOpen_ErrCorrupt(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	for _, tt := range []struct {
+		name   string
+		modify func(m *meta)
+	}{
+		{"pageSize", func(m *meta) { m.pageSize = 1 << 30 }},
+		{"pgid", func(m *meta) { m.pgid = 1 << 40 }},
+		{"freelist", func(m *meta) { m.freelist = 1 << 20 }},
+	} {
+		// Create empty database.
+		db := MustOpenDB()
+		path := db.Path()
+		if err := db.DB.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Rewrite both meta pages. A zero checksum skips verification.
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, off := range []int{0, pageSize} {
+			m := (*meta)(unsafe.Pointer(&buf[off+pageHeaderSize]))
+			tt.modify(m)
+			m.checksum = 0
+		}
+		if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := bolt.Open(path, 0666, nil); err != bolt.ErrCorrupt {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		} else if _, err := bolt.OpenBytes(buf, nil); err != bolt.ErrCorrupt && tt.name != "pgid" {
+			t.Fatalf("%s: unexpected bytes error: %v", tt.name, err)
+		}
+		os.Remove(path)
+	}
 }
 
-// Ensure that a database that is too small returns an error.
-func TestOpen_FileTooSmall(t *testing.T) {
-	path := tempfile()
+// Ensure that a file without a stored freelist, as written by bbolt with
+// NoFreelistSync, can be opened and is converted by the next commit.
+func TestOpen_NoFreelist(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	path := db.Path()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
 
-	db, err := bolt.Open(path, 0666, nil)
+	// Rewrite both meta pages without a freelist.
+	buf, err := ioutil.ReadFile(path)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := db.Close(); err != nil {
+	for _, off := range []int{0, pageSize} {
+		m := (*meta)(unsafe.Pointer(&buf[off+pageHeaderSize]))
+		m.freelist = ^uint64(0)
+		m.checksum = 0
+	}
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
 		t.Fatal(err)
 	}
 
-	// corrupt the database
-	if err := os.Truncate(path, int64(os.Getpagesize())); err != nil {
+	if err := db.Reopen(); err != nil {
 		t.Fatal(err)
 	}
-
-	db, err = bolt.Open(path, 0666, nil)
-	if err == nil || err.Error() != "file size too small" {
-		t.Fatalf("unexpected error: %s", err)
+	db.MustCheck()
+	if n := db.Stats().FreePageN; n == 0 {
+		t.Fatal("expected free pages to be rebuilt")
 	}
-}
 
-// Ensure that a database can be opened in read-only mode by multiple processes
-// and that a database can not be opened in read-write mode and in read-only
-// mode at the same time.
-func TestOpen_ReadOnly(t *testing.T) {
-	if runtime.GOOS == "solaris" {
-		t.Skip("solaris fcntl locks don't support intra-process locking")
+	// A rolled back transaction restores the rebuilt freelist.
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := tx.Bucket([]byte("widgets")).Put([]byte("baz"), make([]byte, 10000)); err != nil {
+		t.Fatal(err)
+	} else if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
 	}
+	db.MustCheck()
 
-	bucket, key, value := []byte(`bucket`), []byte(`key`), []byte(`value`)
-
-	path := tempfile()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return b.Put([]byte("baz"), []byte("bat"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
 
-	// Open in read-write mode.
-	db, err := bolt.Open(path, 0666, nil)
-	if err != nil {
+	// The commit stored a freelist.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if buf, err = ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	m0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
+	m1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
+	if m0.freelist == ^uint64(0) && m1.freelist == ^uint64(0) {
+		t.Fatal("expected a freelist to be written")
+	} else if err := db.Reopen(); err != nil {
 		t.Fatal(err)
-	} else if db.IsReadOnly() {
-		t.Fatal("db should not be in read only mode")
 	}
+}
+
+// Ensure that a file truncated below its high water mark is reported and can be
+// rolled back to the previous transaction.
+func TestOpen_Truncated(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	db := MustOpenDB()
+	path := db.Path()
 	if err := db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucket(bucket)
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			return err
 		}
-		if err := b.Put(key, value); err != nil {
-			t.Fatal(err)
-		}
-		return nil
+		return b.Put([]byte("foo"), []byte("bar"))
 	}); err != nil {
 		t.Fatal(err)
 	}
-	if err := db.Close(); err != nil {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("baz"), make([]byte, 4*pageSize))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Close(); err != nil {
 		t.Fatal(err)
 	}
+	defer os.Remove(path)
 
-	// Open in read-only mode.
-	db0, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	// Cut the file at the high water mark of the previous transaction.
+	buf, err := ioutil.ReadFile(path)
 	if err != nil {
 		t.Fatal(err)
 	}
+	m0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
+	m1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
+	prev, cur := m0.pgid, m1.pgid
+	if prev > cur {
+		prev, cur = cur, prev
+	}
+	buf = buf[:prev*pageSize]
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
 
-	// Opening in read-write mode should return an error.
-	if _, err = bolt.Open(path, 0666, &bolt.Options{Timeout: time.Millisecond * 100}); err == nil {
+	if _, err := bolt.Open(path, 0666, nil); err == nil {
 		t.Fatal("expected error")
+	} else if e, ok := err.(*bolt.TruncatedError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	} else if e.Size != int64(len(buf)) || e.Expected != int64(cur*pageSize) {
+		t.Fatalf("unexpected sizes: %d, %d", e.Size, e.Expected)
+	}
+	if _, err := bolt.OpenBytes(buf, &bolt.Options{RecoverTruncated: true}); err != nil {
+		t.Fatal(err)
 	}
 
-	// And again (in read-only mode).
-	db1, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	// Recover, then commit to make the rollback permanent.
+	db2, err := bolt.Open(path, 0666, &bolt.Options{RecoverTruncated: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// Verify both read-only databases are accessible.
-	for _, db := range []*bolt.DB{db0, db1} {
-		// Verify is is in read only mode indeed.
-		if !db.IsReadOnly() {
-			t.Fatal("expected read only mode")
+	if err := db2.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("baz")); v != nil {
+			t.Fatal("expected rolled back value to be missing")
+		}
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("bat"), []byte("qux"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db3, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db3.Close()
+	if err := db3.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("bat")); string(v) != "qux" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that write errors to the meta file handler during initialization are returned.
+func TestOpen_MetaInitWriteError(t *testing.T) {
+	t.Skip("pending")
+}
+
+// Ensure that a database that is too small returns an error.
+func TestOpen_FileTooSmall(t *testing.T) {
+	path := tempfile()
+
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the database
+	if err := os.Truncate(path, int64(os.Getpagesize())); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = bolt.Open(path, 0666, nil)
+	if err == nil || err.Error() != "file size too small" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that a database can be opened in read-only mode by multiple processes
+// and that a database can not be opened in read-write mode and in read-only
+// mode at the same time.
+func TestOpen_ReadOnly(t *testing.T) {
+	if runtime.GOOS == "solaris" {
+		t.Skip("solaris fcntl locks don't support intra-process locking")
+	}
+
+	bucket, key, value := []byte(`bucket`), []byte(`key`), []byte(`value`)
+
+	path := tempfile()
+
+	// Open in read-write mode.
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if db.IsReadOnly() {
+		t.Fatal("db should not be in read only mode")
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(bucket)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open in read-only mode.
+	db0, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening in read-write mode should return an error.
+	if _, err = bolt.Open(path, 0666, &bolt.Options{Timeout: time.Millisecond * 100}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// And again (in read-only mode).
+	db1, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify both read-only databases are accessible.
+	for _, db := range []*bolt.DB{db0, db1} {
+		// Verify is is in read only mode indeed.
+		if !db.IsReadOnly() {
+			t.Fatal("expected read only mode")
 		}
 
 		// Read-only databases should not allow updates.
@@ -523,6 +927,191 @@ func TestOpen_ReadOnly(t *testing.T) {
 	}
 }
 
+// Ensure that RefuseNetworkFS does not reject a database on a local filesystem.
+func TestOpen_RefuseNetworkFS(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{RefuseNetworkFS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that data written through a writable mapping can be read back
+// after reopening the database normally.
+func TestOpen_UseMmapWrites(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{UseMmapWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write enough data to grow and remap the file several times.
+	for i := 0; i < 10; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 1000; j++ {
+				if err := b.Put([]byte(fmt.Sprintf("%02d%04d", i, j)), make([]byte, 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 10000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a database opened with DropCachesOnClose closes cleanly and
+// can be reopened.
+func TestOpen_DropCachesOnClose(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{DropCachesOnClose: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if !db.DropCachesOnClose {
+		t.Fatal("expected DropCachesOnClose to be set")
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = bolt.Open(path, 0666, &bolt.Options{DropCachesOnClose: true, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that an ephemeral database is usable but leaves no file behind.
+func TestOpen_Ephemeral(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{Ephemeral: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected file to be removed: %v", err)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file can still be copied and scanned.
+	var buf bytes.Buffer
+	if err := db.View(func(tx *bolt.Tx) error {
+		var n int
+		if err := tx.Bucket([]byte("widgets")).ForEachDirect(func(k, v []byte) error {
+			n++
+			return nil
+		}); err != nil {
+			return err
+		} else if n != 1000 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return tx.Copy(&buf)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cdb, err := bolt.OpenBytes(buf.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cdb.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 1000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := cdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected file to be removed: %v", err)
+	} else if err := db.Reopen(); err != bolt.ErrReopenNotSupported {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Existing files are never used as scratch space.
+	if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	} else if _, err := bolt.Open(path, 0666, &bolt.Options{Ephemeral: true}); !os.IsExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected existing file to be kept: %v", err)
+	}
+}
+
 // TestDB_Open_InitialMmapSize tests if having InitialMmapSize large enough
 // to hold data from concurrent write transaction resolves the issue that
 // read transaction blocks the write transaction and causes deadlock.
@@ -583,24 +1172,117 @@ func TestDB_Open_InitialMmapSize(t *testing.T) {
 	}
 }
 
-// Ensure that a database cannot open a transaction when it's not open.
-func TestDB_Begin_ErrDatabaseNotOpen(t *testing.T) {
-	var db bolt.DB
-	if _, err := db.Begin(false); err != bolt.ErrDatabaseNotOpen {
-		t.Fatalf("unexpected error: %s", err)
-	}
-}
-
-// Ensure that a read-write transaction can be retrieved.
-func TestDB_BeginRW(t *testing.T) {
+// Ensure that a database can be opened read-only from an in-memory copy.
+func TestOpenBytes(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
-
-	tx, err := db.Begin(true)
-	if err != nil {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
 		t.Fatal(err)
-	} else if tx == nil {
-		t.Fatal("expected tx")
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.Backup(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, open := range []func() (*bolt.DB, error){
+		func() (*bolt.DB, error) { return bolt.OpenBytes(buf.Bytes(), nil) },
+		func() (*bolt.DB, error) {
+			return bolt.OpenReaderAt(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+		},
+	} {
+		mdb, err := open()
+		if err != nil {
+			t.Fatal(err)
+		} else if !mdb.IsReadOnly() {
+			t.Fatal("expected read only mode")
+		}
+
+		if err := mdb.View(func(tx *bolt.Tx) error {
+			if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+				t.Fatalf("unexpected value: %q", v)
+			}
+
+			// Ensure the in-memory database can be copied back out.
+			var cp bytes.Buffer
+			if _, err := tx.WriteTo(&cp); err != nil {
+				return err
+			} else if !bytes.Equal(cp.Bytes(), buf.Bytes()) {
+				t.Fatal("copy does not match source")
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mdb.Update(func(*bolt.Tx) error {
+			panic("should never get here")
+		}); err != bolt.ErrDatabaseReadOnly {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := mdb.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Ensure that truncated in-memory data is rejected.
+func TestOpenBytes_Truncated(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var buf bytes.Buffer
+	if _, err := db.Backup(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bolt.OpenBytes(buf.Bytes()[:buf.Len()-db.Info().PageSize], nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure that the options that apply to in-memory databases are honored.
+func TestOpenBytes_Options(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var buf bytes.Buffer
+	if _, err := db.Backup(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	mdb, err := bolt.OpenBytes(buf.Bytes(), &bolt.Options{Assertions: true, Timeout: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	} else if !mdb.Assertions {
+		t.Fatal("expected assertions to be enabled")
+	} else if err := mdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a database cannot open a transaction when it's not open.
+func TestDB_Begin_ErrDatabaseNotOpen(t *testing.T) {
+	var db bolt.DB
+	if _, err := db.Begin(false); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that a read-write transaction can be retrieved.
+func TestDB_BeginRW(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	} else if tx == nil {
+		t.Fatal("expected tx")
 	}
 
 	if tx.DB() != db.DB {
@@ -631,7 +1313,7 @@ func testDB_Close_PendingTx(t *testing.T, writable bool) {
 	defer db.MustClose()
 
 	// Start transaction.
-	tx, err := db.Begin(true)
+	tx, err := db.Begin(writable)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -653,8 +1335,12 @@ func testDB_Close_PendingTx(t *testing.T, writable bool) {
 	default:
 	}
 
-	// Commit transaction.
-	if err := tx.Commit(); err != nil {
+	// Commit or roll back transaction.
+	if writable {
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := tx.Rollback(); err != nil {
 		t.Fatal(err)
 	}
 
@@ -667,6 +1353,266 @@ func testDB_Close_PendingTx(t *testing.T, writable bool) {
 	}
 }
 
+// Ensure that transactions started while the database is closing return an error.
+func TestDB_Close_BeginWhileClosing(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- db.DB.Close() }()
+	time.Sleep(50 * time.Millisecond)
+
+	// Begin in the background since it waits for the close to finish.
+	begun := make(chan error, 1)
+	go func() {
+		_, err := db.Begin(false)
+		begun <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	} else if err := <-begun; err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that CloseContext rejects new transactions while waiting for open ones.
+func TestDB_CloseContext(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- db.CloseContext(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// New transactions fail immediately instead of waiting.
+	if _, err := db.Begin(false); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := db.Begin(true); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-closed:
+		t.Fatal("database closed too early")
+	default:
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that CloseContext leaves the database open for readers that are
+// still open at the deadline but keeps rejecting new transactions.
+func TestDB_CloseContext_Readers(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := db.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The reader remains usable but new transactions are still rejected.
+	if tx.Bucket([]byte("widgets")) != nil {
+		t.Fatal("unexpected bucket")
+	} else if _, err := db.Begin(false); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close finishes the shutdown and a reopened database accepts
+	// transactions again.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	} else if err := db.View(func(*bolt.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that CloseContext does not roll back an open write transaction.
+func TestDB_CloseContext_Writer(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := db.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The writer can still commit but new transactions are rejected.
+	if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	} else if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Begin(true); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the string representation of a database includes its path and state.
+func TestDB_String(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	path := db.Path()
+	if s, exp := db.String(), fmt.Sprintf("DB<%q open>", path); s != exp {
+		t.Fatalf("unexpected string: %s", s)
+	} else if s, exp := db.GoString(), fmt.Sprintf("bolt.DB{path:%q, opened:true, readOnly:false}", path); s != exp {
+		t.Fatalf("unexpected go string: %s", s)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if s, exp := db.String(), fmt.Sprintf("DB<%q closed>", path); s != exp {
+		t.Fatalf("unexpected string: %s", s)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a closed database can be reopened in place.
+func TestDB_Reopen(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := db.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	} else if db.Path() != path {
+		t.Fatalf("unexpected path: %s", db.Path())
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return b.Put([]byte("baz"), []byte("bat"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that reopening an open database returns an error.
+func TestDB_Reopen_Open(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Reopen(); err != bolt.ErrDatabaseOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that Ping succeeds on an open database and fails after Close.
+func TestDB_Ping(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	path := db.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Ping(context.Background()); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	} else if db.Path() != path {
+		t.Fatalf("unexpected path: %s", db.Path())
+	}
+}
+
+// Ensure that Ping returns when its context is done while a check blocks.
+func TestDB_Ping_Deadline(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A pending close blocks new transactions.
+	closed := make(chan error, 1)
+	go func() { closed <- db.DB.Close() }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := db.Ping(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := <-closed; err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure a database can provide a transactional block.
 func TestDB_Update(t *testing.T) {
 	db := MustOpenDB()
@@ -694,25 +1640,309 @@ func TestDB_Update(t *testing.T) {
 		if v := b.Get([]byte("foo")); v != nil {
 			t.Fatalf("expected nil value, got: %v", v)
 		}
-		if v := b.Get([]byte("baz")); !bytes.Equal(v, []byte("bat")) {
-			t.Fatalf("unexpected value: %v", v)
+		if v := b.Get([]byte("baz")); !bytes.Equal(v, []byte("bat")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a closed database returns an error while running a transaction block
+func TestDB_Update_Closed(t *testing.T) {
+	var db bolt.DB
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != bolt.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that UpdateWithRetry retries a conflicting read-modify-write.
+func TestDB_UpdateWithRetry(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("counter"), u64tob(0))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the counter in a read-only transaction and increment it with a
+	// compare-and-swap. The first attempt returns a conflict directly and
+	// the second swaps against a stale value.
+	var calls int
+	if err := db.UpdateWithRetry(func(tx *bolt.Tx) error {
+		calls++
+		if calls == 1 {
+			return bolt.ErrConflict
+		}
+		old, err := db.Get([]byte("widgets"), []byte("counter"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls == 2 {
+			old = u64tob(5)
+		}
+		return tx.Bucket([]byte("widgets")).CompareAndSwap([]byte("counter"), old, u64tob(btou64(old)+1))
+	}, 5); err != nil {
+		t.Fatal(err)
+	} else if calls != 3 {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+
+	if v, err := db.Get([]byte("widgets"), []byte("counter")); err != nil {
+		t.Fatal(err)
+	} else if btou64(v) != 1 {
+		t.Fatalf("unexpected value: %d", btou64(v))
+	}
+
+	// Ensure ErrConflict is returned once the retries are exhausted.
+	calls = 0
+	if err := db.UpdateWithRetry(func(tx *bolt.Tx) error {
+		calls++
+		return bolt.ErrConflict
+	}, 2); err != bolt.ErrConflict {
+		t.Fatalf("unexpected error: %v", err)
+	} else if calls != 3 {
+		t.Fatalf("unexpected calls: %d", calls)
+	}
+}
+
+// Ensure that the authorizer can restrict access to buckets and keys.
+func TestDB_Authorize(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"tenant-a", "tenant-b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only allow access to tenant-a and record each operation.
+	errDenied := errors.New("denied")
+	var ops []string
+	db.Authorize = func(op bolt.Op, bucket [][]byte, key []byte) error {
+		ops = append(ops, fmt.Sprintf("%s %s %s", op, bytes.Join(bucket, []byte("/")), key))
+		if bucket == nil && key != nil && !bytes.Equal(key, []byte("tenant-a")) {
+			return errDenied
+		}
+		if bytes.Equal(key, []byte("secret")) {
+			return errDenied
+		}
+		return nil
+	}
+	defer func() { db.Authorize = nil }()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("tenant-b")) != nil {
+			t.Fatal("expected tenant-b to be denied")
+		} else if tx.HasBucket([]byte("tenant-b")) {
+			t.Fatal("expected tenant-b to be hidden")
+		} else if _, err := tx.CreateBucket([]byte("tenant-c")); err != errDenied {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := tx.DeleteBucket([]byte("tenant-b")); err != errDenied {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var names []string
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if !(len(names) == 1 && names[0] == "tenant-a") {
+			t.Fatalf("unexpected names: %v", names)
+		}
+
+		b := tx.Bucket([]byte("tenant-a"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if err := b.Put([]byte("secret"), []byte("baz")); err != errDenied {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ops[0] != "bucket  tenant-b" {
+		t.Fatalf("unexpected first op: %q", ops[0])
+	} else if last := ops[len(ops)-1]; last != "delete tenant-a foo" {
+		t.Fatalf("unexpected last op: %q", last)
+	}
+
+	if names, err := db.Buckets(nil); err != nil {
+		t.Fatal(err)
+	} else if !(len(names) == 1 && string(names[0]) == "tenant-a") {
+		t.Fatalf("unexpected names: %q", names)
+	}
+
+	// Iteration can be denied on its own, including through direct I/O.
+	db.Authorize = func(op bolt.Op, bucket [][]byte, key []byte) error {
+		if op == bolt.OpForEach && bucket != nil {
+			return errDenied
+		}
+		return nil
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tenant-a"))
+		fn := func(k, v []byte) error { return nil }
+		if err := b.ForEach(fn); err != errDenied {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := b.ForEachDirect(fn); err != errDenied {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the authorizer is passed the full path of nested buckets so
+// that buckets with the same name under different parents can be told apart.
+func TestDB_Authorize_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"tenant-a", "tenant-b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			users, err := b.CreateBucket([]byte("users"))
+			if err != nil {
+				return err
+			} else if err := users.Put([]byte("foo"), []byte("bar")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errDenied := errors.New("denied")
+	db.Authorize = func(op bolt.Op, bucket [][]byte, key []byte) error {
+		if len(bucket) == 2 && string(bucket[0]) == "tenant-b" && string(bucket[1]) == "users" {
+			return errDenied
+		}
+		return nil
+	}
+	defer func() { db.Authorize = nil }()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("tenant-a")).Bucket([]byte("users")).Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := tx.Bucket([]byte("tenant-b")).Bucket([]byte("users")).Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that committed mutations are written to the audit log.
+func TestDB_Audit(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var buf bytes.Buffer
+	db.Audit = &buf
+	db.AuditValues = bolt.AuditValueHash
+	defer func() { db.Audit = nil }()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte("missing")); err != nil {
+			return err
 		}
-		return nil
+		return b.Delete([]byte("foo"))
 	}); err != nil {
 		t.Fatal(err)
 	}
+
+	// Rolled back changes are not recorded.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).Put([]byte("baz"), []byte("bat")); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	re := regexp.MustCompile(`(?m)^time=\S+ `)
+	exp := `tx=2 op=create-bucket bucket="" key="widgets"` + "\n" +
+		`tx=2 op=put bucket="widgets" key="foo" value=fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9` + "\n" +
+		`tx=2 op=delete bucket="widgets" key="foo"` + "\n"
+	if s := re.ReplaceAllString(buf.String(), ""); s != exp {
+		t.Fatalf("unexpected audit log:\n%s", buf.String())
+	}
 }
 
-// Ensure a closed database returns an error while running a transaction block
-func TestDB_Update_Closed(t *testing.T) {
-	var db bolt.DB
+// Ensure that the audit log tells nested buckets with the same name apart.
+func TestDB_Audit_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var buf bytes.Buffer
+	db.Audit = &buf
+	defer func() { db.Audit = nil }()
+
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
-			t.Fatal(err)
+		for _, name := range []string{"tenant-a", "tenant-b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			users, err := b.CreateBucket([]byte("users"))
+			if err != nil {
+				return err
+			} else if err := users.Put([]byte("foo"), []byte("bar")); err != nil {
+				return err
+			}
 		}
 		return nil
-	}); err != bolt.ErrDatabaseNotOpen {
-		t.Fatalf("unexpected error: %s", err)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	re := regexp.MustCompile(`(?m)^time=\S+ `)
+	exp := `tx=2 op=create-bucket bucket="" key="tenant-a"` + "\n" +
+		`tx=2 op=create-bucket bucket="tenant-a" key="users"` + "\n" +
+		`tx=2 op=put bucket="tenant-a"/"users" key="foo"` + "\n" +
+		`tx=2 op=create-bucket bucket="" key="tenant-b"` + "\n" +
+		`tx=2 op=create-bucket bucket="tenant-b" key="users"` + "\n" +
+		`tx=2 op=put bucket="tenant-b"/"users" key="foo"` + "\n"
+	if s := re.ReplaceAllString(buf.String(), ""); s != exp {
+		t.Fatalf("unexpected audit log:\n%s", buf.String())
 	}
 }
 
@@ -919,10 +2149,334 @@ func TestDB_View_Panic(t *testing.T) {
 	}
 }
 
-// Ensure that DB stats can be returned.
-func TestDB_Stats(t *testing.T) {
+// Ensure that the same transactions produce identical files in deterministic
+// mode.
+func TestDB_Deterministic(t *testing.T) {
+	build := func() []byte {
+		path := tempfile()
+		defer os.Remove(path)
+		db, err := bolt.Open(path, 0666, &bolt.Options{Deterministic: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetTombstones(true, []byte("bucket3"))
+
+		for i := 0; i < 5; i++ {
+			if err := db.Update(func(tx *bolt.Tx) error {
+				for j := 0; j < 20; j++ {
+					b, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("bucket%d", j)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					child, err := b.CreateBucketIfNotExists([]byte("child"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					for k := 0; k < 200; k++ {
+						key := u64tob(uint64(i*200 + k))
+						if err := b.Put(key, make([]byte, 50)); err != nil {
+							t.Fatal(err)
+						} else if err := child.Put(key, []byte("value")); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Delete across many nodes of many buckets to rebalance them.
+		if err := db.Update(func(tx *bolt.Tx) error {
+			for j := 0; j < 20; j++ {
+				b := tx.Bucket([]byte(fmt.Sprintf("bucket%d", j)))
+				for k := 0; k < 1000; k++ {
+					if k%7 == 0 {
+						continue
+					} else if err := b.Delete(u64tob(uint64(k))); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+
+	a, b := build(), build()
+	if !bytes.Equal(a, b) {
+		t.Fatalf("expected identical files of %d and %d bytes", len(a), len(b))
+	}
+}
+
+// Ensure that free pages at the end of the file are trimmed on commit.
+func TestDB_TrimThreshold(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.TrimThreshold = 16
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("small")); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte("large"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 1024)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := fileSize(db.Path())
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("large"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages freed by the delete are released and trimmed by later commits.
+	for i := 0; i < 2; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("small")).Put([]byte("foo"), []byte("bar"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := fileSize(db.Path()); after > before/4 {
+		t.Fatalf("expected file to shrink: %d -> %d", before, after)
+	}
+
+	// The trimmed file must be consistent and able to grow again.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("large"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 1024)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+}
+
+// Ensure that DB stats can be returned.
+func TestDB_Stats(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.Stats()
+	if stats.TxStats.PageCount != 2 {
+		t.Fatalf("unexpected TxStats.PageCount: %d", stats.TxStats.PageCount)
+	} else if stats.FreePageN != 0 {
+		t.Fatalf("unexpected FreePageN != 0: %d", stats.FreePageN)
+	} else if stats.PendingPageN != 2 {
+		t.Fatalf("unexpected PendingPageN != 2: %d", stats.PendingPageN)
+	}
+}
+
+// Ensure that database pages are in expected order and type.
+func TestDB_Consistency(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			if err := tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if p, _ := tx.Page(0); p == nil {
+			t.Fatal("expected page")
+		} else if p.Type != "meta" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
+
+		if p, _ := tx.Page(1); p == nil {
+			t.Fatal("expected page")
+		} else if p.Type != "meta" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
+
+		if p, _ := tx.Page(2); p == nil {
+			t.Fatal("expected page")
+		} else if p.Type != "free" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
+
+		if p, _ := tx.Page(3); p == nil {
+			t.Fatal("expected page")
+		} else if p.Type != "free" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
+
+		if p, _ := tx.Page(4); p == nil {
+			t.Fatal("expected page")
+		} else if p.Type != "leaf" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
+
+		if p, _ := tx.Page(5); p == nil {
+			t.Fatal("expected page")
+		} else if p.Type != "freelist" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
+
+		if p, _ := tx.Page(6); p != nil {
+			t.Fatal("unexpected page")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that DB stats can be subtracted from one another.
+func TestDBStats_Sub(t *testing.T) {
+	var a, b bolt.Stats
+	a.TxStats.PageCount = 3
+	a.FreePageN = 4
+	b.TxStats.PageCount = 10
+	b.FreePageN = 14
+	diff := b.Sub(&a)
+	if diff.TxStats.PageCount != 7 {
+		t.Fatalf("unexpected TxStats.PageCount: %d", diff.TxStats.PageCount)
+	}
+
+	// free page stats are copied from the receiver and not subtracted
+	if diff.FreePageN != 14 {
+		t.Fatalf("unexpected FreePageN: %d", diff.FreePageN)
+	}
+}
+
+// Ensure that a single bucket can be updated and read without a bucket lookup.
+func TestDB_UpdateBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Missing buckets return an error.
+	if err := db.UpdateBucket([]byte("widgets"), func(*bolt.Bucket) error {
+		panic("should never get here")
+	}); err != bolt.ErrBucketNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := db.ViewBucket([]byte("widgets"), func(*bolt.Bucket) error {
+		panic("should never get here")
+	}); err != bolt.ErrBucketNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UpdateBucket([]byte("widgets"), func(b *bolt.Bucket) error {
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ViewBucket([]byte("widgets"), func(b *bolt.Bucket) error {
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that DB.DeleteBucket removes a bucket and frees its pages.
+func TestDB_DeleteBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.DeleteBucket([]byte("widgets")); err != bolt.ErrBucketNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stats := db.Stats()
+	free := stats.FreePageN + stats.PendingPageN
+
+	if err := db.DeleteBucket([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.HasBucket([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected bucket to be deleted")
+	}
+	stats = db.Stats()
+	if n := stats.FreePageN + stats.PendingPageN; n <= free {
+		t.Fatalf("expected pages to be freed: %d <= %d", n, free)
+	}
+}
+
+// Ensure that DB.Put, DB.Get and DB.Delete operate on an existing bucket.
+func TestDB_Put(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
+
+	if err := db.Put([]byte("widgets"), []byte("foo"), []byte("bar")); err != bolt.ErrBucketNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := db.Get([]byte("widgets"), []byte("foo")); err != bolt.ErrBucketNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
 	if err := db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte("widgets"))
 		return err
@@ -930,99 +2484,92 @@ func TestDB_Stats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	stats := db.Stats()
-	if stats.TxStats.PageCount != 2 {
-		t.Fatalf("unexpected TxStats.PageCount: %d", stats.TxStats.PageCount)
-	} else if stats.FreePageN != 0 {
-		t.Fatalf("unexpected FreePageN != 0: %d", stats.FreePageN)
-	} else if stats.PendingPageN != 2 {
-		t.Fatalf("unexpected PendingPageN != 2: %d", stats.PendingPageN)
+	if err := db.Put([]byte("widgets"), []byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	} else if v, err := db.Get([]byte("widgets"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("unexpected value: %q", v)
+	}
+
+	if err := db.Delete([]byte("widgets"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	} else if v, err := db.Get([]byte("widgets"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("unexpected value: %q", v)
+	}
+
+	// Blank keys are rejected.
+	if _, err := db.Get([]byte("widgets"), nil); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := db.Put([]byte("widgets"), []byte{}, []byte("bar")); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := db.Delete([]byte("widgets"), nil); err != bolt.ErrKeyRequired {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Ensure that database pages are in expected order and type.
-func TestDB_Consistency(t *testing.T) {
+// Ensure that concurrent calls to DB.Put share a commit within the window.
+func TestDB_Put_GroupCommit(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
+	db.GroupCommitWindow = 100 * time.Millisecond
+
 	if err := db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte("widgets"))
 		return err
 	}); err != nil {
 		t.Fatal(err)
 	}
-
-	for i := 0; i < 10; i++ {
-		if err := db.Update(func(tx *bolt.Tx) error {
-			if err := tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar")); err != nil {
-				t.Fatal(err)
-			}
+	txid := func() (id int) {
+		if err := db.View(func(tx *bolt.Tx) error {
+			id = tx.ID()
 			return nil
 		}); err != nil {
 			t.Fatal(err)
 		}
+		return id
 	}
+	start := txid()
 
-	if err := db.Update(func(tx *bolt.Tx) error {
-		if p, _ := tx.Page(0); p == nil {
-			t.Fatal("expected page")
-		} else if p.Type != "meta" {
-			t.Fatalf("unexpected page type: %s", p.Type)
-		}
-
-		if p, _ := tx.Page(1); p == nil {
-			t.Fatal("expected page")
-		} else if p.Type != "meta" {
-			t.Fatalf("unexpected page type: %s", p.Type)
-		}
-
-		if p, _ := tx.Page(2); p == nil {
-			t.Fatal("expected page")
-		} else if p.Type != "free" {
-			t.Fatalf("unexpected page type: %s", p.Type)
-		}
-
-		if p, _ := tx.Page(3); p == nil {
-			t.Fatal("expected page")
-		} else if p.Type != "free" {
-			t.Fatalf("unexpected page type: %s", p.Type)
-		}
-
-		if p, _ := tx.Page(4); p == nil {
-			t.Fatal("expected page")
-		} else if p.Type != "leaf" {
-			t.Fatalf("unexpected page type: %s", p.Type)
-		}
-
-		if p, _ := tx.Page(5); p == nil {
-			t.Fatal("expected page")
-		} else if p.Type != "freelist" {
-			t.Fatalf("unexpected page type: %s", p.Type)
+	const n = 10
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			errs <- db.Put([]byte("widgets"), []byte(fmt.Sprintf("%02d", i)), []byte("bar"))
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
 		}
+	}
 
-		if p, _ := tx.Page(6); p != nil {
-			t.Fatal("unexpected page")
+	if commits := txid() - start; commits >= n {
+		t.Fatalf("expected fewer than %d commits, got %d", n, commits)
+	}
+	for i := 0; i < n; i++ {
+		if v, err := db.Get([]byte("widgets"), []byte(fmt.Sprintf("%02d", i))); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
 	}
 }
 
-// Ensure that DB stats can be subtracted from one another.
-func TestDBStats_Sub(t *testing.T) {
-	var a, b bolt.Stats
-	a.TxStats.PageCount = 3
-	a.FreePageN = 4
-	b.TxStats.PageCount = 10
-	b.FreePageN = 14
-	diff := b.Sub(&a)
-	if diff.TxStats.PageCount != 7 {
-		t.Fatalf("unexpected TxStats.PageCount: %d", diff.TxStats.PageCount)
-	}
+// Ensure that options set the group commit window.
+func TestOpen_GroupCommitWindow(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
 
-	// free page stats are copied from the receiver and not subtracted
-	if diff.FreePageN != 14 {
-		t.Fatalf("unexpected FreePageN: %d", diff.FreePageN)
+	db, err := bolt.Open(path, 0666, &bolt.Options{GroupCommitWindow: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.GroupCommitWindow != time.Second {
+		t.Fatalf("unexpected window: %s", db.GroupCommitWindow)
 	}
 }
 
@@ -1209,6 +2756,277 @@ func TestDB_BatchTime(t *testing.T) {
 	}
 }
 
+// Ensure that injected write faults fail the commit and leave the data intact.
+func TestDB_SetFaults_Write(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	db.SetFaults(&bolt.Faults{WriteErrorRate: 1})
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != bolt.ErrInjectedFault {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Remove faults and ensure the database is still usable.
+	db.SetFaults(nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that injected sync faults use the configured error.
+func TestDB_SetFaults_Sync(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	errSync := errors.New("sync failed")
+	db.SetFaults(&bolt.Faults{SyncErrorRate: 1, Err: errSync})
+	if err := db.Sync(); err != errSync {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != errSync {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	db.SetFaults(nil)
+}
+
+// Ensure that MmapGrowthWindow grows the mmap by the recent write rate.
+func TestDB_MmapGrowthWindow(t *testing.T) {
+	ingest := func(window time.Duration) int {
+		db := MustOpenDB()
+		defer db.MustClose()
+		db.MmapGrowthWindow = window
+
+		value := make([]byte, 20000)
+		for i := 0; i < 200; i++ {
+			if err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				return b.Put(u64tob(uint64(i)), value)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return db.Stats().TxStats.Remap
+	}
+
+	// Doubling from 32KB remaps several times for 4MB of writes while an
+	// hour of headroom only needs the remaps that measure the rate.
+	doubling, adaptive := ingest(0), ingest(time.Hour)
+	if adaptive > 3 || adaptive >= doubling {
+		t.Fatalf("unexpected remaps: doubling=%d adaptive=%d", doubling, adaptive)
+	}
+}
+
+// Ensure that a remap that finds the data file descriptor invalidated reopens
+// the file and continues.
+func TestDB_Remap_BadFile(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Find the descriptor of the data file.
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("descriptors cannot be listed on this platform")
+	}
+	fd := -1
+	for _, fi := range fds {
+		if link, err := os.Readlink("/proc/self/fd/" + fi.Name()); err == nil && link == db.Path() {
+			if fd != -1 {
+				t.Skip("data file is open more than once")
+			}
+			fd, _ = strconv.Atoi(fi.Name())
+		}
+	}
+	if fd == -1 {
+		t.Fatal("data file descriptor not found")
+	}
+
+	// Invalidate the descriptor underneath the database, then commit enough
+	// data to grow the mmap.
+	if err := os.NewFile(uintptr(fd), db.Path()).Close(); err != nil {
+		t.Fatal(err)
+	}
+	remaps := db.Stats().TxStats.Remap
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 10000)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if db.Stats().TxStats.Remap == remaps {
+		t.Fatal("expected a remap")
+	}
+
+	// The commit was written to the reopened file.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 100 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a remap does not map a file that replaced the data file after
+// its descriptor was invalidated, and that the database then fails new
+// transactions instead of crashing.
+func TestDB_Remap_Replaced(t *testing.T) {
+	db := MustOpenDB()
+	path := db.Path()
+	defer os.Remove(path)
+	defer os.Remove(path + ".lockinfo")
+
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("descriptors cannot be listed on this platform")
+	}
+	fd := -1
+	for _, fi := range fds {
+		if link, err := os.Readlink("/proc/self/fd/" + fi.Name()); err == nil && link == path {
+			fd, _ = strconv.Atoi(fi.Name())
+		}
+	}
+	if fd == -1 {
+		t.Fatal("data file descriptor not found")
+	}
+
+	// Replace the file at the path with a copy, then invalidate the
+	// descriptor of the original.
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(path+".new", buf, 0666); err != nil {
+		t.Fatal(err)
+	} else if err := os.Rename(path+".new", path); err != nil {
+		t.Fatal(err)
+	} else if err := os.NewFile(uintptr(fd), path).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 10000)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "data file was replaced") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The database is unusable but can still be closed.
+	if _, err := db.Begin(false); err == nil || !strings.Contains(err.Error(), "data file was replaced") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := db.Begin(true); err == nil {
+		t.Fatal("expected error")
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the durability level controls which syncs a commit makes.
+func TestDB_Durability(t *testing.T) {
+	if bolt.IgnoreNoSync {
+		t.Skip("syncs are always made on this platform")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const latency = 100 * time.Millisecond
+	commit := func(d bolt.Durability) (time.Duration, error) {
+		db.Durability = d
+		t0 := time.Now()
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), []byte("bar"))
+		})
+		return time.Since(t0), err
+	}
+
+	// Syncing the data and meta pages takes two syncs; meta only takes one.
+	db.SetFaults(&bolt.Faults{SyncLatency: latency})
+	if d, err := commit(bolt.DurabilityFull); err != nil {
+		t.Fatal(err)
+	} else if d < 2*latency {
+		t.Fatalf("expected two syncs: %s", d)
+	}
+	if d, err := commit(bolt.DurabilityMeta); err != nil {
+		t.Fatal(err)
+	} else if d < latency || d >= 2*latency {
+		t.Fatalf("expected one sync: %s", d)
+	}
+
+	// No syncs are made at all.
+	db.SetFaults(&bolt.Faults{SyncErrorRate: 1})
+	if _, err := commit(bolt.DurabilityNone); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit(bolt.DurabilityMeta); err != bolt.ErrInjectedFault {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.SetFaults(nil)
+	db.Durability = bolt.DurabilityFull
+}
+
+// Ensure that injected latency delays reads.
+func TestDB_SetFaults_ReadLatency(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.SetFaults(&bolt.Faults{ReadLatency: 10 * time.Millisecond})
+	t0 := time.Now()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(t0); d < 10*time.Millisecond {
+		t.Fatalf("expected read latency, got %s", d)
+	}
+}
+
 func ExampleDB_Update() {
 	// Open the database.
 	db, err := bolt.Open(tempfile(), 0666, nil)
@@ -1548,8 +3366,10 @@ func (db *DB) Close() error {
 	// Check database consistency after every test.
 	db.MustCheck()
 
-	// Close database and remove file.
+	// Close database and remove file and sidecar files.
 	defer os.Remove(db.Path())
+	defer os.Remove(db.Path() + ".bloom")
+	defer os.Remove(db.Path() + ".lockinfo")
 	return db.DB.Close()
 }
 
@@ -0,0 +1,187 @@
+package bolt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// diffMagic marks the start of a page diff stream.
+var diffMagic = []byte("boltdif1")
+
+// diffEnd is the page id that terminates a page diff stream.
+const diffEnd = ^uint64(0)
+
+// PageHash is the SHA-256 hash of a single page.
+type PageHash [sha256.Size]byte
+
+// HashPages returns the hash of each page-sized block read from r.
+// This is used to describe a remote copy of a database to WriteDiff.
+func HashPages(r io.Reader, pageSize int) ([]PageHash, error) {
+	var hashes []PageHash
+	w := &pageWriter{buf: make([]byte, 0, pageSize), fn: func(id uint64, p []byte) error {
+		hashes = append(hashes, sha256.Sum256(p))
+		return nil
+	}}
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// PageHashes returns the hash of each page of the database as it would be
+// written by WriteTo.
+func (tx *Tx) PageHashes() ([]PageHash, error) {
+	var hashes []PageHash
+	w := &pageWriter{buf: make([]byte, 0, tx.db.pageSize), fn: func(id uint64, p []byte) error {
+		hashes = append(hashes, sha256.Sum256(p))
+		return nil
+	}}
+	if _, err := tx.WriteTo(w); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// WriteDiff writes every page of the database whose hash differs from the
+// remote hashes to w. The remote hashes are usually obtained by calling
+// HashPages on the remote copy. Applying the diff with ApplyDiff brings the
+// remote copy up to date with this transaction while transferring only
+// changed pages.
+func (tx *Tx) WriteDiff(w io.Writer, remote []PageHash) (n int64, err error) {
+	cw := &countWriter{w: w}
+
+	// Write header with the page size and the final file size.
+	hdr := make([]byte, len(diffMagic)+12)
+	copy(hdr, diffMagic)
+	binary.BigEndian.PutUint32(hdr[len(diffMagic):], uint32(tx.db.pageSize))
+	binary.BigEndian.PutUint64(hdr[len(diffMagic)+4:], uint64(tx.Size()))
+	if _, err := cw.Write(hdr); err != nil {
+		return cw.n, err
+	}
+
+	// Write each changed page prefixed by its id.
+	var id [8]byte
+	pw := &pageWriter{buf: make([]byte, 0, tx.db.pageSize), fn: func(i uint64, p []byte) error {
+		if i < uint64(len(remote)) && remote[i] == sha256.Sum256(p) {
+			return nil
+		}
+		binary.BigEndian.PutUint64(id[:], i)
+		if _, err := cw.Write(id[:]); err != nil {
+			return err
+		}
+		_, err := cw.Write(p)
+		return err
+	}}
+	if _, err := tx.WriteTo(pw); err != nil {
+		return cw.n, err
+	}
+
+	binary.BigEndian.PutUint64(id[:], diffEnd)
+	_, err = cw.Write(id[:])
+	return cw.n, err
+}
+
+// ApplyDiff applies a page diff written by WriteDiff to the database file at
+// path. Data pages are written and synced before the meta pages so that the
+// new meta never references pages that have not been written. The file must
+// not be open while the diff is applied.
+//
+// Applying a diff is not atomic. Data pages are overwritten in place, so
+// unless the file is exactly one transaction behind, pages still referenced
+// by its current meta may be overwritten. If ApplyDiff returns an error or
+// the process crashes before it returns, the file must be replaced with a
+// full copy.
+func ApplyDiff(path string, r io.Reader) error {
+	// Read header.
+	hdr := make([]byte, len(diffMagic)+12)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return ErrInvalidDiff
+	} else if !bytes.Equal(hdr[:len(diffMagic)], diffMagic) {
+		return ErrInvalidDiff
+	}
+	pageSize := int64(binary.BigEndian.Uint32(hdr[len(diffMagic):]))
+	size := int64(binary.BigEndian.Uint64(hdr[len(diffMagic)+4:]))
+	if pageSize < minPageSize || pageSize > maxPageSize || size%pageSize != 0 {
+		return ErrInvalidDiff
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	// Write data pages and hold back the meta pages.
+	var metas [][]byte
+	var metaIDs []int64
+	var id [8]byte
+	for {
+		if _, err := io.ReadFull(r, id[:]); err != nil {
+			return ErrInvalidDiff
+		}
+		i := binary.BigEndian.Uint64(id[:])
+		if i == diffEnd {
+			break
+		} else if int64(i) >= size/pageSize {
+			return ErrInvalidDiff
+		}
+
+		buf := make([]byte, pageSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return ErrInvalidDiff
+		}
+		if i < 2 {
+			metas, metaIDs = append(metas, buf), append(metaIDs, int64(i))
+			continue
+		}
+		if _, err := f.WriteAt(buf, int64(i)*pageSize); err != nil {
+			return err
+		}
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	} else if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// Write meta pages now that the data they reference is durable.
+	for i, buf := range metas {
+		if _, err := f.WriteAt(buf, metaIDs[i]*pageSize); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// pageWriter splits a stream of database pages and passes each page to fn.
+type pageWriter struct {
+	buf []byte
+	id  uint64
+	fn  func(id uint64, p []byte) error
+}
+
+func (w *pageWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		sz := cap(w.buf) - len(w.buf)
+		if sz > len(p) {
+			sz = len(p)
+		}
+		w.buf = append(w.buf, p[:sz]...)
+		p, n = p[sz:], n+sz
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.fn(w.id, w.buf); err != nil {
+				return n, err
+			}
+			w.id++
+			w.buf = w.buf[:0]
+		}
+	}
+	return n, nil
+}
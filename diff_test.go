@@ -0,0 +1,112 @@
+package bolt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a diff brings a stale copy up to date by sending only changed pages.
+func TestTx_WriteDiff(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 5000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make a standby copy and then change a single key.
+	standby := tempfile()
+	defer os.Remove(standby)
+	if err := db.View(func(tx *bolt.Tx) error { return tx.CopyFile(standby, 0666) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(42), []byte("changed"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hash the standby and generate a diff against it.
+	f, err := os.Open(standby)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := bolt.HashPages(f, db.Info().PageSize)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diff, full bytes.Buffer
+	if err := db.View(func(tx *bolt.Tx) error {
+		if _, err := tx.WriteDiff(&diff, remote); err != nil {
+			return err
+		}
+		_, err := tx.WriteTo(&full)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	} else if diff.Len() >= full.Len()/4 {
+		t.Fatalf("diff too large: %d >= %d", diff.Len(), full.Len()/4)
+	}
+
+	// Apply the diff and ensure the standby matches the database.
+	if err := bolt.ApplyDiff(standby, &diff); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(standby)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf, full.Bytes()) {
+		t.Fatal("standby does not match database")
+	}
+
+	sdb, err := bolt.Open(standby, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	if err := sdb.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get(u64tob(42)); string(v) != "changed" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a malformed diff is rejected.
+func TestApplyDiff_Invalid(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+	if err := bolt.ApplyDiff(path, bytes.NewReader([]byte("garbage"))); err != bolt.ErrInvalidDiff {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Page sizes outside the supported range are rejected before any page
+	// is read.
+	for _, pageSize := range []uint32{0, 256, 1 << 31} {
+		hdr := make([]byte, 20)
+		copy(hdr, "boltdif1")
+		binary.BigEndian.PutUint32(hdr[8:], pageSize)
+		if err := bolt.ApplyDiff(path, bytes.NewReader(hdr)); err != bolt.ErrInvalidDiff {
+			t.Fatalf("unexpected error for page size %d: %v", pageSize, err)
+		}
+	}
+}
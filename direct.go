@@ -0,0 +1,89 @@
+package bolt
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// directAlign is the buffer alignment and the read offset and size multiple
+// required for direct I/O. It is the largest logical block size in common
+// use.
+const directAlign = 4096
+
+// directScanner walks a b+tree by reading pages from the data file. It keeps
+// one buffer per level of the tree so memory use is bounded by the depth of
+// the tree rather than the size of the bucket.
+type directScanner struct {
+	file     *os.File
+	pageSize int
+	bufs     [][]byte
+}
+
+// scan calls fn for every element in the leaf pages under page id.
+func (s *directScanner) scan(id pgid, depth int, fn func(k, v []byte) error) error {
+	p, err := s.read(id, depth)
+	if err != nil {
+		return err
+	}
+
+	if (p.flags & branchPageFlag) != 0 {
+		for i := 0; i < int(p.count); i++ {
+			if err := s.scan(p.branchPageElement(uint16(i)).pgid, depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if (p.flags & leafPageFlag) == 0 {
+		return fmt.Errorf("page %d: invalid page type: %s", id, p.typ())
+	}
+
+	for i := 0; i < int(p.count); i++ {
+		k, v, flags := p.leafElement(uint16(i))
+		if v, flags = expiringValue(v, flags); (flags & hiddenLeafFlags) != 0 {
+			continue
+		} else if (flags & bucketLeafFlag) != 0 {
+			v = nil
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads page id, including any overflow pages, into the buffer for depth.
+func (s *directScanner) read(id pgid, depth int) (*page, error) {
+	for len(s.bufs) <= depth {
+		s.bufs = append(s.bufs, nil)
+	}
+
+	sz := s.pageSize
+	for {
+		if len(s.bufs[depth]) < sz {
+			s.bufs[depth] = alignedBuffer(sz)
+		}
+		buf := s.bufs[depth][:sz]
+		if _, err := s.file.ReadAt(buf, int64(id)*int64(s.pageSize)); err != nil {
+			return nil, fmt.Errorf("read page %d: %s", id, err)
+		}
+
+		// Read again if the page has overflow pages that were not read.
+		p := (*page)(unsafe.Pointer(&buf[0]))
+		if n := (int(p.overflow) + 1) * s.pageSize; n > sz {
+			sz = n
+			continue
+		}
+		return p, nil
+	}
+}
+
+// alignedBuffer returns a buffer of length n aligned for direct I/O.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directAlign)
+	off := int(uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1))
+	if off != 0 {
+		off = directAlign - off
+	}
+	return b[off : off+n]
+}
@@ -0,0 +1,268 @@
+package bolt
+
+// DupBucket stores multiple values per key in a bucket, similar to a sorted
+// duplicates database in LMDB. Values of a key are kept in sorted order and
+// each value is stored at most once.
+//
+// Each key is stored as a nested bucket whose keys are the values of the key,
+// so values are limited to MaxKeySize. Keys without values are removed. The
+// underlying bucket should only be accessed through a DupBucket.
+type DupBucket struct {
+	b *Bucket
+}
+
+// NewDupBucket returns a DupBucket that stores values in b.
+func NewDupBucket(b *Bucket) *DupBucket {
+	return &DupBucket{b: b}
+}
+
+// Bucket returns the underlying bucket.
+func (d *DupBucket) Bucket() *Bucket {
+	return d.b
+}
+
+// Put adds a value to the key. Adding a value that the key already has is a
+// no-op. Returns ErrKeyRequired if the value is empty and ErrIncompatibleValue
+// if the key holds a regular value.
+func (d *DupBucket) Put(key, value []byte) error {
+	if len(value) == 0 {
+		return ErrKeyRequired
+	}
+	values, err := d.b.CreateBucketIfNotExists(key)
+	if err != nil {
+		return err
+	}
+	return values.Put(value, []byte{})
+}
+
+// Get returns the first value of the key or nil if the key does not exist.
+func (d *DupBucket) Get(key []byte) []byte {
+	values := d.b.Bucket(key)
+	if values == nil {
+		return nil
+	}
+	v, _ := values.Cursor().First()
+	return v
+}
+
+// Values returns every value of the key in sorted order.
+func (d *DupBucket) Values(key []byte) [][]byte {
+	values := d.b.Bucket(key)
+	if values == nil {
+		return nil
+	}
+	var a [][]byte
+	c := values.Cursor()
+	for v, _ := c.First(); v != nil; v, _ = c.Next() {
+		a = append(a, v)
+	}
+	return a
+}
+
+// Has returns true if value is one of the values of the key.
+func (d *DupBucket) Has(key, value []byte) bool {
+	values := d.b.Bucket(key)
+	return values != nil && values.Get(value) != nil
+}
+
+// Count returns the number of values of the key. It does not read the pages
+// of the values.
+func (d *DupBucket) Count(key []byte) int {
+	values := d.b.Bucket(key)
+	if values == nil {
+		return 0
+	}
+	return values.Count()
+}
+
+// Delete removes the key and all of its values.
+// If the key does not exist then nothing is done and a nil error is returned.
+func (d *DupBucket) Delete(key []byte) error {
+	if err := d.b.DeleteBucket(key); err != ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
+
+// DeleteValue removes a single value from the key. The key is removed with
+// its last value. If the value does not exist then nothing is done and a nil
+// error is returned.
+func (d *DupBucket) DeleteValue(key, value []byte) error {
+	values := d.b.Bucket(key)
+	if values == nil {
+		return nil
+	} else if err := values.Delete(value); err != nil {
+		return err
+	}
+	if k, _ := values.Cursor().First(); k == nil {
+		return d.b.DeleteBucket(key)
+	}
+	return nil
+}
+
+// Cursor returns a cursor over every key and value pair of the bucket.
+// The cursor is only valid as long as the transaction is open.
+// Do not use a cursor after the transaction is closed.
+func (d *DupBucket) Cursor() *DupCursor {
+	return &DupCursor{bucket: d, keys: d.b.Cursor()}
+}
+
+// DupCursor iterates over the key and value pairs of a DupBucket in sorted
+// order. A key with several values is returned once for each value.
+//
+// The Dup methods move between the values of the current key and return nil
+// once they run out of values without moving to another key.
+type DupCursor struct {
+	bucket *DupBucket
+	keys   *Cursor
+	key    []byte
+	values *Cursor
+}
+
+// Bucket returns the DupBucket that this cursor was created from.
+func (c *DupCursor) Bucket() *DupBucket {
+	return c.bucket
+}
+
+// First moves the cursor to the first value of the first key.
+// If the bucket is empty then a nil key and value are returned.
+func (c *DupCursor) First() (key []byte, value []byte) {
+	return c.nextKey(c.keys.First())
+}
+
+// Last moves the cursor to the last value of the last key.
+// If the bucket is empty then a nil key and value are returned.
+func (c *DupCursor) Last() (key []byte, value []byte) {
+	return c.prevKey(c.keys.Last())
+}
+
+// Next moves the cursor to the next value of the current key or, after its
+// last value, to the first value of the next key. If the cursor is at the
+// end of the bucket then a nil key and value are returned.
+func (c *DupCursor) Next() (key []byte, value []byte) {
+	if c.values == nil {
+		return nil, nil
+	} else if v, _ := c.values.Next(); v != nil {
+		return c.key, v
+	}
+	return c.NextNoDup()
+}
+
+// Prev moves the cursor to the previous value of the current key or, before
+// its first value, to the last value of the previous key. If the cursor is at
+// the beginning of the bucket then a nil key and value are returned.
+func (c *DupCursor) Prev() (key []byte, value []byte) {
+	if c.values == nil {
+		return nil, nil
+	} else if v, _ := c.values.Prev(); v != nil {
+		return c.key, v
+	}
+	return c.PrevNoDup()
+}
+
+// Seek moves the cursor to the first value of the given key or, if the key
+// does not exist, of the next key. If no keys follow then a nil key and value
+// are returned.
+func (c *DupCursor) Seek(seek []byte) (key []byte, value []byte) {
+	return c.nextKey(c.keys.Seek(seek))
+}
+
+// NextNoDup moves the cursor to the first value of the next key.
+func (c *DupCursor) NextNoDup() (key []byte, value []byte) {
+	if c.values == nil {
+		return nil, nil
+	}
+	return c.nextKey(c.keys.Next())
+}
+
+// PrevNoDup moves the cursor to the last value of the previous key.
+func (c *DupCursor) PrevNoDup() (key []byte, value []byte) {
+	if c.values == nil {
+		return nil, nil
+	}
+	return c.prevKey(c.keys.Prev())
+}
+
+// FirstDup moves the cursor to the first value of the current key.
+func (c *DupCursor) FirstDup() []byte {
+	if c.values == nil {
+		return nil
+	}
+	v, _ := c.values.First()
+	return v
+}
+
+// LastDup moves the cursor to the last value of the current key.
+func (c *DupCursor) LastDup() []byte {
+	if c.values == nil {
+		return nil
+	}
+	v, _ := c.values.Last()
+	return v
+}
+
+// NextDup moves the cursor to the next value of the current key.
+// Returns nil if the cursor is on the last value of the key.
+func (c *DupCursor) NextDup() []byte {
+	if c.values == nil {
+		return nil
+	}
+	v, _ := c.values.Next()
+	if v == nil {
+		c.values.Last()
+	}
+	return v
+}
+
+// PrevDup moves the cursor to the previous value of the current key.
+// Returns nil if the cursor is on the first value of the key.
+func (c *DupCursor) PrevDup() []byte {
+	if c.values == nil {
+		return nil
+	}
+	v, _ := c.values.Prev()
+	if v == nil {
+		c.values.First()
+	}
+	return v
+}
+
+// nextKey positions the cursor on the first value of k, skipping forward over
+// keys that do not hold values.
+func (c *DupCursor) nextKey(k, v []byte) ([]byte, []byte) {
+	for ; k != nil; k, v = c.keys.Next() {
+		if val := c.open(k, v); val != nil {
+			return k, val
+		}
+	}
+	c.key, c.values = nil, nil
+	return nil, nil
+}
+
+// prevKey positions the cursor on the last value of k, skipping backward over
+// keys that do not hold values.
+func (c *DupCursor) prevKey(k, v []byte) ([]byte, []byte) {
+	for ; k != nil; k, v = c.keys.Prev() {
+		if val := c.open(k, v); val != nil {
+			v, _ := c.values.Last()
+			return k, v
+		}
+	}
+	c.key, c.values = nil, nil
+	return nil, nil
+}
+
+// open sets the values cursor to the values of k and returns its first value.
+// Returns nil if k is a regular key or has no values.
+func (c *DupCursor) open(k, v []byte) []byte {
+	if v != nil {
+		return nil
+	}
+	values := c.bucket.b.Bucket(k)
+	if values == nil {
+		return nil
+	}
+	c.key, c.values = k, values.Cursor()
+	val, _ := c.values.First()
+	return val
+}
@@ -0,0 +1,123 @@
+package bolt_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a dup bucket stores sorted, unique values per key.
+func TestDupBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("tags"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := bolt.NewDupBucket(b)
+		for _, kv := range [][2]string{{"foo", "c"}, {"foo", "a"}, {"foo", "b"}, {"foo", "a"}, {"bar", "x"}, {"baz", "y"}} {
+			if err := d.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Put([]byte("foo"), nil); err != bolt.ErrKeyRequired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := b.Put([]byte("plain"), []byte("value")); err != nil {
+			t.Fatal(err)
+		} else if err := d.Put([]byte("plain"), []byte("x")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		d := bolt.NewDupBucket(tx.Bucket([]byte("tags")))
+		if v := d.Get([]byte("foo")); string(v) != "a" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if n := d.Count([]byte("foo")); n != 3 {
+			t.Fatalf("unexpected count: %d", n)
+		} else if !d.Has([]byte("foo"), []byte("b")) || d.Has([]byte("foo"), []byte("d")) {
+			t.Fatal("unexpected Has result")
+		} else if s := fmt.Sprintf("%s", d.Values([]byte("foo"))); s != "[a b c]" {
+			t.Fatalf("unexpected values: %s", s)
+		}
+
+		if err := d.DeleteValue([]byte("foo"), []byte("b")); err != nil {
+			t.Fatal(err)
+		} else if err := d.DeleteValue([]byte("baz"), []byte("y")); err != nil {
+			t.Fatal(err)
+		} else if d.Bucket().Bucket([]byte("baz")) != nil {
+			t.Fatal("expected key to be removed with its last value")
+		} else if err := d.Delete([]byte("missing")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a dup cursor moves across keys and between the values of a key.
+func TestDupCursor(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("tags"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := bolt.NewDupBucket(b)
+		for _, kv := range [][2]string{{"a", "1"}, {"a", "2"}, {"b", "1"}, {"c", "1"}, {"c", "2"}, {"c", "3"}} {
+			if err := d.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.Put([]byte("bb"), []byte("plain"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := bolt.NewDupBucket(tx.Bucket([]byte("tags"))).Cursor()
+
+		var pairs []string
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			pairs = append(pairs, string(k)+"="+string(v))
+		}
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			pairs = append(pairs, string(k)+"="+string(v))
+		}
+		if s := strings.Join(pairs, ","); s != "a=1,a=2,b=1,c=1,c=2,c=3,c=3,c=2,c=1,b=1,a=2,a=1" {
+			t.Fatalf("unexpected pairs: %s", s)
+		}
+
+		if k, v := c.Seek([]byte("bb")); string(k) != "c" || string(v) != "1" {
+			t.Fatalf("unexpected seek: %s=%s", k, v)
+		} else if v := c.NextDup(); string(v) != "2" {
+			t.Fatalf("unexpected next dup: %s", v)
+		} else if v := c.LastDup(); string(v) != "3" {
+			t.Fatalf("unexpected last dup: %s", v)
+		} else if v := c.NextDup(); v != nil {
+			t.Fatalf("unexpected next dup: %s", v)
+		} else if v := c.PrevDup(); string(v) != "2" {
+			t.Fatalf("unexpected prev dup: %s", v)
+		} else if v := c.FirstDup(); string(v) != "1" {
+			t.Fatalf("unexpected first dup: %s", v)
+		} else if k, v := c.PrevNoDup(); string(k) != "b" || string(v) != "1" {
+			t.Fatalf("unexpected prev key: %s=%s", k, v)
+		} else if k, v := c.PrevNoDup(); string(k) != "a" || string(v) != "2" {
+			t.Fatalf("unexpected prev key: %s=%s", k, v)
+		} else if k, v := c.NextNoDup(); string(k) != "b" || string(v) != "1" {
+			t.Fatalf("unexpected next key: %s=%s", k, v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,9 @@
 package bolt
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // These errors can be returned when opening or calling methods on a DB.
 var (
@@ -9,9 +12,22 @@ var (
 	ErrDatabaseNotOpen = errors.New("database not open")
 
 	// ErrDatabaseOpen is returned when opening a database that is
-	// already open.
+	// already open, including when another DB in this process has the file
+	// open and either DB is writable.
 	ErrDatabaseOpen = errors.New("database already open")
 
+	// ErrCorrupt is returned when a database file has valid meta pages but
+	// describes sizes or pages that do not fit in the file.
+	ErrCorrupt = errors.New("database file is corrupt")
+
+	// ErrNetworkFilesystem is returned when opening a database that resides on
+	// a network filesystem while Options.RefuseNetworkFS is set.
+	ErrNetworkFilesystem = errors.New("database is on a network filesystem")
+
+	// ErrReopenNotSupported is returned when reopening a database that was
+	// not opened from a file.
+	ErrReopenNotSupported = errors.New("reopen not supported")
+
 	// ErrInvalid is returned when both meta pages on a database are invalid.
 	// This typically occurs when a file is not a bolt database.
 	ErrInvalid = errors.New("invalid database")
@@ -26,6 +42,18 @@ var (
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
+
+	// ErrInjectedFault is returned by file operations that fail because of
+	// faults set with DB.SetFaults().
+	ErrInjectedFault = errors.New("injected fault")
+
+	// ErrAttachmentExists is returned when attaching a file under a name
+	// that is already attached.
+	ErrAttachmentExists = errors.New("attachment already exists")
+
+	// ErrAttachmentNotFound is returned when detaching a name that is not
+	// attached.
+	ErrAttachmentNotFound = errors.New("attachment not found")
 )
 
 // These errors can occur when beginning or committing a Tx.
@@ -38,9 +66,20 @@ var (
 	// that has already been committed or rolled back.
 	ErrTxClosed = errors.New("tx closed")
 
+	// ErrTxWritable is returned when cloning a writable transaction.
+	ErrTxWritable = errors.New("tx is writable")
+
 	// ErrDatabaseReadOnly is returned when a mutating transaction is started on a
 	// read-only database.
 	ErrDatabaseReadOnly = errors.New("database is in read-only mode")
+
+	// ErrInvalidSavepoint is returned when rolling back to a savepoint that
+	// was not created by the transaction.
+	ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+	// ErrSnapshotNotFound is returned when beginning or releasing a snapshot
+	// that has not been retained with DB.RetainSnapshot.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -55,7 +94,19 @@ var (
 	// ErrBucketNameRequired is returned when creating a bucket with a blank name.
 	ErrBucketNameRequired = errors.New("bucket name required")
 
-	// ErrKeyRequired is returned when inserting a zero-length key.
+	// ErrBucketNameTooLarge is returned when creating a bucket with a name
+	// that is larger than MaxBucketNameSize.
+	ErrBucketNameTooLarge = errors.New("bucket name too large")
+
+	// ErrBucketConfigured is returned when renaming a bucket that has
+	// settings registered for its path, or the path of a nested bucket.
+	ErrBucketConfigured = errors.New("bucket has settings registered for its path")
+
+	// ErrTooManyBuckets is returned when creating a bucket would exceed
+	// DB.MaxBuckets.
+	ErrTooManyBuckets = errors.New("too many buckets")
+
+	// ErrKeyRequired is returned when inserting or deleting a zero-length key.
 	ErrKeyRequired = errors.New("key required")
 
 	// ErrKeyTooLarge is returned when inserting a key that is larger than MaxKeySize.
@@ -68,4 +119,66 @@ var (
 	// on an existing non-bucket key or when trying to create or delete a
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
+
+	// ErrConflict is returned when a compare-and-swap finds a value other
+	// than the one expected. DB.UpdateWithRetry retries functions that
+	// return it.
+	ErrConflict = errors.New("conflict")
+
+	// ErrNoMergeOperator is returned when merging a value into a bucket that
+	// has no merge operator registered with DB.RegisterMerge.
+	ErrNoMergeOperator = errors.New("no merge operator")
+
+	// ErrInvalidTTL is returned when putting a key with a TTL that is not
+	// positive.
+	ErrInvalidTTL = errors.New("invalid ttl")
 )
+
+// These errors can occur when restoring a backup, applying a diff or
+// importing an export stream.
+var (
+	// ErrBackupEncrypted is returned when restoring an encrypted backup
+	// without providing an encryption key.
+	ErrBackupEncrypted = errors.New("backup is encrypted")
+
+	// ErrBackupDecrypt is returned when an encrypted backup cannot be
+	// decrypted. This occurs when the key is wrong or the backup has been
+	// modified or truncated.
+	ErrBackupDecrypt = errors.New("backup decryption failed")
+
+	// ErrInvalidDiff is returned when applying a malformed page diff.
+	ErrInvalidDiff = errors.New("invalid diff")
+
+	// ErrInvalidExport is returned when importing a malformed or truncated
+	// export stream.
+	ErrInvalidExport = errors.New("invalid export")
+)
+
+// TruncatedError is returned when opening a database file that is shorter
+// than its meta page requires. This occurs when a file is copied while it is
+// being written or the copy is interrupted. See Options.RecoverTruncated.
+type TruncatedError struct {
+	Size     int64 // actual size of the file in bytes
+	Expected int64 // size required by the meta page in bytes
+}
+
+// Error returns the error string.
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("database file is truncated: size is %d bytes, expected at least %d", e.Size, e.Expected)
+}
+
+// PutError is returned by Tx.PutAll when a write cannot be applied.
+// It identifies the bucket and key that caused the failure.
+type PutError struct {
+	Bucket []byte
+	Key    []byte
+	Err    error
+}
+
+// Error returns the error string.
+func (e *PutError) Error() string {
+	if e.Key == nil {
+		return fmt.Sprintf("put %q: %s", e.Bucket, e.Err)
+	}
+	return fmt.Sprintf("put %q/%q: %s", e.Bucket, e.Key, e.Err)
+}
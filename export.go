@@ -0,0 +1,336 @@
+package bolt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// exportMagic marks the start of an export stream. The final byte is the
+// version of the stream format.
+var exportMagic = []byte("boltexp\x01")
+
+// Record types in an export stream.
+const (
+	exportBucket = 'b' // name, sequence: start of a bucket
+	exportPair   = 'k' // key, value: a key/value pair in the current bucket
+	exportTTL    = 'x' // key, value, expiry: an expiring key/value pair
+	exportEnd    = 'e' // end of the current bucket
+	exportEOF    = 'z' // end of the stream
+)
+
+// Export writes the logical contents of the database to w and returns the
+// number of bytes written to w. See Tx.Export.
+func (db *DB) Export(w io.Writer) (n int64, err error) {
+	err = db.View(func(tx *Tx) error {
+		n, err = tx.Export(w)
+		return err
+	})
+	return n, err
+}
+
+// Hash is the SHA-256 hash of the logical contents of a database or bucket.
+type Hash [sha256.Size]byte
+
+// String returns the hash in hexadecimal.
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// Hash returns the hash of the logical contents of the database. See Tx.Hash.
+func (db *DB) Hash() (h Hash, err error) {
+	err = db.View(func(tx *Tx) error {
+		h, err = tx.Hash()
+		return err
+	})
+	return h, err
+}
+
+// Import reads an export stream from r and writes its contents to the
+// database in a single transaction. See Tx.Import.
+func (db *DB) Import(r io.Reader) error {
+	return db.Update(func(tx *Tx) error {
+		return tx.Import(r)
+	})
+}
+
+// Export writes every bucket, nested bucket and key/value pair visible to the
+// transaction to w in a compact binary format and returns the number of bytes
+// written to w. Expiring keys are written with their expiry time.
+//
+// Unlike WriteTo, the stream does not depend on the page layout so it can be
+// imported into a database with a different page size. Each record is a type
+// byte followed by uvarint length-prefixed fields, and the stream starts with
+// a versioned header and ends with a terminator so that truncation is
+// detected by Import.
+func (tx *Tx) Export(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	e := &exporter{w: bufio.NewWriter(cw)}
+
+	e.w.Write(exportMagic)
+	if err := tx.ForEach(func(name []byte, b *Bucket) error {
+		return e.bucket(name, b)
+	}); err != nil {
+		return cw.n, err
+	}
+	e.w.WriteByte(exportEOF)
+
+	if err := e.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// Hash returns the SHA-256 hash of the export stream of the transaction.
+// Databases with the same buckets, bucket sequences and key/value pairs have
+// the same hash regardless of page size, fill or file layout, so the hash can
+// be used to check that a replica or backup holds the same data. Expiry times
+// of expiring keys are included. Tombstones are not.
+func (tx *Tx) Hash() (Hash, error) {
+	h := sha256.New()
+	if _, err := tx.Export(h); err != nil {
+		return Hash{}, err
+	}
+	var sum Hash
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// Hash returns the hash of the logical contents of the bucket and its nested
+// buckets, computed like Tx.Hash. The name of the bucket is not included.
+func (b *Bucket) Hash() (Hash, error) {
+	h := sha256.New()
+	e := &exporter{w: bufio.NewWriter(h)}
+	if err := e.bucket(nil, b); err != nil {
+		return Hash{}, err
+	}
+	_ = e.w.Flush()
+
+	var sum Hash
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// Import reads an export stream from r and writes its contents to the
+// transaction. Buckets that do not exist are created and existing keys are
+// overwritten. Bucket sequences and the expiry times of expiring keys are
+// restored from the stream. Keys that have expired since the export are
+// skipped.
+//
+// ErrInvalidExport is returned if the stream is malformed or truncated, in
+// which case the transaction should be rolled back.
+func (tx *Tx) Import(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	hdr := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr, exportMagic) {
+		return ErrInvalidExport
+	}
+
+	// Track the stack of open buckets. Top-level records are buckets.
+	var stack []*Bucket
+	for {
+		typ, err := br.ReadByte()
+		if err != nil {
+			return ErrInvalidExport
+		}
+
+		switch typ {
+		case exportBucket:
+			name, err := readExportField(br)
+			if err != nil {
+				return err
+			}
+			seq, err := binary.ReadUvarint(br)
+			if err != nil {
+				return ErrInvalidExport
+			}
+
+			var b *Bucket
+			if len(stack) == 0 {
+				b, err = tx.CreateBucketIfNotExists(name)
+			} else {
+				b, err = stack[len(stack)-1].CreateBucketIfNotExists(name)
+			}
+			if err != nil {
+				return err
+			}
+			if seq != 0 {
+				if b.rootNode == nil {
+					_ = b.node(b.root, nil)
+				}
+				b.bucket.sequence = seq
+			}
+			stack = append(stack, b)
+
+		case exportPair:
+			if len(stack) == 0 {
+				return ErrInvalidExport
+			}
+			k, err := readExportField(br)
+			if err != nil {
+				return err
+			}
+			v, err := readExportField(br)
+			if err != nil {
+				return err
+			}
+			if err := stack[len(stack)-1].Put(k, v); err != nil {
+				return err
+			}
+
+		case exportTTL:
+			if len(stack) == 0 {
+				return ErrInvalidExport
+			}
+			k, err := readExportField(br)
+			if err != nil {
+				return err
+			}
+			v, err := readExportField(br)
+			if err != nil {
+				return err
+			}
+			expiry, err := binary.ReadUvarint(br)
+			if err != nil {
+				return ErrInvalidExport
+			}
+			t := time.Unix(0, int64(expiry))
+			if !t.After(time.Now()) {
+				continue
+			}
+			if err := stack[len(stack)-1].putExpiring(k, v, t); err != nil {
+				return err
+			}
+
+		case exportEnd:
+			if len(stack) == 0 {
+				return ErrInvalidExport
+			}
+			stack = stack[:len(stack)-1]
+
+		case exportEOF:
+			if len(stack) != 0 {
+				return ErrInvalidExport
+			}
+			return nil
+
+		default:
+			return ErrInvalidExport
+		}
+	}
+}
+
+// CSVOptions represents the options used when exporting a bucket as CSV.
+type CSVOptions struct {
+	// Hex writes keys and values hex encoded. Otherwise they are written as
+	// raw strings and quoted where necessary.
+	Hex bool
+
+	// Header writes a "key,value" row before the first key.
+	Header bool
+}
+
+// ExportCSV writes each key/value pair in the bucket to w as a key,value
+// row. Nested buckets are skipped. Passing in nil options writes raw rows
+// without a header.
+func (b *Bucket) ExportCSV(w io.Writer, options *CSVOptions) error {
+	if options == nil {
+		options = &CSVOptions{}
+	}
+
+	cw := csv.NewWriter(w)
+	if options.Header {
+		if err := cw.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+	}
+
+	if err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		if options.Hex {
+			return cw.Write([]string{hex.EncodeToString(k), hex.EncodeToString(v)})
+		}
+		return cw.Write([]string{string(k), string(v)})
+	}); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// exporter writes the records of an export stream. Write errors are
+// retained by the bufio.Writer and reported on Flush.
+type exporter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+// bucket writes a bucket record, the contents of b and the end record.
+func (e *exporter) bucket(name []byte, b *Bucket) error {
+	if err := b.authorize(OpForEach, nil); err != nil {
+		return err
+	}
+
+	e.w.WriteByte(exportBucket)
+	e.field(name)
+	e.uvarint(b.bucket.sequence)
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			if child := b.Bucket(k); child != nil {
+				if err := e.bucket(k, child); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Expiring keys keep their expiry time, stored ahead of the value.
+		if _, raw, flags := c.rawKeyValue(); (flags & expiringLeafFlag) != 0 {
+			e.w.WriteByte(exportTTL)
+			e.field(k)
+			e.field(v)
+			e.uvarint(binary.BigEndian.Uint64(raw))
+			continue
+		}
+		e.w.WriteByte(exportPair)
+		e.field(k)
+		e.field(v)
+	}
+
+	e.w.WriteByte(exportEnd)
+	return nil
+}
+
+func (e *exporter) field(p []byte) {
+	e.uvarint(uint64(len(p)))
+	e.w.Write(p)
+}
+
+func (e *exporter) uvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+// readExportField reads a uvarint length-prefixed field from r.
+func readExportField(r *bufio.Reader) ([]byte, error) {
+	sz, err := binary.ReadUvarint(r)
+	if err != nil || sz > MaxValueSize {
+		return nil, ErrInvalidExport
+	}
+	p := make([]byte, sz)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, ErrInvalidExport
+	}
+	return p, nil
+}
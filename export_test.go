@@ -0,0 +1,263 @@
+package bolt_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that an export can be imported into another database.
+func TestDB_Export(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if _, err := b.NextSequence(); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("empty"), []byte{}); err != nil {
+			return err
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := child.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte("empty"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if n, err := db.Export(&buf); err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Fatalf("unexpected n: %d", n)
+	}
+
+	db2 := MustOpenDB()
+	defer db2.MustClose()
+	if err := db2.Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db2.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b == nil {
+			t.Fatal("expected bucket")
+		} else if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("empty")); v == nil || len(v) != 0 {
+			t.Fatalf("unexpected value: %v", v)
+		} else if seq, err := b.NextSequence(); err != nil || seq != 2 {
+			t.Fatalf("unexpected sequence: %d (%v)", seq, err)
+		} else if n := b.Bucket([]byte("child")).Stats().KeyN; n != 1000 {
+			t.Fatalf("unexpected child key count: %d", n)
+		} else if tx.Bucket([]byte("empty")) == nil {
+			t.Fatal("expected empty bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that expiring keys keep their expiry time through an export and
+// that keys which expired before the import are skipped.
+func TestDB_Export_TTL(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("sessions"))
+		if err != nil {
+			return err
+		} else if err := b.PutTTL([]byte("foo"), []byte("bar"), time.Hour); err != nil {
+			return err
+		} else if err := b.PutTTL([]byte("soon"), []byte("bar"), 50*time.Millisecond); err != nil {
+			return err
+		}
+		return b.Put([]byte("baz"), []byte("bat"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	db2 := MustOpenDB()
+	defer db2.MustClose()
+	if err := db2.Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var exp time.Time
+	if err := db.View(func(tx *bolt.Tx) error {
+		exp = tx.Bucket([]byte("sessions")).ExpiresAt([]byte("foo"))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db2.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if at := b.ExpiresAt([]byte("foo")); !at.Equal(exp) {
+			t.Fatalf("unexpected expiry: %s, expected %s", at, exp)
+		} else if !b.ExpiresAt([]byte("baz")).IsZero() {
+			t.Fatal("expected baz to be permanent")
+		} else if n := b.Stats().KeyN; n != 2 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that importing a truncated or malformed export returns an error.
+func TestDB_Import_Invalid(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for _, p := range [][]byte{nil, []byte("notanexport"), data[:len(data)-1], data[:len(data)-2]} {
+		db2 := MustOpenDB()
+		if err := db2.Import(bytes.NewReader(p)); err != bolt.ErrInvalidExport {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := db2.View(func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte("widgets")) != nil {
+				t.Fatal("expected import to be rolled back")
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		db2.MustClose()
+	}
+}
+
+// Ensure that databases with the same contents have the same hash regardless
+// of how they were written.
+func TestDB_Hash(t *testing.T) {
+	load := func(db *DB, order []int, fillPercent float64) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{"a", "b"} {
+				b, err := tx.CreateBucket([]byte(name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b.FillPercent = fillPercent
+				child, err := b.CreateBucket([]byte("child"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, i := range order {
+					if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+						t.Fatal(err)
+					} else if err := child.Put(u64tob(uint64(i)), []byte("value")); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	order := make([]int, 2000)
+	reverse := make([]int, 2000)
+	for i := range order {
+		order[i], reverse[i] = i, 1999-i
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	load(db, order, 0.9)
+
+	db2 := MustOpenDB()
+	defer db2.MustClose()
+	db2.SetTombstones(true, []byte("a"))
+	load(db2, reverse, 0.5)
+
+	// Keys that were deleted, including tombstones, are not part of the hash.
+	if err := db2.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("a"))
+		if err := b.Put([]byte("deleted"), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		return b.Delete([]byte("deleted"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	h1, err := db.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := db2.Hash()
+	if err != nil {
+		t.Fatal(err)
+	} else if h1 != h2 {
+		t.Fatalf("expected equal hashes: %s != %s", h1, h2)
+	}
+
+	// Buckets with the same contents have the same hash.
+	if err := db.View(func(tx *bolt.Tx) error {
+		a, err := tx.Bucket([]byte("a")).Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := tx.Bucket([]byte("b")).Hash()
+		if err != nil {
+			t.Fatal(err)
+		} else if a != b || a == h1 {
+			t.Fatalf("unexpected bucket hashes: %s, %s", a, b)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any change to the contents changes the hash.
+	if err := db2.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("b")).Bucket([]byte("child")).Put(u64tob(1000), []byte("other"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if h2, err := db2.Hash(); err != nil {
+		t.Fatal(err)
+	} else if h1 == h2 {
+		t.Fatal("expected hashes to differ")
+	}
+}
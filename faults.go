@@ -0,0 +1,110 @@
+package bolt
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Faults describes latency and errors to inject into the database's file
+// operations. It allows applications embedding Bolt to exercise their own
+// retry and error handling paths without a misbehaving disk.
+//
+// Faults are intended for testing only and should never be set on a
+// production database.
+type Faults struct {
+	// ReadLatency is added to every page read from the mmap. Reads are
+	// served from memory so they can be delayed but cannot fail.
+	ReadLatency time.Duration
+
+	// WriteLatency is added to every write to the data file.
+	WriteLatency time.Duration
+
+	// SyncLatency is added to every fdatasync() of the data file.
+	SyncLatency time.Duration
+
+	// WriteErrorRate is the probability, between 0 and 1, that a write to
+	// the data file fails. Nothing is written when a write fails.
+	WriteErrorRate float64
+
+	// SyncErrorRate is the probability, between 0 and 1, that an
+	// fdatasync() of the data file fails.
+	SyncErrorRate float64
+
+	// Err is the error returned by injected failures.
+	// Defaults to ErrInjectedFault.
+	Err error
+
+	// Seed seeds the random source used to decide which operations fail.
+	// A zero seed uses the current time.
+	Seed int64
+}
+
+// faultInjector applies a Faults configuration to file operations.
+type faultInjector struct {
+	Faults
+
+	mu   sync.Mutex // Protects rand.
+	rand *rand.Rand
+}
+
+func newFaultInjector(f *Faults) *faultInjector {
+	fi := &faultInjector{Faults: *f}
+	if fi.Err == nil {
+		fi.Err = ErrInjectedFault
+	}
+	seed := fi.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fi.rand = rand.New(rand.NewSource(seed))
+	return fi
+}
+
+// fail returns true with the given probability.
+func (fi *faultInjector) fail(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.rand.Float64() < rate
+}
+
+// writeAt wraps fn with the configured write latency and error rate.
+func (fi *faultInjector) writeAt(fn func(b []byte, off int64) (int, error)) func(b []byte, off int64) (int, error) {
+	return func(b []byte, off int64) (int, error) {
+		if fi.WriteLatency > 0 {
+			time.Sleep(fi.WriteLatency)
+		}
+		if fi.fail(fi.WriteErrorRate) {
+			return 0, fi.Err
+		}
+		return fn(b, off)
+	}
+}
+
+// fdatasync wraps fn with the configured sync latency and error rate.
+func (fi *faultInjector) fdatasync(fn func(db *DB) error) func(db *DB) error {
+	return func(db *DB) error {
+		if fi.SyncLatency > 0 {
+			time.Sleep(fi.SyncLatency)
+		}
+		if fi.fail(fi.SyncErrorRate) {
+			return fi.Err
+		}
+		return fn(db)
+	}
+}
+
+// SetFaults injects latency and errors into the database's file operations.
+// Passing nil removes any previously set faults.
+//
+// Do not call concurrently with open transactions.
+func (db *DB) SetFaults(f *Faults) {
+	db.faults = nil
+	if f != nil {
+		db.faults = newFaultInjector(f)
+	}
+	db.resetOps()
+}
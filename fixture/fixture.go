@@ -0,0 +1,102 @@
+// Package fixture seeds and captures the contents of Bolt databases in tests.
+//
+// Fixtures describe the top-level buckets of a database as maps of string
+// keys to string values, so table-driven tests can set up and check state
+// without transaction boilerplate:
+//
+//	if err := fixture.LoadFixture(db, map[string]map[string]string{
+//		"users": {"1": "alice", "2": "bob"},
+//	}); err != nil {
+//		t.Fatal(err)
+//	}
+//
+//	// ... exercise the application ...
+//
+//	got, err := fixture.Snapshot(db)
+//	if err != nil {
+//		t.Fatal(err)
+//	} else if diff := fixture.Diff(want, got); len(diff) > 0 {
+//		t.Fatalf("unexpected state:\n%s", strings.Join(diff, "\n"))
+//	}
+package fixture
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// LoadFixture writes the buckets and key/value pairs of fixture to db in a
+// single transaction. Buckets are created if they do not exist and existing
+// keys are overwritten. Other keys and buckets are left unchanged.
+func LoadFixture(db *bolt.DB, fixture map[string]map[string]string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for name, pairs := range fixture {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("bucket %q: %s", name, err)
+			}
+			for k, v := range pairs {
+				if err := b.Put([]byte(k), []byte(v)); err != nil {
+					return fmt.Errorf("put %q/%q: %s", name, k, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Snapshot returns the key/value pairs of every top-level bucket in db.
+// Empty buckets are returned as empty maps and nested buckets are skipped.
+func Snapshot(db *bolt.DB) (map[string]map[string]string, error) {
+	snapshot := make(map[string]map[string]string)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			pairs := make(map[string]string)
+			if err := b.ForEach(func(k, v []byte) error {
+				if v != nil {
+					pairs[string(k)] = string(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			snapshot[string(name)] = pairs
+			return nil
+		})
+	})
+	return snapshot, err
+}
+
+// Diff returns a sorted description of each difference between the
+// fixtures want and got, or nil if they are equal.
+func Diff(want, got map[string]map[string]string) []string {
+	var diff []string
+	for name, pairs := range want {
+		other, ok := got[name]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("bucket %q: missing", name))
+			continue
+		}
+		for k, v := range pairs {
+			if w, ok := other[k]; !ok {
+				diff = append(diff, fmt.Sprintf("%q/%q: missing, want %q", name, k, v))
+			} else if w != v {
+				diff = append(diff, fmt.Sprintf("%q/%q: got %q, want %q", name, k, w, v))
+			}
+		}
+		for k, v := range other {
+			if _, ok := pairs[k]; !ok {
+				diff = append(diff, fmt.Sprintf("%q/%q: unexpected %q", name, k, v))
+			}
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			diff = append(diff, fmt.Sprintf("bucket %q: unexpected", name))
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
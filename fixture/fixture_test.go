@@ -0,0 +1,83 @@
+package fixture_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/fixture"
+)
+
+// Ensure that a loaded fixture is returned by Snapshot.
+func TestLoadFixture(t *testing.T) {
+	f, err := ioutil.TempFile("", "bolt-fixture-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	want := map[string]map[string]string{
+		"users":  {"1": "alice", "2": "bob"},
+		"groups": {"admins": "1"},
+		"empty":  {},
+	}
+	if err := fixture.LoadFixture(db, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nested buckets are not part of a snapshot.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket([]byte("users")).CreateBucket([]byte("nested"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fixture.Snapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected snapshot: %v", got)
+	} else if diff := fixture.Diff(want, got); diff != nil {
+		t.Fatalf("unexpected diff: %v", diff)
+	}
+
+	// Loading again overwrites keys and leaves others unchanged.
+	if err := fixture.LoadFixture(db, map[string]map[string]string{"users": {"2": "carol"}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = fixture.Snapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		`"users"/"2": got "carol", want "bob"`,
+	}
+	if diff := fixture.Diff(want, got); !reflect.DeepEqual(diff, exp) {
+		t.Fatalf("unexpected diff: %q", diff)
+	}
+
+	delete(got, "groups")
+	got["extra"] = map[string]string{}
+	delete(got["users"], "1")
+	got["empty"]["x"] = "y"
+	exp = []string{
+		`"empty"/"x": unexpected "y"`,
+		`"users"/"1": missing, want "alice"`,
+		`"users"/"2": got "carol", want "bob"`,
+		`bucket "extra": unexpected`,
+		`bucket "groups": missing`,
+	}
+	if diff := fixture.Diff(want, got); !reflect.DeepEqual(diff, exp) {
+		t.Fatalf("unexpected diff: %q", diff)
+	}
+}
@@ -139,6 +139,25 @@ func (f *freelist) release(txid txid) {
 	f.ids = pgids(f.ids).merge(m)
 }
 
+// trim removes the run of free pages that ends at the high water mark hwm if it
+// holds at least min pages. Returns the new high water mark.
+func (f *freelist) trim(hwm pgid, min int) pgid {
+	i := len(f.ids)
+	for i > 0 && f.ids[i-1] == hwm-pgid(len(f.ids)-i)-1 {
+		i--
+	}
+	if n := len(f.ids) - i; n == 0 || n < min {
+		return hwm
+	}
+
+	for _, id := range f.ids[i:] {
+		delete(f.cache, id)
+	}
+	hwm -= pgid(len(f.ids) - i)
+	f.ids = f.ids[:i]
+	return hwm
+}
+
 // rollback removes the pages from a given pending tx.
 func (f *freelist) rollback(txid txid) {
 	// Remove page ids from cache.
@@ -167,8 +186,12 @@ func (f *freelist) read(p *page) {
 
 	// Copy the list of page ids from the freelist.
 	ids := ((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[idx:count]
-	f.ids = make([]pgid, len(ids))
-	copy(f.ids, ids)
+	f.readIDs(append([]pgid(nil), ids...))
+}
+
+// readIDs initializes the freelist from a list of free page ids.
+func (f *freelist) readIDs(ids []pgid) {
+	f.ids = ids
 
 	// Make sure they're sorted.
 	sort.Sort(pgids(f.ids))
@@ -204,7 +227,19 @@ func (f *freelist) write(p *page) error {
 // reload reads the freelist from a page and filters out pending items.
 func (f *freelist) reload(p *page) {
 	f.read(p)
+	f.removePending()
+}
+
+// reloadIDs initializes the freelist from a list of free page ids and
+// filters out pending items.
+func (f *freelist) reloadIDs(ids []pgid) {
+	f.readIDs(ids)
+	f.removePending()
+}
 
+// removePending removes the pages of pending transactions from the list of
+// available pages.
+func (f *freelist) removePending() {
 	// Build a cache of only pending pages.
 	pcache := make(map[pgid]bool)
 	for _, pendingIDs := range f.pending {
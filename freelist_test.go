@@ -156,3 +156,21 @@ func randomPgids(n int) []pgid {
 	sort.Sort(pgids)
 	return pgids
 }
+
+// Ensure that free pages at the high water mark can be trimmed.
+func TestFreelist_trim(t *testing.T) {
+	f := &freelist{ids: []pgid{3, 5, 7, 8, 9}}
+	f.reindex()
+
+	if hwm := f.trim(10, 4); hwm != 10 {
+		t.Fatalf("unexpected high water mark: %d", hwm)
+	} else if hwm := f.trim(11, 1); hwm != 11 {
+		t.Fatalf("unexpected high water mark: %d", hwm)
+	} else if hwm := f.trim(10, 3); hwm != 7 {
+		t.Fatalf("unexpected high water mark: %d", hwm)
+	} else if exp := []pgid{3, 5}; !reflect.DeepEqual(exp, f.ids) {
+		t.Fatalf("exp=%v; got=%v", exp, f.ids)
+	} else if f.freed(8) {
+		t.Fatal("expected trimmed page to be removed from the cache")
+	}
+}
@@ -0,0 +1,70 @@
+package bolt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// LockInfo describes the process holding a database open for writing. It is
+// written to a sidecar file next to the database while the file lock is held
+// so that operators can find which process holds a database that another
+// process fails to open.
+type LockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Opened   time.Time `json:"opened"`
+}
+
+// ReadLockInfo returns the lock info of the database at path. It returns an
+// error satisfying os.IsNotExist if no process has the database open for
+// writing. The info is left behind if the holding process crashes, so the
+// process it names may no longer be running.
+func ReadLockInfo(path string) (LockInfo, error) {
+	var info LockInfo
+	if cpath, err := canonicalPath(path); err == nil {
+		path = cpath
+	}
+	data, err := ioutil.ReadFile(lockInfoPath(path))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// LockInfo returns the lock info written by the database, or false if it is
+// not open for writing.
+func (db *DB) LockInfo() (LockInfo, bool) {
+	return db.lockInfo, db.lockInfo.PID != 0
+}
+
+// lockInfoPath returns the path of the lock info sidecar file.
+func lockInfoPath(path string) string {
+	return path + ".lockinfo"
+}
+
+// writeLockInfo records this process as the holder of the file lock. The
+// info is only advisory so errors are ignored.
+func (db *DB) writeLockInfo() {
+	info := LockInfo{PID: os.Getpid(), Opened: time.Now()}
+	info.Hostname, _ = os.Hostname()
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(lockInfoPath(db.path), data, 0644); err != nil {
+		return
+	}
+	db.lockInfo = info
+}
+
+// removeLockInfo removes the lock info written by the database.
+func (db *DB) removeLockInfo() {
+	if db.lockInfo.PID == 0 {
+		return
+	}
+	_ = os.Remove(lockInfoPath(db.path))
+	db.lockInfo = LockInfo{}
+}
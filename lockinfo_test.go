@@ -0,0 +1,58 @@
+package bolt_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that the process holding a database open for writing can be found.
+func TestReadLockInfo(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	start := time.Now()
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := bolt.ReadLockInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if info.PID != os.Getpid() {
+		t.Fatalf("unexpected pid: %d", info.PID)
+	} else if info.Hostname != hostname {
+		t.Fatalf("unexpected hostname: %s", info.Hostname)
+	} else if info.Opened.Before(start.Add(-time.Second)) || info.Opened.After(time.Now()) {
+		t.Fatalf("unexpected open time: %s", info.Opened)
+	}
+	if own, ok := db.LockInfo(); !ok || own.PID != info.PID || !own.Opened.Equal(info.Opened) {
+		t.Fatalf("unexpected lock info: %+v", own)
+	}
+
+	// The info is removed when the lock is released.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := bolt.ReadLockInfo(path); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := db.LockInfo(); ok {
+		t.Fatal("expected no lock info")
+	}
+
+	// Read-only databases do not write lock info.
+	db, err = bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := bolt.ReadLockInfo(path); !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := db.LockInfo(); ok {
+		t.Fatal("expected no lock info")
+	}
+}
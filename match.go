@@ -0,0 +1,100 @@
+package bolt
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// MatchOptions selects the key/value pairs visited by Bucket.ForEachMatch.
+// Pairs must satisfy every option that is set.
+type MatchOptions struct {
+	// Regexp matches keys. Patterns anchored with ^ followed by literal
+	// text only visit keys with that prefix.
+	Regexp *regexp.Regexp
+
+	// Glob matches keys with the pattern syntax of path.Match, so '*'
+	// does not match '/'. Only keys with the literal text before the first
+	// wildcard are visited.
+	Glob string
+
+	// MinValueSize and MaxValueSize limit the size of values in bytes. A
+	// MaxValueSize of zero does not limit the size. Nested buckets are
+	// skipped when either limit is set.
+	MinValueSize int
+	MaxValueSize int
+}
+
+// ForEachMatch executes a function for each key/value pair in a bucket that
+// is selected by options. Keys outside the literal prefix of the Regexp or
+// Glob pattern are not visited and values are only passed to fn when they
+// match, so a filtered scan reads far less than filtering the output of
+// ForEach. If the provided function returns an error then the iteration is
+// stopped and the error is returned to the caller. Returns path.ErrBadPattern
+// if the Glob pattern is malformed.
+func (b *Bucket) ForEachMatch(options *MatchOptions, fn func(k, v []byte) error) error {
+	if options == nil {
+		options = &MatchOptions{}
+	}
+	if options.Glob != "" {
+		if _, err := path.Match(options.Glob, ""); err != nil {
+			return err
+		}
+	}
+
+	// Only scan keys that start with the literal prefix of both patterns.
+	prefix := regexpPrefix(options.Regexp)
+	if g := globPrefix(options.Glob); len(g) > len(prefix) {
+		if !bytes.HasPrefix(g, prefix) {
+			return nil
+		}
+		prefix = g
+	} else if !bytes.HasPrefix(prefix, g) {
+		return nil
+	}
+
+	sized := options.MinValueSize > 0 || options.MaxValueSize > 0
+	return b.ForEachPrefix(prefix, func(k, v []byte) error {
+		if sized && (v == nil || len(v) < options.MinValueSize || (options.MaxValueSize > 0 && len(v) > options.MaxValueSize)) {
+			return nil
+		} else if options.Regexp != nil && !options.Regexp.Match(k) {
+			return nil
+		} else if options.Glob != "" {
+			if ok, _ := path.Match(options.Glob, string(k)); !ok {
+				return nil
+			}
+		}
+		return fn(k, v)
+	})
+}
+
+// regexpPrefix returns the literal text that every key matched by re must
+// start with, or nil if the pattern is not anchored to the start.
+func regexpPrefix(re *regexp.Regexp) []byte {
+	if re == nil {
+		return nil
+	}
+	s, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	s = s.Simplify()
+	if s.Op != syntax.OpConcat || len(s.Sub) < 2 || s.Sub[0].Op != syntax.OpBeginText {
+		return nil
+	}
+	if lit := s.Sub[1]; lit.Op == syntax.OpLiteral && (lit.Flags&syntax.FoldCase) == 0 {
+		return []byte(string(lit.Rune))
+	}
+	return nil
+}
+
+// globPrefix returns the literal text before the first wildcard of a
+// path.Match pattern.
+func globPrefix(pattern string) []byte {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return []byte(pattern)
+}
@@ -0,0 +1,79 @@
+package bolt_test
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that ForEachMatch only visits keys and values selected by the
+// options.
+func TestBucket_ForEachMatch(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range map[string]string{
+			"user/1":       "alice",
+			"user/2":       "bob",
+			"user/2/posts": "a much longer value",
+			"group/1":      "admins",
+			"USER/3":       "carol",
+		} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = b.CreateBucket([]byte("user/bucket"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		match := func(options *bolt.MatchOptions) string {
+			var keys []string
+			if err := b.ForEachMatch(options, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			return strings.Join(keys, ",")
+		}
+
+		for _, tt := range []struct {
+			options *bolt.MatchOptions
+			exp     string
+		}{
+			{nil, "USER/3,group/1,user/1,user/2,user/2/posts,user/bucket"},
+			{&bolt.MatchOptions{Regexp: regexp.MustCompile(`^user/\d+$`)}, "user/1,user/2"},
+			{&bolt.MatchOptions{Regexp: regexp.MustCompile(`(?i)^user/\d$`)}, "USER/3,user/1,user/2"},
+			{&bolt.MatchOptions{Regexp: regexp.MustCompile(`/1`)}, "group/1,user/1"},
+			{&bolt.MatchOptions{Glob: "user/*"}, "user/1,user/2,user/bucket"},
+			{&bolt.MatchOptions{Glob: "user/*", Regexp: regexp.MustCompile(`^group`)}, ""},
+			{&bolt.MatchOptions{Glob: "*/[12]", MaxValueSize: 4}, "user/2"},
+			{&bolt.MatchOptions{MinValueSize: 6}, "group/1,user/2/posts"},
+		} {
+			if s := match(tt.options); s != tt.exp {
+				t.Fatalf("unexpected keys for %+v: %s", tt.options, s)
+			}
+		}
+
+		if err := b.ForEachMatch(&bolt.MatchOptions{Glob: "user/["}, func(k, v []byte) error {
+			return nil
+		}); err != path.ErrBadPattern {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// MergeFunc combines the existing value for a key with a merge operand and
+// returns the new value. The existing value is nil if the key does not exist.
+// The existing value is only valid for the duration of the call and must not
+// be modified or retained.
+type MergeFunc func(key, existing, operand []byte) ([]byte, error)
+
+// RegisterMerge sets the merge operator used by Bucket.Merge for the bucket
+// at path, given as the names of its parents and itself. Passing a nil fn
+// removes the operator.
+func (db *DB) RegisterMerge(fn MergeFunc, path ...[]byte) {
+	db.bucketlock.Lock()
+	defer db.bucketlock.Unlock()
+	if fn == nil {
+		delete(db.merges, pathKey(path))
+		return
+	}
+	if db.merges == nil {
+		db.merges = make(map[string]MergeFunc)
+	}
+	db.merges[pathKey(path)] = fn
+}
+
+// mergeFunc returns the merge operator registered for the bucket.
+func (b *Bucket) mergeFunc() MergeFunc {
+	db := b.tx.db
+	db.bucketlock.RLock()
+	defer db.bucketlock.RUnlock()
+	return db.merges[pathKey(b.path())]
+}
+
+// Merge combines operand with the value for a key in an existing bucket using
+// the bucket's merge operator and commits it. Concurrent calls are committed
+// in a single transaction when GroupCommitWindow is set. Returns
+// ErrKeyRequired if the key is blank and ErrBucketNotFound if the bucket does
+// not exist.
+func (db *DB) Merge(bucket, key, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	return db.groupCommit(bucket, func(b *Bucket) error {
+		return b.Merge(key, operand)
+	})
+}
+
+// Merge combines operand with the value for a key using the merge operator
+// registered for the bucket's path and stores the result. The key is created
+// if it does not exist. Returns ErrNoMergeOperator if no operator has been
+// registered with DB.RegisterMerge.
+func (b *Bucket) Merge(key, operand []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if err := b.authorize(OpPut, key); err != nil {
+		return err
+	}
+
+	fn := b.mergeFunc()
+	if fn == nil {
+		return ErrNoMergeOperator
+	}
+
+	// Bucket values cannot be merged.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+	if bytes.Equal(key, k) && (flags&hiddenLeafFlags) == 0 {
+		if (flags & bucketLeafFlag) != 0 {
+			return ErrIncompatibleValue
+		}
+	} else {
+		v = nil
+	}
+
+	value, err := fn(key, v, operand)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+// MergeAdd is a MergeFunc that treats values as 8-byte big endian integers
+// and adds the operand to the existing value.
+func MergeAdd(key, existing, operand []byte) ([]byte, error) {
+	if (existing != nil && len(existing) != 8) || len(operand) != 8 {
+		return nil, ErrIncompatibleValue
+	}
+	var n uint64
+	if existing != nil {
+		n = binary.BigEndian.Uint64(existing)
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, n+binary.BigEndian.Uint64(operand))
+	return value, nil
+}
+
+// MergeAppend is a MergeFunc that appends the operand to the existing value.
+func MergeAppend(key, existing, operand []byte) ([]byte, error) {
+	value := make([]byte, 0, len(existing)+len(operand))
+	return append(append(value, existing...), operand...), nil
+}
+
+// MergeOr is a MergeFunc that ORs the operand into the existing value byte by
+// byte. The result is as long as the longer of the two, so it suits plain
+// fixed-layout bitsets but not compressed bitmap formats.
+func MergeOr(key, existing, operand []byte) ([]byte, error) {
+	if len(existing) < len(operand) {
+		existing, operand = operand, existing
+	}
+	value := append([]byte{}, existing...)
+	for i, c := range operand {
+		value[i] |= c
+	}
+	return value, nil
+}
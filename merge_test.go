@@ -0,0 +1,131 @@
+package bolt_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that merges are applied with the operator registered for the bucket.
+func TestBucket_Merge(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.RegisterMerge(bolt.MergeAppend, []byte("widgets"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Merge([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Merge([]byte("foo"), []byte("baz")); err != nil {
+			t.Fatal(err)
+		}
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("barbaz")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Merge([]byte("sub"), []byte("bar")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		other, err := tx.CreateBucket([]byte("other"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Merge([]byte("foo"), []byte("bar")); err != bolt.ErrNoMergeOperator {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that merge operators are registered by bucket path so that nested
+// buckets with the same name can use different operators.
+func TestBucket_Merge_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.RegisterMerge(bolt.MergeAppend, []byte("a"), []byte("widgets"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			parent, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			} else if _, err := parent.CreateBucket([]byte("widgets")); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket([]byte("a")).Bucket([]byte("widgets")).Merge([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		} else if err := tx.Bucket([]byte("b")).Bucket([]byte("widgets")).Merge([]byte("foo"), []byte("bar")); err != bolt.ErrNoMergeOperator {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := tx.Bucket([]byte("widgets")).Merge([]byte("foo"), []byte("bar")); err != bolt.ErrNoMergeOperator {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that concurrent merges through the DB are all applied.
+func TestDB_Merge(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.GroupCommitWindow = 10 * time.Millisecond
+	db.RegisterMerge(bolt.MergeAdd, []byte("counters"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("counters"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Merge([]byte("counters"), []byte("hits"), u64tob(2)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, err := db.Get([]byte("counters"), []byte("hits")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, u64tob(20)) {
+		t.Fatalf("unexpected value: %x", v)
+	}
+}
+
+// Ensure that MergeOr combines values of different lengths.
+func TestMergeOr(t *testing.T) {
+	if v, err := bolt.MergeOr(nil, []byte{0x01, 0x10}, []byte{0x02}); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, []byte{0x03, 0x10}) {
+		t.Fatalf("unexpected value: %x", v)
+	}
+	if v, err := bolt.MergeOr(nil, nil, []byte{0x02, 0x20}); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, []byte{0x02, 0x20}) {
+		t.Fatalf("unexpected value: %x", v)
+	}
+}
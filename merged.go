@@ -0,0 +1,133 @@
+package bolt
+
+import "bytes"
+
+// MergedCursor iterates over the union of the keys of several buckets in key
+// order, such as a bucket of defaults layered under a bucket of overrides.
+// The buckets may belong to different transactions, including transactions
+// on attached files.
+//
+// When a key exists in more than one bucket, the pair from the bucket that
+// was passed first to NewMergedCursor is returned and the others are hidden.
+// Like Cursor, nested buckets are returned with a nil value.
+type MergedCursor struct {
+	cursors []*Cursor
+	keys    [][]byte // current key of each cursor
+	values  [][]byte // current value of each cursor
+	source  int      // index of the cursor that supplied the current pair
+	forward bool     // direction of the last move
+}
+
+// NewMergedCursor returns a cursor over the union of buckets, in order of
+// precedence. The cursor is only valid as long as the transactions of the
+// buckets are open.
+func NewMergedCursor(buckets ...*Bucket) *MergedCursor {
+	c := &MergedCursor{
+		keys:   make([][]byte, len(buckets)),
+		values: make([][]byte, len(buckets)),
+		source: -1,
+	}
+	for _, b := range buckets {
+		c.cursors = append(c.cursors, b.Cursor())
+	}
+	return c
+}
+
+// Source returns the index of the bucket that supplied the current pair, or
+// -1 if the cursor is not positioned on a pair.
+func (c *MergedCursor) Source() int {
+	return c.source
+}
+
+// First moves the cursor to the first key of any bucket and returns it.
+// If all buckets are empty then a nil key and value are returned.
+func (c *MergedCursor) First() (key []byte, value []byte) {
+	for i, cur := range c.cursors {
+		c.keys[i], c.values[i] = cur.First()
+	}
+	return c.pick(true)
+}
+
+// Last moves the cursor to the last key of any bucket and returns it.
+// If all buckets are empty then a nil key and value are returned.
+func (c *MergedCursor) Last() (key []byte, value []byte) {
+	for i, cur := range c.cursors {
+		c.keys[i], c.values[i] = cur.Last()
+	}
+	return c.pick(false)
+}
+
+// Seek moves the cursor to the given key, or the next key if it does not
+// exist in any bucket, and returns it. If no keys follow then a nil key and
+// value are returned.
+func (c *MergedCursor) Seek(seek []byte) (key []byte, value []byte) {
+	for i, cur := range c.cursors {
+		c.keys[i], c.values[i] = cur.Seek(seek)
+	}
+	return c.pick(true)
+}
+
+// Next moves the cursor to the next key and returns it. If the cursor is at
+// the end then a nil key and value are returned.
+func (c *MergedCursor) Next() (key []byte, value []byte) {
+	if c.source == -1 {
+		return nil, nil
+	}
+	current := c.keys[c.source]
+	for i, cur := range c.cursors {
+		if !c.forward {
+			// Reposition on the first key after the current one.
+			k, v := cur.Seek(current)
+			if bytes.Equal(k, current) {
+				k, v = cur.Next()
+			}
+			c.keys[i], c.values[i] = k, v
+		} else if bytes.Equal(c.keys[i], current) {
+			c.keys[i], c.values[i] = cur.Next()
+		}
+	}
+	return c.pick(true)
+}
+
+// Prev moves the cursor to the previous key and returns it. If the cursor is
+// at the beginning then a nil key and value are returned.
+func (c *MergedCursor) Prev() (key []byte, value []byte) {
+	if c.source == -1 {
+		return nil, nil
+	}
+	current := c.keys[c.source]
+	for i, cur := range c.cursors {
+		if c.forward {
+			// Reposition on the last key before the current one.
+			k, v := cur.Seek(current)
+			if k == nil {
+				k, v = cur.Last()
+			} else {
+				k, v = cur.Prev()
+			}
+			c.keys[i], c.values[i] = k, v
+		} else if bytes.Equal(c.keys[i], current) {
+			c.keys[i], c.values[i] = cur.Prev()
+		}
+	}
+	return c.pick(false)
+}
+
+// pick selects the smallest key when moving forward or the largest key when
+// moving backward, preferring earlier buckets for equal keys.
+func (c *MergedCursor) pick(forward bool) ([]byte, []byte) {
+	c.forward, c.source = forward, -1
+	for i, k := range c.keys {
+		if k == nil {
+			continue
+		} else if c.source == -1 {
+			c.source = i
+		} else if cmp := bytes.Compare(k, c.keys[c.source]); (forward && cmp < 0) || (!forward && cmp > 0) {
+			c.source = i
+		}
+	}
+	if c.source == -1 {
+		return nil, nil
+	}
+	return c.keys[c.source], c.values[c.source]
+}
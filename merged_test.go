@@ -0,0 +1,135 @@
+package bolt_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a merged cursor iterates the union of buckets in key order and
+// prefers earlier buckets for duplicate keys.
+func TestMergedCursor(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for name, pairs := range map[string][]string{
+			"overrides": {"color=red", "size=10"},
+			"defaults":  {"color=blue", "font=mono", "size=12", "theme=dark"},
+			"empty":     nil,
+		} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, pair := range pairs {
+				kv := strings.SplitN(pair, "=", 2)
+				if err := b.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := bolt.NewMergedCursor(tx.Bucket([]byte("overrides")), tx.Bucket([]byte("empty")), tx.Bucket([]byte("defaults")))
+
+		var pairs []string
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			pairs = append(pairs, string(k)+"="+string(v))
+		}
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			pairs = append(pairs, string(k)+"="+string(v))
+		}
+		if s := strings.Join(pairs, ","); s != "color=red,font=mono,size=10,theme=dark,theme=dark,size=10,font=mono,color=red" {
+			t.Fatalf("unexpected pairs: %s", s)
+		}
+
+		// Change direction in the middle of the keys.
+		if k, _ := c.Seek([]byte("g")); string(k) != "size" || c.Source() != 0 {
+			t.Fatalf("unexpected seek: %s (%d)", k, c.Source())
+		} else if k, v := c.Prev(); string(k) != "font" || string(v) != "mono" || c.Source() != 2 {
+			t.Fatalf("unexpected prev: %s=%s (%d)", k, v, c.Source())
+		} else if k, v := c.Next(); string(k) != "size" || string(v) != "10" {
+			t.Fatalf("unexpected next: %s=%s", k, v)
+		} else if k, _ := c.Next(); string(k) != "theme" {
+			t.Fatalf("unexpected next: %s", k)
+		} else if k, _ := c.Next(); k != nil || c.Source() != -1 {
+			t.Fatalf("unexpected next: %s", k)
+		} else if k, _ := c.Next(); k != nil {
+			t.Fatalf("unexpected next: %s", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a merged cursor matches the sorted union of large buckets when
+// changing direction at random.
+func TestMergedCursor_Random(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	rand := rand.New(rand.NewSource(42))
+	union := make(map[string]int)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 3; i++ {
+			b, err := tx.CreateBucket([]byte{byte('a' + i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j := 0; j < 2000; j++ {
+				k := fmt.Sprintf("%05d", rand.Intn(10000))
+				if err := b.Put([]byte(k), []byte{byte(i)}); err != nil {
+					t.Fatal(err)
+				}
+				if src, ok := union[k]; !ok || i < src {
+					union[k] = i
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(union))
+	for k := range union {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := bolt.NewMergedCursor(tx.Bucket([]byte("a")), tx.Bucket([]byte("b")), tx.Bucket([]byte("c")))
+		i := len(keys) / 2
+		k, v := c.Seek([]byte(keys[i]))
+		for step := 0; step < 5000; step++ {
+			if i < 0 || i >= len(keys) {
+				if k != nil {
+					t.Fatalf("expected end at step %d: %s", step, k)
+				}
+				k, v = c.Seek([]byte(keys[len(keys)/2]))
+				i = len(keys) / 2
+				continue
+			} else if string(k) != keys[i] || int(v[0]) != union[keys[i]] {
+				t.Fatalf("unexpected pair at step %d: %s=%v, expected %s=%d", step, k, v, keys[i], union[keys[i]])
+			}
+			if rand.Intn(2) == 0 {
+				k, v = c.Next()
+				i++
+			} else {
+				k, v = c.Prev()
+				i--
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -38,7 +38,7 @@ func (n *node) minKeys() int {
 
 // size returns the size of the node after serialization.
 func (n *node) size() int {
-	sz, elsz := pageHeaderSize, n.pageElementSize()
+	sz, elsz := n.layout()
 	for i := 0; i < len(n.inodes); i++ {
 		item := &n.inodes[i]
 		sz += elsz + len(item.key) + len(item.value)
@@ -50,7 +50,7 @@ func (n *node) size() int {
 // This is an optimization to avoid calculating a large node when we only need
 // to know if it fits inside a certain page size.
 func (n *node) sizeLessThan(v int) bool {
-	sz, elsz := pageHeaderSize, n.pageElementSize()
+	sz, elsz := n.layout()
 	for i := 0; i < len(n.inodes); i++ {
 		item := &n.inodes[i]
 		sz += elsz + len(item.key) + len(item.value)
@@ -69,6 +69,15 @@ func (n *node) pageElementSize() int {
 	return branchPageElementSize
 }
 
+// layout returns the size of the page header and of each page element used to
+// serialize the node, which depend on whether the node is packed.
+func (n *node) layout() (hdrsz, elsz int) {
+	if n.packed() {
+		return pageHeaderSize + packedLeafHeaderSize, packedLeafPageElementSize
+	}
+	return pageHeaderSize, n.pageElementSize()
+}
+
 // childAt returns the child node at a given index.
 func (n *node) childAt(index int) *node {
 	if n.isLeaf {
@@ -130,6 +139,20 @@ func (n *node) put(oldKey, newKey, value []byte, pgid pgid, flags uint32) {
 	if !exact {
 		n.inodes = append(n.inodes, inode{})
 		copy(n.inodes[index+1:], n.inodes[index:])
+		if n.isLeaf {
+			n.bucket.totals.keyN++
+		}
+	} else if n.isLeaf {
+		n.bucket.totals.size -= len(n.inodes[index].key) + len(n.inodes[index].value)
+		if (n.inodes[index].flags & bucketLeafFlag) != 0 {
+			n.bucket.totals.bucketN--
+		}
+	}
+	if n.isLeaf {
+		n.bucket.totals.size += len(newKey) + len(value)
+		if (flags & bucketLeafFlag) != 0 {
+			n.bucket.totals.bucketN++
+		}
 	}
 
 	inode := &n.inodes[index]
@@ -138,6 +161,10 @@ func (n *node) put(oldKey, newKey, value []byte, pgid pgid, flags uint32) {
 	inode.value = value
 	inode.pgid = pgid
 	_assert(len(inode.key) > 0, "put: zero-length inode key")
+
+	if db := n.bucket.tx.db; db != nil && db.Assertions {
+		n.validateOrder()
+	}
 }
 
 // del removes a key from the node.
@@ -151,10 +178,77 @@ func (n *node) del(key []byte) {
 	}
 
 	// Delete inode from the node.
+	if n.isLeaf {
+		n.bucket.totals.keyN--
+		n.bucket.totals.size -= len(n.inodes[index].key) + len(n.inodes[index].value)
+		if (n.inodes[index].flags & bucketLeafFlag) != 0 {
+			n.bucket.totals.bucketN--
+		}
+	}
 	n.inodes = append(n.inodes[:index], n.inodes[index+1:]...)
 
 	// Mark the node as needing rebalancing.
 	n.unbalanced = true
+
+	if db := n.bucket.tx.db; db != nil && db.Assertions {
+		n.validateOrder()
+	}
+}
+
+// validateOrder panics if the node's keys are not in strictly increasing order.
+func (n *node) validateOrder() {
+	for i := 1; i < len(n.inodes); i++ {
+		if bytes.Compare(n.inodes[i-1].key, n.inodes[i].key) != -1 {
+			panic(fmt.Sprintf("key order violation: %x >= %x at index %d", n.inodes[i-1].key, n.inodes[i].key, i))
+		}
+	}
+}
+
+// assertions returns true if internal invariant checks are enabled.
+func (n *node) assertions() bool {
+	return n.bucket != nil && n.bucket.tx != nil && n.bucket.tx.db != nil && n.bucket.tx.db.Assertions
+}
+
+// checkPage panics if the elements of a page written for or read into the node
+// do not fit within the page, if its keys are not in strictly increasing order
+// or if a branch element references a page outside the file. The bounds of
+// inline pages are not checked.
+func (n *node) checkPage(p *page) {
+	tx := n.bucket.tx
+	var size uintptr
+	if p.id != 0 {
+		size = uintptr(tx.db.pageSize) * uintptr(p.overflow+1)
+	}
+	hdrsz, elsz := pageHeaderSize, n.pageElementSize()
+	if (p.flags & packedPageFlag) != 0 {
+		hdrsz, elsz = pageHeaderSize+packedLeafHeaderSize, packedLeafPageElementSize
+	}
+	hdr := uintptr(hdrsz + elsz*int(p.count))
+	_assert(size == 0 || hdr <= size, "page %d: %d elements do not fit in %d bytes", p.id, p.count, size)
+
+	var prev []byte
+	base := uintptr(unsafe.Pointer(p))
+	for i := uint16(0); i < p.count; i++ {
+		var key []byte
+		var end uintptr
+		if (p.flags & packedPageFlag) != 0 {
+			elem := p.packedLeafPageElement(i)
+			key = elem.key()
+			end = uintptr(unsafe.Pointer(elem)) + uintptr(elem.pos) + uintptr(elem.ksize) + uintptr(p.packedValueSize())
+		} else if n.isLeaf {
+			elem := p.leafPageElement(i)
+			key = elem.key()
+			end = uintptr(unsafe.Pointer(elem)) + uintptr(elem.pos) + uintptr(elem.ksize) + uintptr(elem.vsize)
+		} else {
+			elem := p.branchPageElement(i)
+			key = elem.key()
+			end = uintptr(unsafe.Pointer(elem)) + uintptr(elem.pos) + uintptr(elem.ksize)
+			_assert(elem.pgid > 1 && elem.pgid < tx.meta.pgid, "page %d: element %d references page %d outside the file (high water mark %d)", p.id, i, elem.pgid, tx.meta.pgid)
+		}
+		_assert(size == 0 || end-base <= size, "page %d: element %d ends at offset %d beyond page size %d", p.id, i, end-base, size)
+		_assert(prev == nil || bytes.Compare(prev, key) == -1, "page %d: key %x at index %d does not follow %x", p.id, key, i, prev)
+		prev = key
+	}
 }
 
 // read initializes the node from a page.
@@ -166,10 +260,7 @@ func (n *node) read(p *page) {
 	for i := 0; i < int(p.count); i++ {
 		inode := &n.inodes[i]
 		if n.isLeaf {
-			elem := p.leafPageElement(uint16(i))
-			inode.flags = elem.flags
-			inode.key = elem.key()
-			inode.value = elem.value()
+			inode.key, inode.value, inode.flags = p.leafElement(uint16(i))
 		} else {
 			elem := p.branchPageElement(uint16(i))
 			inode.pgid = elem.pgid
@@ -185,6 +276,10 @@ func (n *node) read(p *page) {
 	} else {
 		n.key = nil
 	}
+
+	if n.assertions() {
+		n.checkPage(p)
+	}
 }
 
 // write writes the items onto one or more pages.
@@ -196,23 +291,49 @@ func (n *node) write(p *page) {
 		p.flags |= branchPageFlag
 	}
 
+	// Mark the pages of the bucket directory so that they can be identified
+	// and validated without walking the tree from the meta page.
+	if n.bucket != nil && n.bucket == &n.bucket.tx.root {
+		p.flags |= directoryPageFlag
+	}
+
 	if len(n.inodes) >= 0xFFFF {
 		panic(fmt.Sprintf("inode overflow: %d (pgid=%d)", len(n.inodes), p.id))
 	}
 	p.count = uint16(len(n.inodes))
 
+	// Packed pages store the shared value size ahead of the elements.
+	var features uint32
+	hdrsz, elsz := n.layout()
+	packed := hdrsz > pageHeaderSize
+	if packed {
+		p.flags |= packedPageFlag
+		*(*uint32)(unsafe.Pointer(&p.ptr)) = uint32(len(n.inodes[0].value))
+		features |= featurePacked
+	}
+
 	// Loop over each item and write it to the page.
-	b := (*[maxAllocSize]byte)(unsafe.Pointer(&p.ptr))[n.pageElementSize()*len(n.inodes):]
+	b := (*[maxAllocSize]byte)(unsafe.Pointer(&p.ptr))[hdrsz-pageHeaderSize+elsz*len(n.inodes):]
 	for i, item := range n.inodes {
 		_assert(len(item.key) > 0, "write: zero-length inode key")
 
 		// Write the page element.
-		if n.isLeaf {
+		if packed {
+			elem := p.packedLeafPageElement(uint16(i))
+			elem.pos = uint32(uintptr(unsafe.Pointer(&b[0])) - uintptr(unsafe.Pointer(elem)))
+			elem.ksize = uint32(len(item.key))
+		} else if n.isLeaf {
 			elem := p.leafPageElement(uint16(i))
 			elem.pos = uint32(uintptr(unsafe.Pointer(&b[0])) - uintptr(unsafe.Pointer(elem)))
 			elem.flags = item.flags
 			elem.ksize = uint32(len(item.key))
 			elem.vsize = uint32(len(item.value))
+			if (item.flags & tombstoneLeafFlag) != 0 {
+				features |= featureTombstones
+			}
+			if (item.flags & expiringLeafFlag) != 0 {
+				features |= featureExpiring
+			}
 		} else {
 			elem := p.branchPageElement(uint16(i))
 			elem.pos = uint32(uintptr(unsafe.Pointer(&b[0])) - uintptr(unsafe.Pointer(elem)))
@@ -237,6 +358,16 @@ func (n *node) write(p *page) {
 		b = b[vlen:]
 	}
 
+	// Record the format features used by the page so that older versions
+	// refuse to open the file.
+	if features != 0 {
+		n.bucket.tx.meta.flags |= features
+	}
+
+	if n.assertions() {
+		n.checkPage(p)
+	}
+
 	// DEBUG ONLY: n.dump()
 }
 
@@ -308,13 +439,13 @@ func (n *node) splitTwo(pageSize int) (*node, *node) {
 // It returns the index as well as the size of the first page.
 // This is only be called from split().
 func (n *node) splitIndex(threshold int) (index, sz int) {
-	sz = pageHeaderSize
+	sz, elsz := n.layout()
 
 	// Loop until we only have the minimum number of keys required for the second page.
 	for i := 0; i < len(n.inodes)-minKeysPerPage; i++ {
 		index = i
 		inode := n.inodes[i]
-		elsize := n.pageElementSize() + len(inode.key) + len(inode.value)
+		elsize := elsz + len(inode.key) + len(inode.value)
 
 		// If we have at least the minimum number of keys and adding another
 		// node would put us over the threshold then exit and return.
@@ -410,8 +541,14 @@ func (n *node) rebalance() {
 	// Update statistics.
 	n.bucket.tx.stats.Rebalance++
 
-	// Ignore if node is above threshold (25%) and has enough keys.
-	var threshold = n.bucket.tx.db.pageSize / 4
+	// Ignore if node is above threshold (25% by default) and has enough keys.
+	var rebalancePercent = n.bucket.RebalancePercent
+	if rebalancePercent < 0 {
+		rebalancePercent = 0
+	} else if rebalancePercent > maxRebalancePercent {
+		rebalancePercent = maxRebalancePercent
+	}
+	var threshold = int(float64(n.bucket.tx.db.pageSize) * rebalancePercent)
 	if n.size() > threshold && len(n.inodes) > n.minKeys() {
 		return
 	}
@@ -1,6 +1,8 @@
 package bolt
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"unsafe"
 )
@@ -30,6 +32,25 @@ func TestNode_put(t *testing.T) {
 	}
 }
 
+// Ensure that assertions catch out of order keys.
+func TestNode_put_Assertions(t *testing.T) {
+	db := &DB{Assertions: true}
+	n := &node{inodes: make(inodes, 0), bucket: &Bucket{tx: &Tx{db: db, meta: &meta{pgid: 1}}}}
+	n.put([]byte("baz"), []byte("baz"), []byte("2"), 0, 0)
+	n.put([]byte("foo"), []byte("foo"), []byte("0"), 0, 0)
+	n.put([]byte("bar"), []byte("bar"), []byte("1"), 0, 0)
+	n.del([]byte("baz"))
+
+	// Simulate a broken ordering and ensure the next modification panics.
+	n.inodes[0], n.inodes[1] = n.inodes[1], n.inodes[0]
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	n.put([]byte("zzz"), []byte("zzz"), []byte("3"), 0, 0)
+}
+
 // Ensure that a node can deserialize from a leaf page.
 func TestNode_read_LeafPage(t *testing.T) {
 	// Create a page.
@@ -99,6 +120,39 @@ func TestNode_write_LeafPage(t *testing.T) {
 	}
 }
 
+// Ensure that assertions catch a page whose elements overflow its size or
+// whose keys are out of order.
+func TestNode_write_Assertions(t *testing.T) {
+	db := &DB{Assertions: true, pageSize: 4096}
+	n := &node{isLeaf: true, inodes: make(inodes, 0), bucket: &Bucket{tx: &Tx{db: db, meta: &meta{pgid: 10}}}}
+	n.put([]byte("foo"), []byte("foo"), make([]byte, 5000), 0, 0)
+
+	var buf [8192]byte
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	p.id = 3
+	assertPanic(t, "page 3: element 0 ends at offset", func() { n.write(p) })
+
+	// Out of order keys are reported when the page is read back.
+	p.overflow = 1
+	n.inodes = inodes{{key: []byte("b")}, {key: []byte("a")}}
+	db.Assertions = false
+	n.write(p)
+	db.Assertions = true
+	assertPanic(t, "page 3: key 61 at index 1 does not follow 62", func() { n.read(p) })
+}
+
+// assertPanic fails the test unless fn panics with a message containing s.
+func assertPanic(t *testing.T, s string, fn func()) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic")
+		} else if msg := fmt.Sprint(r); !strings.Contains(msg, s) {
+			t.Fatalf("unexpected panic: %s", msg)
+		}
+	}()
+	fn()
+}
+
 // Ensure that a node can split into appropriate subgroups.
 func TestNode_split(t *testing.T) {
 	// Create a node.
@@ -0,0 +1,60 @@
+package bolt
+
+import (
+	"os"
+	"sync"
+)
+
+// openFiles tracks the data files opened by this process. Two DBs mapping
+// the same file would each take their own writer lock and overwrite each
+// other's pages, and file locks do not prevent this on every platform.
+var openFiles struct {
+	sync.Mutex
+	files []openFile
+}
+
+// openFile is a data file opened by a DB in this process.
+type openFile struct {
+	db       *DB
+	info     os.FileInfo
+	readOnly bool
+}
+
+// register records the data file as open by db. Files are matched by device
+// and inode, so other paths to the same file are also detected. Returns
+// ErrDatabaseOpen if the file is already open in this process and either
+// DB is writable.
+func (db *DB) register() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	openFiles.Lock()
+	defer openFiles.Unlock()
+	for _, f := range openFiles.files {
+		if os.SameFile(f.info, info) && !(f.readOnly && db.readOnly) {
+			return ErrDatabaseOpen
+		}
+	}
+	openFiles.files = append(openFiles.files, openFile{db: db, info: info, readOnly: db.readOnly})
+	db.registered = true
+	return nil
+}
+
+// unregister removes the data file of db from the open files.
+func (db *DB) unregister() {
+	if !db.registered {
+		return
+	}
+	db.registered = false
+
+	openFiles.Lock()
+	defer openFiles.Unlock()
+	for i, f := range openFiles.files {
+		if f.db == db {
+			openFiles.files = append(openFiles.files[:i], openFiles.files[i+1:]...)
+			break
+		}
+	}
+}
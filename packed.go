@@ -0,0 +1,58 @@
+package bolt
+
+// SetFixedValueSize enables the packed leaf layout for the bucket at path,
+// given as the names of its parents and itself, whose values are all size
+// bytes, such as index buckets that map keys to 8-byte ids. A size of zero or
+// less disables it. Transactions that are already open are not affected.
+//
+// Packed leaf pages store the value size once per page instead of a flags and
+// value size header per element, which saves 8 bytes per key. Leaf pages that
+// hold a value of another size, a nested bucket or a tombstone are written
+// with the regular layout, so the setting only affects space and never
+// restricts the values that can be stored. Pages are repacked as they are
+// written and both layouts can be read regardless of the setting.
+//
+// Files containing packed pages are marked with a newer format version and
+// cannot be opened by versions of Bolt without packed leaf support.
+func (db *DB) SetFixedValueSize(size int, path ...[]byte) {
+	db.bucketlock.Lock()
+	defer db.bucketlock.Unlock()
+	if size <= 0 {
+		delete(db.valueSizes, pathKey(path))
+		return
+	}
+	if db.valueSizes == nil {
+		db.valueSizes = make(map[string]int)
+	}
+	db.valueSizes[pathKey(path)] = size
+}
+
+// fixedValueSize returns the value size set with SetFixedValueSize for the
+// bucket or zero if none is set. The setting is read once per transaction so
+// that nodes are sized and written with the same layout.
+func (b *Bucket) fixedValueSize() int {
+	if b.valueSize < 0 {
+		db := b.tx.db
+		db.bucketlock.RLock()
+		b.valueSize = db.valueSizes[pathKey(b.path())]
+		db.bucketlock.RUnlock()
+	}
+	return b.valueSize
+}
+
+// packed returns true if the node is written with the packed leaf layout.
+func (n *node) packed() bool {
+	if !n.isLeaf || len(n.inodes) == 0 || n.bucket == nil || n.bucket.tx == nil || n.bucket.tx.db == nil {
+		return false
+	}
+	size := n.bucket.fixedValueSize()
+	if size == 0 {
+		return false
+	}
+	for i := range n.inodes {
+		if n.inodes[i].flags != 0 || len(n.inodes[i].value) != size {
+			return false
+		}
+	}
+	return true
+}
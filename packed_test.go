@@ -0,0 +1,200 @@
+package bolt_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"unsafe"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that buckets with fixed size values use less space and can still be
+// read, updated and checked.
+func TestDB_SetFixedValueSize(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.Assertions = true
+	db.SetFixedValueSize(8, []byte("packed"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"packed", "regular"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10000; i++ {
+				if err := b.Put(u64tob(uint64(i)), u64tob(uint64(i*2))); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		packed, regular := tx.Bucket([]byte("packed")).Stats(), tx.Bucket([]byte("regular")).Stats()
+		if packed.KeyN != 10000 {
+			t.Fatalf("unexpected key count: %d", packed.KeyN)
+		} else if packed.LeafInuse >= regular.LeafInuse-10000*7 {
+			t.Fatalf("expected packed pages to be smaller: %d >= %d", packed.LeafInuse, regular.LeafInuse)
+		} else if packed.LeafPageN >= regular.LeafPageN {
+			t.Fatalf("expected fewer packed pages: %d >= %d", packed.LeafPageN, regular.LeafPageN)
+		}
+
+		c := tx.Bucket([]byte("packed")).Cursor()
+		var i uint64
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !bytes.Equal(k, u64tob(i)) || !bytes.Equal(v, u64tob(i*2)) {
+				t.Fatalf("unexpected pair %d: %x=%x", i, k, v)
+			}
+			i++
+		}
+		if k, v := c.Seek(u64tob(5000)); !bytes.Equal(k, u64tob(5000)) || !bytes.Equal(v, u64tob(10000)) {
+			t.Fatalf("unexpected seek: %x=%x", k, v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A value of another size falls back to the regular layout for its page.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("packed"))
+		if err := b.Put(u64tob(100), []byte("longer value")); err != nil {
+			t.Fatal(err)
+		} else if err := b.Delete(u64tob(200)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := b.CreateBucket([]byte("child"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("packed"))
+		if v := b.Get(u64tob(100)); string(v) != "longer value" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get(u64tob(101)); !bytes.Equal(v, u64tob(202)) {
+			t.Fatalf("unexpected value: %x", v)
+		} else if v := b.Get(u64tob(200)); v != nil {
+			t.Fatalf("unexpected value: %x", v)
+		} else if b.Bucket([]byte("child")) == nil {
+			t.Fatal("expected child bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the fixed value size applies to the bucket at the given path
+// only and not to other buckets with the same name.
+func TestDB_SetFixedValueSize_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetFixedValueSize(8, []byte("users"), []byte("ids"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nested, err := users.CreateBucket([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		top, err := tx.CreateBucket([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			for _, b := range []*bolt.Bucket{nested, top} {
+				if err := b.Put(u64tob(uint64(i)), u64tob(uint64(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		nested := tx.Bucket([]byte("users")).Bucket([]byte("ids")).Stats()
+		top := tx.Bucket([]byte("ids")).Stats()
+		if nested.LeafInuse >= top.LeafInuse-1000*7 {
+			t.Fatalf("expected only the nested bucket to be packed: %d, %d", nested.LeafInuse, top.LeafInuse)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that files with packed pages are marked with a newer format version
+// so that versions of Bolt without packed leaf support refuse to open them.
+func TestDB_SetFixedValueSize_Version(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	path := db.Path()
+
+	put := func(name string) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), u64tob(1))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("regular")
+	if m := readMeta(t, path); m.version != 2 || m.flags != 0 {
+		t.Fatalf("unexpected meta: version=%d flags=%x", m.version, m.flags)
+	}
+
+	db.SetFixedValueSize(8, []byte("packed"))
+	put("packed")
+	if m := readMeta(t, path); m.version != 3 || m.flags != 0x01 {
+		t.Fatalf("unexpected meta: version=%d flags=%x", m.version, m.flags)
+	}
+
+	// The file can still be opened by this version.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	put("regular")
+	if m := readMeta(t, path); m.version != 3 || m.flags != 0x01 {
+		t.Fatalf("unexpected meta: version=%d flags=%x", m.version, m.flags)
+	}
+}
+
+// readMeta returns the newest meta page of the file at path.
+func readMeta(t *testing.T, path string) meta {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newest meta
+	for _, off := range []int{pageHeaderSize, pageSize + pageHeaderSize} {
+		if m := (*meta)(unsafe.Pointer(&buf[off])); m.txid >= newest.txid {
+			newest = *m
+		}
+	}
+	return newest
+}
@@ -13,16 +13,80 @@ const minKeysPerPage = 2
 
 const branchPageElementSize = int(unsafe.Sizeof(branchPageElement{}))
 const leafPageElementSize = int(unsafe.Sizeof(leafPageElement{}))
+const packedLeafPageElementSize = int(unsafe.Sizeof(packedLeafPageElement{}))
+
+// packedLeafHeaderSize is the size of the value size stored at the start of a
+// packed leaf page, padded to keep the elements aligned.
+const packedLeafHeaderSize = 8
+
+// PageHeaderSize is the size, in bytes, of the header at the start of every page.
+const PageHeaderSize = pageHeaderSize
+
+// LeafElementSize is the size, in bytes, of the header stored for each
+// key/value pair on a leaf page.
+const LeafElementSize = leafPageElementSize
+
+// BranchElementSize is the size, in bytes, of the header stored for each
+// child reference on a branch page.
+const BranchElementSize = branchPageElementSize
+
+// MaxInlineValueSize returns the largest value, in bytes, that can be stored
+// with a key of keyLen bytes without spilling onto overflow pages for the
+// given page size. Returns zero if the key alone does not fit in a page.
+func MaxInlineValueSize(pageSize, keyLen int) int {
+	if n := pageSize - pageHeaderSize - leafPageElementSize - keyLen; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// EstimatePages returns an estimate of the number of leaf pages used to
+// store count key/value pairs of the given sizes, assuming keys are
+// inserted in order with the default fill percent. Branch pages are not
+// included.
+func EstimatePages(pageSize, keyLen, valLen, count int) int {
+	if count <= 0 {
+		return 0
+	}
+
+	// All elements fit in a single page so no split occurs.
+	elsize := leafPageElementSize + keyLen + valLen
+	if pageHeaderSize+count*elsize <= pageSize {
+		return 1
+	}
+
+	// Determine how many elements are written to each split page and how
+	// many pages, including overflow, each split page occupies.
+	threshold := int(float64(pageSize) * DefaultFillPercent)
+	perPage := (threshold - pageHeaderSize) / elsize
+	if perPage < minKeysPerPage {
+		perPage = minKeysPerPage
+	}
+	span := (pageHeaderSize + perPage*elsize + pageSize - 1) / pageSize
+
+	return ((count + perPage - 1) / perPage) * span
+}
 
 const (
-	branchPageFlag   = 0x01
-	leafPageFlag     = 0x02
-	metaPageFlag     = 0x04
-	freelistPageFlag = 0x10
+	branchPageFlag    = 0x01
+	leafPageFlag      = 0x02
+	metaPageFlag      = 0x04
+	freelistPageFlag  = 0x10
+	directoryPageFlag = 0x20 // set with branchPageFlag or leafPageFlag
+	packedPageFlag    = 0x40 // set with leafPageFlag
 )
 
 const (
-	bucketLeafFlag = 0x01
+	bucketLeafFlag    = 0x01
+	tombstoneLeafFlag = 0x02
+	expiringLeafFlag  = 0x04 // value is prefixed with its expiry time
+
+	// expiredLeafFlag is never stored. Cursors set it on expiring elements
+	// whose expiry time has passed.
+	expiredLeafFlag = 0x08
+
+	// hiddenLeafFlags marks elements that readers treat as missing.
+	hiddenLeafFlags = tombstoneLeafFlag | expiredLeafFlag
 )
 
 type pgid uint64
@@ -65,6 +129,53 @@ func (p *page) leafPageElements() []leafPageElement {
 	return ((*[0x7FFFFFF]leafPageElement)(unsafe.Pointer(&p.ptr)))[:]
 }
 
+// packedValueSize returns the size of every value on a packed leaf page.
+func (p *page) packedValueSize() uint32 {
+	return *(*uint32)(unsafe.Pointer(&p.ptr))
+}
+
+// packedLeafPageElement retrieves the packed leaf node by index.
+func (p *page) packedLeafPageElement(index uint16) *packedLeafPageElement {
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(&p.ptr))
+	return &((*[0x7FFFFFF]packedLeafPageElement)(unsafe.Pointer(&buf[packedLeafHeaderSize])))[index]
+}
+
+// leafKey returns the key of a leaf element in either layout.
+func (p *page) leafKey(index uint16) []byte {
+	if (p.flags & packedPageFlag) != 0 {
+		return p.packedLeafPageElement(index).key()
+	}
+	return p.leafPageElement(index).key()
+}
+
+// leafElement returns the key, value and flags of a leaf element in either
+// layout. Elements of packed pages never have flags.
+func (p *page) leafElement(index uint16) (key, value []byte, flags uint32) {
+	if (p.flags & packedPageFlag) != 0 {
+		elem := p.packedLeafPageElement(index)
+		return elem.key(), elem.value(p.packedValueSize()), 0
+	}
+	elem := p.leafPageElement(index)
+	return elem.key(), elem.value(), elem.flags
+}
+
+// leafInuse returns the number of bytes used by a leaf page, excluding the
+// page header.
+func (p *page) leafInuse() int {
+	if p.count == 0 {
+		return 0
+	}
+
+	// The position of the last element's key/value equals the total size of
+	// the element headers and of all previous keys and values.
+	if (p.flags & packedPageFlag) != 0 {
+		last := p.packedLeafPageElement(p.count - 1)
+		return packedLeafHeaderSize + packedLeafPageElementSize*int(p.count-1) + int(last.pos+last.ksize+p.packedValueSize())
+	}
+	last := p.leafPageElement(p.count - 1)
+	return leafPageElementSize*int(p.count-1) + int(last.pos+last.ksize+last.vsize)
+}
+
 // branchPageElement retrieves the branch node by index
 func (p *page) branchPageElement(index uint16) *branchPageElement {
 	return &((*[0x7FFFFFF]branchPageElement)(unsafe.Pointer(&p.ptr)))[index]
@@ -120,12 +231,98 @@ func (n *leafPageElement) value() []byte {
 	return (*[maxAllocSize]byte)(unsafe.Pointer(&buf[n.pos+n.ksize]))[:n.vsize:n.vsize]
 }
 
+// packedLeafPageElement represents a node on a packed leaf page. The value
+// follows the key and has the size stored at the start of the page.
+type packedLeafPageElement struct {
+	pos   uint32
+	ksize uint32
+}
+
+// key returns a byte slice of the node key.
+func (n *packedLeafPageElement) key() []byte {
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(n))
+	return (*[maxAllocSize]byte)(unsafe.Pointer(&buf[n.pos]))[:n.ksize:n.ksize]
+}
+
+// value returns a byte slice of the node value.
+func (n *packedLeafPageElement) value(vsize uint32) []byte {
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(n))
+	return (*[maxAllocSize]byte)(unsafe.Pointer(&buf[n.pos+n.ksize]))[:vsize:vsize]
+}
+
 // PageInfo represents human readable information about a page.
 type PageInfo struct {
 	ID            int
 	Type          string
 	Count         int
 	OverflowCount int
+
+	// Directory is true if the page belongs to the bucket directory, the
+	// B+tree that maps top-level bucket names to their bucket headers.
+	Directory bool
+}
+
+// TreePage is a read-only view of a branch or leaf page passed to
+// Tx.ForEachPage. It is only valid for the duration of the callback.
+type TreePage struct {
+	PageInfo
+
+	// Bucket is the path of the bucket that owns the page. It is empty for
+	// pages of the bucket directory.
+	Bucket [][]byte
+
+	// Depth is the depth of the page within its bucket. The bucket's root
+	// page has a depth of 0.
+	Depth int
+
+	// Inline is true if the page is stored inside its bucket's header
+	// instead of on its own page. The ID of an inline page is 0.
+	Inline bool
+
+	p *page
+}
+
+// PageElement is a single element of a TreePage.
+type PageElement struct {
+	Key []byte
+
+	// Value is the value of a leaf element. It is nil for branch elements.
+	Value []byte
+
+	// Child is the id of the page referenced by a branch element.
+	Child int
+
+	// Bucket is true if the leaf element holds a nested bucket header.
+	Bucket bool
+}
+
+// Element returns the element at index i. Returns an error if i is not
+// between 0 and Count-1. The returned slices point directly into the
+// database and must not be modified.
+func (p *TreePage) Element(i int) (PageElement, error) {
+	if i < 0 || i >= int(p.p.count) {
+		return PageElement{}, fmt.Errorf("element index out of range: %d of %d", i, p.p.count)
+	}
+	if (p.p.flags & branchPageFlag) != 0 {
+		e := p.p.branchPageElement(uint16(i))
+		return PageElement{Key: e.key(), Child: int(e.pgid)}, nil
+	}
+	k, v, flags := p.p.leafElement(uint16(i))
+	return PageElement{Key: k, Value: v, Bucket: (flags & bucketLeafFlag) != 0}, nil
+}
+
+// Used returns the number of bytes used by the page header, element headers,
+// keys and values. Comparing it with the allocated size of the page shows
+// how full the page is.
+func (p *TreePage) Used() int {
+	if (p.p.flags & branchPageFlag) == 0 {
+		return pageHeaderSize + p.p.leafInuse()
+	}
+	n := pageHeaderSize
+	for i := uint16(0); i < p.p.count; i++ {
+		n += branchPageElementSize + int(p.p.branchPageElement(i).ksize)
+	}
+	return n
 }
 
 type pgids []pgid
@@ -70,3 +70,33 @@ func TestPgids_merge_quick(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// Ensure that the maximum inline value size fills exactly one page.
+func TestMaxInlineValueSize(t *testing.T) {
+	if n := MaxInlineValueSize(4096, 10); n != 4096-PageHeaderSize-LeafElementSize-10 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+	if n := MaxInlineValueSize(4096, 5000); n != 0 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+}
+
+// Ensure that page estimates account for splits and overflow.
+func TestEstimatePages(t *testing.T) {
+	if n := EstimatePages(4096, 8, 8, 0); n != 0 {
+		t.Fatalf("unexpected pages: %d", n)
+	}
+	if n := EstimatePages(4096, 8, 8, 10); n != 1 {
+		t.Fatalf("unexpected pages: %d", n)
+	}
+
+	// Small elements split at half a page: (2048-16)/32 = 63 per page.
+	if n := EstimatePages(4096, 8, 8, 630); n != 10 {
+		t.Fatalf("unexpected pages: %d", n)
+	}
+
+	// Large values are stored two per split page and need overflow pages.
+	if n := EstimatePages(4096, 8, 5000, 4); n != 6 {
+		t.Fatalf("unexpected pages: %d", n)
+	}
+}
@@ -0,0 +1,47 @@
+package bolt
+
+// RecoveryInfo describes the state the database was recovered to when it was
+// opened.
+type RecoveryInfo struct {
+	Meta            int  // index of the meta page in use, 0 or 1
+	Txid            int  // transaction id of the meta page in use
+	Fallback        bool // the newest meta page was invalid or truncated and the previous one is in use
+	FreelistRebuilt bool // no freelist was stored and it was rebuilt from the reachable pages
+	RecoveredPageN  int  // number of free pages found when the freelist was rebuilt
+}
+
+// Recovered returns true if the database was not closed cleanly and had to be
+// recovered when it was opened.
+//
+// A rebuilt freelist is not a recovery. Bolt always stores the freelist, and
+// files without one are written by bbolt with NoFreelistSync even when they
+// are closed cleanly.
+func (r RecoveryInfo) Recovered() bool {
+	return r.Fallback
+}
+
+// RecoveryInfo returns how the database was recovered when it was opened, so
+// that a crash recovery can be reported.
+func (db *DB) RecoveryInfo() RecoveryInfo {
+	return db.recovery
+}
+
+// recoveryInfo returns the recovery state of the meta page and freelist that
+// were loaded by open.
+func (db *DB) recoveryInfo() RecoveryInfo {
+	m := db.meta()
+	newest := db.meta0.txid
+	if db.meta1.txid > newest {
+		newest = db.meta1.txid
+	}
+
+	r := RecoveryInfo{Txid: int(m.txid), Fallback: m.txid != newest}
+	if m.txid != db.meta0.txid {
+		r.Meta = 1
+	}
+	if m.freelist == pgidNoFreelist {
+		r.FreelistRebuilt = true
+		r.RecoveredPageN = db.freelist.free_count()
+	}
+	return r
+}
@@ -0,0 +1,152 @@
+package bolt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"unsafe"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that the recovery info reports a fallback to the previous meta page.
+func TestDB_RecoveryInfo(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	path := db.Path()
+
+	if r := db.RecoveryInfo(); r.Recovered() || r.Meta != 1 || r.Txid != 1 {
+		t.Fatalf("unexpected recovery info: %+v", r)
+	}
+
+	// The first commit is written to meta page 0.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the checksum of the newest meta page.
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, pageHeaderSize+int(unsafe.Sizeof(meta{})))
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	m := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
+	m.checksum++
+	if _, err := f.WriteAt(buf, 0); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	} else if r := db.RecoveryInfo(); !r.Recovered() || !r.Fallback || r.Meta != 1 || r.Txid != 1 || r.FreelistRebuilt {
+		t.Fatalf("unexpected recovery info: %+v", r)
+	}
+}
+
+// Ensure that a truncated file rolled back to the previous meta page is
+// reported as recovered.
+func TestDB_RecoveryInfo_Truncated(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	db := MustOpenDB()
+	path := db.Path()
+	defer os.Remove(path)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), make([]byte, 4*pageSize))
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the file at the high water mark of the previous transaction.
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
+	m1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
+	prev := m0.pgid
+	if m1.pgid < prev {
+		prev = m1.pgid
+	}
+	if err := os.Truncate(path, int64(prev*pageSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	db2, err := bolt.Open(path, 0666, &bolt.Options{RecoverTruncated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	if r := db2.RecoveryInfo(); !r.Recovered() || !r.Fallback || r.FreelistRebuilt {
+		t.Fatalf("unexpected recovery info: %+v", r)
+	}
+}
+
+// Ensure that a file without a stored freelist, as written by bbolt with
+// NoFreelistSync on a clean close, reports the rebuilt freelist without
+// being treated as recovered.
+func TestDB_RecoveryInfo_NoFreelist(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	path := db.Path()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}); err != nil {
+		t.Fatal(err)
+	} else if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite both meta pages without a freelist.
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int{0, pageSize} {
+		m := (*meta)(unsafe.Pointer(&buf[off+pageHeaderSize]))
+		m.freelist = ^uint64(0)
+		m.checksum = 0
+	}
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	} else if r := db.RecoveryInfo(); r.Recovered() || r.Fallback || !r.FreelistRebuilt || r.RecoveredPageN == 0 {
+		t.Fatalf("unexpected recovery info: %+v", r)
+	}
+}
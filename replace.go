@@ -0,0 +1,104 @@
+package bolt
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// ReplaceWith atomically replaces the database file with the bolt file at
+// path, such as an index rebuilt offline, and reopens the database on it.
+// The new file is validated and synced first, then renamed over the current
+// file once open transactions have finished, so a crash leaves either the
+// old or the new file in place. New transactions block until the database
+// has been reopened. Per-bucket settings and attached files are kept.
+//
+// The file at path must be on the same filesystem as the database and must
+// not be open elsewhere. If the new file cannot be renamed into place then
+// the old file is reopened and the error is returned.
+func (db *DB) ReplaceWith(path string) error {
+	if db.inmem || db.ephemeral {
+		return ErrReopenNotSupported
+	} else if db.IsReadOnly() {
+		return ErrDatabaseReadOnly
+	}
+
+	// Make sure the new file is a valid database and is on disk.
+	options := &Options{ReadOnly: true}
+	if db.openOptions != nil {
+		options.Timeout = db.openOptions.Timeout
+	}
+	ndb, err := Open(path, 0600, options)
+	if err != nil {
+		return err
+	}
+	err = ndb.file.Sync()
+	if cerr := ndb.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+
+	// Wait for read-only transactions to finish before unmapping.
+	db.mmaplock.Lock()
+	if !db.opened {
+		db.mmaplock.Unlock()
+		return ErrDatabaseNotOpen
+	}
+
+	// Keep attached files open across the close.
+	db.attachlock.Lock()
+	attached := db.attached
+	db.attached = nil
+	db.attachlock.Unlock()
+
+	dbpath, mode, openOptions := db.openPath, db.openMode, db.openOptions
+	err = db.close()
+	db.mmaplock.Unlock()
+	if err == nil {
+		err = os.Rename(path, dbpath)
+	}
+	if err == nil {
+		// Bloom filters saved for the old file do not describe the new one.
+		_ = os.Remove(dbpath + ".bloom")
+		err = syncDir(dbpath)
+	}
+
+	// Reopen the database, which is the old file if the rename failed.
+	if oerr := db.open(dbpath, mode, openOptions); err == nil {
+		err = oerr
+	}
+	if !db.opened {
+		for _, adb := range attached {
+			_ = adb.Close()
+		}
+		return err
+	}
+	db.attachlock.Lock()
+	db.attached = attached
+	db.attachlock.Unlock()
+	return err
+}
+
+// syncDir syncs the directory containing path so that a rename is durable.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
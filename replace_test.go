@@ -0,0 +1,126 @@
+package bolt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a database can be replaced with another file while it is read.
+func TestDB_ReplaceWith(t *testing.T) {
+	put := func(db *bolt.DB, value string) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), []byte(value))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Build the replacement in a separate file.
+	path := tempfile()
+	defer os.Remove(path)
+	ndb, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(ndb, "new")
+	if err := ndb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := tempfile()
+	defer os.Remove(archive)
+	adb, err := bolt.Open(archive, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := adb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	put(db.DB, "old")
+	if err := db.Attach("archive", archive); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers see either the old or the new value throughout the swap.
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				if err := db.View(func(tx *bolt.Tx) error {
+					if v := string(tx.Bucket([]byte("widgets")).Get([]byte("foo"))); v != "old" && v != "new" {
+						t.Errorf("unexpected value: %q", v)
+					}
+					return nil
+				}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	if err := db.ReplaceWith(path); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); string(v) != "new" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if tx.Attached("archive") == nil {
+			t.Fatal("expected attachment to be kept")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected replacement to be moved: %v", err)
+	}
+
+	// The replacement is writable.
+	put(db.DB, "newer")
+	db.MustCheck()
+}
+
+// Ensure that an invalid replacement leaves the database unchanged.
+func TestDB_ReplaceWith_Invalid(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := tempfile()
+	defer os.Remove(path)
+	if err := ioutil.WriteFile(path, make([]byte, 16384), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceWith(path); err != bolt.ErrInvalid {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
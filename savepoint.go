@@ -0,0 +1,220 @@
+package bolt
+
+// Savepoint represents the state of a writable transaction at a point in
+// time. Rolling back to a savepoint discards every change made to the
+// transaction after the savepoint was created while keeping earlier changes.
+type Savepoint struct {
+	tx       *Tx
+	root     *bucketState
+	pending  int // number of pages freed by the transaction
+	handlers int // number of commit handlers
+	audit    int // number of audit records
+}
+
+// bucketState holds a copy of a bucket and its materialized nodes.
+type bucketState struct {
+	b        *Bucket
+	name     []byte
+	header   bucket
+	page     *page
+	totals   bucketTotals
+	rootNode *node
+	nodes    map[pgid]*node
+	buckets  map[string]*bucketState
+}
+
+// Savepoint records the current state of the transaction so that later
+// changes can be discarded with RollbackTo. Savepoints can be nested and
+// a savepoint can be rolled back to multiple times.
+//
+// Buckets and cursors obtained before rolling back remain valid for buckets
+// that existed when the savepoint was created, however, cursors must be
+// repositioned after rolling back. Buckets created after the savepoint are
+// closed by rolling back, like the buckets of a closed transaction, and
+// writes to them return ErrTxClosed.
+func (tx *Tx) Savepoint() (*Savepoint, error) {
+	if tx.db == nil {
+		return nil, ErrTxClosed
+	} else if !tx.writable {
+		return nil, ErrTxNotWritable
+	}
+
+	return &Savepoint{
+		tx:       tx,
+		root:     saveBucket(&tx.root),
+		pending:  len(tx.db.freelist.pending[tx.meta.txid]),
+		handlers: len(tx.commitHandlers),
+		audit:    len(tx.audit),
+	}, nil
+}
+
+// RollbackTo discards all changes made to the transaction since sp was created.
+func (tx *Tx) RollbackTo(sp *Savepoint) error {
+	if tx.db == nil {
+		return ErrTxClosed
+	} else if !tx.writable {
+		return ErrTxNotWritable
+	} else if sp == nil || sp.tx != tx {
+		return ErrInvalidSavepoint
+	}
+
+	// Release pages freed after the savepoint.
+	f := tx.db.freelist
+	if ids := f.pending[tx.meta.txid]; len(ids) > sp.pending {
+		for _, id := range ids[sp.pending:] {
+			delete(f.cache, id)
+		}
+		if sp.pending == 0 {
+			delete(f.pending, tx.meta.txid)
+		} else {
+			f.pending[tx.meta.txid] = ids[:sp.pending]
+		}
+	}
+
+	tx.commitHandlers = tx.commitHandlers[:sp.handlers]
+	tx.audit = tx.audit[:sp.audit]
+	open := openBuckets(&tx.root)
+	sp.root.restore()
+	reattach(open)
+	return nil
+}
+
+// openBuckets returns the sub-buckets cached by b and its sub-buckets.
+// Parents are returned before their children.
+func openBuckets(b *Bucket) []*Bucket {
+	var a []*Bucket
+	queue := []*Bucket{b}
+	for len(queue) > 0 {
+		b, queue = queue[0], queue[1:]
+		for _, child := range b.buckets {
+			a = append(a, child)
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// reattach restores buckets that were opened after a savepoint and are no
+// longer cached once it is rolled back. Buckets that still exist are reopened
+// from their parent so that the handles remain valid. The others are closed
+// so that writes to them fail instead of being lost.
+func reattach(open []*Bucket) {
+	attached := make(map[*Bucket]bool)
+	for _, b := range open {
+		p := b.parent
+		if p.buckets[string(b.name)] == b {
+			attached[b] = true
+			continue
+		}
+
+		// The parent must be attached and must not cache another handle.
+		if p == &p.tx.root || attached[p] {
+			if _, ok := p.buckets[string(b.name)]; !ok {
+				if child := p.child(b.name); child != nil {
+					fillPercent, rebalancePercent := b.FillPercent, b.RebalancePercent
+					*b = *child
+					b.FillPercent, b.RebalancePercent = fillPercent, rebalancePercent
+					p.buckets[string(b.name)] = b
+					attached[b] = true
+					continue
+				}
+			}
+		}
+		b.close()
+	}
+}
+
+// close detaches a bucket from its transaction so that writes to it return
+// ErrTxClosed.
+func (b *Bucket) close() {
+	b.tx = &Tx{}
+	b.page = nil
+	b.rootNode = nil
+	b.nodes = nil
+	b.buckets = nil
+}
+
+// saveBucket recursively copies the state of b and its cached sub-buckets.
+func saveBucket(b *Bucket) *bucketState {
+	s := &bucketState{
+		b:       b,
+		name:    b.name,
+		header:  *b.bucket,
+		page:    b.page,
+		totals:  b.totals,
+		buckets: make(map[string]*bucketState, len(b.buckets)),
+	}
+	s.rootNode, s.nodes = cloneNodes(b.rootNode, b.nodes)
+	for name, child := range b.buckets {
+		s.buckets[name] = saveBucket(child)
+	}
+	return s
+}
+
+// restore resets the saved bucket, and its sub-buckets, to the saved state.
+// The saved nodes are copied again so the state can be restored repeatedly.
+func (s *bucketState) restore() {
+	b := s.b
+	b.name = s.name
+	*b.bucket = s.header
+	b.page = s.page
+	b.totals = s.totals
+	b.rootNode, b.nodes = cloneNodes(s.rootNode, s.nodes)
+	b.buckets = make(map[string]*Bucket, len(s.buckets))
+	for name, child := range s.buckets {
+		child.restore()
+		b.buckets[name] = child.b
+	}
+}
+
+// cloneNodes returns a deep copy of a bucket's root node and node cache.
+// Parent and child references are remapped to the copies. Keys and values
+// are shared since they are never modified in place.
+func cloneNodes(root *node, nodes map[pgid]*node) (*node, map[pgid]*node) {
+	if nodes == nil {
+		return cloneNode(root, nil), nil
+	}
+
+	memo := make(map[*node]*node, len(nodes))
+	other := make(map[pgid]*node, len(nodes))
+	for id, n := range nodes {
+		other[id] = cloneNode(n, memo)
+	}
+	return cloneNode(root, memo), other
+}
+
+// cloneNode copies n along with its parents and children, using memo to
+// ensure that each node is copied once.
+func cloneNode(n *node, memo map[*node]*node) *node {
+	if n == nil {
+		return nil
+	} else if memo == nil {
+		memo = make(map[*node]*node)
+	}
+	if clone, ok := memo[n]; ok {
+		return clone
+	}
+
+	clone := &node{
+		bucket:     n.bucket,
+		isLeaf:     n.isLeaf,
+		unbalanced: n.unbalanced,
+		spilled:    n.spilled,
+		key:        n.key,
+		pgid:       n.pgid,
+	}
+	memo[n] = clone
+
+	clone.parent = cloneNode(n.parent, memo)
+	if n.children != nil {
+		clone.children = make(nodes, len(n.children))
+		for i, child := range n.children {
+			clone.children[i] = cloneNode(child, memo)
+		}
+	}
+	if n.inodes != nil {
+		clone.inodes = make(inodes, len(n.inodes))
+		copy(clone.inodes, n.inodes)
+	}
+	return clone
+}
@@ -0,0 +1,119 @@
+package bolt
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// snapshot is a committed state of the database retained by RetainSnapshot.
+type snapshot struct {
+	meta *meta
+	refs int
+}
+
+// RetainSnapshot keeps the latest committed state of the database readable
+// with BeginAt until it is released with ReleaseSnapshot, and returns the id
+// of the transaction that committed it. Snapshots can be retained more than
+// once and must be released once for each call.
+//
+// Pages that belong to a retained snapshot are not reused, so the database
+// file grows while old snapshots are held. Unlike an open read-only
+// transaction, a retained snapshot does not prevent the mmap from growing.
+func (db *DB) RetainSnapshot() (int, error) {
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	if !db.opened {
+		return 0, ErrDatabaseNotOpen
+	}
+
+	m := db.meta()
+	if s := db.snapshots[m.txid]; s != nil {
+		s.refs++
+		return int(m.txid), nil
+	}
+
+	s := &snapshot{meta: &meta{}, refs: 1}
+	m.copy(s.meta)
+	if db.snapshots == nil {
+		db.snapshots = make(map[txid]*snapshot)
+	}
+	db.snapshots[m.txid] = s
+	return int(m.txid), nil
+}
+
+// ReleaseSnapshot releases a snapshot retained with RetainSnapshot. Its pages
+// are reclaimed once it has been released as many times as it was retained
+// and no transactions started with BeginAt are still open.
+// Returns ErrSnapshotNotFound if the snapshot is not retained.
+func (db *DB) ReleaseSnapshot(id int) error {
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	s := db.snapshots[txid(id)]
+	if s == nil {
+		return ErrSnapshotNotFound
+	}
+	if s.refs--; s.refs == 0 {
+		delete(db.snapshots, txid(id))
+	}
+	return nil
+}
+
+// Snapshots returns the ids of the retained snapshots in ascending order.
+func (db *DB) Snapshots() []int {
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	ids := make([]int, 0, len(db.snapshots))
+	for id := range db.snapshots {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// BeginAt starts a read-only transaction that sees the database as of the
+// retained snapshot with the given transaction id. The transaction can be
+// used with WriteTo, Backup or Export to copy the database as it was at that
+// point. Returns ErrSnapshotNotFound if the snapshot is not retained.
+//
+// The transaction must be rolled back when it is no longer needed, like any
+// transaction started with Begin.
+func (db *DB) BeginAt(id int) (*Tx, error) {
+	// Reject new transactions while the database is closing.
+	if atomic.LoadInt32(&db.closing) == 1 {
+		return nil, ErrDatabaseNotOpen
+	}
+
+	// Follow the same locking order as beginTx.
+	db.metalock.Lock()
+	db.mmaplock.RLock()
+
+	if !db.opened {
+		db.mmaplock.RUnlock()
+		db.metalock.Unlock()
+		return nil, ErrDatabaseNotOpen
+	} else if db.fileErr != nil {
+		db.mmaplock.RUnlock()
+		db.metalock.Unlock()
+		return nil, db.fileErr
+	}
+
+	s := db.snapshots[txid(id)]
+	if s == nil {
+		db.mmaplock.RUnlock()
+		db.metalock.Unlock()
+		return nil, ErrSnapshotNotFound
+	}
+
+	t := &Tx{lease: &mmapLease{refs: 1}}
+	t.initMeta(db, s.meta)
+	db.txs = append(db.txs, t)
+	n := len(db.txs)
+	db.metalock.Unlock()
+
+	db.statlock.Lock()
+	db.stats.TxN++
+	db.stats.OpenTxN = n
+	db.statlock.Unlock()
+
+	return t, nil
+}
@@ -0,0 +1,85 @@
+package bolt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a retained snapshot can be read and copied after later commits.
+func TestDB_BeginAt(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("v1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := db.RetainSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite the value many times so that freed pages would be reused.
+	for i := 0; i < 100; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			if err := b.Put([]byte("foo"), []byte("v2")); err != nil {
+				return err
+			}
+			return b.Put(u64tob(uint64(i)), make([]byte, 1000))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.BeginAt(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if tx.ID() != id {
+		t.Fatalf("unexpected id: %d", tx.ID())
+	} else if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("v1")) {
+		t.Fatalf("unexpected value: %q", v)
+	}
+
+	// Copy the snapshot and check the copy.
+	path := tempfile()
+	if err := tx.CopyFile(path, 0600); err != nil {
+		t.Fatal(err)
+	} else if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	cdb, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := &DB{cdb}
+	defer copied.MustClose()
+	if err := copied.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("v1")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if n := b.Stats().KeyN; n != 1 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := db.Snapshots(); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("unexpected snapshots: %v", ids)
+	} else if err := db.ReleaseSnapshot(id); err != nil {
+		t.Fatal(err)
+	} else if err := db.ReleaseSnapshot(id); err != bolt.ErrSnapshotNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := db.BeginAt(id); err != bolt.ErrSnapshotNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
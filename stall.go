@@ -0,0 +1,67 @@
+package bolt
+
+import "time"
+
+// StallReason identifies what a stalled writer is waiting for.
+type StallReason int
+
+const (
+	// StallWriterLock waits for another write transaction to finish.
+	StallWriterLock StallReason = iota
+
+	// StallRemap waits for read transactions to finish so the mmap can
+	// grow, and for the remap itself.
+	StallRemap
+
+	// StallGrow waits for the data file to be extended because the freelist
+	// had no room for the pages written by a commit.
+	StallGrow
+
+	// StallSync waits for an fdatasync() of the data file.
+	StallSync
+)
+
+// String returns the name of the reason.
+func (r StallReason) String() string {
+	switch r {
+	case StallWriterLock:
+		return "writer-lock"
+	case StallRemap:
+		return "remap"
+	case StallGrow:
+		return "grow"
+	case StallSync:
+		return "sync"
+	default:
+		return "unknown"
+	}
+}
+
+// Stall describes a writer that has been blocked for longer than
+// DB.StallThreshold.
+type Stall struct {
+	// Reason is what the writer is waiting for.
+	Reason StallReason
+
+	// Start is when the writer started waiting.
+	Start time.Time
+}
+
+// watchStall starts timing a wait for reason and returns a function that
+// ends it. OnStall is called if the wait exceeds StallThreshold.
+func (db *DB) watchStall(reason StallReason) (stop func()) {
+	fn, threshold := db.OnStall, db.StallThreshold
+	if fn == nil || threshold <= 0 {
+		return func() {}
+	}
+	s := Stall{Reason: reason, Start: time.Now()}
+	t := time.AfterFunc(threshold, func() { fn(s) })
+	return func() { t.Stop() }
+}
+
+// sync executes fdatasync() against the data file while watching for stalls.
+func (db *DB) sync() error {
+	stop := db.watchStall(StallSync)
+	defer stop()
+	return db.ops.fdatasync(db)
+}
@@ -0,0 +1,83 @@
+package bolt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that OnStall is called while a writer is blocked.
+func TestDB_OnStall(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	stalls := make(chan bolt.Stall, 16)
+	db.StallThreshold = 50 * time.Millisecond
+	db.OnStall = func(s bolt.Stall) { stalls <- s }
+
+	// Slow syncs on the test machine are ignored unless they are expected.
+	expect := func(reason bolt.StallReason) {
+		for {
+			select {
+			case s := <-stalls:
+				if s.Reason == bolt.StallSync && reason != bolt.StallSync {
+					continue
+				} else if s.Reason != reason {
+					t.Fatalf("unexpected reason: %s", s.Reason)
+				} else if d := time.Since(s.Start); d < db.StallThreshold {
+					t.Fatalf("stall reported too early: %s", d)
+				}
+				return
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected %s stall", reason)
+			}
+		}
+	}
+	update := func(fn func(tx *bolt.Tx) error) chan error {
+		ch := make(chan error, 1)
+		go func() { ch <- db.Update(fn) }()
+		return ch
+	}
+
+	// A writer waiting for another write transaction.
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	expect(bolt.StallWriterLock)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer growing the mmap while a read transaction is open.
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), make([]byte, 1<<20))
+	})
+	expect(bolt.StallRemap)
+	if err := rtx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow sync.
+	db.SetFaults(&bolt.Faults{SyncLatency: 200 * time.Millisecond})
+	defer db.SetFaults(nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("bar"), []byte("baz"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect(bolt.StallSync)
+}
@@ -0,0 +1,245 @@
+// Package storagetest provides a conformance suite for Bolt databases.
+//
+// The suite asserts the ordering, snapshot isolation and durability
+// guarantees that applications rely on. Forks and alternative backends can
+// run it against their own open function to verify that they still meet
+// the contract:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(path string) (*bolt.DB, error) {
+//			return bolt.Open(path, 0600, nil)
+//		})
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// OpenFunc opens the database at path, creating it if it does not exist.
+// It is called again with the same path to verify durability.
+type OpenFunc func(path string) (*bolt.DB, error)
+
+// Run executes the conformance suite as subtests of t.
+func Run(t *testing.T, open OpenFunc) {
+	t.Run("Ordering", func(t *testing.T) { testOrdering(t, open) })
+	t.Run("SnapshotIsolation", func(t *testing.T) { testSnapshotIsolation(t, open) })
+	t.Run("Durability", func(t *testing.T) { testDurability(t, open) })
+}
+
+// testOrdering verifies that keys and buckets are iterated in byte order in
+// both directions regardless of insertion order and across commits.
+func testOrdering(t *testing.T, open OpenFunc) {
+	db, path := mustOpen(t, open)
+	defer mustClose(t, db, path)
+
+	// Insert keys in random order across several transactions.
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		binary.BigEndian.PutUint64(keys[i], uint64(i))
+	}
+	for _, i := range rand.New(rand.NewSource(0)).Perm(len(keys)) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("keys"))
+			if err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucketIfNotExists(keys[i][6:]); err != nil {
+				return err
+			}
+			return b.Put(keys[i], keys[i])
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		// Iterate forward.
+		c := tx.Bucket([]byte("keys")).Cursor()
+		i := 0
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !bytes.Equal(k, keys[i]) || !bytes.Equal(v, keys[i]) {
+				t.Fatalf("unexpected key at %d: %x", i, k)
+			}
+			i++
+		}
+		if i != len(keys) {
+			t.Fatalf("unexpected key count: %d", i)
+		}
+
+		// Iterate backward.
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			i--
+			if !bytes.Equal(k, keys[i]) {
+				t.Fatalf("unexpected key at %d: %x", i, k)
+			}
+		}
+
+		// Ensure bucket names are ordered too.
+		var names [][]byte
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !sort.SliceIsSorted(names, func(i, j int) bool { return bytes.Compare(names[i], names[j]) < 0 }) {
+			t.Fatal("bucket names not sorted")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testSnapshotIsolation verifies that a read transaction does not observe
+// writes committed after it began.
+func testSnapshotIsolation(t *testing.T, open OpenFunc) {
+	db, path := mustOpen(t, open)
+	defer mustClose(t, db, path)
+
+	// An open read transaction prevents the data file from being remapped
+	// so write and delete a large value to leave free pages for the writes
+	// made while the reader is open.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("padding"), make([]byte, 1<<20))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Delete([]byte("padding"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rtx.Rollback() }()
+
+	// Modify, add and delete data after the reader has started.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Put([]byte("foo"), []byte("2")); err != nil {
+			return err
+		} else if err := b.Put([]byte("bar"), []byte("3")); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte("gadgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := rtx.Bucket([]byte("widgets"))
+	if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("1")) {
+		t.Fatalf("reader observed committed write: %q", v)
+	} else if v := b.Get([]byte("bar")); v != nil {
+		t.Fatalf("reader observed committed insert: %q", v)
+	} else if rtx.Bucket([]byte("gadgets")) != nil {
+		t.Fatal("reader observed committed bucket")
+	}
+
+	// A new reader observes the committed state.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("2")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testDurability verifies that committed data survives reopening the
+// database and that rolled back data does not.
+func testDurability(t *testing.T, open OpenFunc) {
+	db, path := mustOpen(t, open)
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("committed"), []byte("yes"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Bucket([]byte("widgets")).Put([]byte("rolledback"), []byte("yes")); err != nil {
+		t.Fatal(err)
+	} else if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the database.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mustClose(t, db, path)
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b == nil {
+			t.Fatal("committed bucket lost")
+		} else if v := b.Get([]byte("committed")); !bytes.Equal(v, []byte("yes")) {
+			t.Fatalf("committed value lost: %q", v)
+		} else if v := b.Get([]byte("rolledback")); v != nil {
+			t.Fatalf("rolled back value persisted: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustOpen opens a database in a new temporary directory.
+func mustOpen(t *testing.T, open OpenFunc) (*bolt.DB, string) {
+	dir, err := ioutil.TempDir("", "bolt-storagetest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "db")
+	db, err := open(path)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+// mustClose closes the database and removes its temporary directory.
+func mustClose(t *testing.T, db *bolt.DB, path string) {
+	defer func() { _ = os.RemoveAll(filepath.Dir(path)) }()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
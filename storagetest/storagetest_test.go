@@ -0,0 +1,15 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/storagetest"
+)
+
+// Ensure that the default database passes the conformance suite.
+func TestRun(t *testing.T) {
+	storagetest.Run(t, func(path string) (*bolt.DB, error) {
+		return bolt.Open(path, 0600, nil)
+	})
+}
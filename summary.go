@@ -0,0 +1,150 @@
+package bolt
+
+import (
+	"encoding/binary"
+)
+
+// bucketTotalsSize is the size of the key count, data size and nested bucket
+// count stored after a bucket's header, and its inline page for inline
+// buckets.
+//
+// The totals are a trailer rather than header fields so that files stay
+// readable by other bolt implementations, which ignore them. Buckets written
+// without a trailer are counted by walking their pages the first time their
+// totals are needed, and the totals are stored when the bucket is next
+// written.
+const bucketTotalsSize = 24
+
+// bucketTotals holds the key count, data size and nested bucket count of a
+// bucket.
+type bucketTotals struct {
+	keyN    int  // number of keys
+	size    int  // bytes of keys and values
+	bucketN int  // number of nested buckets
+	counted bool // keyN, size and bucketN are known
+}
+
+// BucketSummary represents statistics about a bucket that are stored with it
+// and are read without walking its pages.
+type BucketSummary struct {
+	Name     []byte // name of the bucket
+	Root     int    // page id of the root page, or zero for inline buckets
+	Sequence uint64 // last value returned by NextSequence
+	KeyN     int    // number of keys, as returned by Count
+	DataSize int    // bytes of keys and values, excluding those of nested buckets
+}
+
+// Count returns the number of keys in the bucket, including nested buckets,
+// tombstones and expired keys that have not been removed, like
+// BucketStats.KeyN. Keys of nested buckets are not included.
+//
+// The count is stored with the bucket and kept up to date by every write, so
+// Count does not read the bucket's pages. Buckets written by an older version
+// are counted once by walking their pages.
+func (b *Bucket) Count() int {
+	return b.loadTotals().keyN
+}
+
+// Summary returns the stored statistics of the bucket. Like Count, it only
+// walks the bucket's pages if they were written by an older version.
+func (b *Bucket) Summary() BucketSummary {
+	t := b.loadTotals()
+	return BucketSummary{
+		Name:     cloneBytes(b.name),
+		Root:     int(b.root),
+		Sequence: b.bucket.sequence,
+		KeyN:     t.keyN,
+		DataSize: t.size,
+	}
+}
+
+// BucketSummaries returns the stored statistics of the top-level buckets
+// whose names begin with prefix, in key order. The after and limit arguments
+// page through the buckets like Buckets.
+func (tx *Tx) BucketSummaries(prefix, after []byte, limit int) []BucketSummary {
+	var summaries []BucketSummary
+	for _, name := range tx.Buckets(prefix, after, limit) {
+		if b := tx.root.child(name); b != nil {
+			summaries = append(summaries, b.Summary())
+		}
+	}
+	return summaries
+}
+
+// loadTotals returns the totals of the bucket, counting them if they were
+// not stored.
+func (b *Bucket) loadTotals() bucketTotals {
+	if !b.totals.counted {
+		b.totals = b.countTotals()
+
+		// Materialize the root node so that the totals are saved on commit.
+		if b.tx.writable && b != &b.tx.root && b.rootNode == nil {
+			_ = b.node(b.root, nil)
+		}
+	}
+	return b.totals
+}
+
+// countTotals returns the totals of the bucket by walking its pages and
+// nodes.
+func (b *Bucket) countTotals() bucketTotals {
+	t := bucketTotals{counted: true}
+	b._forEachPageNode(b.root, 0, func(p *page, n *node, _ int) {
+		if n != nil {
+			if n.isLeaf {
+				t.keyN += len(n.inodes)
+				for _, inode := range n.inodes {
+					t.size += len(inode.key) + len(inode.value)
+					if (inode.flags & bucketLeafFlag) != 0 {
+						t.bucketN++
+					}
+				}
+			}
+		} else if (p.flags & leafPageFlag) != 0 {
+			t.keyN += int(p.count)
+			for i := uint16(0); i < p.count; i++ {
+				k, v, flags := p.leafElement(i)
+				t.size += len(k) + len(v)
+				if (flags & bucketLeafFlag) != 0 {
+					t.bucketN++
+				}
+			}
+		}
+	})
+	return t
+}
+
+// readTotals sets the totals of a bucket opened from value, if the value has
+// a totals trailer.
+func (b *Bucket) readTotals(value []byte) {
+	size := bucketHeaderSize
+	if b.root == 0 {
+		size += inlinePageSize(b.page)
+	}
+	if len(value) == size+bucketTotalsSize {
+		b.totals = bucketTotals{
+			keyN:    int(binary.BigEndian.Uint64(value[size:])),
+			size:    int(binary.BigEndian.Uint64(value[size+8:])),
+			bucketN: int(binary.BigEndian.Uint64(value[size+16:])),
+			counted: true,
+		}
+	}
+}
+
+// appendTotals appends the totals trailer to a bucket value if the totals
+// are known.
+func (b *Bucket) appendTotals(value []byte) []byte {
+	if !b.totals.counted {
+		return value
+	}
+	var buf [bucketTotalsSize]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(b.totals.keyN))
+	binary.BigEndian.PutUint64(buf[8:], uint64(b.totals.size))
+	binary.BigEndian.PutUint64(buf[16:], uint64(b.totals.bucketN))
+	return append(value, buf[:]...)
+}
+
+// inlinePageSize returns the size of an inline leaf page.
+func inlinePageSize(p *page) int {
+	return pageHeaderSize + p.leafInuse()
+}
@@ -0,0 +1,196 @@
+package bolt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that the key count is kept up to date by writes and saved with the
+// bucket.
+func TestBucket_Count(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	count := func(n int) {
+		t.Helper()
+		if err := db.View(func(tx *bolt.Tx) error {
+			if c := tx.Bucket([]byte("widgets")).Count(); c != n {
+				t.Fatalf("unexpected count: %d, want %d", c, n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if n := b.Count(); n != 0 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+
+		// Overwriting a key does not change the count.
+		if err := b.Put(u64tob(0), nil); err != nil {
+			return err
+		}
+
+		// Nested buckets are keys, but their keys are not counted.
+		sub, err := b.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		} else if err := sub.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		} else if n := b.Count(); n != 1001 {
+			t.Fatalf("unexpected count: %d", n)
+		} else if n := sub.Count(); n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	count(1001)
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 500; i++ {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				return err
+			}
+		}
+
+		// Deleting a missing key does not change the count.
+		if err := b.Delete([]byte("zzz")); err != nil {
+			return err
+		}
+		return b.DeleteBucket([]byte("sub"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	count(500)
+
+	// Changes rolled back to a savepoint are not counted.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		sp, err := tx.Savepoint()
+		if err != nil {
+			return err
+		} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		} else if n := b.Count(); n != 501 {
+			t.Fatalf("unexpected count: %d", n)
+		} else if err := tx.RollbackTo(sp); err != nil {
+			return err
+		} else if n := b.Count(); n != 500 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The count is read back after reopening.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	count(500)
+
+	// The root bucket counts its buckets.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Cursor().Bucket().Count(); n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that tombstones are counted until they are purged.
+func TestBucket_Count_Tombstones(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetTombstones(true, []byte("widgets"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		} else if err := b.Delete([]byte("foo")); err != nil {
+			return err
+		} else if n := b.Count(); n != 1 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		if _, err := b.PurgeTombstones(time.Now().Add(time.Second)); err != nil {
+			return err
+		} else if n := b.Count(); n != 0 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that bucket summaries report the stored statistics of buckets.
+func TestDB_BucketSummaries(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetFixedValueSize(3, []byte("a/small"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a/big", "a/small", "b"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		b := tx.Bucket([]byte("a/big"))
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		if err := b.Put(u64tob(0), make([]byte, 10)); err != nil {
+			return err
+		} else if err := b.Delete(u64tob(1)); err != nil {
+			return err
+		} else if _, err := b.NextSequence(); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("a/small")).Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := db.BucketSummaries([]byte("a/"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(summaries) != 2 {
+		t.Fatalf("unexpected summaries: %d", len(summaries))
+	}
+
+	big, small := summaries[0], summaries[1]
+	if string(big.Name) != "a/big" || big.Root == 0 || big.Sequence != 1 {
+		t.Fatalf("unexpected summary: %+v", big)
+	} else if big.KeyN != 999 {
+		t.Fatalf("unexpected key count: %d", big.KeyN)
+	} else if big.DataSize != 999*8+998*100+10 {
+		t.Fatalf("unexpected data size: %d", big.DataSize)
+	}
+	if string(small.Name) != "a/small" || small.Root != 0 || small.KeyN != 1 || small.DataSize != 6 {
+		t.Fatalf("unexpected summary: %+v", small)
+	}
+}
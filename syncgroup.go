@@ -0,0 +1,137 @@
+package bolt
+
+import (
+	"sync"
+	"time"
+)
+
+// syncGroup is a set of commits that are made durable by the same sync.
+type syncGroup struct {
+	timer *time.Timer
+	once  sync.Once
+	done  chan struct{}
+	err   error
+}
+
+// groupSync returns true if commits should share syncs. Writes through the
+// mapping cannot be shared since the file may be remapped during a sync.
+func (db *DB) groupSync() bool {
+	return db.SyncInterval > 0 && db.syncMeta() && !db.mmapWrites
+}
+
+// deferMeta publishes m as the latest committed meta and returns the sync
+// group that will write it to disk. A new group is synced after SyncInterval.
+func (db *DB) deferMeta(m *meta) *syncGroup {
+	db.pendinglock.Lock()
+	defer db.pendinglock.Unlock()
+
+	// The meta on disk belongs to the previous commit until a group is synced.
+	if db.pendingMeta == nil {
+		db.durableTxid = m.txid - 1
+	}
+	db.pendingMeta = m
+
+	if db.syncGroup == nil {
+		g := &syncGroup{done: make(chan struct{})}
+		db.syncTimers.Add(1)
+		g.timer = time.AfterFunc(db.SyncInterval, func() {
+			defer db.syncTimers.Done()
+			db.syncNow(g)
+		})
+		db.syncGroup = g
+	}
+	return db.syncGroup
+}
+
+// syncNow syncs the commits of g, or of the current group if g is nil, and
+// wakes the committers waiting for it.
+func (db *DB) syncNow(g *syncGroup) {
+	db.pendinglock.Lock()
+	if g == nil {
+		g = db.syncGroup
+	}
+	if db.syncGroup == g {
+		db.syncGroup = nil
+	}
+	db.pendinglock.Unlock()
+
+	db.synclock.Lock()
+	err := db.syncPending()
+	db.synclock.Unlock()
+
+	if g != nil {
+		if g.timer.Stop() {
+			db.syncTimers.Done()
+		}
+		g.once.Do(func() {
+			g.err = err
+			close(g.done)
+		})
+	}
+}
+
+// syncPending syncs the data pages of every deferred commit and then writes
+// and syncs the latest deferred meta. The caller must hold synclock.
+//
+// A failed sync is not retried since a later sync that succeeds does not show
+// that the earlier writes reached the disk. The error is kept and returned
+// by every later sync and write transaction instead.
+func (db *DB) syncPending() error {
+	db.pendinglock.Lock()
+	m, err := db.pendingMeta, db.syncErr
+	db.pendinglock.Unlock()
+	if err != nil || m == nil {
+		return err
+	}
+
+	if err := db.writePendingMeta(m); err != nil {
+		db.pendinglock.Lock()
+		db.syncErr = err
+		db.pendinglock.Unlock()
+		return err
+	}
+
+	db.pendinglock.Lock()
+	if db.pendingMeta == m {
+		db.pendingMeta = nil
+	}
+	db.durableTxid = m.txid
+	db.pendinglock.Unlock()
+	return nil
+}
+
+// writePendingMeta syncs the data pages of the deferred commits and writes
+// and syncs m. The caller must hold synclock.
+//
+// m is written over the meta page that does not hold the latest synced meta
+// rather than the one picked by its txid. The pages of older metas may have
+// been reused, so a torn write must leave the latest synced meta to fall
+// back to.
+func (db *DB) writePendingMeta(m *meta) error {
+	// Data pages must be on disk before a meta page that references them.
+	if db.syncData() {
+		if err := db.sync(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, db.pageSize)
+	p := db.pageInBuffer(buf, 0)
+	p.id = 1 - db.metaPage
+	other := *m
+	other.write(p)
+	if _, err := db.ops.writeAt(buf, int64(p.id)*int64(db.pageSize)); err != nil {
+		return err
+	} else if err := db.sync(); err != nil {
+		return err
+	}
+	db.metaPage = p.id
+	return nil
+}
+
+// syncFailed returns the error of a failed group sync, if any.
+func (db *DB) syncFailed() error {
+	db.pendinglock.Lock()
+	defer db.pendinglock.Unlock()
+	return db.syncErr
+}
@@ -0,0 +1,228 @@
+package bolt_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that concurrent commits share syncs when SyncInterval is set.
+func TestDB_SyncInterval(t *testing.T) {
+	if bolt.IgnoreNoSync {
+		t.Skip("syncs are always made on this platform")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const latency = 20 * time.Millisecond
+	db.SyncInterval = 10 * time.Millisecond
+	db.SetFaults(&bolt.Faults{SyncLatency: latency})
+	defer db.SetFaults(nil)
+
+	// A single commit waits for the interval and the shared syncs.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Twenty commits with two syncs each would take at least 800ms.
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	t0 := time.Now()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Put([]byte(fmt.Sprintf("%02d", i)), []byte("bar"))
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(t0); d >= n*latency {
+		t.Fatalf("expected commits to share syncs: %s", d)
+	}
+
+	// Every commit is on disk after reopening.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 20 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+}
+
+// Ensure that a failed group sync is returned by every commit in the group
+// and rejects later writes until the database is reopened.
+func TestDB_SyncInterval_Error(t *testing.T) {
+	if bolt.IgnoreNoSync {
+		t.Skip("syncs are always made on this platform")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SyncInterval = time.Millisecond
+
+	db.SetFaults(&bolt.Faults{SyncErrorRate: 1})
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != bolt.ErrInjectedFault {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.SetFaults(nil)
+
+	// The commit remains visible but the sync is not retried.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Begin(true); err != bolt.ErrInjectedFault {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Reopening discards the commit that was never made durable.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) != nil {
+			t.Fatal("unexpected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that closing the database waits for a group sync started by its
+// timer.
+func TestDB_SyncInterval_Close(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		db := MustOpenDB()
+		db.SyncInterval = time.Millisecond
+
+		done := make(chan error, 1)
+		go func() {
+			done <- db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucket([]byte("widgets"))
+				return err
+			})
+		}()
+		time.Sleep(time.Duration(i*50) * time.Microsecond)
+		db.MustClose()
+		if err := <-done; err != nil && err != bolt.ErrDatabaseNotOpen {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Ensure that a group sync leaves the previous synced meta in place so that
+// a torn write of the newest meta falls back to it.
+func TestDB_SyncInterval_TornMeta(t *testing.T) {
+	if bolt.IgnoreNoSync {
+		t.Skip("syncs are always made on this platform")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make two groups of two commits each, so that the meta of each group
+	// has the same txid parity as the meta synced before it.
+	db.SyncInterval = 200 * time.Millisecond
+	for _, keys := range [][]string{{"a", "b"}, {"c", "d"}} {
+		var wg sync.WaitGroup
+		errs := make(chan error, len(keys))
+		for _, k := range keys {
+			wg.Add(1)
+			go func(k string) {
+				defer wg.Done()
+				errs <- db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte("widgets")).Put([]byte(k), []byte("bar"))
+				})
+			}(k)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	path := db.Path()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the checksum of the newest meta page.
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
+	m1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
+	newest := m0
+	if m1.txid > m0.txid {
+		newest = m1
+	}
+	if newest.txid != 6 {
+		t.Skipf("commits did not share syncs: txid %d", newest.txid)
+	}
+	newest.checksum++
+	if err := ioutil.WriteFile(path, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database reopens at the end of the first group.
+	if err := db.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b == nil {
+			t.Fatal("expected bucket")
+		} else if n := b.Stats().KeyN; n != 2 {
+			t.Fatalf("unexpected key count: %d", n)
+		} else if b.Get([]byte("a")) == nil || b.Get([]byte("b")) == nil {
+			t.Fatal("expected keys of the first group")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// TB is the subset of testing.TB used by TempDB. It is satisfied by
+// *testing.T and *testing.B.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// TestDB wraps a database opened by TempDB with helpers that fail the test
+// instead of returning errors.
+type TestDB struct {
+	*DB
+	t TB
+}
+
+// TempDB opens a database in a new temporary file for use by a test. The
+// database is closed and its files are removed when the test completes. The
+// test fails immediately if the database cannot be created.
+func TempDB(t TB) *TestDB {
+	t.Helper()
+	f, err := ioutil.TempFile("", "bolt-")
+	if err != nil {
+		t.Fatalf("bolt: create temp file: %s", err)
+	}
+	path := f.Name()
+	_ = f.Close()
+	_ = os.Remove(path)
+
+	db, err := Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt: open temp database: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+		_ = os.Remove(db.bloomPath())
+	})
+	return &TestDB{DB: db, t: t}
+}
+
+// MustUpdate executes fn in a read-write transaction and fails the test if
+// it returns an error.
+func (db *TestDB) MustUpdate(fn func(*Tx) error) {
+	db.t.Helper()
+	if err := db.Update(fn); err != nil {
+		db.t.Fatalf("bolt: update: %s", err)
+	}
+}
+
+// MustView executes fn in a read-only transaction and fails the test if it
+// returns an error.
+func (db *TestDB) MustView(fn func(*Tx) error) {
+	db.t.Helper()
+	if err := db.View(fn); err != nil {
+		db.t.Fatalf("bolt: view: %s", err)
+	}
+}
+
+// MustPut sets the value for a key in a top-level bucket, creating the
+// bucket if it does not exist, and fails the test on error.
+func (db *TestDB) MustPut(bucket, key, value []byte) {
+	db.t.Helper()
+	if err := db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	}); err != nil {
+		db.t.Fatalf("bolt: put %q/%q: %s", bucket, key, err)
+	}
+}
+
+// MustGet returns a copy of the value for a key in a top-level bucket. It
+// returns nil if the bucket or key does not exist and fails the test on any
+// other error.
+func (db *TestDB) MustGet(bucket, key []byte) []byte {
+	db.t.Helper()
+	v, err := db.Get(bucket, key)
+	if err != nil && err != ErrBucketNotFound {
+		db.t.Fatalf("bolt: get %q/%q: %s", bucket, key, err)
+	}
+	return v
+}
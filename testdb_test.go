@@ -0,0 +1,38 @@
+package bolt_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that TempDB opens a database that is removed after the test.
+func TestTempDB(t *testing.T) {
+	var path string
+	t.Run("Sub", func(t *testing.T) {
+		db := bolt.TempDB(t)
+		path = db.Path()
+
+		db.MustPut([]byte("widgets"), []byte("foo"), []byte("bar"))
+		if v := db.MustGet([]byte("widgets"), []byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := db.MustGet([]byte("missing"), []byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		db.MustUpdate(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Delete([]byte("foo"))
+		})
+		db.MustView(func(tx *bolt.Tx) error {
+			if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); v != nil {
+				t.Fatalf("unexpected value: %q", v)
+			}
+			return nil
+		})
+	})
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected database file to be removed: %v", err)
+	}
+}
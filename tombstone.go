@@ -0,0 +1,142 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// SetTombstones enables or disables soft deletes for the bucket at path,
+// given as the names of its parents and itself.
+//
+// When enabled, Delete replaces a key with a tombstone that records the
+// deleted value and the time it was deleted instead of removing it. Tombstones
+// are hidden from Get, cursors and ForEach but can be read with
+// Bucket.Tombstone and Bucket.ForEachTombstone, and are removed with
+// Bucket.PurgeTombstones. Putting a key replaces its tombstone. Tombstones
+// still occupy space and are included in bucket statistics.
+//
+// Files containing tombstones are marked with a newer format version and
+// cannot be opened by versions of Bolt without tombstone support.
+func (db *DB) SetTombstones(enabled bool, path ...[]byte) {
+	db.bucketlock.Lock()
+	defer db.bucketlock.Unlock()
+	if !enabled {
+		delete(db.tombstones, pathKey(path))
+		return
+	}
+	if db.tombstones == nil {
+		db.tombstones = make(map[string]bool)
+	}
+	db.tombstones[pathKey(path)] = true
+}
+
+// tombstones returns true if soft deletes are enabled for the bucket.
+func (b *Bucket) tombstones() bool {
+	db := b.tx.db
+	db.bucketlock.RLock()
+	defer db.bucketlock.RUnlock()
+	return db.tombstones[pathKey(b.path())]
+}
+
+// remove deletes the key under the cursor, leaving a tombstone if soft
+// deletes are enabled for the bucket.
+func (b *Bucket) remove(c *Cursor, key []byte) {
+	if !b.tombstones() {
+		c.node().del(key)
+		return
+	}
+
+	_, v, flags := c.keyValue()
+	if (flags & tombstoneLeafFlag) != 0 {
+		return
+	}
+	value := make([]byte, 8+len(v))
+	if !b.tx.db.Deterministic {
+		binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+	}
+	copy(value[8:], v)
+
+	key = cloneBytes(key)
+	c.node().put(key, key, value, 0, tombstoneLeafFlag)
+}
+
+// Tombstone returns the value of a deleted key and the time it was deleted.
+// Returns a nil value if the key has no tombstone or DB.Authorize denies
+// reading it.
+// The returned value is only valid for the life of the transaction.
+func (b *Bucket) Tombstone(key []byte) (value []byte, deleted time.Time) {
+	if b.authorize(OpGet, key) != nil {
+		return nil, time.Time{}
+	}
+	k, v, flags := b.Cursor().seek(key)
+	if !bytes.Equal(key, k) || (flags&tombstoneLeafFlag) == 0 {
+		return nil, time.Time{}
+	}
+	return decodeTombstone(v)
+}
+
+// ForEachTombstone executes a function for each tombstone in the bucket with
+// the deleted key and value and the time it was deleted.
+// If the provided function returns an error then the iteration is stopped and
+// the error is returned to the caller. The provided function must not modify
+// the bucket.
+func (b *Bucket) ForEachTombstone(fn func(k, v []byte, deleted time.Time) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if err := b.authorize(OpForEach, nil); err != nil {
+		return err
+	}
+	c := b.Cursor()
+	for k, v, flags := c.first0(); k != nil; k, v, flags = c.next() {
+		if (flags & tombstoneLeafFlag) == 0 {
+			continue
+		}
+		value, deleted := decodeTombstone(v)
+		if err := fn(k, value, deleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeTombstones removes tombstones for keys deleted before the given time
+// and returns the number removed. Passing the current time less a retention
+// window keeps recent deletes available for undo and review. Nothing is
+// removed if DB.Authorize denies deleting any of the keys.
+func (b *Bucket) PurgeTombstones(before time.Time) (int, error) {
+	if b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !b.Writable() {
+		return 0, ErrTxNotWritable
+	}
+
+	var keys [][]byte
+	if err := b.ForEachTombstone(func(k, v []byte, deleted time.Time) error {
+		if !deleted.Before(before) {
+			return nil
+		} else if err := b.authorize(OpDelete, k); err != nil {
+			return err
+		}
+		keys = append(keys, cloneBytes(k))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	c := b.Cursor()
+	for _, k := range keys {
+		c.seek(k)
+		c.node().del(k)
+		b.audit(OpDelete, k, nil)
+	}
+	return len(keys), nil
+}
+
+// decodeTombstone returns the value and deletion time stored in a tombstone.
+func decodeTombstone(v []byte) ([]byte, time.Time) {
+	if len(v) < 8 {
+		return nil, time.Time{}
+	}
+	return v[8:], time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+}
@@ -0,0 +1,228 @@
+package bolt_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that deletes in a bucket with tombstones enabled can be reviewed and purged.
+func TestBucket_Tombstone(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetTombstones(true, []byte("widgets"))
+
+	start := time.Now()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a", "b", "c", "d"} {
+			if err := b.Put([]byte(k), []byte(k+"-value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Delete([]byte("a")); err != nil {
+			t.Fatal(err)
+		} else if err := b.Delete([]byte("c")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("a")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		// Deleted keys are hidden from cursors in both directions.
+		var keys []string
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			keys = append(keys, string(k))
+		}
+		if k, _ := c.Seek([]byte("c")); !bytes.Equal(k, []byte("d")) {
+			t.Fatalf("unexpected seek key: %q", k)
+		}
+		if s := strings.Join(keys, ","); s != "b,d,d,b" {
+			t.Fatalf("unexpected keys: %s", s)
+		}
+
+		if v, deleted := b.Tombstone([]byte("a")); !bytes.Equal(v, []byte("a-value")) {
+			t.Fatalf("unexpected tombstone value: %q", v)
+		} else if deleted.Before(start.Add(-time.Second)) || deleted.After(time.Now()) {
+			t.Fatalf("unexpected deletion time: %s", deleted)
+		} else if v, _ := b.Tombstone([]byte("b")); v != nil {
+			t.Fatalf("unexpected tombstone value: %q", v)
+		}
+
+		keys = nil
+		if err := b.ForEachTombstone(func(k, v []byte, deleted time.Time) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		} else if s := strings.Join(keys, ","); s != "a,c" {
+			t.Fatalf("unexpected tombstones: %s", s)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undo a delete, then purge the remaining tombstones.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		v, _ := b.Tombstone([]byte("a"))
+		if err := b.Put([]byte("a"), append([]byte{}, v...)); err != nil {
+			t.Fatal(err)
+		} else if v := b.Get([]byte("a")); !bytes.Equal(v, []byte("a-value")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if n, err := b.PurgeTombstones(start.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		} else if n != 0 {
+			t.Fatalf("unexpected purge count: %d", n)
+		}
+		if n, err := b.PurgeTombstones(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		} else if n != 1 {
+			t.Fatalf("unexpected purge count: %d", n)
+		} else if v, _ := b.Tombstone([]byte("c")); v != nil {
+			t.Fatalf("unexpected tombstone value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that reading and purging tombstones is authorized and that purges
+// are written to the audit log.
+func TestBucket_Tombstone_Authorize(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetTombstones(true, []byte("widgets"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		return b.Delete([]byte("foo"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errDenied := errors.New("denied")
+	db.Authorize = func(op bolt.Op, bucket [][]byte, key []byte) error {
+		if bucket != nil && op != bolt.OpPut {
+			return errDenied
+		}
+		return nil
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v, _ := b.Tombstone([]byte("foo")); v != nil {
+			t.Fatalf("unexpected tombstone value: %q", v)
+		} else if err := b.ForEachTombstone(func(k, v []byte, deleted time.Time) error { return nil }); err != errDenied {
+			t.Fatalf("unexpected error: %v", err)
+		} else if n, err := b.PurgeTombstones(time.Now().Add(time.Second)); err != errDenied || n != 0 {
+			t.Fatalf("unexpected purge: %d, %v", n, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Purges are recorded as deletes.
+	var buf bytes.Buffer
+	db.Authorize = nil
+	db.Audit = &buf
+	defer func() { db.Audit = nil }()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		n, err := tx.Bucket([]byte("widgets")).PurgeTombstones(time.Now().Add(time.Second))
+		if err != nil {
+			return err
+		} else if n != 1 {
+			t.Fatalf("unexpected purge count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.Contains(s, `op=delete bucket="widgets" key="foo"`) {
+		t.Fatalf("unexpected audit log: %s", s)
+	}
+}
+
+// Ensure that tombstones are only kept for the bucket at the given path and
+// that files containing them are marked with a newer format version.
+func TestBucket_Tombstone_Nested(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.SetTombstones(true, []byte("users"), []byte("widgets"))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, parent := range []interface {
+			CreateBucket([]byte) (*bolt.Bucket, error)
+		}{users, tx} {
+			b, err := parent.CreateBucket([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
+			} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	} else if m := readMeta(t, db.Path()); m.version != 2 || m.flags != 0 {
+		t.Fatalf("unexpected meta: version=%d flags=%x", m.version, m.flags)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		return tx.Bucket([]byte("users")).Bucket([]byte("widgets")).Delete([]byte("foo"))
+	}); err != nil {
+		t.Fatal(err)
+	} else if m := readMeta(t, db.Path()); m.version != 3 || m.flags != 0x02 {
+		t.Fatalf("unexpected meta: version=%d flags=%x", m.version, m.flags)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v, _ := tx.Bucket([]byte("widgets")).Tombstone([]byte("foo")); v != nil {
+			t.Fatalf("unexpected tombstone: %q", v)
+		} else if v, _ := tx.Bucket([]byte("users")).Bucket([]byte("widgets")).Tombstone([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected tombstone: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
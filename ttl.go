@@ -0,0 +1,150 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+)
+
+// PutTTL sets the value for a key like Put but the key expires once d has
+// passed. Expired keys are hidden from Get, cursors and ForEach and are
+// removed by DeleteExpired or by the reaper started with
+// Options.ReapInterval. Putting the key with Put makes it permanent.
+// Returns ErrInvalidTTL if d is not positive.
+//
+// The expiry time is stored in the 8 bytes ahead of the value, so values can
+// be at most MaxValueSize-8 bytes. Files containing expiring keys are marked
+// with a newer format version and cannot be opened by versions of Bolt
+// without TTL support.
+func (b *Bucket) PutTTL(key []byte, value []byte, d time.Duration) error {
+	if d <= 0 {
+		return ErrInvalidTTL
+	}
+	return b.putExpiring(key, value, time.Now().Add(d))
+}
+
+// putExpiring sets the value for a key that expires at t.
+func (b *Bucket) putExpiring(key []byte, value []byte, t time.Time) error {
+	if err := b.validatePut(key, value); err != nil {
+		return err
+	} else if int64(len(value)) > MaxValueSize-8 {
+		return ErrValueTooLarge
+	}
+
+	v := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(v, uint64(t.UnixNano()))
+	copy(v[8:], value)
+
+	c := b.Cursor()
+	c.seek(key)
+	key = cloneBytes(key)
+	c.node().put(key, key, v, 0, expiringLeafFlag)
+	b.bloomAdd(key)
+	b.audit(OpPut, key, value)
+
+	return nil
+}
+
+// ExpiresAt returns the time at which key expires. Returns the zero time if
+// the key does not exist, has expired or was stored without a TTL.
+func (b *Bucket) ExpiresAt(key []byte) time.Time {
+	if b.authorize(OpGet, key) != nil {
+		return time.Time{}
+	}
+	c := b.Cursor()
+	c.seek(key)
+	k, v, flags := c.rawKeyValue()
+	if !bytes.Equal(key, k) || (flags&expiringLeafFlag) == 0 {
+		return time.Time{}
+	}
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+	if !t.After(time.Now()) {
+		return time.Time{}
+	}
+	return t
+}
+
+// DeleteExpired removes the expired keys of the bucket and returns the number
+// of keys removed. Keys of nested buckets are not removed.
+func (b *Bucket) DeleteExpired() (int, error) {
+	if b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !b.Writable() {
+		return 0, ErrTxNotWritable
+	}
+
+	var keys [][]byte
+	c := b.Cursor()
+	for k, _, flags := c.first0(); k != nil; k, _, flags = c.next() {
+		if (flags & expiredLeafFlag) != 0 {
+			keys = append(keys, cloneBytes(k))
+		}
+	}
+
+	for i, k := range keys {
+		if err := b.authorize(OpDelete, k); err != nil {
+			return i, err
+		}
+		c.seek(k)
+		c.node().del(k)
+		b.audit(OpDelete, k, nil)
+	}
+	return len(keys), nil
+}
+
+// reap removes the expired keys of every bucket every interval until stop
+// is closed. Buckets that cannot be reaped, such as buckets denied by
+// DB.Authorize, are logged and skipped so that they do not stop the others.
+func (db *DB) reap(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		err := db.Update(func(tx *Tx) error {
+			return tx.ForEach(func(name []byte, b *Bucket) error {
+				reapBucket(b, [][]byte{name})
+				return nil
+			})
+		})
+		if err != nil && err != ErrDatabaseNotOpen {
+			log.Printf("bolt.DeleteExpired(): %s", err)
+		}
+	}
+}
+
+// reapBucket removes the expired keys of b and its nested buckets. The path
+// of the bucket is used to log errors.
+func reapBucket(b *Bucket, path [][]byte) {
+	if _, err := b.DeleteExpired(); err != nil {
+		log.Printf("bolt.DeleteExpired(): bucket %q: %s", path, err)
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		if child := b.Bucket(k); child != nil {
+			reapBucket(child, append(path[:len(path):len(path)], k))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("bolt.DeleteExpired(): bucket %q: %s", path, err)
+	}
+}
+
+// expiringValue removes the expiry time from the value of an expiring leaf
+// element and sets expiredLeafFlag if the time has passed.
+func expiringValue(v []byte, flags uint32) ([]byte, uint32) {
+	if (flags & expiringLeafFlag) == 0 {
+		return v, flags
+	}
+	if int64(binary.BigEndian.Uint64(v)) <= time.Now().UnixNano() {
+		flags |= expiredLeafFlag
+	}
+	return v[8:], flags
+}
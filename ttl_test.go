@@ -0,0 +1,294 @@
+package bolt_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that keys put with a TTL are readable until they expire.
+func TestBucket_PutTTL(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("sessions"))
+		if err != nil {
+			return err
+		}
+		if err := b.PutTTL([]byte("live"), []byte("a"), time.Hour); err != nil {
+			return err
+		} else if err := b.PutTTL([]byte("dead"), []byte("b"), time.Nanosecond); err != nil {
+			return err
+		} else if err := b.PutTTL([]byte("fixed"), []byte("c"), time.Nanosecond); err != nil {
+			return err
+		} else if err := b.Put([]byte("fixed"), []byte("d")); err != nil {
+			return err
+		} else if err := b.PutTTL([]byte("x"), nil, 0); err != bolt.ErrInvalidTTL {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		if v := b.Get([]byte("live")); !bytes.Equal(v, []byte("a")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("dead")); v != nil {
+			t.Fatalf("unexpected expired value: %q", v)
+		} else if v := b.Get([]byte("fixed")); !bytes.Equal(v, []byte("d")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if exp := b.ExpiresAt([]byte("live")); exp.Before(time.Now().Add(59*time.Minute)) || exp.After(time.Now().Add(time.Hour)) {
+			t.Fatalf("unexpected expiry: %s", exp)
+		} else if !b.ExpiresAt([]byte("dead")).IsZero() || !b.ExpiresAt([]byte("fixed")).IsZero() {
+			t.Fatal("expected no expiry")
+		}
+
+		// Cursors skip expired keys and do not see expiry times.
+		var pairs []string
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			pairs = append(pairs, string(k)+"="+string(v))
+		}
+		if len(pairs) != 2 || pairs[0] != "fixed=d" || pairs[1] != "live=a" {
+			t.Fatalf("unexpected pairs: %v", pairs)
+		}
+		if k, _ := c.Seek([]byte("d")); !bytes.Equal(k, []byte("fixed")) {
+			t.Fatalf("unexpected key: %s", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired keys can be replaced and are removed without tombstones.
+	db.SetTombstones(true, []byte("sessions"))
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		if _, err := b.CreateBucket([]byte("dead")); err != nil {
+			return err
+		} else if err := b.DeleteBucket([]byte("dead")); err != nil {
+			return err
+		}
+		if err := b.PutTTL([]byte("dead"), []byte("b"), time.Nanosecond); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+		if err := b.Delete([]byte("dead")); err != nil {
+			return err
+		} else if v, _ := b.Tombstone([]byte("dead")); v != nil {
+			t.Fatalf("unexpected tombstone: %q", v)
+		}
+
+		// Deleting a live key keeps its value without the expiry time.
+		if err := b.Delete([]byte("live")); err != nil {
+			return err
+		} else if v, _ := b.Tombstone([]byte("live")); !bytes.Equal(v, []byte("a")) {
+			t.Fatalf("unexpected tombstone: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that DeleteExpired removes only expired keys.
+func TestBucket_DeleteExpired(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("sessions"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			d := time.Hour
+			if i%2 == 0 {
+				d = time.Nanosecond
+			}
+			if err := b.PutTTL(u64tob(uint64(i)), make([]byte, 100), d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket([]byte("sessions")).DeleteExpired(); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removed keys are written to the audit log.
+	var buf bytes.Buffer
+	db.Audit = &buf
+	defer func() { db.Audit = nil }()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		if n, err := b.DeleteExpired(); err != nil {
+			return err
+		} else if n != 500 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		if n, err := b.DeleteExpired(); err != nil {
+			return err
+		} else if n != 0 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(buf.String(), `op=delete bucket="sessions"`); n != 500 {
+		t.Fatalf("unexpected audited deletes: %d", n)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("sessions")).Stats().KeyN; n != 500 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the reaper removes expired keys from nested buckets.
+func TestOpen_ReapInterval(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReapInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		b, err = b.CreateBucket([]byte("b"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("keep"), []byte("x")); err != nil {
+			return err
+		}
+		return b.PutTTL([]byte("drop"), []byte("y"), time.Nanosecond)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		if err := db.View(func(tx *bolt.Tx) error {
+			n = tx.Bucket([]byte("a")).Bucket([]byte("b")).Stats().KeyN
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expired key not removed: %d keys", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Ensure that the reaper keeps removing expired keys from other buckets when
+// a bucket cannot be reaped.
+func TestOpen_ReapInterval_Denied(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReapInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The authorizer is set under the writer lock, which the reaper holds
+	// while it runs.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		db.Authorize = func(op bolt.Op, bucket [][]byte, key []byte) error {
+			if op == bolt.OpDelete && len(bucket) == 1 && string(bucket[0]) == "secret" {
+				return errors.New("denied")
+			}
+			return nil
+		}
+		for _, name := range []string{"open", "secret"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			} else if err := b.PutTTL([]byte("drop"), []byte("y"), time.Nanosecond); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		if err := db.View(func(tx *bolt.Tx) error {
+			n = tx.Bucket([]byte("open")).Stats().KeyN
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expired key not removed: %d keys", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Ensure that files containing expiring keys are marked with a newer format
+// version.
+func TestBucket_PutTTL_Version(t *testing.T) {
+	if pageSize != os.Getpagesize() {
+		t.Skip("page size mismatch")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("sessions"))
+		if err != nil {
+			return err
+		}
+		return b.PutTTL([]byte("foo"), []byte("bar"), time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	} else if m := readMeta(t, db.Path()); m.version != 3 || m.flags != 0x04 {
+		t.Fatalf("unexpected meta: version=%d flags=%x", m.version, m.flags)
+	}
+}
@@ -1,11 +1,13 @@
 package bolt
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -30,6 +32,9 @@ type Tx struct {
 	pages          map[pgid]*page
 	stats          TxStats
 	commitHandlers []func()
+	audit          []auditRecord
+	attached       map[string]*Tx
+	lease          *mmapLease
 
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
@@ -42,12 +47,17 @@ type Tx struct {
 
 // init initializes the transaction.
 func (tx *Tx) init(db *DB) {
+	tx.initMeta(db, db.meta())
+}
+
+// initMeta initializes the transaction from the state described by m.
+func (tx *Tx) initMeta(db *DB, m *meta) {
 	tx.db = db
 	tx.pages = nil
 
 	// Copy the meta page since it can be changed by the writer.
 	tx.meta = &meta{}
-	db.meta().copy(tx.meta)
+	m.copy(tx.meta)
 
 	// Copy over the root bucket.
 	tx.root = newBucket(tx)
@@ -66,6 +76,27 @@ func (tx *Tx) ID() int {
 	return int(tx.meta.txid)
 }
 
+// String returns the string representation of the transaction, including its
+// id, whether it is writable and the path of its database.
+func (tx *Tx) String() string {
+	mode := "ro"
+	if tx.writable {
+		mode = "rw"
+	}
+	if tx.db == nil {
+		return fmt.Sprintf("Tx<%s closed>", mode)
+	}
+	return fmt.Sprintf("Tx<%d %s %q>", tx.meta.txid, mode, tx.db.path)
+}
+
+// GoString returns the Go string representation of the transaction.
+func (tx *Tx) GoString() string {
+	if tx.db == nil {
+		return fmt.Sprintf("bolt.Tx{writable:%t, closed:true}", tx.writable)
+	}
+	return fmt.Sprintf("bolt.Tx{id:%d, writable:%t, db:%q}", tx.meta.txid, tx.writable, tx.db.path)
+}
+
 // DB returns a reference to the database that created the transaction.
 func (tx *Tx) DB() *DB {
 	return tx.db
@@ -76,6 +107,30 @@ func (tx *Tx) Size() int64 {
 	return int64(tx.meta.pgid) * int64(tx.db.pageSize)
 }
 
+// PendingSize returns an estimate of the number of bytes that will be
+// written when the transaction commits. This includes every node that has
+// been modified or read for modification, the freelist and the meta page.
+// Applications can use it to commit and start a new transaction before a
+// large write transaction exceeds memory or quota limits.
+//
+// Returns zero for read-only and closed transactions.
+func (tx *Tx) PendingSize() int64 {
+	if tx.db == nil || !tx.writable {
+		return 0
+	}
+
+	// Count the pages needed for dirty nodes, freelist and meta.
+	n := tx.root.pendingPages(true)
+	n += (tx.db.freelist.size() / tx.db.pageSize) + 1
+	n++
+
+	// Include any pages already allocated during commit.
+	for _, p := range tx.pages {
+		n += int(p.overflow) + 1
+	}
+	return int64(n) * int64(tx.db.pageSize)
+}
+
 // Writable returns whether the transaction can perform write operations.
 func (tx *Tx) Writable() bool {
 	return tx.writable
@@ -101,6 +156,93 @@ func (tx *Tx) Bucket(name []byte) *Bucket {
 	return tx.root.Bucket(name)
 }
 
+// HasBucket returns true if a bucket exists with the given name.
+func (tx *Tx) HasBucket(name []byte) bool {
+	return tx.root.HasBucket(name)
+}
+
+// Buckets returns the names of top-level buckets in key order.
+// Only names beginning with prefix are returned. If after is set then
+// iteration starts at the first name greater than after, allowing large
+// bucket lists to be paged through. A limit of zero or less returns all
+// matching names. Names that DB.Authorize denies access to are skipped. The
+// returned names are copies and remain valid after the transaction closes.
+func (tx *Tx) Buckets(prefix, after []byte, limit int) [][]byte {
+	var names [][]byte
+	c := tx.root.Cursor()
+
+	// Position the cursor at the first candidate name.
+	start := prefix
+	if bytes.Compare(after, prefix) > 0 {
+		start = after
+	}
+	k, v := c.Seek(start)
+	if after != nil && bytes.Equal(k, after) {
+		k, v = c.Next()
+	}
+
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if v != nil || tx.root.authorize(OpBucket, k) != nil {
+			continue
+		}
+		names = append(names, cloneBytes(k))
+		if limit > 0 && len(names) >= limit {
+			break
+		}
+	}
+	return names
+}
+
+// KV represents a key/value pair.
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// PutAll writes key/value pairs to several top-level buckets, keyed by
+// bucket name. Every write is validated before any is applied so a
+// validation failure leaves the transaction unchanged. Failures that
+// identify a bucket or key are returned as a *PutError.
+func (tx *Tx) PutAll(m map[string][]KV) error {
+	if tx.db == nil {
+		return ErrTxClosed
+	} else if !tx.writable {
+		return ErrTxNotWritable
+	}
+
+	// Process buckets in name order so writes are applied deterministically.
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Validate every write.
+	buckets := make([]*Bucket, len(names))
+	for i, name := range names {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return &PutError{Bucket: []byte(name), Err: ErrBucketNotFound}
+		}
+		for _, kv := range m[name] {
+			if err := b.validatePut(kv.Key, kv.Value); err != nil {
+				return &PutError{Bucket: []byte(name), Key: kv.Key, Err: err}
+			}
+		}
+		buckets[i] = b
+	}
+
+	// Apply writes.
+	for i, name := range names {
+		for _, kv := range m[name] {
+			if err := buckets[i].Put(kv.Key, kv.Value); err != nil {
+				return &PutError{Bucket: []byte(name), Key: kv.Key, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
 // CreateBucket creates a new bucket.
 // Returns an error if the bucket already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
@@ -121,12 +263,24 @@ func (tx *Tx) DeleteBucket(name []byte) error {
 	return tx.root.DeleteBucket(name)
 }
 
+// RenameBucket renames a bucket, replacing any bucket at the new name.
+// Returns an error if the bucket cannot be found or if either name represents
+// a non-bucket value.
+func (tx *Tx) RenameBucket(oldName, newName []byte) error {
+	return tx.root.RenameBucket(oldName, newName)
+}
+
 // ForEach executes a function for each bucket in the root.
+// Buckets that DB.Authorize denies access to are skipped.
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller.
 func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
 	return tx.root.ForEach(func(k, v []byte) error {
-		if err := fn(k, tx.root.Bucket(k)); err != nil {
+		b := tx.root.Bucket(k)
+		if b == nil {
+			return nil
+		}
+		if err := fn(k, b); err != nil {
 			return err
 		}
 		return nil
@@ -169,11 +323,21 @@ func (tx *Tx) Commit() error {
 	// Free the old root bucket.
 	tx.meta.root.root = tx.root.root
 
+	// Lower the high water mark below free pages at the end of the file.
+	var trimmed bool
+	if tx.db.TrimThreshold > 0 {
+		if hwm := tx.db.freelist.trim(tx.meta.pgid, tx.db.TrimThreshold); hwm < tx.meta.pgid {
+			tx.meta.pgid, trimmed = hwm, true
+		}
+	}
+
 	opgid := tx.meta.pgid
 
 	// Free the freelist and allocate new pages for it. This will overestimate
 	// the size of the freelist but not underestimate the size (which would be bad).
-	tx.db.freelist.free(tx.meta.txid, tx.db.page(tx.meta.freelist))
+	if tx.meta.freelist != pgidNoFreelist {
+		tx.db.freelist.free(tx.meta.txid, tx.db.page(tx.meta.freelist))
+	}
 	p, err := tx.allocate((tx.db.freelist.size() / tx.db.pageSize) + 1)
 	if err != nil {
 		tx.rollback()
@@ -185,9 +349,15 @@ func (tx *Tx) Commit() error {
 	}
 	tx.meta.freelist = p.id
 
+	// Commits sharing syncs leave the meta page to the group sync.
+	group := tx.db.groupSync()
+
 	// If the high water mark has moved up then attempt to grow the database.
 	if tx.meta.pgid > opgid {
-		if err := tx.db.grow(int(tx.meta.pgid+1) * tx.db.pageSize); err != nil {
+		stop := tx.db.watchStall(StallGrow)
+		err := tx.db.grow(int(tx.meta.pgid+1) * tx.db.pageSize)
+		stop()
+		if err != nil {
 			tx.rollback()
 			return err
 		}
@@ -195,7 +365,7 @@ func (tx *Tx) Commit() error {
 
 	// Write dirty pages to disk.
 	startTime = time.Now()
-	if err := tx.write(); err != nil {
+	if err := tx.write(tx.db.syncData() && !group); err != nil {
 		tx.rollback()
 		return err
 	}
@@ -218,16 +388,38 @@ func (tx *Tx) Commit() error {
 	}
 
 	// Write meta to disk.
-	if err := tx.writeMeta(); err != nil {
+	var g *syncGroup
+	if group {
+		g = tx.deferMeta()
+	} else if err := tx.writeMeta(); err != nil {
 		tx.rollback()
 		return err
 	}
 	tx.stats.WriteTime += time.Since(startTime)
 
+	// Truncate trimmed pages now that the meta page no longer references them.
+	if trimmed && !group {
+		tx.db.shrink(int(tx.meta.pgid) * tx.db.pageSize)
+	}
+
+	// Build bloom filters from the committed state.
+	tx.buildBlooms()
+
 	// Finalize the transaction.
+	db, id := tx.db, tx.meta.txid
 	tx.close()
 
-	// Execute commit handlers now that the locks have been removed.
+	// Wait for the group sync that makes the commit durable.
+	if g != nil {
+		<-g.done
+		if g.err != nil {
+			return g.err
+		}
+	}
+
+	// Record the committed mutations and execute commit handlers now that
+	// the locks have been removed.
+	tx.writeAudit(db, id, time.Now())
 	for _, fn := range tx.commitHandlers {
 		fn()
 	}
@@ -235,6 +427,51 @@ func (tx *Tx) Commit() error {
 	return nil
 }
 
+// Clone returns a new read-only transaction that sees the same state of the
+// database as tx, so the snapshot can be read from several goroutines with a
+// transaction, and cursors, for each. A transaction must not be used from
+// more than one goroutine at a time. Cloning does not wait for a pending
+// remap, so it is safe while tx is open. Clones share tx's hold on the mmap
+// and each must be rolled back independently of tx.
+func (tx *Tx) Clone() (*Tx, error) {
+	if tx.db == nil {
+		return nil, ErrTxClosed
+	} else if tx.writable {
+		return nil, ErrTxWritable
+	}
+
+	db := tx.db
+	db.metalock.Lock()
+	if !db.opened {
+		db.metalock.Unlock()
+		return nil, ErrDatabaseNotOpen
+	}
+	atomic.AddInt32(&tx.lease.refs, 1)
+	t := &Tx{WriteFlag: tx.WriteFlag, lease: tx.lease}
+	t.initMeta(db, tx.meta)
+	db.txs = append(db.txs, t)
+	n := len(db.txs)
+	db.metalock.Unlock()
+
+	db.statlock.Lock()
+	db.stats.TxN++
+	db.stats.OpenTxN = n
+	db.statlock.Unlock()
+
+	return t, nil
+}
+
+// mmapLease is the read lock on the mmap held by a read-only transaction and
+// shared with its clones. The lock is released when the last one closes.
+type mmapLease struct {
+	refs int32
+}
+
+// release drops a reference and returns true if the lock should be released.
+func (l *mmapLease) release() bool {
+	return atomic.AddInt32(&l.refs, -1) == 0
+}
+
 // Rollback closes the transaction and ignores all previous updates. Read-only
 // transactions must be rolled back and not committed.
 func (tx *Tx) Rollback() error {
@@ -252,7 +489,16 @@ func (tx *Tx) rollback() {
 	}
 	if tx.writable {
 		tx.db.freelist.rollback(tx.meta.txid)
-		tx.db.freelist.reload(tx.db.page(tx.db.meta().freelist))
+		// The freelist cannot be read back once the data file is lost.
+		if tx.db.fileErr != nil {
+			tx.close()
+			return
+		}
+		if freelist := tx.db.meta().freelist; freelist == pgidNoFreelist {
+			tx.db.freelist.reloadIDs(tx.db.freePages())
+		} else {
+			tx.db.freelist.reload(tx.db.page(freelist))
+		}
 	}
 	tx.close()
 }
@@ -282,6 +528,7 @@ func (tx *Tx) close() {
 	} else {
 		tx.db.removeTx(tx)
 	}
+	tx.closeAttached()
 
 	// Clear all references.
 	tx.db = nil
@@ -300,6 +547,16 @@ func (tx *Tx) Copy(w io.Writer) error {
 // WriteTo writes the entire database to a writer.
 // If err == nil then exactly tx.Size() bytes will be written into the writer.
 func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
+	// In-memory databases are copied directly from their data.
+	if tx.db.inmem {
+		return tx.writeToFrom(w, bytes.NewReader(tx.db.dataref))
+	}
+
+	// Ephemeral files cannot be opened again by path so share the handle.
+	if tx.db.ephemeral {
+		return tx.writeToFrom(w, io.NewSectionReader(tx.db.file, 0, tx.Size()))
+	}
+
 	// Attempt to open reader with WriteFlag
 	f, err := os.OpenFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
 	if err != nil {
@@ -307,6 +564,16 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	}
 	defer func() { _ = f.Close() }()
 
+	if n, err = tx.writeToFrom(w, f); err != nil {
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// writeToFrom writes the meta pages for the transaction followed by the data
+// pages read from r.
+func (tx *Tx) writeToFrom(w io.Writer, r io.ReadSeeker) (n int64, err error) {
+
 	// Generate a meta page. We use the same page data for both meta pages.
 	buf := make([]byte, tx.db.pageSize)
 	page := (*page)(unsafe.Pointer(&buf[0]))
@@ -333,18 +600,14 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	}
 
 	// Move past the meta pages in the file.
-	if _, err := f.Seek(int64(tx.db.pageSize*2), os.SEEK_SET); err != nil {
+	if _, err := r.Seek(int64(tx.db.pageSize*2), os.SEEK_SET); err != nil {
 		return n, fmt.Errorf("seek: %s", err)
 	}
 
 	// Copy data pages.
-	wn, err := io.CopyN(w, f, tx.Size()-int64(tx.db.pageSize*2))
+	wn, err := io.CopyN(w, r, tx.Size()-int64(tx.db.pageSize*2))
 	n += wn
-	if err != nil {
-		return n, err
-	}
-
-	return n, f.Close()
+	return n, err
 }
 
 // CopyFile copies the entire database to file at the given path.
@@ -392,8 +655,10 @@ func (tx *Tx) check(ch chan error) {
 	reachable := make(map[pgid]*page)
 	reachable[0] = tx.page(0) // meta0
 	reachable[1] = tx.page(1) // meta1
-	for i := uint32(0); i <= tx.page(tx.meta.freelist).overflow; i++ {
-		reachable[tx.meta.freelist+pgid(i)] = tx.page(tx.meta.freelist)
+	if tx.meta.freelist != pgidNoFreelist {
+		for i := uint32(0); i <= tx.page(tx.meta.freelist).overflow; i++ {
+			reachable[tx.meta.freelist+pgid(i)] = tx.page(tx.meta.freelist)
+		}
 	}
 
 	// Recursively check buckets.
@@ -412,6 +677,17 @@ func (tx *Tx) check(ch chan error) {
 }
 
 func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bool, ch chan error) {
+	// Ensure the stored totals match the keys in the bucket.
+	if b.totals.counted {
+		if t := b.countTotals(); t.keyN != b.totals.keyN {
+			ch <- fmt.Errorf("bucket %q: key count %d, want %d", b.name, b.totals.keyN, t.keyN)
+		} else if t.size != b.totals.size {
+			ch <- fmt.Errorf("bucket %q: data size %d, want %d", b.name, b.totals.size, t.size)
+		} else if t.bucketN != b.totals.bucketN {
+			ch <- fmt.Errorf("bucket %q: bucket count %d, want %d", b.name, b.totals.bucketN, t.bucketN)
+		}
+	}
+
 	// Ignore inline buckets.
 	if b.root == 0 {
 		return
@@ -437,12 +713,23 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bo
 			ch <- fmt.Errorf("page %d: reachable freed", int(p.id))
 		} else if (p.flags&branchPageFlag) == 0 && (p.flags&leafPageFlag) == 0 {
 			ch <- fmt.Errorf("page %d: invalid type: %s", int(p.id), p.typ())
+		} else if (p.flags&directoryPageFlag) != 0 && b != &tx.root {
+			ch <- fmt.Errorf("page %d: directory page outside the bucket directory", int(p.id))
+		}
+
+		// The bucket directory may only contain buckets.
+		if b == &tx.root && (p.flags&leafPageFlag) != 0 {
+			for i := uint16(0); i < p.count; i++ {
+				if k, _, flags := p.leafElement(i); (flags & bucketLeafFlag) == 0 {
+					ch <- fmt.Errorf("page %d: non-bucket key in bucket directory: %q", int(p.id), k)
+				}
+			}
 		}
 	})
 
 	// Check each bucket within this bucket.
-	_ = b.ForEach(func(k, v []byte) error {
-		if child := b.Bucket(k); child != nil {
+	_ = b.forEach(func(k, v []byte) error {
+		if child := b.child(k); child != nil {
 			tx.checkBucket(child, reachable, freed, ch)
 		}
 		return nil
@@ -466,8 +753,8 @@ func (tx *Tx) allocate(count int) (*page, error) {
 	return p, nil
 }
 
-// write writes any dirty pages to disk.
-func (tx *Tx) write() error {
+// write writes any dirty pages to disk and syncs them if sync is true.
+func (tx *Tx) write(sync bool) error {
 	// Sort pages by id.
 	pages := make(pages, 0, len(tx.pages))
 	for _, p := range tx.pages {
@@ -478,6 +765,7 @@ func (tx *Tx) write() error {
 	sort.Sort(pages)
 
 	// Write pages to disk in order.
+	startTime := time.Now()
 	for _, p := range pages {
 		size := (int(p.overflow) + 1) * tx.db.pageSize
 		offset := int64(p.id) * int64(tx.db.pageSize)
@@ -492,6 +780,7 @@ func (tx *Tx) write() error {
 			}
 
 			// Write chunk to disk.
+			tx.trace("write", p.id)
 			buf := ptr[:sz]
 			if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
 				return err
@@ -511,12 +800,16 @@ func (tx *Tx) write() error {
 			ptr = (*[maxAllocSize]byte)(unsafe.Pointer(&ptr[sz]))
 		}
 	}
+	tx.stats.PageWriteTime += time.Since(startTime)
 
-	// Ignore file sync if flag is set on DB.
-	if !tx.db.NoSync || IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+	// Ignore file sync if NoSync, the durability level skips data syncs or
+	// the sync is shared with other commits.
+	if sync {
+		startTime = time.Now()
+		if err := tx.db.sync(); err != nil {
 			return err
 		}
+		tx.stats.DataSyncTime += time.Since(startTime)
 	}
 
 	// Put small pages back to page pool.
@@ -544,16 +837,38 @@ func (tx *Tx) writeMeta() error {
 	// Create a temporary buffer for the meta page.
 	buf := make([]byte, tx.db.pageSize)
 	p := tx.db.pageInBuffer(buf, 0)
-	tx.meta.write(p)
 
-	// Write the meta page to file.
+	// Write the meta page to file. Group syncs are excluded so that an
+	// older deferred meta cannot overwrite it.
+	tx.db.synclock.Lock()
+	defer tx.db.synclock.Unlock()
+	p.id = 1 - tx.db.metaPage
+	tx.meta.write(p)
+	tx.trace("write", p.id)
+	startTime := time.Now()
 	if _, err := tx.db.ops.writeAt(buf, int64(p.id)*int64(tx.db.pageSize)); err != nil {
 		return err
 	}
-	if !tx.db.NoSync || IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+	tx.stats.MetaWriteTime += time.Since(startTime)
+	if tx.db.syncMeta() {
+		startTime = time.Now()
+		if err := tx.db.sync(); err != nil {
 			return err
 		}
+		tx.stats.MetaSyncTime += time.Since(startTime)
+	}
+	tx.db.metaPage = p.id
+
+	// The meta supersedes any commits still waiting for a group sync.
+	tx.db.pendinglock.Lock()
+	tx.db.pendingMeta = nil
+	tx.db.pendinglock.Unlock()
+
+	// The new meta page replaces the one skipped by a truncation rollback.
+	if tx.db.rolledBack != nil {
+		tx.db.metalock.Lock()
+		tx.db.rolledBack = nil
+		tx.db.metalock.Unlock()
 	}
 
 	// Update statistics.
@@ -562,6 +877,23 @@ func (tx *Tx) writeMeta() error {
 	return nil
 }
 
+// deferMeta publishes the transaction's meta in memory without writing it and
+// returns the sync group that will write it.
+func (tx *Tx) deferMeta() *syncGroup {
+	m := &meta{}
+	tx.meta.copy(m)
+	m.checksum = m.sum64()
+	g := tx.db.deferMeta(m)
+
+	// The new meta replaces the one skipped by a truncation rollback.
+	if tx.db.rolledBack != nil {
+		tx.db.metalock.Lock()
+		tx.db.rolledBack = nil
+		tx.db.metalock.Unlock()
+	}
+	return g
+}
+
 // page returns a reference to the page with a given id.
 // If page has been written to then a temporary buffered page is returned.
 func (tx *Tx) page(id pgid) *page {
@@ -573,9 +905,23 @@ func (tx *Tx) page(id pgid) *page {
 	}
 
 	// Otherwise return directly from the mmap.
+	tx.trace("read", id)
+	if f := tx.db.faults; f != nil && f.ReadLatency > 0 {
+		time.Sleep(f.ReadLatency)
+	}
 	return tx.db.page(id)
 }
 
+// trace writes a page access record to the database's trace writer, if set.
+func (tx *Tx) trace(op string, id pgid) {
+	if tx.db.Trace == nil {
+		return
+	}
+	tx.db.tracelock.Lock()
+	defer tx.db.tracelock.Unlock()
+	fmt.Fprintf(tx.db.Trace, "tx=%d op=%s page=%d\n", tx.meta.txid, op, id)
+}
+
 // forEachPage iterates over every page within a given page and executes a function.
 func (tx *Tx) forEachPage(pgid pgid, depth int, fn func(*page, int)) {
 	p := tx.page(pgid)
@@ -614,11 +960,78 @@ func (tx *Tx) Page(id int) (*PageInfo, error) {
 		info.Type = "free"
 	} else {
 		info.Type = p.typ()
+		info.Directory = (p.flags & directoryPageFlag) != 0
 	}
 
 	return info, nil
 }
 
+// Root returns the id of the root page of the bucket directory, the B+tree
+// that holds the headers of all top-level buckets.
+func (tx *Tx) Root() int {
+	return int(tx.meta.root.root)
+}
+
+// ForEachPage executes a function for each branch and leaf page reachable from
+// the bucket directory, including the pages of every nested bucket. Pages are
+// visited depth first and a bucket's pages are visited right after the leaf
+// page that holds its header. If fn returns an error then iteration stops and
+// the error is returned.
+//
+// Only committed pages are visited so changes made by a writable transaction
+// are not visible until it commits.
+func (tx *Tx) ForEachPage(fn func(p *TreePage) error) error {
+	if tx.db == nil {
+		return ErrTxClosed
+	}
+	return tx.walkTree(tx.page(tx.meta.root.root), nil, 0, fn)
+}
+
+// walkTree executes fn for p, its children and any buckets stored on them.
+func (tx *Tx) walkTree(p *page, path [][]byte, depth int, fn func(*TreePage) error) error {
+	info := &TreePage{
+		PageInfo: PageInfo{
+			ID:            int(p.id),
+			Type:          p.typ(),
+			Count:         int(p.count),
+			OverflowCount: int(p.overflow),
+			Directory:     (p.flags & directoryPageFlag) != 0,
+		},
+		Bucket: path,
+		Depth:  depth,
+		Inline: p.id == 0,
+		p:      p,
+	}
+	if err := fn(info); err != nil {
+		return err
+	}
+
+	for i := uint16(0); i < p.count; i++ {
+		if (p.flags & branchPageFlag) != 0 {
+			if err := tx.walkTree(tx.page(p.branchPageElement(i).pgid), path, depth+1, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Descend into nested buckets.
+		k, v, flags := p.leafElement(i)
+		if (flags & bucketLeafFlag) == 0 {
+			continue
+		}
+		var child *page
+		if root := (*bucket)(unsafe.Pointer(&v[0])).root; root != 0 {
+			child = tx.page(root)
+		} else {
+			child = (*page)(unsafe.Pointer(&v[bucketHeaderSize]))
+		}
+		if err := tx.walkTree(child, append(path[:len(path):len(path)], k), 0, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // TxStats represents statistics about the actions performed by the transaction.
 type TxStats struct {
 	// Page statistics.
@@ -641,9 +1054,20 @@ type TxStats struct {
 	Spill     int           // number of nodes spilled
 	SpillTime time.Duration // total time spent spilling
 
-	// Write statistics.
-	Write     int           // number of writes performed
-	WriteTime time.Duration // total time spent writing to disk
+	// Write statistics. WriteTime covers the whole write phase of a commit;
+	// the stage timings break it down and exclude syncs shared by a group.
+	Write         int           // number of writes performed
+	WriteTime     time.Duration // total time spent writing to disk
+	PageWriteTime time.Duration // time spent writing dirty pages
+	DataSyncTime  time.Duration // time spent syncing dirty pages
+	MetaWriteTime time.Duration // time spent writing the meta page
+	MetaSyncTime  time.Duration // time spent syncing the meta page
+
+	// Bloom filter statistics.
+	BloomSkip int // number of lookups answered by a bloom filter
+
+	// Mmap statistics.
+	Remap int // number of times the mmap was grown
 }
 
 func (s *TxStats) add(other *TxStats) {
@@ -659,6 +1083,12 @@ func (s *TxStats) add(other *TxStats) {
 	s.SpillTime += other.SpillTime
 	s.Write += other.Write
 	s.WriteTime += other.WriteTime
+	s.PageWriteTime += other.PageWriteTime
+	s.DataSyncTime += other.DataSyncTime
+	s.MetaWriteTime += other.MetaWriteTime
+	s.MetaSyncTime += other.MetaSyncTime
+	s.BloomSkip += other.BloomSkip
+	s.Remap += other.Remap
 }
 
 // Sub calculates and returns the difference between two sets of transaction stats.
@@ -678,5 +1108,11 @@ func (s *TxStats) Sub(other *TxStats) TxStats {
 	diff.SpillTime = s.SpillTime - other.SpillTime
 	diff.Write = s.Write - other.Write
 	diff.WriteTime = s.WriteTime - other.WriteTime
+	diff.PageWriteTime = s.PageWriteTime - other.PageWriteTime
+	diff.DataSyncTime = s.DataSyncTime - other.DataSyncTime
+	diff.MetaWriteTime = s.MetaWriteTime - other.MetaWriteTime
+	diff.MetaSyncTime = s.MetaSyncTime - other.MetaSyncTime
+	diff.BloomSkip = s.BloomSkip - other.BloomSkip
+	diff.Remap = s.Remap - other.Remap
 	return diff
 }
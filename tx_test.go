@@ -6,11 +6,39 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/boltdb/bolt"
 )
 
+// Ensure that the string representation of a transaction includes its id,
+// mode and database path.
+func TestTx_String(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, exp := tx.String(), fmt.Sprintf("Tx<2 rw %q>", db.Path()); s != exp {
+		t.Fatalf("unexpected string: %s", s)
+	} else if s, exp := tx.GoString(), fmt.Sprintf("bolt.Tx{id:2, writable:true, db:%q}", db.Path()); s != exp {
+		t.Fatalf("unexpected go string: %s", s)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if s := tx.String(); s != "Tx<rw closed>" {
+		t.Fatalf("unexpected string: %s", s)
+	} else if s := tx.GoString(); s != "bolt.Tx{writable:true, closed:true}" {
+		t.Fatalf("unexpected go string: %s", s)
+	}
+}
+
 // Ensure that committing a closed transaction returns an error.
 func TestTx_Commit_ErrTxClosed(t *testing.T) {
 	db := MustOpenDB()
@@ -62,6 +90,51 @@ func TestTx_Commit_ErrTxNotWritable(t *testing.T) {
 	if err := tx.Commit(); err != bolt.ErrTxNotWritable {
 		t.Fatal(err)
 	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that committing records the time spent in each write stage.
+func TestTx_Commit_StageTimings(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	put := func() bolt.TxStats {
+		prev := db.Stats()
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b.Put([]byte("foo"), []byte("bar"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		stats := db.Stats()
+		return stats.TxStats.Sub(&prev.TxStats)
+	}
+
+	stats := put()
+	if stats.PageWriteTime <= 0 || stats.MetaWriteTime <= 0 {
+		t.Fatalf("unexpected write timings: %+v", stats)
+	} else if stats.DataSyncTime <= 0 || stats.MetaSyncTime <= 0 {
+		t.Fatalf("unexpected sync timings: %+v", stats)
+	} else if sum := stats.PageWriteTime + stats.DataSyncTime + stats.MetaWriteTime + stats.MetaSyncTime; sum > stats.WriteTime {
+		t.Fatalf("stage timings exceed write time: %v > %v", sum, stats.WriteTime)
+	}
+
+	// Skipped syncs are not timed.
+	if bolt.IgnoreNoSync {
+		return
+	}
+	db.NoSync = true
+	stats = put()
+	if stats.DataSyncTime != 0 || stats.MetaSyncTime != 0 {
+		t.Fatalf("unexpected sync timings: %+v", stats)
+	} else if stats.MetaWriteTime <= 0 {
+		t.Fatalf("unexpected meta write time: %v", stats.MetaWriteTime)
+	}
 }
 
 // Ensure that a transaction can retrieve a cursor on the root bucket.
@@ -151,6 +224,55 @@ func TestTx_Bucket(t *testing.T) {
 	}
 }
 
+// Ensure that cached bucket lookups in read-only transactions see later commits.
+func TestTx_Bucket_Cached(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	put := func(i int) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put(u64tob(uint64(i)), make([]byte, 500))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() (n int) {
+		if err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			if b == nil {
+				return nil
+			} else if tx.Bucket([]byte("widgets")) != b {
+				t.Fatal("expected cached bucket")
+			}
+			return b.ForEach(func(k, v []byte) error { n++; return nil })
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	for i := 0; i < 20; i++ {
+		put(i)
+		if n := count(); n != i+1 {
+			t.Fatalf("unexpected count: %d", n)
+		} else if n := count(); n != i+1 {
+			t.Fatalf("unexpected cached count: %d", n)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}); err != nil {
+		t.Fatal(err)
+	} else if n := count(); n != 0 {
+		t.Fatalf("unexpected count after delete: %d", n)
+	}
+}
+
 // Ensure that a Tx retrieving a non-existent key returns nil.
 func TestTx_Get_NotFound(t *testing.T) {
 	db := MustOpenDB()
@@ -202,6 +324,188 @@ func TestTx_CreateBucket(t *testing.T) {
 	}
 }
 
+// Ensure that the bucket returned by CreateBucket is the same bucket returned
+// by a later lookup in the transaction and can be used immediately.
+func TestTx_CreateBucket_Returned(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		} else if b != tx.Bucket([]byte("widgets")) {
+			t.Fatal("expected same bucket")
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if b.Bucket([]byte("sub")) == nil {
+			t.Fatal("expected sub-bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that bucket existence can be checked.
+func TestTx_HasBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if tx.HasBucket([]byte("widgets")) {
+			t.Fatal("unexpected bucket")
+		}
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			t.Fatal(err)
+		}
+		if !tx.HasBucket([]byte("widgets")) {
+			t.Fatal("expected bucket")
+		} else if !b.HasBucket([]byte("sub")) {
+			t.Fatal("expected sub-bucket")
+		} else if b.HasBucket([]byte("foo")) {
+			t.Fatal("key should not be reported as a bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := db.HasBucket([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected bucket")
+	}
+	if ok, err := db.HasBucket([]byte("gadgets")); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("unexpected bucket")
+	}
+}
+
+// Ensure that bucket names can be listed by prefix and paged through.
+func TestTx_Buckets(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"user/3", "other", "user/1", "user/2", "zzz"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if names := tx.Buckets(nil, nil, 0); len(names) != 5 {
+			t.Fatalf("unexpected names: %q", names)
+		}
+		if names := tx.Buckets([]byte("user/"), nil, 2); !reflect.DeepEqual(names, [][]byte{[]byte("user/1"), []byte("user/2")}) {
+			t.Fatalf("unexpected names: %q", names)
+		}
+		if names := tx.Buckets([]byte("user/"), []byte("user/2"), 2); !reflect.DeepEqual(names, [][]byte{[]byte("user/3")}) {
+			t.Fatalf("unexpected names: %q", names)
+		}
+		if names := tx.Buckets([]byte("none"), nil, 0); len(names) != 0 {
+			t.Fatalf("unexpected names: %q", names)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, err := db.Buckets([]byte("user/")); err != nil {
+		t.Fatal(err)
+	} else if len(names) != 3 {
+		t.Fatalf("unexpected names: %q", names)
+	}
+}
+
+// Ensure that writes can be applied to several buckets at once.
+func TestTx_PutAll(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"users", "emails"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tx.Bucket([]byte("users")).CreateBucket([]byte("sub")); err != nil {
+			t.Fatal(err)
+		}
+		return tx.PutAll(map[string][]bolt.KV{
+			"users":  {{Key: []byte("1"), Value: []byte("bob")}},
+			"emails": {{Key: []byte("bob@example.com"), Value: []byte("1")}},
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ensure a failed validation identifies the key and applies nothing.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		err := tx.PutAll(map[string][]bolt.KV{
+			"emails": {{Key: []byte("susy@example.com"), Value: []byte("2")}},
+			"users":  {{Key: []byte("2"), Value: []byte("susy")}, {Key: []byte("sub"), Value: []byte("x")}},
+		})
+		if e, ok := err.(*bolt.PutError); !ok {
+			t.Fatalf("unexpected error: %#v", err)
+		} else if string(e.Bucket) != "users" || string(e.Key) != "sub" || e.Err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", e)
+		}
+		if v := tx.Bucket([]byte("emails")).Get([]byte("susy@example.com")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		err = tx.PutAll(map[string][]bolt.KV{"missing": {{Key: []byte("1"), Value: []byte("x")}}})
+		if e, ok := err.(*bolt.PutError); !ok || e.Err != bolt.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("users")).Get([]byte("1")); !bytes.Equal(v, []byte("bob")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := tx.Bucket([]byte("emails")).Get([]byte("bob@example.com")); !bytes.Equal(v, []byte("1")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if err := tx.PutAll(nil); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a bucket can be created if it doesn't already exist.
 func TestTx_CreateBucketIfNotExists(t *testing.T) {
 	db := MustOpenDB()
@@ -282,6 +586,134 @@ func TestTx_CreateBucket_ErrBucketExists(t *testing.T) {
 	}
 }
 
+// Ensure that a bucket name larger than the maximum returns an error.
+func TestTx_CreateBucket_ErrBucketNameTooLarge(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket(make([]byte, bolt.MaxBucketNameSize+1)); err != bolt.ErrBucketNameTooLarge {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := tx.CreateBucketIfNotExists(make([]byte, bolt.MaxBucketNameSize+1)); err != bolt.ErrBucketNameTooLarge {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the bucket limit is enforced per parent bucket.
+func TestTx_CreateBucket_ErrTooManyBuckets(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	db.MaxBuckets = 2
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		a, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := a.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatal(err)
+		} else if _, err := tx.CreateBucket([]byte("b")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.CreateBucket([]byte("c")); err != bolt.ErrTooManyBuckets {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Existing buckets can still be retrieved.
+		if _, err := tx.CreateBucketIfNotExists([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Regular keys do not count towards the limit.
+		if _, err := a.CreateBucket([]byte("x")); err != nil {
+			t.Fatal(err)
+		} else if _, err := a.CreateBucket([]byte("y")); err != nil {
+			t.Fatal(err)
+		} else if _, err := a.CreateBucket([]byte("z")); err != bolt.ErrTooManyBuckets {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The count is stored with the bucket and follows deletes.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		a := tx.Bucket([]byte("a"))
+		if _, err := a.CreateBucket([]byte("z")); err != bolt.ErrTooManyBuckets {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := a.DeleteBucket([]byte("x")); err != nil {
+			t.Fatal(err)
+		} else if _, err := a.CreateBucket([]byte("z")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that bucket names containing NUL bytes, invalid UTF-8 and
+// multi-byte characters round-trip unchanged.
+func TestTx_CreateBucket_BinaryNames(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	names := [][]byte{
+		[]byte("\x00"),
+		[]byte("user\x00id"),
+		[]byte("\xff\xfe\xfd"),
+		[]byte("\xc3\x28"),
+		[]byte("日本語"),
+		[]byte("emoji-😀"),
+		{0x00, 0x00, 0x01},
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			b, err := tx.CreateBucket(name)
+			if err != nil {
+				t.Fatalf("create %q: %s", name, err)
+			} else if err := b.Put(name, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sort names to match the byte order used by the database.
+	sorted := make([][]byte, len(names))
+	copy(sorted, names)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if got := tx.Buckets(nil, nil, 0); !reflect.DeepEqual(got, sorted) {
+			t.Fatalf("unexpected names: %q", got)
+		}
+		for _, name := range names {
+			b := tx.Bucket(name)
+			if b == nil {
+				t.Fatalf("bucket not found: %q", name)
+			} else if v := b.Get(name); !bytes.Equal(v, name) {
+				t.Fatalf("unexpected value in %q: %q", name, v)
+			}
+		}
+
+		// Names that differ only by a trailing NUL are distinct.
+		if tx.Bucket([]byte("user")) != nil || tx.Bucket([]byte("\x00\x00")) != nil {
+			t.Fatal("unexpected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a bucket is created with a non-blank name.
 func TestTx_CreateBucket_ErrBucketNameRequired(t *testing.T) {
 	db := MustOpenDB()
@@ -602,30 +1034,339 @@ func TestTx_CopyFile_Error_Normal(t *testing.T) {
 	}
 }
 
-func ExampleTx_Rollback() {
-	// Open the database.
-	db, err := bolt.Open(tempfile(), 0666, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer os.Remove(db.Path())
+// Ensure that page accesses are written to the trace writer when set.
+func TestTx_Trace(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
 
-	// Create a bucket.
+	var buf bytes.Buffer
+	db.Trace = &buf
 	if err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte("widgets"))
-		return err
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
 	}); err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
-
-	// Set a value for a key.
-	if err := db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
-	}); err != nil {
-		log.Fatal(err)
+	if !strings.Contains(buf.String(), "tx=2 op=write page=") {
+		t.Fatalf("expected write trace:\n%s", buf.String())
 	}
 
-	// Update the key but rollback the transaction so it never saves.
+	buf.Reset()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "tx=2 op=read page=") {
+		t.Fatalf("expected read trace:\n%s", buf.String())
+	} else if strings.Contains(buf.String(), "op=write") {
+		t.Fatalf("unexpected write trace:\n%s", buf.String())
+	}
+	db.Trace = nil
+}
+
+// Ensure that changes made after a savepoint can be rolled back.
+func TestTx_RollbackTo(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Create a bucket large enough to span multiple pages.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("large"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Make changes that will be discarded.
+		if err := b.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		} else if err := b.Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		} else if _, err := tx.CreateBucket([]byte("gadgets")); err != nil {
+			t.Fatal(err)
+		} else if err := tx.DeleteBucket([]byte("large")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.RollbackTo(sp); err != nil {
+			t.Fatal(err)
+		}
+
+		// Ensure the existing bucket reference sees the savepoint state.
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("baz")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		} else if tx.Bucket([]byte("gadgets")) != nil {
+			t.Fatal("unexpected bucket")
+		} else if tx.Bucket([]byte("large")) == nil {
+			t.Fatal("expected bucket")
+		}
+
+		// Continue making changes after rolling back.
+		return b.Put([]byte("qux"), []byte("quux"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("qux")); !bytes.Equal(v, []byte("quux")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if n := tx.Bucket([]byte("large")).Stats().KeyN; n != 1000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that nested savepoints can be rolled back independently.
+func TestTx_RollbackTo_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp1, _ := tx.Savepoint()
+		if err := b.Put([]byte("1"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		sp2, _ := tx.Savepoint()
+		if err := b.Put([]byte("2"), []byte("2")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.RollbackTo(sp2); err != nil {
+			t.Fatal(err)
+		} else if b.Get([]byte("1")) == nil || b.Get([]byte("2")) != nil {
+			t.Fatal("unexpected state after rollback to sp2")
+		}
+
+		// A savepoint can be reused.
+		if err := b.Put([]byte("2"), []byte("2")); err != nil {
+			t.Fatal(err)
+		} else if err := tx.RollbackTo(sp2); err != nil {
+			t.Fatal(err)
+		} else if b.Get([]byte("2")) != nil {
+			t.Fatal("unexpected state after second rollback to sp2")
+		}
+
+		if err := tx.RollbackTo(sp1); err != nil {
+			t.Fatal(err)
+		} else if b.Get([]byte("1")) != nil {
+			t.Fatal("unexpected state after rollback to sp1")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that buckets opened after a savepoint remain usable after rolling
+// back and that buckets created after it are closed.
+func TestTx_RollbackTo_OpenedAfter(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := tx.Bucket([]byte("widgets"))
+		child := b.Bucket([]byte("child"))
+		created, err := tx.CreateBucket([]byte("woojits"))
+		if err != nil {
+			t.Fatal(err)
+		} else if err := b.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		} else if err := child.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.RollbackTo(sp); err != nil {
+			t.Fatal(err)
+		} else if v := b.Get([]byte("baz")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := child.Get([]byte("baz")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		// Writes to reopened buckets are committed.
+		if err := b.Put([]byte("x"), []byte("1")); err != nil {
+			t.Fatal(err)
+		} else if err := child.Put([]byte("y"), []byte("2")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Buckets created after the savepoint are closed.
+		if err := created.Put([]byte("z"), []byte("3")); err != bolt.ErrTxClosed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("x")); string(v) != "1" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Bucket([]byte("child")).Get([]byte("y")); string(v) != "2" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if tx.Bucket([]byte("woojits")) != nil {
+			t.Fatal("unexpected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that savepoints cannot be used across transactions.
+func TestTx_RollbackTo_ErrInvalidSavepoint(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var sp *bolt.Savepoint
+	if err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		sp, err = tx.Savepoint()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.RollbackTo(sp); err != bolt.ErrInvalidSavepoint {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if _, err := tx.Savepoint(); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the pending size of a write transaction grows with its changes.
+func TestTx_PendingSize(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	pageSize := int64(db.Info().PageSize)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		initial := tx.PendingSize()
+		if initial < pageSize || initial > 4*pageSize {
+			t.Fatalf("unexpected initial size: %d", initial)
+		}
+
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if sz := tx.PendingSize(); sz < 1000*100 {
+			t.Fatalf("unexpected pending size: %d", sz)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if sz := tx.PendingSize(); sz != 0 {
+			t.Fatalf("unexpected pending size: %d", sz)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ExampleTx_Rollback() {
+	// Open the database.
+	db, err := bolt.Open(tempfile(), 0666, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(db.Path())
+
+	// Create a bucket.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Set a value for a key.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Update the key but rollback the transaction so it never saves.
 	tx, err := db.Begin(true)
 	if err != nil {
 		log.Fatal(err)
@@ -714,3 +1455,225 @@ func ExampleTx_CopyFile() {
 	// Output:
 	// The value for 'foo' in the clone is: bar
 }
+
+// Ensure that pages of the bucket directory are flagged and other pages are not.
+func TestTx_Page_Directory(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var n int
+		for id := 0; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				t.Fatal(err)
+			} else if p == nil {
+				break
+			}
+			if p.Directory {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Fatalf("unexpected directory page count: %d", n)
+		}
+
+		root := int(tx.Bucket([]byte("widgets")).Root())
+		if p, err := tx.Page(root); err != nil {
+			t.Fatal(err)
+		} else if p.Directory {
+			t.Fatalf("unexpected directory flag on page %d", root)
+		}
+
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that ForEachPage visits the pages of the directory and every bucket.
+func TestTx_ForEachPage(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return child.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var keys, inline int
+		seen := make(map[int]bool)
+		if err := tx.ForEachPage(func(p *bolt.TreePage) error {
+			if len(p.Bucket) == 0 {
+				if p.ID != tx.Root() || !p.Directory {
+					t.Fatalf("unexpected directory page: %d", p.ID)
+				}
+				return nil
+			}
+			if p.Inline {
+				inline++
+				if e, err := p.Element(0); err != nil {
+					t.Fatal(err)
+				} else if string(e.Key) != "foo" || string(e.Value) != "bar" {
+					t.Fatalf("unexpected element: %q=%q", e.Key, e.Value)
+				} else if _, err := p.Element(1); err == nil {
+					t.Fatal("expected out of range error")
+				} else if _, err := p.Element(-1); err == nil {
+					t.Fatal("expected out of range error")
+				}
+				return nil
+			}
+			if seen[p.ID] {
+				t.Fatalf("page visited twice: %d", p.ID)
+			} else if p.Used() > db.Info().PageSize*(p.OverflowCount+1) {
+				t.Fatalf("unexpected used size: %d", p.Used())
+			}
+			seen[p.ID] = true
+			if p.Type == "leaf" {
+				for i := 0; i < p.Count; i++ {
+					if e, err := p.Element(i); err != nil {
+						t.Fatal(err)
+					} else if !e.Bucket {
+						keys++
+					}
+				}
+			} else if e, err := p.Element(0); err != nil {
+				t.Fatal(err)
+			} else if e.Child == 0 {
+				t.Fatal("expected child page")
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if keys != 1000 {
+			t.Fatalf("unexpected key count: %d", keys)
+		} else if inline != 1 {
+			t.Fatalf("unexpected inline page count: %d", inline)
+		}
+
+		errStop := errors.New("stop")
+		if err := tx.ForEachPage(func(p *bolt.TreePage) error { return errStop }); err != errStop {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that clones of a read-only transaction see the same snapshot from
+// several goroutines and do not wait for a pending remap.
+func TestTx_Clone(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start a write that grows the mmap and waits for tx to finish.
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put(u64tob(100), make([]byte, 4<<20))
+		})
+	}()
+
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		clone, err := tx.Clone()
+		if err != nil {
+			t.Fatal(err)
+		} else if clone.ID() != tx.ID() {
+			t.Fatalf("unexpected clone id: %d", clone.ID())
+		}
+		go func() {
+			defer func() { _ = clone.Rollback() }()
+			var n int
+			c := clone.Bucket([]byte("widgets")).Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				n++
+			}
+			if n != 100 {
+				errs <- fmt.Errorf("unexpected count: %d", n)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The write finishes once tx and its clones have been rolled back.
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := <-done; err != nil {
+		t.Fatal(err)
+	} else if _, err := tx.Clone(); err != bolt.ErrTxClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.Clone(); err != bolt.ErrTxWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().OpenTxN; n != 0 {
+		t.Fatalf("unexpected open transactions: %d", n)
+	}
+}
@@ -0,0 +1,174 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Version markers stored in the first byte of a versioned value.
+const (
+	versionDeleted = 0
+	versionValue   = 1
+)
+
+// VersionedBucket stores every version of each key in a bucket so that a key
+// can be read as of an earlier transaction.
+//
+// Each Put or Delete is stored under a composite key made of the key followed
+// by the 8-byte big endian id of the writing transaction. Writes to the same
+// key within a transaction replace each other. The underlying bucket should
+// only be accessed through a VersionedBucket.
+type VersionedBucket struct {
+	b *Bucket
+}
+
+// Version is a single version of a key in a VersionedBucket.
+type Version struct {
+	// TxID is the id of the transaction that wrote the version.
+	TxID int
+
+	// Value is the value written by the transaction. It is nil for deletes
+	// and is only valid for the life of the transaction.
+	Value []byte
+
+	// Deleted is true if the transaction deleted the key.
+	Deleted bool
+}
+
+// NewVersionedBucket returns a VersionedBucket that stores versions in b.
+func NewVersionedBucket(b *Bucket) *VersionedBucket {
+	return &VersionedBucket{b: b}
+}
+
+// Bucket returns the underlying bucket.
+func (vb *VersionedBucket) Bucket() *Bucket {
+	return vb.b
+}
+
+// Put stores a new version of the key written by the current transaction.
+func (vb *VersionedBucket) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	v := make([]byte, 1+len(value))
+	v[0] = versionValue
+	copy(v[1:], value)
+	return vb.b.Put(versionKey(key, vb.b.tx.ID()), v)
+}
+
+// Delete stores a version that marks the key as deleted by the current
+// transaction. Earlier versions remain readable with GetAt.
+func (vb *VersionedBucket) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	return vb.b.Put(versionKey(key, vb.b.tx.ID()), []byte{versionDeleted})
+}
+
+// Get returns the latest value for the key.
+// Returns nil if the key does not exist or its latest version is a delete.
+func (vb *VersionedBucket) Get(key []byte) []byte {
+	return vb.GetAt(key, vb.b.tx.ID())
+}
+
+// GetAt returns the value of the key as of the transaction with the given id,
+// which is the newest version written by that transaction or an earlier one.
+// Returns nil if the key did not exist at that point.
+func (vb *VersionedBucket) GetAt(key []byte, id int) []byte {
+	if id < 0 {
+		return nil
+	}
+
+	// Walk back from the requested transaction to the newest version.
+	c := vb.b.Cursor()
+	k, v := c.Seek(versionKey(key, id+1))
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	for ; k != nil && bytes.HasPrefix(k, key); k, v = c.Prev() {
+		if len(k) != len(key)+8 {
+			continue
+		}
+		if v == nil || v[0] == versionDeleted {
+			return nil
+		}
+		return v[1:]
+	}
+	return nil
+}
+
+// History returns every stored version of the key in transaction order.
+func (vb *VersionedBucket) History(key []byte) []Version {
+	var versions []Version
+	c := vb.b.Cursor()
+	for k, v := c.Seek(versionKey(key, 0)); k != nil && bytes.HasPrefix(k, key); k, v = c.Next() {
+		if len(k) != len(key)+8 || v == nil {
+			continue
+		}
+		ver := Version{TxID: int(binary.BigEndian.Uint64(k[len(key):]))}
+		if v[0] == versionDeleted {
+			ver.Deleted = true
+		} else {
+			ver.Value = v[1:]
+		}
+		versions = append(versions, ver)
+	}
+	return versions
+}
+
+// Prune removes versions that are not needed to read any key as of the
+// transaction with id before or later. For each key, the newest version
+// written before that transaction is kept unless it is a delete. Returns the
+// number of versions removed.
+func (vb *VersionedBucket) Prune(before int) (int, error) {
+	if vb.b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !vb.b.Writable() {
+		return 0, ErrTxNotWritable
+	}
+
+	// Track the newest version of each key seen so far. Versions of a key
+	// are visited in transaction order, so each new version supersedes the
+	// previous one.
+	type latest struct {
+		k       []byte
+		deleted bool
+	}
+	var keys [][]byte
+	seen := make(map[string]latest)
+	c := vb.b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil || len(k) <= 8 || int(binary.BigEndian.Uint64(k[len(k)-8:])) >= before {
+			continue
+		}
+		name := string(k[:len(k)-8])
+		if prev, ok := seen[name]; ok {
+			keys = append(keys, prev.k)
+		}
+		seen[name] = latest{k: cloneBytes(k), deleted: v[0] == versionDeleted}
+	}
+
+	// A delete is not needed once the versions before it are gone.
+	for _, l := range seen {
+		if l.deleted {
+			keys = append(keys, l.k)
+		}
+	}
+
+	for _, k := range keys {
+		if err := vb.b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// versionKey returns the composite key for a version of key.
+func versionKey(key []byte, id int) []byte {
+	k := make([]byte, len(key)+8)
+	copy(k, key)
+	binary.BigEndian.PutUint64(k[len(key):], uint64(id))
+	return k
+}
@@ -0,0 +1,92 @@
+package bolt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a versioned bucket can read keys as of earlier transactions.
+func TestVersionedBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write three versions of "foo" and a key that shares its prefix.
+	var ids []int
+	for _, fn := range []func(vb *bolt.VersionedBucket) error{
+		func(vb *bolt.VersionedBucket) error { return vb.Put([]byte("foo"), []byte("v1")) },
+		func(vb *bolt.VersionedBucket) error { return vb.Put([]byte("foo\x00"), []byte("other")) },
+		func(vb *bolt.VersionedBucket) error { return vb.Put([]byte("foo"), []byte("v2")) },
+		func(vb *bolt.VersionedBucket) error { return vb.Delete([]byte("foo")) },
+		func(vb *bolt.VersionedBucket) error { return vb.Put([]byte("foo"), []byte("v3")) },
+	} {
+		fn := fn
+		if err := db.Update(func(tx *bolt.Tx) error {
+			ids = append(ids, tx.ID())
+			return fn(bolt.NewVersionedBucket(tx.Bucket([]byte("widgets"))))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		vb := bolt.NewVersionedBucket(tx.Bucket([]byte("widgets")))
+		for i, exp := range []string{"v1", "v1", "v2", "", "v3"} {
+			if v := vb.GetAt([]byte("foo"), ids[i]); string(v) != exp || (exp == "") != (v == nil) {
+				t.Fatalf("unexpected value at %d: %q", ids[i], v)
+			}
+		}
+		if v := vb.GetAt([]byte("foo"), ids[0]-1); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := vb.Get([]byte("foo")); !bytes.Equal(v, []byte("v3")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := vb.Get([]byte("foo\x00")); !bytes.Equal(v, []byte("other")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		h := vb.History([]byte("foo"))
+		if len(h) != 4 {
+			t.Fatalf("unexpected history: %+v", h)
+		} else if h[0].TxID != ids[0] || !bytes.Equal(h[0].Value, []byte("v1")) {
+			t.Fatalf("unexpected version: %+v", h[0])
+		} else if h[2].TxID != ids[3] || !h[2].Deleted || h[2].Value != nil {
+			t.Fatalf("unexpected version: %+v", h[2])
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pruning before the delete keeps the newest earlier version; pruning
+	// after it removes the delete and everything before it.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		vb := bolt.NewVersionedBucket(tx.Bucket([]byte("widgets")))
+		if n, err := vb.Prune(ids[3]); err != nil {
+			t.Fatal(err)
+		} else if n != 1 {
+			t.Fatalf("unexpected prune count: %d", n)
+		} else if v := vb.GetAt([]byte("foo"), ids[2]); !bytes.Equal(v, []byte("v2")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if n, err := vb.Prune(ids[4]); err != nil {
+			t.Fatal(err)
+		} else if n != 2 {
+			t.Fatalf("unexpected prune count: %d", n)
+		} else if h := vb.History([]byte("foo")); len(h) != 1 || h[0].TxID != ids[4] {
+			t.Fatalf("unexpected history: %+v", h)
+		} else if v := vb.Get([]byte("foo\x00")); !bytes.Equal(v, []byte("other")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}